@@ -0,0 +1,96 @@
+// Package apperror maps domain failures to the codes clients branch on. The graph-ql lambda
+// reports the code as extensions.code, errors without a code are logged and hidden as INTERNAL.
+package apperror
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type Code string
+
+const (
+	NotFound            Code = "NOT_FOUND"
+	Unauthenticated     Code = "UNAUTHENTICATED"
+	Forbidden           Code = "FORBIDDEN"
+	Validation          Code = "VALIDATION"
+	UpstreamUnavailable Code = "UPSTREAM_UNAVAILABLE"
+	Conflict            Code = "CONFLICT"
+	RateLimited         Code = "RATE_LIMITED"
+	Internal            Code = "INTERNAL"
+
+	// messages of errors that were not created with a code
+	ErrorInternal            = "ErrorInternal"
+	ErrorUpstreamUnavailable = "ErrorUpstreamUnavailable"
+	ErrorConflict            = "ErrorConflict"
+)
+
+// Error is a failure with a code and a message that is safe to show to clients.
+// The cause is only for logs.
+type Error struct {
+	Code    Code
+	Message string
+	Field   string // the invalid input field of a VALIDATION error
+	Err     error
+}
+
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap keeps err as the cause of the client facing error
+func Wrap(code Code, message string, err error) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// Invalid is a VALIDATION error of a single input field
+func Invalid(field, message string) *Error {
+	return &Error{Code: Validation, Message: message, Field: field}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Extensions is added to the GraphQL error by graphql-go
+func (e *Error) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": string(e.Code)}
+	if e.Field != "" {
+		extensions["field"] = e.Field
+	}
+	return extensions
+}
+
+// CodeOf returns the code of err, INTERNAL if it has none
+func CodeOf(err error) Code {
+	return From(err).Code
+}
+
+// From returns the typed error in the chain of err. AWS errors the client can react to are
+// mapped to a code, every other error is INTERNAL.
+func From(err error) *Error {
+
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case dynamodb.ErrCodeConditionalCheckFailedException, dynamodb.ErrCodeTransactionConflictException:
+			return Wrap(Conflict, ErrorConflict, err)
+		case dynamodb.ErrCodeProvisionedThroughputExceededException, dynamodb.ErrCodeRequestLimitExceeded,
+			dynamodb.ErrCodeInternalServerError, "ThrottlingException", "ServiceUnavailable", "RequestError", "SlowDown":
+			return Wrap(UpstreamUnavailable, ErrorUpstreamUnavailable, err)
+		}
+	}
+
+	return Wrap(Internal, ErrorInternal, err)
+}
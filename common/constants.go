@@ -18,17 +18,80 @@ const (
 	ErrorUserIsNotAuthenticated    = "ErrorUserIsNotAuthenticated"
 	ErrorUserDoesNotHaveSellerAuth = "ErrorUserDoesNotHaveSellerAuth"
 	ErrorSpotIsAlreadyReserved     = "ErrorSpotIsAlreadyReserved"
+	ErrorInvalidRegion             = "ErrorInvalidRegion"
+	ErrorRegionTooLarge            = "ErrorRegionTooLarge"
+	ErrorInvalidCursor             = "ErrorInvalidCursor"
+	ErrorInvalidContentType        = "ErrorInvalidContentType"
+	ErrorImageTooLarge             = "ErrorImageTooLarge"
+	ErrorSpotNotFound              = "ErrorSpotNotFound"
+	ErrorMapboxUnavailable         = "ErrorMapboxUnavailable"
 
 	// prefixes
-	SpotPrefix    = "Spot#"
-	UserPrefix    = "User#"
-	ReviewPrefix  = "Review#"
-	GeohashPrefix = "Geohash#"
+	SpotPrefix         = "Spot#"
+	UserPrefix         = "User#"
+	ReviewPrefix       = "Review#"
+	SpotDistancePrefix = "SpotDistance#"
+	SpotImagePrefix    = "SpotImage#"
+	QuestionPrefix     = "Question#"
+	AnswerPrefix       = "Answer#" // Answer#<question_id>#<answer_id>
+
+	// s3 object prefixes
+	SpotImageObjectPrefix    = "spots/"
+	SpotImageRenditionPrefix = "renditions/"
 
 	// queryNames
 	SpotQueryName          = "spots"
 	SpotDistancesQueryName = "SpotDistances"
 
+	// spots
+	SpotGeohashPrecision = 12 // the precision of geohash.Encode
+
+	// reviews
+	MinRating    = 1
+	MaxRating    = 5
+	WilsonScoreZ = 1.96 // 95% confidence for the lower bound of the helpful share
+
+	// review indexes, the reviews of a spot by PK or of a user by GSI2 sorted by a rank
+	SpotReviewsByHelpfulIndex = "SpotHelpfulRank"
+	SpotReviewsByRatingIndex  = "SpotRatingRank"
+	UserReviewsByHelpfulIndex = "UserHelpfulRank"
+	UserReviewsByRatingIndex  = "UserRatingRank"
+
+	// the questions of a spot by PK, the most recently active last
+	SpotQuestionsByActivityIndex = "SpotQuestionActivity"
+
+	// sub-ratings, the names are the review attributes and prefix the spot aggregates
+	SubRatingToiletCleanliness = "ToiletCleanliness"
+	SubRatingQuietness         = "Quietness"
+	SubRatingFlatness          = "Flatness"
+	SubRatingSafety            = "Safety"
+
+	// spotsNear
+	SpotsNearDefaultLimit    = 20
+	SpotsNearMaxLimit        = 100
+	SpotsNearMaxRadiusMeters = 50000
+	SpotsNearMinPrecision    = 3
+	SpotsNearMaxPrecision    = 7
+
+	// pagination
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+
+	// spotsInRegion
+	RegionDefaultLimit = 20
+	RegionMaxLimit     = 100
+	RegionMaxCells     = 32
+	RegionMaxScanCells = 1024
+	RegionMinPrecision = 3
+	RegionMaxPrecision = 7
+
+	// spot images
+	ContentTypeJPEG              = "image/jpeg"
+	ContentTypePNG               = "image/png"
+	SpotImageMaxBytes            = 10 << 20
+	SpotImageUploadsPerDay       = 20
+	SpotImageUploadExpiryMinutes = 15
+
 	// keys
 	PKKey           = "PK"
 	SKKey           = "SK"
@@ -47,6 +110,28 @@ const (
 	CityKey         = "City"
 	HomePageUrlsKey = "HomePageUrls"
 	TagsKey         = "Tags"
-	MessageKey      = "Message"
-	RatingKey       = "Rating"
+
+	DefaultImageUrlKey        = "DefaultImageUrl"
+	DestinationImageUrlKey    = "DestinationImageUrl"
+	DestinationNameKey        = "DestinationName"
+	DestinationDescriptionKey = "DestinationDescription"
+
+	DistanceSecondsKey     = "DistanceSeconds"
+	DistanceMetersKey      = "DistanceMeters"
+	DestinationSpotTypeKey = "DestinationSpotType"
+	MessageKey             = "Message"
+	RatingKey              = "Rating"
+	HelpfulCountKey        = "HelpfulCount"
+	UnhelpfulCountKey      = "UnhelpfulCount"
+	HelpfulRankKey         = "HelpfulRank"
+	RatingRankKey          = "RatingRank"
+	ActivityRankKey        = "ActivityRank"
+
+	ReviewCountKey       = "ReviewCount"
+	RatingSumKey         = "RatingSum"
+	RatingCountKeyPrefix = "RatingCount"
+
+	SubRatingSumKeySuffix     = "Sum"
+	SubRatingCountKeySuffix   = "Count"
+	SubRatingAverageKeySuffix = "Average"
 )
@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/mmcloughlin/geohash"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
 )
 
 func UploadSpot(ctx context.Context, spot Spot, db dynamodbiface.DynamoDBAPI, tableName string) error {
@@ -38,7 +42,624 @@ func UploadSpot(ctx context.Context, spot Spot, db dynamodbiface.DynamoDBAPI, ta
 
 }
 
-func GetSpotsWithGeohash(ctx context.Context, geohash string, db dynamodbiface.DynamoDBAPI, tableName string) ([]Spot, error) {
+// GetSpot loads the spot by its id, the sort key contains the geohash so the partition is queried
+func GetSpot(ctx context.Context, spotId string, projection Projection, db dynamodbiface.DynamoDBAPI, tableName string) (Spot, error) {
+
+	LogInfo(ctx, "Invoke", "GetSpot", map[string]interface{}{"spotId": spotId})
+	expressionAttributeNames := map[string]*string{
+		"#pk": aws.String(PKKey),
+		"#sk": aws.String(SKKey),
+	}
+	output, err := db.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("#pk = :pk AND begins_with(#sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(fmt.Sprintf("%s%s", SpotPrefix, spotId))},
+			":sk": {S: aws.String(SpotPrefix)},
+		},
+		ExpressionAttributeNames: expressionAttributeNames,
+		ProjectionExpression:     projection.ProjectionExpression(expressionAttributeNames),
+		Limit:                    aws.Int64(1),
+	})
+	if err != nil {
+		LogError(ctx, "Failed to query spot", "GetSpot", err, nil)
+		return Spot{}, err
+	}
+	if len(output.Items) == 0 {
+		return Spot{}, apperror.New(apperror.NotFound, ErrorSpotNotFound)
+	}
+
+	var spot Spot
+	err = dynamodbattribute.UnmarshalMap(output.Items[0], &spot)
+	if err != nil {
+		LogError(ctx, "Failed to unmarshal spot", "GetSpot", err, nil)
+		return Spot{}, err
+	}
+	return spot, nil
+}
+
+// SetDefaultImageUrl sets the default image of the spot unless it already has one.
+// It returns false if the spot kept its image.
+func SetDefaultImageUrl(ctx context.Context, spot Spot, imageUrl string, db dynamodbiface.DynamoDBAPI, tableName string) (bool, error) {
+
+	LogInfo(ctx, "Invoke", "SetDefaultImageUrl", map[string]interface{}{"spotId": spot.SpotId()})
+	_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(spot.PK)},
+			SKKey: {S: aws.String(spot.SK)},
+		},
+		UpdateExpression:    aws.String("SET #defaultImageUrl = :defaultImageUrl"),
+		ConditionExpression: aws.String("attribute_exists(#pk) AND attribute_not_exists(#defaultImageUrl)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk":              aws.String(PKKey),
+			"#defaultImageUrl": aws.String(DefaultImageUrlKey),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":defaultImageUrl": {S: aws.String(imageUrl)},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		LogError(ctx, "Failed to set default image", "SetDefaultImageUrl", err, nil)
+		return false, err
+	}
+	return true, nil
+}
+
+// UpdateDestinationImageUrls copies the image url into every spot distance that leads to the spot
+func UpdateDestinationImageUrls(ctx context.Context, spotId, imageUrl string, db dynamodbiface.DynamoDBAPI, tableName string) error {
+
+	LogInfo(ctx, "Invoke", "UpdateDestinationImageUrls", map[string]interface{}{"spotId": spotId})
+	err := forEachItem(incomingSpotDistancesQuery(spotId, tableName), db, func(item map[string]*dynamodb.AttributeValue) error {
+		_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				PKKey: item[PKKey],
+				SKKey: item[SKKey],
+			},
+			UpdateExpression: aws.String("SET #destinationImageUrl = :destinationImageUrl"),
+			ExpressionAttributeNames: map[string]*string{
+				"#destinationImageUrl": aws.String(DestinationImageUrlKey),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":destinationImageUrl": {S: aws.String(imageUrl)},
+			},
+		})
+		return err
+	})
+	if err != nil {
+		LogError(ctx, "Failed to update spot distances", "UpdateDestinationImageUrls", err, nil)
+	}
+	return err
+}
+
+// UpdateDestinationDetails copies the name, spot type and description of the spot into every
+// spot distance that leads to the spot
+func UpdateDestinationDetails(ctx context.Context, spot Spot, db dynamodbiface.DynamoDBAPI, tableName string) error {
+
+	LogInfo(ctx, "Invoke", "UpdateDestinationDetails", map[string]interface{}{"spotId": spot.SpotId()})
+	set := []string{"#destinationSpotType = :destinationSpotType"}
+	remove := []string{}
+	expressionAttributeNames := map[string]*string{
+		"#destinationSpotType":    aws.String(DestinationSpotTypeKey),
+		"#destinationName":        aws.String(DestinationNameKey),
+		"#destinationDescription": aws.String(DestinationDescriptionKey),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":destinationSpotType": {S: aws.String(spot.SpotType)},
+	}
+	if spot.Name != nil {
+		set = append(set, "#destinationName = :destinationName")
+		expressionAttributeValues[":destinationName"] = &dynamodb.AttributeValue{S: spot.Name}
+	} else {
+		remove = append(remove, "#destinationName")
+	}
+	if spot.Description != nil {
+		set = append(set, "#destinationDescription = :destinationDescription")
+		expressionAttributeValues[":destinationDescription"] = &dynamodb.AttributeValue{S: spot.Description}
+	} else {
+		remove = append(remove, "#destinationDescription")
+	}
+	updateExpression := fmt.Sprintf("SET %s", strings.Join(set, ", "))
+	if len(remove) > 0 {
+		updateExpression = fmt.Sprintf("%s REMOVE %s", updateExpression, strings.Join(remove, ", "))
+	}
+
+	err := forEachItem(incomingSpotDistancesQuery(spot.SpotId(), tableName), db, func(item map[string]*dynamodb.AttributeValue) error {
+		_, err := db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				PKKey: item[PKKey],
+				SKKey: item[SKKey],
+			},
+			UpdateExpression:          aws.String(updateExpression),
+			ExpressionAttributeNames:  expressionAttributeNames,
+			ExpressionAttributeValues: expressionAttributeValues,
+		})
+		return err
+	})
+	if err != nil {
+		LogError(ctx, "Failed to update spot distances", "UpdateDestinationDetails", err, nil)
+	}
+	return err
+}
+
+// DeleteSpotDistances deletes the distances from the spot and the distances that lead to it
+func DeleteSpotDistances(ctx context.Context, spotId string, db dynamodbiface.DynamoDBAPI, tableName string) error {
+
+	LogInfo(ctx, "Invoke", "DeleteSpotDistances", map[string]interface{}{"spotId": spotId})
+	outgoing := partitionQuery(spotId, SpotDistancePrefix, tableName)
+	for _, queryInput := range []dynamodb.QueryInput{outgoing, incomingSpotDistancesQuery(spotId, tableName)} {
+		err := forEachItem(queryInput, db, func(item map[string]*dynamodb.AttributeValue) error {
+			return deleteItem(item, db, tableName)
+		})
+		if err != nil {
+			LogError(ctx, "Failed to delete spot distances", "DeleteSpotDistances", err, nil)
+			return err
+		}
+	}
+	return DeleteLegacySpotDistance(ctx, spotId, db, tableName)
+}
+
+// DeleteSpot deletes the spot with everything in its partition and the distances that lead to it.
+// The spot item is deleted last so a failed delete can be retried.
+func DeleteSpot(ctx context.Context, spot Spot, db dynamodbiface.DynamoDBAPI, tableName string) error {
+
+	LogInfo(ctx, "Invoke", "DeleteSpot", map[string]interface{}{"spotId": spot.SpotId()})
+	err := DeleteSpotDistances(ctx, spot.SpotId(), db, tableName)
+	if err != nil {
+		return err
+	}
+
+	err = forEachItem(partitionQuery(spot.SpotId(), "", tableName), db, func(item map[string]*dynamodb.AttributeValue) error {
+		if item[SKKey] != nil && aws.StringValue(item[SKKey].S) == spot.SK {
+			return nil
+		}
+		return deleteItem(item, db, tableName)
+	})
+	if err != nil {
+		LogError(ctx, "Failed to delete spot items", "DeleteSpot", err, nil)
+		return err
+	}
+
+	err = deleteItem(map[string]*dynamodb.AttributeValue{
+		PKKey: {S: aws.String(spot.PK)},
+		SKKey: {S: aws.String(spot.SK)},
+	}, db, tableName)
+	if err != nil {
+		LogError(ctx, "Failed to delete spot", "DeleteSpot", err, nil)
+	}
+	return err
+}
+
+// DeleteSpotItems deletes the items of the spot partition whose sort key starts with the prefix
+func DeleteSpotItems(ctx context.Context, spotId, prefix string, db dynamodbiface.DynamoDBAPI, tableName string) error {
+
+	LogInfo(ctx, "Invoke", "DeleteSpotItems", map[string]interface{}{"spotId": spotId, "prefix": prefix})
+	err := forEachItem(partitionQuery(spotId, prefix, tableName), db, func(item map[string]*dynamodb.AttributeValue) error {
+		return deleteItem(item, db, tableName)
+	})
+	if err != nil {
+		LogError(ctx, "Failed to delete spot items", "DeleteSpotItems", err, nil)
+	}
+	return err
+}
+
+// RatingCountKey is the attribute of the spot that counts the reviews with the rating
+func RatingCountKey(rating int32) string {
+	return fmt.Sprintf("%s%d", RatingCountKeyPrefix, rating)
+}
+
+func SubRatingSumKey(subRating string) string {
+	return subRating + SubRatingSumKeySuffix
+}
+
+func SubRatingCountKey(subRating string) string {
+	return subRating + SubRatingCountKeySuffix
+}
+
+func SubRatingAverageKey(subRating string) string {
+	return subRating + SubRatingAverageKeySuffix
+}
+
+// RatingAggregateUpdate adds the change to the rating aggregates of the spot. It is written in
+// the same transaction as the review, the spot has to exist so a review cannot bring back a
+// deleted spot. Changed sub-ratings are set from the sums and counts of the spot, which has to be
+// read with GetSubRatingAggregates, together with their averages. The update is conditioned on the
+// sums and counts that were read, if another review changed them the transaction is cancelled.
+func RatingAggregateUpdate(spot Spot, change RatingChange, tableName string) *dynamodb.Update {
+
+	var ratingSum int64
+	add, set, remove := []string{"#reviewCount :reviewCount"}, []string{}, []string{}
+	conditions := []string{"attribute_exists(#pk)"}
+	expressionAttributeNames := map[string]*string{
+		"#pk":          aws.String(PKKey),
+		"#reviewCount": aws.String(ReviewCountKey),
+		"#ratingSum":   aws.String(RatingSumKey),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":reviewCount": numberValue(change.ReviewCount),
+	}
+	addValue := func(key string, value int64) {
+		add = append(add, fmt.Sprintf("#%s :%s", key, key))
+		expressionAttributeNames["#"+key] = aws.String(key)
+		expressionAttributeValues[":"+key] = numberValue(value)
+	}
+	setValue := func(key string, value *dynamodb.AttributeValue) {
+		set = append(set, fmt.Sprintf("#%s = :%s", key, key))
+		expressionAttributeNames["#"+key] = aws.String(key)
+		expressionAttributeValues[":"+key] = value
+	}
+	for rating := int32(MinRating); rating <= MaxRating; rating++ {
+		count := change.Ratings[rating]
+		if count == 0 {
+			continue
+		}
+		ratingSum += int64(rating) * count
+		addValue(RatingCountKey(rating), count)
+	}
+	add = append(add, "#ratingSum :ratingSum")
+	expressionAttributeValues[":ratingSum"] = numberValue(ratingSum)
+	aggregates := spot.SubRatingAggregates()
+	for _, subRating := range SubRatings {
+		subRatingChange := change.SubRatings[subRating]
+		if subRatingChange.Count == 0 && subRatingChange.Sum == 0 {
+			continue
+		}
+		aggregate := aggregates[subRating]
+		for key, value := range map[string]*int64{SubRatingSumKey(subRating): aggregate.Sum, SubRatingCountKey(subRating): aggregate.Count} {
+			if value == nil {
+				conditions = append(conditions, fmt.Sprintf("attribute_not_exists(#%s)", key))
+				continue
+			}
+			conditions = append(conditions, fmt.Sprintf("#%s = :current%s", key, key))
+			expressionAttributeValues[":current"+key] = numberValue(*value)
+		}
+		sum := aws.Int64Value(aggregate.Sum) + subRatingChange.Sum
+		count := aws.Int64Value(aggregate.Count) + subRatingChange.Count
+		setValue(SubRatingSumKey(subRating), numberValue(sum))
+		setValue(SubRatingCountKey(subRating), numberValue(count))
+		key := SubRatingAverageKey(subRating)
+		if count <= 0 {
+			remove = append(remove, "#"+key)
+			expressionAttributeNames["#"+key] = aws.String(key)
+			continue
+		}
+		setValue(key, &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(float64(sum)/float64(count), 'f', -1, 64))})
+	}
+	sort.Strings(conditions[1:])
+
+	expression := fmt.Sprintf("ADD %s", strings.Join(add, ", "))
+	if len(set) > 0 || len(remove) > 0 {
+		expression = fmt.Sprintf("%s %s", expression, updateExpression(set, remove))
+	}
+	return &dynamodb.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(spot.PK)},
+			SKKey: {S: aws.String(spot.SK)},
+		},
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+	}
+}
+
+// GetSubRatingAggregates reads the keys and the sub-rating sums and counts of the spot with a
+// consistent read, it returns nil if the spot does not exist.
+func GetSubRatingAggregates(ctx context.Context, spot Spot, db dynamodbiface.DynamoDBAPI, tableName string) (*Spot, error) {
+
+	LogInfo(ctx, "Invoke", "GetSubRatingAggregates", map[string]interface{}{"spotId": spot.SpotId()})
+	keys := Projection{}
+	for _, subRating := range SubRatings {
+		keys = append(keys, SubRatingSumKey(subRating), SubRatingCountKey(subRating))
+	}
+	expressionAttributeNames := map[string]*string{}
+	output, err := db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(spot.PK)},
+			SKKey: {S: aws.String(spot.SK)},
+		},
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     keys.ProjectionExpression(expressionAttributeNames),
+		ExpressionAttributeNames: expressionAttributeNames,
+	})
+	if err != nil {
+		LogError(ctx, "Failed to get sub-rating aggregates", "GetSubRatingAggregates", err, nil)
+		return nil, err
+	}
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+	var current Spot
+	err = dynamodbattribute.UnmarshalMap(output.Item, &current)
+	if err != nil {
+		LogError(ctx, "Failed to unmarshal spot", "GetSubRatingAggregates", err, nil)
+		return nil, err
+	}
+	return &current, nil
+}
+
+// RecomputeRatingAggregates counts the reviews of the spot and overwrites its rating aggregates.
+// Reviews written while it runs can be counted twice or missed, it is meant for backfills.
+func RecomputeRatingAggregates(ctx context.Context, spot Spot, db dynamodbiface.DynamoDBAPI, tableName string) error {
+
+	LogInfo(ctx, "Invoke", "RecomputeRatingAggregates", map[string]interface{}{"spotId": spot.SpotId()})
+	var reviewCount, ratingSum int64
+	ratings := map[int32]int64{}
+	subRatings := map[string]SubRatingChange{}
+	queryInput := partitionQuery(spot.SpotId(), ReviewPrefix, tableName)
+	projection := []string{"#pk", "#sk", "#rating"}
+	queryInput.ExpressionAttributeNames["#rating"] = aws.String(RatingKey)
+	for _, subRating := range SubRatings {
+		queryInput.ExpressionAttributeNames["#"+subRating] = aws.String(subRating)
+		projection = append(projection, "#"+subRating)
+	}
+	queryInput.ProjectionExpression = aws.String(strings.Join(projection, ", "))
+	err := forEachItem(queryInput, db, func(item map[string]*dynamodb.AttributeValue) error {
+		number := func(name string) (int32, bool, error) {
+			var value int32
+			if item[name] == nil {
+				return 0, false, nil
+			}
+			err := dynamodbattribute.Unmarshal(item[name], &value)
+			return value, err == nil, err
+		}
+		reviewCount++
+		// reviews written before ratings were required may not have one
+		rating, ok, err := number(RatingKey)
+		if err != nil {
+			return err
+		}
+		if ok && rating >= MinRating && rating <= MaxRating {
+			ratings[rating]++
+			ratingSum += int64(rating)
+		}
+		for _, subRating := range SubRatings {
+			value, ok, err := number(subRating)
+			if err != nil {
+				return err
+			}
+			if ok {
+				total := subRatings[subRating]
+				subRatings[subRating] = SubRatingChange{Sum: total.Sum + int64(value), Count: total.Count + 1}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		LogError(ctx, "Failed to count reviews", "RecomputeRatingAggregates", err, nil)
+		return err
+	}
+
+	set, remove := []string{}, []string{}
+	expressionAttributeNames := map[string]*string{"#pk": aws.String(PKKey)}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{}
+	setValue := func(key string, value *dynamodb.AttributeValue) {
+		set = append(set, fmt.Sprintf("#%s = :%s", key, key))
+		expressionAttributeNames["#"+key] = aws.String(key)
+		expressionAttributeValues[":"+key] = value
+	}
+	setValue(ReviewCountKey, numberValue(reviewCount))
+	setValue(RatingSumKey, numberValue(ratingSum))
+	for rating := int32(MinRating); rating <= MaxRating; rating++ {
+		setValue(RatingCountKey(rating), numberValue(ratings[rating]))
+	}
+	for _, subRating := range SubRatings {
+		aggregate := subRatings[subRating]
+		setValue(SubRatingSumKey(subRating), numberValue(aggregate.Sum))
+		setValue(SubRatingCountKey(subRating), numberValue(aggregate.Count))
+		if aggregate.Count == 0 {
+			key := SubRatingAverageKey(subRating)
+			remove = append(remove, "#"+key)
+			expressionAttributeNames["#"+key] = aws.String(key)
+			continue
+		}
+		average := float64(aggregate.Sum) / float64(aggregate.Count)
+		setValue(SubRatingAverageKey(subRating), &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(average, 'f', -1, 64))})
+	}
+
+	_, err = db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(spot.PK)},
+			SKKey: {S: aws.String(spot.SK)},
+		},
+		UpdateExpression:          aws.String(updateExpression(set, remove)),
+		ConditionExpression:       aws.String("attribute_exists(#pk)"),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+	})
+	if err != nil {
+		LogError(ctx, "Failed to update rating aggregates", "RecomputeRatingAggregates", err, nil)
+	}
+	return err
+}
+
+// BackfillReviewRanks sets the helpful and rating ranks of the reviews of the spot, reviews written
+// before the review indexes are missing from the ordered review lists without them. The update is
+// conditioned on the rating and counts that were read, a review written in between already got
+// its ranks from that write.
+func BackfillReviewRanks(ctx context.Context, spot Spot, db dynamodbiface.DynamoDBAPI, tableName string) error {
+
+	LogInfo(ctx, "Invoke", "BackfillReviewRanks", map[string]interface{}{"spotId": spot.SpotId()})
+	queryInput := partitionQuery(spot.SpotId(), ReviewPrefix, tableName)
+	projection := []string{"#pk", "#sk"}
+	for _, key := range []string{RatingKey, HelpfulCountKey, UnhelpfulCountKey, HelpfulRankKey, RatingRankKey} {
+		queryInput.ExpressionAttributeNames["#"+key] = aws.String(key)
+		projection = append(projection, "#"+key)
+	}
+	queryInput.ProjectionExpression = aws.String(strings.Join(projection, ", "))
+	err := forEachItem(queryInput, db, func(item map[string]*dynamodb.AttributeValue) error {
+		var review struct {
+			PK             string  `dynamodbav:"PK"`
+			SK             string  `dynamodbav:"SK"`
+			Rating         *int32  `dynamodbav:"Rating"`
+			HelpfulCount   *int64  `dynamodbav:"HelpfulCount"`
+			UnhelpfulCount *int64  `dynamodbav:"UnhelpfulCount"`
+			HelpfulRank    *string `dynamodbav:"HelpfulRank"`
+			RatingRank     *string `dynamodbav:"RatingRank"`
+		}
+		err := dynamodbattribute.UnmarshalMap(item, &review)
+		if err != nil {
+			return err
+		}
+		reviewId := strings.TrimPrefix(review.SK, ReviewPrefix)
+		helpfulRank := HelpfulRank(aws.Int64Value(review.HelpfulCount), aws.Int64Value(review.UnhelpfulCount), reviewId)
+		ratingRank := RatingRank(review.Rating, reviewId)
+		if aws.StringValue(review.HelpfulRank) == helpfulRank && aws.StringValue(review.RatingRank) == ratingRank {
+			return nil
+		}
+
+		conditions := []string{"attribute_exists(#pk)"}
+		expressionAttributeNames := map[string]*string{
+			"#pk":                aws.String(PKKey),
+			"#" + HelpfulRankKey: aws.String(HelpfulRankKey),
+			"#" + RatingRankKey:  aws.String(RatingRankKey),
+		}
+		expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+			":" + HelpfulRankKey: {S: aws.String(helpfulRank)},
+			":" + RatingRankKey:  {S: aws.String(ratingRank)},
+		}
+		for _, key := range []string{RatingKey, HelpfulCountKey, UnhelpfulCountKey} {
+			expressionAttributeNames["#"+key] = aws.String(key)
+			if item[key] == nil {
+				conditions = append(conditions, fmt.Sprintf("attribute_not_exists(#%s)", key))
+				continue
+			}
+			conditions = append(conditions, fmt.Sprintf("#%s = :%s", key, key))
+			expressionAttributeValues[":"+key] = item[key]
+		}
+		_, err = db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				PKKey: {S: aws.String(review.PK)},
+				SKKey: {S: aws.String(review.SK)},
+			},
+			UpdateExpression:          aws.String(fmt.Sprintf("SET #%s = :%s, #%s = :%s", HelpfulRankKey, HelpfulRankKey, RatingRankKey, RatingRankKey)),
+			ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+			ExpressionAttributeNames:  expressionAttributeNames,
+			ExpressionAttributeValues: expressionAttributeValues,
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		LogError(ctx, "Failed to backfill review ranks", "BackfillReviewRanks", err, nil)
+	}
+	return err
+}
+
+// updateExpression joins the SET and REMOVE clauses that are not empty
+func updateExpression(set, remove []string) string {
+	clauses := []string{}
+	if len(set) > 0 {
+		clauses = append(clauses, "SET "+strings.Join(set, ", "))
+	}
+	if len(remove) > 0 {
+		clauses = append(clauses, "REMOVE "+strings.Join(remove, ", "))
+	}
+	return strings.Join(clauses, " ")
+}
+
+func numberValue(value int64) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(value, 10))}
+}
+
+// partitionQuery reads the keys of the items in the partition of the spot whose sort key starts with prefix
+func partitionQuery(spotId, prefix, tableName string) dynamodb.QueryInput {
+	keyConditionExpression := "#pk = :pk"
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":pk": {S: aws.String(fmt.Sprintf("%s%s", SpotPrefix, spotId))},
+	}
+	if prefix != "" {
+		keyConditionExpression = keyConditionExpression + " AND begins_with(#sk, :sk)"
+		expressionAttributeValues[":sk"] = &dynamodb.AttributeValue{S: aws.String(prefix)}
+	}
+	return dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String(keyConditionExpression),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(PKKey),
+			"#sk": aws.String(SKKey),
+		},
+		ExpressionAttributeValues: expressionAttributeValues,
+		ProjectionExpression:      aws.String("#pk, #sk"),
+	}
+}
+
+// incomingSpotDistancesQuery reads the keys of the spot distances that lead to the spot
+func incomingSpotDistancesQuery(spotId, tableName string) dynamodb.QueryInput {
+	return dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		IndexName:              aws.String(GSI1Key),
+		KeyConditionExpression: aws.String("#gsi1 = :gsi1 AND begins_with(#sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":gsi1": {S: aws.String(fmt.Sprintf("%s%s", SpotPrefix, spotId))},
+			":sk":   {S: aws.String(SpotDistancePrefix)},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#pk":   aws.String(PKKey),
+			"#gsi1": aws.String(GSI1Key),
+			"#sk":   aws.String(SKKey),
+		},
+		ProjectionExpression: aws.String("#pk, #sk"),
+	}
+}
+
+// forEachItem calls f with every item of the query, it reads all pages
+func forEachItem(queryInput dynamodb.QueryInput, db dynamodbiface.DynamoDBAPI, f func(item map[string]*dynamodb.AttributeValue) error) error {
+	for {
+		output, err := db.Query(&queryInput)
+		if err != nil {
+			return err
+		}
+		for _, item := range output.Items {
+			if err := f(item); err != nil {
+				return err
+			}
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		queryInput.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+func deleteItem(item map[string]*dynamodb.AttributeValue, db dynamodbiface.DynamoDBAPI, tableName string) error {
+	_, err := db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: item[PKKey],
+			SKKey: item[SKKey],
+		},
+	})
+	return err
+}
+
+// DeleteLegacySpotDistance deletes the distance row of the spot that is keyed SpotDistances. It was
+// written before every destination got its own SpotDistance#<destination> key and is not read.
+func DeleteLegacySpotDistance(ctx context.Context, spotId string, db dynamodbiface.DynamoDBAPI, tableName string) error {
+
+	LogInfo(ctx, "Invoke", "DeleteLegacySpotDistance", map[string]interface{}{"spotId": spotId})
+	err := deleteItem(map[string]*dynamodb.AttributeValue{
+		PKKey: {S: aws.String(fmt.Sprintf("%s%s", SpotPrefix, spotId))},
+		SKKey: {S: aws.String(SpotDistancesQueryName)},
+	}, db, tableName)
+	if err != nil {
+		LogError(ctx, "Failed to delete legacy spot distance", "DeleteLegacySpotDistance", err, nil)
+	}
+	return err
+}
+
+func GetSpotsWithGeohash(ctx context.Context, geohash string, filter SpotFilter, db dynamodbiface.DynamoDBAPI, tableName string) ([]Spot, error) {
 
 	LogInfo(ctx, "Invoke", "GetSpotsWithGeohash", nil)
 	sk := fmt.Sprintf("%s%s", SpotPrefix, geohash)
@@ -51,35 +672,140 @@ func GetSpotsWithGeohash(ctx context.Context, geohash string, db dynamodbiface.D
 		"#gsi2": aws.String("GSI2"),
 		"#sk":   aws.String("SK"),
 	}
+	filterExpression := filter.FilterExpression(expressionAttributeNames, expressionAttributeValues)
+	// the location is read as well, spots near a point or in a region are selected by it
+	projectionExpression := filter.Projection.With(LatitudeKey, LongitudeKey).ProjectionExpression(expressionAttributeNames)
 	LogInfo(ctx, "Query", "GetSpotsWithGeohash", map[string]interface{}{
 		"keyConditionExpression":    keyConditionExpression,
 		"expressionAttributeValues": expressionAttributeValues,
 		"expressionAttributeNames":  expressionAttributeNames,
+		"filterExpression":          filterExpression,
+		"projectionExpression":      projectionExpression,
 	})
 
-	output, err := db.Query(&dynamodb.QueryInput{
+	queryInput := dynamodb.QueryInput{
 		TableName:                 aws.String(tableName),
 		IndexName:                 aws.String("GSI2"),
 		KeyConditionExpression:    aws.String(keyConditionExpression),
 		ExpressionAttributeValues: expressionAttributeValues,
 		ExpressionAttributeNames:  expressionAttributeNames,
+		FilterExpression:          filterExpression,
+		ProjectionExpression:      projectionExpression,
+	}
+
+	spots := []Spot{}
+	for {
+		output, err := db.Query(&queryInput)
+		if err != nil {
+			LogError(ctx, "Failed to query spots", "GetSpotsWithGeohash", err, nil)
+			return nil, err
+		}
+
+		for index := range output.Items {
+			item := output.Items[index]
+			var spot Spot
+			err := dynamodbattribute.UnmarshalMap(item, &spot)
+			if err != nil {
+				return nil, err
+			}
+			spots = append(spots, spot)
+		}
+
+		// results are cut off at 1MB, keep going until the whole prefix is read
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		queryInput.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return spots, nil
+}
+
+// GetSpotsNear returns up to limit spots within radiusMeters of the point, ordered by distance.
+// A limit of 0 returns every spot in the radius. The ring is read once, at the highest precision
+// whose cells cover the whole radius.
+func GetSpotsNear(ctx context.Context, latitude, longitude, radiusMeters float64, limit int, filter SpotFilter, db dynamodbiface.DynamoDBAPI, tableName string) ([]Spot, error) {
+
+	LogInfo(ctx, "Invoke", "GetSpotsNear", nil)
+	type spotDistance struct {
+		spot     Spot
+		distance float64
+	}
+
+	precision := SpotsNearMinPrecision
+	for p := SpotsNearMaxPrecision; p > SpotsNearMinPrecision; p-- {
+		if RingCoverage(latitude, longitude, uint(p)) >= radiusMeters {
+			precision = p
+			break
+		}
+	}
+
+	// near the poles the neighbours of a cell can repeat
+	checkedSpots := map[string]bool{}
+	spotsInRange := []spotDistance{}
+	for _, g := range GeohashRing(latitude, longitude, uint(precision)) {
+		spots, err := GetSpotsWithGeohash(ctx, g, filter, db, tableName)
+		if err != nil {
+			LogError(ctx, "Failed to get spots in ring", "GetSpotsNear", err, nil)
+			return nil, err
+		}
+		for j := range spots {
+			s := spots[j]
+			if checkedSpots[s.PK] {
+				continue
+			}
+			checkedSpots[s.PK] = true
+			d := Distance(latitude, longitude, s.Latitude, s.Longitude)
+			if d <= radiusMeters {
+				spotsInRange = append(spotsInRange, spotDistance{s, d})
+			}
+		}
+	}
+
+	sort.Slice(spotsInRange, func(i, j int) bool {
+		return spotsInRange[i].distance < spotsInRange[j].distance
 	})
+	if limit > 0 && len(spotsInRange) > limit {
+		spotsInRange = spotsInRange[:limit]
+	}
+
+	spots := make([]Spot, len(spotsInRange))
+	for index := range spotsInRange {
+		spots[index] = spotsInRange[index].spot
+	}
+	return spots, nil
+}
+
+// GetSpotsInRegion returns up to limit spots inside the region, a limit of 0 returns every spot.
+// The covering geohash cells are queried in order until limit spots are found, the spots are
+// filtered exactly with the region boundary.
+func GetSpotsInRegion(ctx context.Context, region Region, limit int, filter SpotFilter, db dynamodbiface.DynamoDBAPI, tableName string) ([]Spot, error) {
+
+	LogInfo(ctx, "Invoke", "GetSpotsInRegion", nil)
+	cells, err := CoveringGeohashes(region)
 	if err != nil {
-		LogError(ctx, "Failed to query spots", "GetSpotsWithGeohash", err, nil)
+		LogError(ctx, "Failed to cover region", "GetSpotsInRegion", err, nil)
 		return nil, err
 	}
 
-	spots := make([]Spot, len(output.Items))
-	for index := range output.Items {
-		item := output.Items[index]
-		var spot Spot
-		err := dynamodbattribute.UnmarshalMap(item, &spot)
+	spotsInRegion := []Spot{}
+	for _, c := range cells {
+		spots, err := GetSpotsWithGeohash(ctx, c, filter, db, tableName)
 		if err != nil {
+			LogError(ctx, "Failed to get spots in cell", "GetSpotsInRegion", err, nil)
 			return nil, err
 		}
-		spots[index] = spot
+		for j := range spots {
+			s := spots[j]
+			if region.Contains(s.Latitude, s.Longitude) {
+				spotsInRegion = append(spotsInRegion, s)
+			}
+		}
+		if limit > 0 && len(spotsInRegion) >= limit {
+			return spotsInRegion[:limit], nil
+		}
 	}
-	return spots, nil
+
+	return spotsInRegion, nil
 }
 
 func GetAllSpots(ctx context.Context, lastEvaluatedKey string, db dynamodbiface.DynamoDBAPI, tableName string) ([]Spot, string, error) {
@@ -106,9 +832,12 @@ func GetAllSpots(ctx context.Context, lastEvaluatedKey string, db dynamodbiface.
 		ExpressionAttributeNames:  expressionAttributeNames,
 	}
 	if lastEvaluatedKey != "" {
-		queryInput.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
-			"PK": {S: aws.String(lastEvaluatedKey)},
+		startKey, err := DecodeCursor(lastEvaluatedKey)
+		if err != nil {
+			LogError(ctx, "Failed to decode last evaluated key", "GetAllSpots", err, nil)
+			return nil, "", err
 		}
+		queryInput.ExclusiveStartKey = startKey
 	}
 
 	output, err := db.Query(&queryInput)
@@ -127,10 +856,14 @@ func GetAllSpots(ctx context.Context, lastEvaluatedKey string, db dynamodbiface.
 		}
 		spots[index] = spot
 	}
+
+	// the key of a GSI2 query holds the index key as well as PK and SK
 	var newLastEvaluatedKey string
-	if output.LastEvaluatedKey != nil {
-		if val, ok := output.LastEvaluatedKey["PK"]; ok {
-			newLastEvaluatedKey = *val.S
+	if len(output.LastEvaluatedKey) > 0 {
+		newLastEvaluatedKey, err = EncodeCursor(output.LastEvaluatedKey)
+		if err != nil {
+			LogError(ctx, "Failed to encode last evaluated key", "GetAllSpots", err, nil)
+			return nil, "", err
 		}
 	}
 
@@ -162,10 +895,10 @@ func GetSpotDistances(ctx context.Context, origin Spot, destinations []Spot, db
 
 	LogInfo(ctx, "Invoke", "GetSpotDistances", nil)
 	var spotDistance []SpotDistance
-	keyConditionExpression := fmt.Sprintf("#pk = :pk AND #sk = :sk")
+	keyConditionExpression := "#pk = :pk AND begins_with(#sk, :sk)"
 	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
 		":pk": {S: aws.String(origin.PK)},
-		":sk": {S: aws.String(SpotDistancesQueryName)},
+		":sk": {S: aws.String(SpotDistancePrefix)},
 	}
 	expressionAttributeNames := map[string]*string{
 		"#pk":   aws.String("PK"),
@@ -213,6 +946,84 @@ func GetSpotDistances(ctx context.Context, origin Spot, destinations []Spot, db
 	return spotDistance, nil
 }
 
+// QuerySpotDistances returns all distances from the spot that are within maxSeconds and maxMeters
+// and lead to a spot of one of the spot types. Nil limits and empty spot types are not applied.
+func QuerySpotDistances(ctx context.Context, spotId string, maxSeconds, maxMeters *float64, spotTypes []string, db dynamodbiface.DynamoDBAPI, tableName string) ([]SpotDistance, error) {
+
+	LogInfo(ctx, "Invoke", "QuerySpotDistances", nil)
+	pk := fmt.Sprintf("%s%s", SpotPrefix, spotId)
+	keyConditionExpression := "#pk = :pk AND begins_with(#sk, :sk)"
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":pk": {S: aws.String(pk)},
+		":sk": {S: aws.String(SpotDistancePrefix)},
+	}
+	expressionAttributeNames := map[string]*string{
+		"#pk": aws.String(PKKey),
+		"#sk": aws.String(SKKey),
+	}
+
+	conditions := []string{}
+	if maxSeconds != nil {
+		expressionAttributeNames["#distanceSeconds"] = aws.String(DistanceSecondsKey)
+		expressionAttributeValues[":maxSeconds"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%f", *maxSeconds))}
+		conditions = append(conditions, "#distanceSeconds <= :maxSeconds")
+	}
+	if maxMeters != nil {
+		expressionAttributeNames["#distanceMeters"] = aws.String(DistanceMetersKey)
+		expressionAttributeValues[":maxMeters"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%f", *maxMeters))}
+		conditions = append(conditions, "#distanceMeters <= :maxMeters")
+	}
+	if len(spotTypes) > 0 {
+		expressionAttributeNames["#destinationSpotType"] = aws.String(DestinationSpotTypeKey)
+		valueNames := make([]string, len(spotTypes))
+		for i, st := range spotTypes {
+			valueNames[i] = fmt.Sprintf(":spotType_%d", i)
+			expressionAttributeValues[valueNames[i]] = &dynamodb.AttributeValue{S: aws.String(st)}
+		}
+		conditions = append(conditions, fmt.Sprintf("#destinationSpotType IN(%s)", strings.Join(valueNames, ",")))
+	}
+
+	queryInput := dynamodb.QueryInput{
+		TableName:                 aws.String(tableName),
+		KeyConditionExpression:    aws.String(keyConditionExpression),
+		ExpressionAttributeValues: expressionAttributeValues,
+		ExpressionAttributeNames:  expressionAttributeNames,
+	}
+	if len(conditions) > 0 {
+		queryInput.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+	LogInfo(ctx, "Query", "QuerySpotDistances", map[string]interface{}{
+		"keyConditionExpression":    keyConditionExpression,
+		"expressionAttributeValues": expressionAttributeValues,
+		"expressionAttributeNames":  expressionAttributeNames,
+		"filterExpression":          queryInput.FilterExpression,
+	})
+
+	spotDistances := []SpotDistance{}
+	for {
+		output, err := db.Query(&queryInput)
+		if err != nil {
+			LogError(ctx, "Failed to query spotDistances", "QuerySpotDistances", err, nil)
+			return nil, err
+		}
+		for index := range output.Items {
+			var sd SpotDistance
+			err := dynamodbattribute.UnmarshalMap(output.Items[index], &sd)
+			if err != nil {
+				LogError(ctx, "Failed to unmarshal spotDistance", "QuerySpotDistances", err, nil)
+				return nil, err
+			}
+			spotDistances = append(spotDistances, sd)
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		queryInput.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	return spotDistances, nil
+}
+
 func CreateSpotDistances(ctx context.Context, spot Spot, db dynamodbiface.DynamoDBAPI, tableName string, mbClient MapboxClient) error {
 
 	LogInfo(ctx, "Invoke", "CreateSpotDistances", nil)
@@ -222,7 +1033,7 @@ func CreateSpotDistances(ctx context.Context, spot Spot, db dynamodbiface.Dynamo
 	ghash4s := geohash.Neighbors(ghash4)
 
 	for _, g4 := range ghash4s {
-		ns, err := GetSpotsWithGeohash(ctx, g4, db, tableName)
+		ns, err := GetSpotsWithGeohash(ctx, g4, SpotFilter{}, db, tableName)
 		if err != nil {
 			LogError(ctx, "Failed to get nearby Spots", "CreateSpot", err, nil)
 			continue
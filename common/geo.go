@@ -0,0 +1,230 @@
+package common
+
+import (
+	"math"
+
+	"github.com/mmcloughlin/geohash"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+// GeohashRing returns the geohash cell containing the point at the given precision
+// together with its eight neighbours. Neighbors takes care of cells that lie across
+// a geohash border from the point.
+func GeohashRing(latitude, longitude float64, precision uint) []string {
+	center := geohash.EncodeWithPrecision(latitude, longitude, precision)
+	return append([]string{center}, geohash.Neighbors(center)...)
+}
+
+// RingCoverage returns the distance in meters around the point that is guaranteed to lie
+// inside the ring returned by GeohashRing. The point is inside the center cell so every
+// direction is covered by at least one full cell.
+func RingCoverage(latitude, longitude float64, precision uint) float64 {
+	box := geohash.BoundingBox(geohash.EncodeWithPrecision(latitude, longitude, precision))
+	height := Distance(box.MinLat, longitude, box.MaxLat, longitude)
+	width := Distance(latitude, box.MinLng, latitude, box.MaxLng)
+	return math.Min(height, width)
+}
+
+type BoundingBox struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+func (b BoundingBox) Contains(latitude, longitude float64) bool {
+	return latitude >= b.MinLatitude && latitude <= b.MaxLatitude &&
+		longitude >= b.MinLongitude && longitude <= b.MaxLongitude
+}
+
+func (b BoundingBox) intersects(box geohash.Box) bool {
+	return box.MinLat <= b.MaxLatitude && box.MaxLat >= b.MinLatitude &&
+		box.MinLng <= b.MaxLongitude && box.MaxLng >= b.MinLongitude
+}
+
+// Region is a bounding box or a GeoJSON polygon. Polygon positions are [longitude, latitude],
+// the first ring is the outer boundary and the other rings are holes.
+type Region struct {
+	Box     BoundingBox
+	Polygon [][][]float64
+}
+
+func NewBoundingBoxRegion(box BoundingBox) (Region, error) {
+	if box.MinLatitude > box.MaxLatitude || box.MinLongitude > box.MaxLongitude ||
+		box.MinLatitude < -90 || box.MaxLatitude > 90 || box.MinLongitude < -180 || box.MaxLongitude > 180 {
+		return Region{}, apperror.New(apperror.Validation, ErrorInvalidRegion)
+	}
+	return Region{Box: box}, nil
+}
+
+func NewPolygonRegion(polygon [][][]float64) (Region, error) {
+	if len(polygon) == 0 {
+		return Region{}, apperror.New(apperror.Validation, ErrorInvalidRegion)
+	}
+
+	box := BoundingBox{MinLatitude: 90, MinLongitude: 180, MaxLatitude: -90, MaxLongitude: -180}
+	for _, ring := range polygon {
+		// a linear ring is closed and has at least four positions
+		if len(ring) < 4 {
+			return Region{}, apperror.New(apperror.Validation, ErrorInvalidRegion)
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if len(first) < 2 || len(last) < 2 || first[0] != last[0] || first[1] != last[1] {
+			return Region{}, apperror.New(apperror.Validation, ErrorInvalidRegion)
+		}
+		for _, position := range ring {
+			if len(position) < 2 {
+				return Region{}, apperror.New(apperror.Validation, ErrorInvalidRegion)
+			}
+			box.MinLongitude = math.Min(box.MinLongitude, position[0])
+			box.MaxLongitude = math.Max(box.MaxLongitude, position[0])
+			box.MinLatitude = math.Min(box.MinLatitude, position[1])
+			box.MaxLatitude = math.Max(box.MaxLatitude, position[1])
+		}
+	}
+
+	region, err := NewBoundingBoxRegion(box)
+	if err != nil {
+		return Region{}, err
+	}
+	region.Polygon = polygon
+	return region, nil
+}
+
+func (r Region) Contains(latitude, longitude float64) bool {
+	if !r.Box.Contains(latitude, longitude) {
+		return false
+	}
+	if r.Polygon == nil {
+		return true
+	}
+	if !ringContains(r.Polygon[0], latitude, longitude) {
+		return false
+	}
+	for _, hole := range r.Polygon[1:] {
+		if ringContains(hole, latitude, longitude) {
+			return false
+		}
+	}
+	return true
+}
+
+// intersects returns true if the geohash cell overlaps the region
+func (r Region) intersects(box geohash.Box) bool {
+	if !r.Box.intersects(box) {
+		return false
+	}
+	if r.Polygon == nil {
+		return true
+	}
+
+	outer := r.Polygon[0]
+	// the cell is inside the polygon or the polygon is inside the cell
+	if ringContains(outer, box.MinLat, box.MinLng) || box.Contains(outer[0][1], outer[0][0]) {
+		return true
+	}
+	// otherwise an edge of the polygon has to cross an edge of the cell
+	corners := [][]float64{
+		{box.MinLng, box.MinLat},
+		{box.MaxLng, box.MinLat},
+		{box.MaxLng, box.MaxLat},
+		{box.MinLng, box.MaxLat},
+	}
+	for i := 0; i < len(outer)-1; i++ {
+		for j := range corners {
+			if segmentsIntersect(outer[i], outer[i+1], corners[j], corners[(j+1)%len(corners)]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ringContains uses ray casting to check if the point is inside the linear ring
+func ringContains(ring [][]float64, latitude, longitude float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > latitude) != (yj > latitude) && longitude < (xj-xi)*(latitude-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func segmentsIntersect(p1, p2, p3, p4 []float64) bool {
+	orientation := func(a, b, c []float64) float64 {
+		return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+	}
+	d1 := orientation(p3, p4, p1)
+	d2 := orientation(p3, p4, p2)
+	d3 := orientation(p1, p2, p3)
+	d4 := orientation(p1, p2, p4)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+// CoveringGeohashes returns the geohash prefixes covering the region. It uses the finest
+// precision that needs at most RegionMaxCells cells and fails if even the coarsest
+// allowed precision needs more.
+func CoveringGeohashes(region Region) ([]string, error) {
+
+	for precision := RegionMaxPrecision; precision >= RegionMinPrecision; precision-- {
+		cells, ok := boxGeohashes(region.Box, uint(precision), RegionMaxScanCells)
+		if !ok {
+			continue
+		}
+		coveringCells := []string{}
+		for _, c := range cells {
+			if region.intersects(geohash.BoundingBox(c)) {
+				coveringCells = append(coveringCells, c)
+			}
+		}
+		if len(coveringCells) <= RegionMaxCells {
+			return coveringCells, nil
+		}
+	}
+
+	return nil, apperror.New(apperror.Validation, ErrorRegionTooLarge)
+}
+
+// SpotGeohash is the geohash in the sort key of a spot, it has the full precision of geohash.Encode
+func SpotGeohash(latitude, longitude float64) string {
+	return geohash.EncodeWithPrecision(latitude, longitude, SpotGeohashPrecision)
+}
+
+// ContainingGeohashes returns the cells from minPrecision to maxPrecision that contain the point,
+// these are all the cells CoveringGeohashes can return for a region around the point
+func ContainingGeohashes(latitude, longitude float64, minPrecision, maxPrecision int) []string {
+	cell := geohash.EncodeWithPrecision(latitude, longitude, uint(maxPrecision))
+	cells := []string{}
+	for precision := minPrecision; precision <= maxPrecision; precision++ {
+		cells = append(cells, cell[:precision])
+	}
+	return cells
+}
+
+// boxGeohashes walks the cells of the box row by row, it gives up once more than maxCells are needed
+func boxGeohashes(box BoundingBox, precision uint, maxCells int) ([]string, bool) {
+
+	cells := []string{}
+	rowStart := geohash.EncodeWithPrecision(box.MinLatitude, box.MinLongitude, precision)
+	for {
+		cell := rowStart
+		for {
+			cells = append(cells, cell)
+			if len(cells) > maxCells {
+				return nil, false
+			}
+			if geohash.BoundingBox(cell).MaxLng >= box.MaxLongitude {
+				break
+			}
+			cell = geohash.Neighbor(cell, geohash.East)
+		}
+		if geohash.BoundingBox(rowStart).MaxLat >= box.MaxLatitude {
+			break
+		}
+		rowStart = geohash.Neighbor(rowStart, geohash.North)
+	}
+	return cells, true
+}
@@ -10,10 +10,13 @@ import (
 	"log"
 	"net/http"
 	"strings"
+
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
 )
 
 type MapboxClient interface {
 	AddFeature(ctx context.Context, spot Spot) error
+	DeleteFeature(ctx context.Context, spotId string) error
 	LoadDistances(ctx context.Context, origin Spot, destinations []Spot) (LoadDistancesResponse, error)
 }
 
@@ -66,11 +69,13 @@ func (z *MapboxClientImpl) AddFeature(ctx context.Context, spot Spot) error {
 			Coordinates: []float64{spot.Longitude, spot.Latitude},
 		},
 		Properties: map[string]string{
-			"Name":     *spot.Name,
 			"SpotType": spot.SpotType,
 			"SpotId":   spot.SpotId(),
 		},
 	}
+	if spot.Name != nil {
+		featureRequest.Properties["Name"] = *spot.Name
+	}
 
 	featureRequestJson, err := json.Marshal(featureRequest)
 	if err != nil {
@@ -87,6 +92,23 @@ func (z *MapboxClientImpl) AddFeature(ctx context.Context, spot Spot) error {
 	return err
 }
 
+// DeleteFeature removes the spot from the dataset
+func (z *MapboxClientImpl) DeleteFeature(ctx context.Context, spotId string) error {
+
+	requestUrl := fmt.Sprintf("%s/datasets/v1/ninotokuda/%s/features/%s?access_token=%s", z.BaseUrl, z.DataSetId, spotId, z.AccessToken)
+	request, err := http.NewRequest("DELETE", requestUrl, nil)
+	if err != nil {
+		return err
+	}
+	_, err = z.executeRequest(ctx, request)
+	// the feature is already gone when a failed delete is retried
+	var statusErr *mapboxStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
 type LoadDistancesResponse struct {
 	Code      string      `json:"code"`
 	Durations [][]float64 `json:"durations"`
@@ -120,6 +142,14 @@ func (z *MapboxClientImpl) LoadDistances(ctx context.Context, origin Spot, desti
 	return resp, nil
 }
 
+type mapboxStatusError struct {
+	StatusCode int
+}
+
+func (e *mapboxStatusError) Error() string {
+	return fmt.Sprintf("Non success status code %d", e.StatusCode)
+}
+
 func (z *MapboxClientImpl) executeRequest(ctx context.Context, req *http.Request) ([]byte, error) {
 	log.Println("executeRequest")
 	req.Header.Add("Content-Type", "application/json")
@@ -128,18 +158,18 @@ func (z *MapboxClientImpl) executeRequest(ctx context.Context, req *http.Request
 	response, err := z.Client.Do(req.WithContext(ctx))
 	if err != nil {
 		log.Println("Error making request", err.Error())
-		return nil, err
+		return nil, apperror.Wrap(apperror.UpstreamUnavailable, ErrorMapboxUnavailable, err)
 	}
 	defer response.Body.Close()
 	var bytes []byte
 	bytes, err = ioutil.ReadAll(response.Body)
 	if err != nil {
 		log.Println("Error reading Response body:", err)
-		return nil, err
+		return nil, apperror.Wrap(apperror.UpstreamUnavailable, ErrorMapboxUnavailable, err)
 	}
 	if response.StatusCode < 200 || response.StatusCode > 299 {
 		log.Println("Error in status code", response.StatusCode, string(bytes))
-		return nil, errors.New("Non success status code")
+		return nil, apperror.Wrap(apperror.UpstreamUnavailable, ErrorMapboxUnavailable, &mapboxStatusError{StatusCode: response.StatusCode})
 	}
 	log.Println("response body", string(bytes))
 	return bytes, nil
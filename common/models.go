@@ -2,6 +2,7 @@ package common
 
 import (
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
@@ -10,7 +11,7 @@ import (
 
 type Spot struct {
 	PK              string    `dynamodbav:"PK"`             // Spot#<spot_id>
-	SK              string    `dynamodbav:"SK"`             // Geohash#<geohash>
+	SK              string    `dynamodbav:"SK"`             // Spot#<geohash>
 	GSI1            *string   `dynamodbav:"GSI1,omitempty"` // User#<creatory_id>
 	GSI2            *string   `dynamodbav:"GSI2,omitempty"` // spots
 	CreationTime    string    `dynamodbav:"CreationTime"`
@@ -26,21 +27,160 @@ type Spot struct {
 	HomePageUrls    *[]string `dynamodbav:"HomePageUrls,omitempty"`
 	Tags            *[]string `dynamodbav:"Tags,omitempty"`
 	DefaultImageUrl *string   `dynamodbav:"DefaultImageUrl,omitempty"`
+	// rating aggregates, kept up to date by the review writes
+	ReviewCount  *int64 `dynamodbav:"ReviewCount,omitempty"`
+	RatingSum    *int64 `dynamodbav:"RatingSum,omitempty"`
+	RatingCount1 *int64 `dynamodbav:"RatingCount1,omitempty"`
+	RatingCount2 *int64 `dynamodbav:"RatingCount2,omitempty"`
+	RatingCount3 *int64 `dynamodbav:"RatingCount3,omitempty"`
+	RatingCount4 *int64 `dynamodbav:"RatingCount4,omitempty"`
+	RatingCount5 *int64 `dynamodbav:"RatingCount5,omitempty"`
+	// sub-rating aggregates, the averages are derived from the sums and counts after the review writes
+	ToiletCleanlinessSum     *int64   `dynamodbav:"ToiletCleanlinessSum,omitempty"`
+	ToiletCleanlinessCount   *int64   `dynamodbav:"ToiletCleanlinessCount,omitempty"`
+	ToiletCleanlinessAverage *float64 `dynamodbav:"ToiletCleanlinessAverage,omitempty"`
+	QuietnessSum             *int64   `dynamodbav:"QuietnessSum,omitempty"`
+	QuietnessCount           *int64   `dynamodbav:"QuietnessCount,omitempty"`
+	QuietnessAverage         *float64 `dynamodbav:"QuietnessAverage,omitempty"`
+	FlatnessSum              *int64   `dynamodbav:"FlatnessSum,omitempty"`
+	FlatnessCount            *int64   `dynamodbav:"FlatnessCount,omitempty"`
+	FlatnessAverage          *float64 `dynamodbav:"FlatnessAverage,omitempty"`
+	SafetySum                *int64   `dynamodbav:"SafetySum,omitempty"`
+	SafetyCount              *int64   `dynamodbav:"SafetyCount,omitempty"`
+	SafetyAverage            *float64 `dynamodbav:"SafetyAverage,omitempty"`
+}
+
+// SubRatings are the aspects a review can rate besides the overall rating
+var SubRatings = []string{SubRatingToiletCleanliness, SubRatingQuietness, SubRatingFlatness, SubRatingSafety}
+
+// SubRatingAggregate sums one sub-rating over the reviews of a spot
+type SubRatingAggregate struct {
+	Sum     *int64
+	Count   *int64
+	Average *float64 // spot queries filter on the average, expressions cannot divide
+}
+
+func (s Spot) SubRatingAggregates() map[string]SubRatingAggregate {
+	return map[string]SubRatingAggregate{
+		SubRatingToiletCleanliness: {s.ToiletCleanlinessSum, s.ToiletCleanlinessCount, s.ToiletCleanlinessAverage},
+		SubRatingQuietness:         {s.QuietnessSum, s.QuietnessCount, s.QuietnessAverage},
+		SubRatingFlatness:          {s.FlatnessSum, s.FlatnessCount, s.FlatnessAverage},
+		SubRatingSafety:            {s.SafetySum, s.SafetyCount, s.SafetyAverage},
+	}
+}
+
+// RatingChange is what a review write changes in the rating aggregates of its spot
+type RatingChange struct {
+	ReviewCount int64
+	Ratings     map[int32]int64            // rating to the change of its count
+	SubRatings  map[string]SubRatingChange // sub-rating to the change of its sum and count
+}
+
+type SubRatingChange struct {
+	Sum   int64
+	Count int64
+}
+
+// Plus adds the changes up, an edited review adds the new ratings and removes the old ones
+func (c RatingChange) Plus(other RatingChange) RatingChange {
+	sum := RatingChange{
+		ReviewCount: c.ReviewCount + other.ReviewCount,
+		Ratings:     map[int32]int64{},
+		SubRatings:  map[string]SubRatingChange{},
+	}
+	for _, change := range []RatingChange{c, other} {
+		for rating, count := range change.Ratings {
+			sum.Ratings[rating] += count
+		}
+		for name, subRating := range change.SubRatings {
+			total := sum.SubRatings[name]
+			sum.SubRatings[name] = SubRatingChange{Sum: total.Sum + subRating.Sum, Count: total.Count + subRating.Count}
+		}
+	}
+	return sum
+}
+
+// IsZero reports whether the change leaves the aggregates as they are
+func (c RatingChange) IsZero() bool {
+	if c.ReviewCount != 0 || c.HasSubRatings() {
+		return false
+	}
+	for _, count := range c.Ratings {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// HasSubRatings reports whether the change moves a sub-rating, the averages are updated then
+func (c RatingChange) HasSubRatings() bool {
+	for _, subRating := range c.SubRatings {
+		if subRating.Sum != 0 || subRating.Count != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Helpfulness is the lower bound of the Wilson score interval of the helpful share of the votes,
+// so a review with few votes ranks below one with the same share of many votes
+func Helpfulness(helpful, unhelpful int64) float64 {
+	n := float64(helpful + unhelpful)
+	if n == 0 {
+		return 0
+	}
+	p := float64(helpful) / n
+	z := WilsonScoreZ
+	return (p + z*z/(2*n) - z*math.Sqrt((p*(1-p)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
+
+// HelpfulRank is the sort key of a review in the helpfulness indexes. The score has a fixed width
+// so the strings sort like the numbers, ties sort by review id which is a ULID.
+func HelpfulRank(helpful, unhelpful int64, reviewId string) string {
+	return fmt.Sprintf("%.10f#%s", Helpfulness(helpful, unhelpful), reviewId)
+}
+
+// RatingRank is the sort key of a review in the rating indexes, reviews without a rating rank 0
+func RatingRank(rating *int32, reviewId string) string {
+	return fmt.Sprintf("%d#%s", aws.Int32Value(rating), reviewId)
 }
 
 func (s Spot) SpotId() string {
 	return strings.TrimPrefix(s.PK, SpotPrefix)
 }
 
+// RatingHistogram counts the reviews by rating, index 0 counts the reviews rated MinRating
+func (s Spot) RatingHistogram() []int64 {
+	counts := []*int64{s.RatingCount1, s.RatingCount2, s.RatingCount3, s.RatingCount4, s.RatingCount5}
+	histogram := make([]int64, len(counts))
+	for index, count := range counts {
+		histogram[index] = aws.Int64Value(count)
+	}
+	return histogram
+}
+
+// AverageRating is nil while the spot has no rated reviews
+func (s Spot) AverageRating() *float64 {
+	var rated int64
+	for _, count := range s.RatingHistogram() {
+		rated += count
+	}
+	if rated <= 0 {
+		return nil
+	}
+	return aws.Float64(float64(aws.Int64Value(s.RatingSum)) / float64(rated))
+}
+
 func (s Spot) Geohash() string {
 	return strings.TrimPrefix(s.SK, SpotPrefix)
 }
 
 type SpotDistance struct {
-	PK                     string   `dynamodbav:"PK"`
-	SK                     string   `dynamodbav:"SK"`
-	GSI1                   *string  `dynamodbav:"GSI1,omitempty"`
-	GSI2                   *string  `dynamodbav:"GSI2,omitempty"`
+	PK                     string   `dynamodbav:"PK"`             // Spot#<origin_spot_id>
+	SK                     string   `dynamodbav:"SK"`             // SpotDistance#<destination_spot_id>
+	GSI1                   *string  `dynamodbav:"GSI1,omitempty"` // Spot#<destination_spot_id>
+	GSI2                   *string  `dynamodbav:"GSI2,omitempty"` // SpotDistances
 	CreationTime           string   `dynamodbav:"CreationTime"`
 	DistanceSeconds        *float64 `dynamodbav:"DistanceSeconds,omitempty"`
 	DistanceMeters         *float64 `dynamodbav:"DistanceMeters,omitempty"`
@@ -63,20 +203,93 @@ func (s SpotDistance) DestinationSpotId() string {
 
 func NewSpotDistance(origin, destination Spot, DistanceSeconds, DistanceMeters float64) SpotDistance {
 	pk := fmt.Sprintf("%s%s", SpotPrefix, origin.SpotId())
+	sk := fmt.Sprintf("%s%s", SpotDistancePrefix, destination.SpotId())
 	gsi1 := fmt.Sprintf("%s%s", SpotPrefix, destination.SpotId())
 	creationTime := time.Now().Format(time.RFC3339)
 	spotDistance := SpotDistance{
 		PK:                     pk,
-		SK:                     SpotDistancesQueryName,
+		SK:                     sk,
 		GSI1:                   aws.String(gsi1),
 		GSI2:                   aws.String(SpotDistancesQueryName),
 		CreationTime:           creationTime,
 		DistanceSeconds:        aws.Float64(DistanceSeconds),
 		DistanceMeters:         aws.Float64(DistanceMeters),
 		DestinationName:        destination.Name,
+		DestinationSpotType:    aws.String(destination.SpotType),
 		DestinationImageUrl:    destination.DefaultImageUrl,
 		DestinationDescription: destination.Description,
 	}
 
 	return spotDistance
 }
+
+type SpotImage struct {
+	PK            string  `dynamodbav:"PK"`             // Spot#<spot_id>
+	SK            string  `dynamodbav:"SK"`             // SpotImage#<spot_image_id>
+	GSI2          *string `dynamodbav:"GSI2,omitempty"` // User#<user_id>
+	CreationTime  string  `dynamodbav:"CreationTime"`
+	ObjectKey     string  `dynamodbav:"ObjectKey"`
+	ImageUrl      string  `dynamodbav:"ImageUrl"`
+	ContentType   string  `dynamodbav:"ContentType"`
+	ContentLength int64   `dynamodbav:"ContentLength"`
+	ThumbnailUrl  *string `dynamodbav:"ThumbnailUrl,omitempty"`
+	MediumUrl     *string `dynamodbav:"MediumUrl,omitempty"`
+	ProcessedTime *string `dynamodbav:"ProcessedTime,omitempty"`
+	ReviewId      *string `dynamodbav:"ReviewId,omitempty"` // set for photos attached to a review
+}
+
+func NewSpotImage(spotId, spotImageId, userId, bucketName, contentType string, contentLength int64) SpotImage {
+	objectKey := SpotImageObjectKey(spotId, userId, spotImageId)
+	return SpotImage{
+		PK:            fmt.Sprintf("%s%s", SpotPrefix, spotId),
+		SK:            fmt.Sprintf("%s%s", SpotImagePrefix, spotImageId),
+		GSI2:          aws.String(fmt.Sprintf("%s%s", UserPrefix, userId)),
+		CreationTime:  time.Now().Format(time.RFC3339),
+		ObjectKey:     objectKey,
+		ImageUrl:      ObjectUrl(bucketName, objectKey),
+		ContentType:   contentType,
+		ContentLength: contentLength,
+	}
+}
+
+func (s SpotImage) SpotImageId() string {
+	return strings.TrimPrefix(s.SK, SpotImagePrefix)
+}
+
+func (s SpotImage) SpotId() string {
+	return strings.TrimPrefix(s.PK, SpotPrefix)
+}
+
+func (s SpotImage) UserId() *string {
+	if s.GSI2 != nil {
+		return aws.String(strings.TrimPrefix(*s.GSI2, UserPrefix))
+	}
+	return nil
+}
+
+// SpotImageObjectKey is the s3 key an image is uploaded to: spots/<spot_id>/<user_id>/<spot_image_id>
+func SpotImageObjectKey(spotId, userId, spotImageId string) string {
+	return fmt.Sprintf("%s%s/%s/%s", SpotImageObjectPrefix, spotId, userId, spotImageId)
+}
+
+// ParseSpotImageObjectKey splits an upload key created by SpotImageObjectKey
+func ParseSpotImageObjectKey(objectKey string) (spotId, userId, spotImageId string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(objectKey, SpotImageObjectPrefix), "/")
+	if !strings.HasPrefix(objectKey, SpotImageObjectPrefix) || len(parts) != 3 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// SpotImageRenditionKey is the s3 key of a processed image: renditions/<spot_id>/<spot_image_id>/<name>.<ext>
+func SpotImageRenditionKey(spotId, spotImageId, name, extension string) string {
+	return fmt.Sprintf("%s%s/%s/%s.%s", SpotImageRenditionPrefix, spotId, spotImageId, name, extension)
+}
+
+func ObjectUrl(bucketName, objectKey string) string {
+	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucketName, objectKey)
+}
+
+func IsImageContentType(contentType string) bool {
+	return contentType == ContentTypeJPEG || contentType == ContentTypePNG
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+type Page struct {
+	Items            []map[string]*dynamodb.AttributeValue
+	LastEvaluatedKey map[string]*dynamodb.AttributeValue
+}
+
+func (p Page) HasNextPage() bool {
+	return len(p.LastEvaluatedKey) > 0
+}
+
+// EncodeCursor turns a dynamodb key into an opaque cursor
+func EncodeCursor(key map[string]*dynamodb.AttributeValue) (string, error) {
+	var values map[string]interface{}
+	err := dynamodbattribute.UnmarshalMap(key, &values)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func DecodeCursor(cursor string) (map[string]*dynamodb.AttributeValue, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, apperror.New(apperror.Validation, ErrorInvalidCursor)
+	}
+	var values map[string]interface{}
+	err = json.Unmarshal(data, &values)
+	if err != nil || len(values) == 0 {
+		return nil, apperror.New(apperror.Validation, ErrorInvalidCursor)
+	}
+	return dynamodbattribute.MarshalMap(values)
+}
+
+// indexKeys are the key attributes of the indexes that are not named like their hash key and do
+// not sort by SK
+var indexKeys = map[string][]string{
+	SpotReviewsByHelpfulIndex: {HelpfulRankKey},
+	SpotReviewsByRatingIndex:  {RatingRankKey},
+	UserReviewsByHelpfulIndex: {GSI2Key, HelpfulRankKey},
+	UserReviewsByRatingIndex:  {GSI2Key, RatingRankKey},
+
+	SpotQuestionsByActivityIndex: {ActivityRankKey},
+}
+
+// ItemKey returns the key dynamodb would use as LastEvaluatedKey if the query stopped at this item.
+// Index queries include the index keys as well as the table keys.
+func ItemKey(item map[string]*dynamodb.AttributeValue, indexName *string) map[string]*dynamodb.AttributeValue {
+	key := map[string]*dynamodb.AttributeValue{}
+	for _, name := range keyNames(indexName) {
+		if val, ok := item[name]; ok {
+			key[name] = val
+		}
+	}
+	return key
+}
+
+func keyNames(indexName *string) []string {
+	names := []string{PKKey, SKKey}
+	if indexName == nil {
+		return names
+	}
+	if keys, ok := indexKeys[*indexName]; ok {
+		return append(names, keys...)
+	}
+	return append(names, *indexName)
+}
+
+// QueryPage runs the query from the after cursor until it has collected first items or the
+// partition is exhausted. Filter expressions can drop items so several requests may be needed.
+func QueryPage(ctx context.Context, input dynamodb.QueryInput, first int, after string, db dynamodbiface.DynamoDBAPI) (Page, error) {
+
+	LogInfo(ctx, "Invoke", "QueryPage", nil)
+	if after != "" {
+		startKey, err := DecodeCursor(after)
+		if err != nil {
+			LogError(ctx, "Failed to decode cursor", "QueryPage", err, nil)
+			return Page{}, err
+		}
+		// a cursor of another order of the list lacks the keys of the index
+		for _, name := range keyNames(input.IndexName) {
+			if startKey[name] == nil {
+				return Page{}, apperror.New(apperror.Validation, ErrorInvalidCursor)
+			}
+		}
+		input.ExclusiveStartKey = startKey
+	}
+
+	page := Page{Items: []map[string]*dynamodb.AttributeValue{}}
+	for {
+		input.Limit = aws.Int64(int64(first - len(page.Items)))
+		output, err := db.Query(&input)
+		if err != nil {
+			LogError(ctx, "Failed to query page", "QueryPage", err, nil)
+			return Page{}, err
+		}
+		page.Items = append(page.Items, output.Items...)
+		page.LastEvaluatedKey = output.LastEvaluatedKey
+		if len(page.Items) >= first || len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	return page, nil
+}
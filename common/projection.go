@@ -0,0 +1,43 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Projection lists the attributes a read returns. The key attributes are always read,
+// a nil projection reads whole items.
+type Projection []string
+
+var keyAttributes = []string{PKKey, SKKey, GSI1Key, GSI2Key}
+
+// With adds attributes the caller needs itself, a nil projection stays nil
+func (p Projection) With(attributes ...string) Projection {
+	if p == nil {
+		return nil
+	}
+	return append(append(Projection{}, p...), attributes...)
+}
+
+// ProjectionExpression adds the attribute names to the map and returns the expression,
+// it returns nil for a nil projection.
+func (p Projection) ProjectionExpression(expressionAttributeNames map[string]*string) *string {
+
+	if p == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	placeholders := []string{}
+	for _, attribute := range append(append([]string{}, keyAttributes...), p...) {
+		if seen[attribute] {
+			continue
+		}
+		seen[attribute] = true
+		placeholder := "#projection_" + attribute
+		expressionAttributeNames[placeholder] = aws.String(attribute)
+		placeholders = append(placeholders, placeholder)
+	}
+	return aws.String(strings.Join(placeholders, ", "))
+}
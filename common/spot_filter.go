@@ -0,0 +1,78 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// SpotFilter restricts spot queries to some spot types and tags. A spot matches if it has one of
+// the spot types, every tag of AllTags, at least one tag of AnyTags and sub-rating averages of at
+// least MinSubRatings. Projection limits the attributes read for the matching spots.
+type SpotFilter struct {
+	SpotTypes     []string
+	AllTags       []string
+	AnyTags       []string
+	MinSubRatings map[string]float64
+	Projection    Projection
+}
+
+func (f SpotFilter) IsEmpty() bool {
+	return len(f.SpotTypes) == 0 && len(f.AllTags) == 0 && len(f.AnyTags) == 0 && len(f.MinSubRatings) == 0
+}
+
+// FilterExpression adds the names and values of the filter to the maps and returns the expression,
+// it returns nil for an empty filter.
+func (f SpotFilter) FilterExpression(expressionAttributeNames map[string]*string, expressionAttributeValues map[string]*dynamodb.AttributeValue) *string {
+
+	if f.IsEmpty() {
+		return nil
+	}
+
+	conditions := []string{}
+	if len(f.SpotTypes) > 0 {
+		expressionAttributeNames["#spotType"] = aws.String(SpotTypeKey)
+		spotTypes := make([]string, len(f.SpotTypes))
+		for i, st := range f.SpotTypes {
+			valueName := fmt.Sprintf(":spotType_%d", i)
+			spotTypes[i] = valueName
+			expressionAttributeValues[valueName] = &dynamodb.AttributeValue{S: aws.String(st)}
+		}
+		conditions = append(conditions, fmt.Sprintf("#spotType IN(%s)", strings.Join(spotTypes, ",")))
+	}
+
+	if len(f.AllTags) > 0 || len(f.AnyTags) > 0 {
+		expressionAttributeNames["#tags"] = aws.String(TagsKey)
+	}
+	for i, tag := range f.AllTags {
+		valueName := fmt.Sprintf(":allTag_%d", i)
+		expressionAttributeValues[valueName] = &dynamodb.AttributeValue{S: aws.String(tag)}
+		conditions = append(conditions, fmt.Sprintf("contains(#tags, %s)", valueName))
+	}
+	if len(f.AnyTags) > 0 {
+		anyTags := make([]string, len(f.AnyTags))
+		for i, tag := range f.AnyTags {
+			valueName := fmt.Sprintf(":anyTag_%d", i)
+			expressionAttributeValues[valueName] = &dynamodb.AttributeValue{S: aws.String(tag)}
+			anyTags[i] = fmt.Sprintf("contains(#tags, %s)", valueName)
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s)", strings.Join(anyTags, " OR ")))
+	}
+	// spots without reviews for the sub-rating have no average and do not match
+	for _, subRating := range SubRatings {
+		minimum, ok := f.MinSubRatings[subRating]
+		if !ok {
+			continue
+		}
+		key := SubRatingAverageKey(subRating)
+		valueName := fmt.Sprintf(":minSubRating_%s", subRating)
+		expressionAttributeNames["#"+key] = aws.String(key)
+		expressionAttributeValues[valueName] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(minimum, 'f', -1, 64))}
+		conditions = append(conditions, fmt.Sprintf("#%s >= %s", key, valueName))
+	}
+
+	return aws.String(strings.Join(conditions, " AND "))
+}
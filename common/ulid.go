@@ -0,0 +1,41 @@
+package common
+
+import (
+	"crypto/rand"
+	"time"
+)
+
+// crockford base32, the alphabet of ULIDs
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a ULID for the time: 48 bits of milliseconds followed by 80 random bits,
+// encoded as 26 characters. ULIDs sort by time as strings, so they can be used in sort keys.
+func NewULID(t time.Time) string {
+
+	var data [16]byte
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		data[i] = byte(ms >> uint(40-8*i))
+	}
+	if _, err := rand.Read(data[6:]); err != nil {
+		panic(err)
+	}
+
+	// 128 bits in 26 characters of 5 bits, the first character only holds 3 bits
+	id := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		bit := uint(125 - 5*i) // offset of the lowest bit of the character from the end
+		var value byte
+		for b := uint(0); b < 5; b++ {
+			position := bit + b
+			if position >= 128 {
+				break
+			}
+			if data[15-position/8]&(1<<(position%8)) != 0 {
+				value |= 1 << b
+			}
+		}
+		id[i] = ulidAlphabet[value]
+	}
+	return string(id)
+}
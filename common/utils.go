@@ -44,6 +44,14 @@ type RequestUser struct {
 	userId     string
 }
 
+func NewRequestUser(userGroups []string, companyId, userId string) RequestUser {
+	return RequestUser{
+		userGroups: userGroups,
+		companyId:  companyId,
+		userId:     userId,
+	}
+}
+
 func (u *RequestUser) IsAdminUser() bool {
 	for _, ug := range u.userGroups {
 		if ug == "Admin" {
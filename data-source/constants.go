@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 const (
 	SchemaName = "schema.graphql"
 
@@ -10,6 +12,14 @@ const (
 	TableNameEvn  = "DynamoTableName"
 	BucketNameEnv = "S3BucketName"
 
+	// jobs, the handler runs the job named by the job query parameter. Jobs that walk every
+	// spot stop before the invocation times out and return the key to start the next run from.
+	JobParameter            = "job"
+	JobStartKeyParameter    = "startKey"
+	JobBackfillRatings      = "backfillRatings"
+	JobMigrateSpotDistances = "migrateSpotDistances"
+	JobTimeMargin           = time.Minute
+
 	// spot statuses
 	SpotStatusOpen     = "open"
 	SpotStatusReserved = "reserved"
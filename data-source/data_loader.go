@@ -8,7 +8,6 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/mmcloughlin/geohash"
 	"github.com/ninotokuda/carcamp_v2/common"
 	uuid "github.com/satori/go.uuid"
 )
@@ -64,7 +63,7 @@ func FeatureToSpot(in Feature) common.Spot {
 
 	lat := in.Properties["P35_001"].(float64)
 	lng := in.Properties["P35_002"].(float64)
-	ghash := geohash.Encode(lat, lng)
+	ghash := common.SpotGeohash(lat, lng)
 
 	pk := fmt.Sprintf("%s%s", common.SpotPrefix, uuid)
 	sk := fmt.Sprintf("%s%s", common.SpotPrefix, ghash)
@@ -8,6 +8,7 @@ import (
 	"log"
 	"math"
 	"os"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -78,7 +79,7 @@ func (z *App) uploadRoadSideStations(ctx context.Context, request events.APIGate
 		spot := csvSpots[i]
 
 		// check if spot with geohash and type exists
-		geohashSpots, err := common.GetSpotsWithGeohash(ctx, spot.Geohash(), z.db, z.tableName)
+		geohashSpots, err := common.GetSpotsWithGeohash(ctx, spot.Geohash(), common.SpotFilter{}, z.db, z.tableName)
 		if err != nil {
 			log.Println("Failed to get geohash spots:", err.Error())
 			continue
@@ -121,7 +122,7 @@ func (z *App) uploadRoadSideStations(ctx context.Context, request events.APIGate
 		ghash4s := geohash.Neighbors(ghash4)
 		nearbySpots := []common.Spot{}
 		for _, g4 := range ghash4s {
-			ns, err := common.GetSpotsWithGeohash(ctx, g4, z.db, z.tableName)
+			ns, err := common.GetSpotsWithGeohash(ctx, g4, common.SpotFilter{}, z.db, z.tableName)
 			if err != nil {
 				log.Println("Failed to get nearby Spots:", err.Error())
 				continue
@@ -229,15 +230,16 @@ func (z *App) updateMapboxDataSet(ctx context.Context, request events.APIGateway
 	var lastKey string
 	allSpots := []common.Spot{}
 	for {
-		spots, lastKey, err := common.GetAllSpots(ctx, lastKey, z.db, z.tableName)
+		spots, nextKey, err := common.GetAllSpots(ctx, lastKey, z.db, z.tableName)
 		if err != nil {
 			log.Println("Error loading all spots", err.Error())
 			break
 		}
 		allSpots = append(allSpots, spots...)
-		if lastKey == "" {
+		if nextKey == "" {
 			break
 		}
+		lastKey = nextKey
 	}
 
 	log.Println("Did fetch spots", len(allSpots))
@@ -264,7 +266,98 @@ func (z *App) updateMapboxDataSet(ctx context.Context, request events.APIGateway
 
 }
 
+// backfillRatings recomputes the rating aggregates of every spot from its reviews and sets the
+// ranks the ordered review lists sort by
+func (z *App) backfillRatings(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	log.Println("backfillRatings")
+	nextKey, failed, err := z.forEachSpot(ctx, request.QueryStringParameters[JobStartKeyParameter], func(spot common.Spot) error {
+		err := common.RecomputeRatingAggregates(ctx, spot, z.db, z.tableName)
+		if err != nil {
+			return err
+		}
+		return common.BackfillReviewRanks(ctx, spot, z.db, z.tableName)
+	})
+	if err != nil {
+		log.Println("Error loading all spots", err.Error())
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+		}, err
+	}
+
+	log.Println("Did backfill ratings, failed spots:", failed)
+	return jobResponse(failed, nextKey), nil
+}
+
+// migrateSpotDistances regenerates the distances of every spot under the SpotDistance#<destination>
+// keys and deletes the rows of the old SpotDistances key, which are not read anymore
+func (z *App) migrateSpotDistances(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	log.Println("migrateSpotDistances")
+	nextKey, failed, err := z.forEachSpot(ctx, request.QueryStringParameters[JobStartKeyParameter], func(spot common.Spot) error {
+		err := common.CreateSpotDistances(ctx, spot, z.db, z.tableName, z.mapboxClient)
+		if err != nil {
+			return err
+		}
+		return common.DeleteLegacySpotDistance(ctx, spot.SpotId(), z.db, z.tableName)
+	})
+	if err != nil {
+		log.Println("Error loading all spots", err.Error())
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+		}, err
+	}
+
+	log.Println("Did migrate spot distances, failed spots:", failed)
+	return jobResponse(failed, nextKey), nil
+}
+
+// forEachSpot calls f with every spot from startKey on, page by page, until all spots are done or
+// the invocation is about to time out. Spots that fail are logged and counted. The returned key
+// continues the walk in the next run, it is empty once every spot is done.
+func (z *App) forEachSpot(ctx context.Context, startKey string, f func(spot common.Spot) error) (string, int, error) {
+
+	failed := 0
+	for {
+		spots, nextKey, err := common.GetAllSpots(ctx, startKey, z.db, z.tableName)
+		if err != nil {
+			return "", failed, err
+		}
+		for i := range spots {
+			err := f(spots[i])
+			if err != nil {
+				log.Println("Failed to process spot:", spots[i].SpotId(), err.Error())
+				failed++
+			}
+		}
+		if nextKey == "" {
+			return "", failed, nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < JobTimeMargin {
+			return nextKey, failed, nil
+		}
+		startKey = nextKey
+	}
+}
+
+// jobResponse reports the failed spots and the key the next run starts from
+func jobResponse(failed int, nextKey string) events.APIGatewayProxyResponse {
+	return events.APIGatewayProxyResponse{
+		Body:       fmt.Sprintf(`{"failed":%d,"nextKey":"%s"}`, failed, nextKey),
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		},
+	}
+}
+
 func (z *App) handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	switch request.QueryStringParameters[JobParameter] {
+	case JobBackfillRatings:
+		return z.backfillRatings(ctx, request)
+	case JobMigrateSpotDistances:
+		return z.migrateSpotDistances(ctx, request)
+	}
 	return z.updateMapboxDataSet(ctx, request)
 }
 
@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -158,6 +159,135 @@ func TestHandler(t *testing.T) {
 	}
 }
 
+func TestBackfillRatings(t *testing.T) {
+
+	spot := map[string]*dynamodb.AttributeValue{
+		"PK": {S: aws.String("Spot#a")},
+		"SK": {S: aws.String("Spot#xn0000000000")},
+	}
+	review := func(id string, rating string) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{
+			"PK": {S: aws.String("Spot#a")},
+			"SK": {S: aws.String("Review#" + id)},
+		}
+		if rating != "" {
+			item["Rating"] = &dynamodb.AttributeValue{N: aws.String(rating)}
+		}
+		return item
+	}
+	quiet := review("1", "5")
+	quiet["Quietness"] = &dynamodb.AttributeValue{N: aws.String("4")}
+	noisy := review("2", "3")
+	noisy["Quietness"] = &dynamodb.AttributeValue{N: aws.String("1")}
+
+	aggregates, ranks := map[string]string{}, map[string]string{}
+	app := App{
+		db: &mockDbClient{
+			QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				if in.IndexName != nil {
+					return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spot}}, nil
+				}
+				// a review without a rating counts as a review but not in the average
+				return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{
+					quiet, noisy, review("3", "5"), review("4", ""),
+				}}, nil
+			},
+			UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+				if strings.HasPrefix(*in.Key["SK"].S, "Review#") {
+					ranks[*in.Key["SK"].S] = *in.ExpressionAttributeValues[":HelpfulRank"].S + " " + *in.ExpressionAttributeValues[":RatingRank"].S
+					return &dynamodb.UpdateItemOutput{}, nil
+				}
+				require.Equal(t, "Spot#xn0000000000", *in.Key["SK"].S)
+				for name, value := range in.ExpressionAttributeValues {
+					aggregates[name] = *value.N
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			},
+		},
+	}
+
+	request := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{JobParameter: JobBackfillRatings}}
+	resp, err := app.handler(context.Background(), request)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, `{"failed":0,"nextKey":""}`, resp.Body)
+	require.Equal(t, map[string]string{
+		":ReviewCount":            "4",
+		":RatingSum":              "13",
+		":RatingCount1":           "0",
+		":RatingCount2":           "0",
+		":RatingCount3":           "1",
+		":RatingCount4":           "0",
+		":RatingCount5":           "2",
+		":QuietnessSum":           "5",
+		":QuietnessCount":         "2",
+		":QuietnessAverage":       "2.5",
+		":ToiletCleanlinessSum":   "0",
+		":ToiletCleanlinessCount": "0",
+		":FlatnessSum":            "0",
+		":FlatnessCount":          "0",
+		":SafetySum":              "0",
+		":SafetyCount":            "0",
+	}, aggregates)
+	require.Equal(t, map[string]string{
+		"Review#1": "0.0000000000#1 5#1",
+		"Review#2": "0.0000000000#2 3#2",
+		"Review#3": "0.0000000000#3 5#3",
+		"Review#4": "0.0000000000#4 0#4",
+	}, ranks)
+}
+
+func TestMigrateSpotDistances(t *testing.T) {
+
+	spot := func(id string) map[string]*dynamodb.AttributeValue {
+		return map[string]*dynamodb.AttributeValue{
+			"PK":        {S: aws.String("Spot#" + id)},
+			"SK":        {S: aws.String("Spot#xn0000000000")},
+			"GSI2":      {S: aws.String("spots")},
+			"Latitude":  {N: aws.String("35")},
+			"Longitude": {N: aws.String("137")},
+		}
+	}
+
+	deleted := []string{}
+	app := App{
+		db: &mockDbClient{
+			QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				if in.ExpressionAttributeValues[":sk"] != nil {
+					// no spots nearby, there are no distances to load
+					return &dynamodb.QueryOutput{}, nil
+				}
+				if in.ExclusiveStartKey == nil {
+					return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spot("a")}, LastEvaluatedKey: spot("a")}, nil
+				}
+				require.Equal(t, "Spot#a", *in.ExclusiveStartKey["PK"].S)
+				return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spot("b")}}, nil
+			},
+			DeleteItemFunc: func(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+				deleted = append(deleted, *in.Key["PK"].S+" "+*in.Key["SK"].S)
+				return &dynamodb.DeleteItemOutput{}, nil
+			},
+		},
+	}
+
+	// the first run stops after a page, it is about to time out
+	ctx, cancel := context.WithTimeout(context.Background(), JobTimeMargin/2)
+	defer cancel()
+	request := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{JobParameter: JobMigrateSpotDistances}}
+	resp, err := app.handler(ctx, request)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	nextKey, _ := common.EncodeCursor(spot("a"))
+	require.Equal(t, fmt.Sprintf(`{"failed":0,"nextKey":"%s"}`, nextKey), resp.Body)
+	require.Equal(t, []string{"Spot#a SpotDistances"}, deleted)
+
+	request.QueryStringParameters[JobStartKeyParameter] = nextKey
+	resp, err = app.handler(context.Background(), request)
+	require.Nil(t, err)
+	require.Equal(t, `{"failed":0,"nextKey":""}`, resp.Body)
+	require.Equal(t, []string{"Spot#a SpotDistances", "Spot#b SpotDistances"}, deleted)
+}
+
 type mockS3Client struct {
 	s3iface.S3API
 	GetObjectFunc func(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
@@ -169,8 +299,18 @@ func (m *mockS3Client) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, er
 
 type mockDbClient struct {
 	dynamodbiface.DynamoDBAPI
-	PutItemFunc func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
-	QueryFunc   func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+	PutItemFunc    func(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+	QueryFunc      func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+	UpdateItemFunc func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+	DeleteItemFunc func(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error)
+}
+
+func (m *mockDbClient) DeleteItem(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	return m.DeleteItemFunc(in)
+}
+
+func (m *mockDbClient) UpdateItem(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return m.UpdateItemFunc(in)
 }
 
 func (m *mockDbClient) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
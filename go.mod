@@ -7,13 +7,14 @@ require (
 	github.com/aws/aws-lambda-go v1.20.0
 	github.com/aws/aws-sdk-go v1.35.33
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/graph-gophers/graphql-go v0.0.0-20201113091052-beb923fada29
+	github.com/graph-gophers/graphql-go v1.7.0
 	github.com/grpc-ecosystem/go-grpc-middleware v1.2.2
 	github.com/lestrrat-go/jwx v1.0.5
 	github.com/mmcloughlin/geohash v0.10.0
-	github.com/opentracing/opentracing-go v1.1.0
+	github.com/opentracing/opentracing-go v1.2.0
 	github.com/prometheus/common v0.7.0
 	github.com/satori/go.uuid v1.2.0
-	github.com/stretchr/testify v1.6.1
+	github.com/stretchr/testify v1.7.1
+	github.com/vektah/gqlparser/v2 v2.5.1
 	go.uber.org/zap v1.16.0
 )
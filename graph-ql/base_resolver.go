@@ -7,9 +7,10 @@ import (
 )
 
 type QueryRequest struct {
-	Query     string                 `json:"query"`
-	OpName    string                 `json:"opName"`
-	Variables map[string]interface{} `json:"variables"`
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    *QueryExtensions       `json:"extensions"`
 }
 
 type Resolver struct {
@@ -18,4 +19,5 @@ type Resolver struct {
 	Db           dynamodbiface.DynamoDBAPI
 	TableName    string
 	MapboxClient common.MapboxClient
+	Publisher    *Publisher
 }
@@ -79,27 +79,116 @@ func (fi bindataFileInfo) Sys() interface{} {
 }
 
 var _bindataSchemagraphql = []byte(
-	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\x55\x4d\x8f\xda\x30\x10\xbd\xfb\x57\x0c\xea\x05\x24\x7e\x41\x6e\x6d" +
-	"\x51\xb7\x48\xa5\xda\x2e\x70\xaa\x38\x58\xc9\x6c\xb0\x9a\xd8\xa9\x3d\x69\x85\xaa\xfd\xef\x95\xed\x04\x8f\x43\x10" +
-	"\xed\x85\xc4\xcf\xf3\xe5\xf7\x9e\x89\x2b\xcf\xd8\x4a\xf8\x23\x00\x7e\xf6\x68\x2f\x05\x7c\xf3\x0f\x01\xd0\xf6\x24" +
-	"\x49\x19\x5d\xc0\x6e\x78\x13\x6f\x42\xd0\xa5\xc3\x18\x12\x72\x5c\x67\x68\xe9\x7f\xb6\x55\x01\x7b\xb2\x4a\xd7\x8b" +
-	"\x55\x01\xfb\xce\xd0\x62\xd8\x76\x1f\x2e\x4f\x68\xce\xd2\x9d\x97\x75\x7c\x5e\x23\xd7\x21\xe0\x70\xe9\xd0\x15\xf0" +
-	"\x3d\x82\xa7\x95\x7f\xed\x0c\x9d\x7c\x81\x7d\x2c\xf0\xd1\xa2\x24\x63\x97\x65\x7c\xb2\x66\x8f\x4b\x58\xfc\xa5\xf0" +
-	"\xb7\x9b\x4c\xb9\x86\xde\xa1\xe5\xeb\x46\x3a\x7a\x09\xb1\x09\xf5\x85\x22\x16\x4a\xf9\x94\x65\x9e\xe7\x4f\x7b\x74" +
-	"\x68\x17\x57\x72\x46\xb6\x02\x3f\x61\x60\xf4\xb3\x8c\xb3\x1f\xf3\xf4\x35\xd4\x06\xef\x90\xc2\xa0\x46\x92\xa2\xbe" +
-	"\xc2\x02\x3e\x35\x46\x92\x47\x8c\xae\x27\x90\x96\x2d\xa6\xf3\xc8\xaa\xb2\xe8\x5c\x02\x4a\x53\xb1\xed\xce\xe2\x2b" +
-	"\x96\xd4\x5b\x86\x95\x8a\x2e\x69\x75\x36\x2d\x3e\xcb\x1a\x8f\xb6\x49\xdc\x2e\x4e\x6b\x20\x59\x73\x80\xe9\x1d\x8f" +
-	"\x1b\x19\x9b\xda\xe2\x96\xf1\x16\x9d\x93\x35\xeb\x6f\x25\x29\x5d\x17\xb0\xd5\xb4\x2a\x20\x96\xf1\x65\xdf\x31\x1e" +
-	"\xb7\xad\xac\xf1\x71\x6d\xd5\xb2\xca\xc3\x84\x21\x35\x09\xe5\xa1\x20\xd2\x3e\x2f\x26\x00\x9e\x26\x46\x1d\x82\x32" +
-	"\x51\x04\xc0\x97\x89\x2a\x1e\x9a\xca\x22\x00\x82\x7b\x95\xd1\x07\xd5\x66\xe9\xf1\x80\x2e\x79\x6c\x68\xb3\x51\x8e" +
-	"\xa4\x2e\xa3\xa3\xd9\xda\xef\x87\x33\x8c\x1b\x61\x71\x1a\x3b\xf0\x7b\x91\xb0\x68\x4f\x01\xf0\x95\xb9\x43\x00\x6c" +
-	"\xd0\x95\x56\x75\xf1\x86\x5f\xd1\xf7\xb9\x69\x7c\x19\xe6\x1a\x01\xf0\x7c\x63\x1b\x1f\xc3\x7c\x23\x00\x3e\xcf\x1b" +
-	"\x47\x00\x1c\x72\xe7\x84\x31\x5e\x65\xdf\xc4\x93\x1c\x6d\x73\xad\x32\x8a\x14\x99\x09\x32\x4d\x2f\xe7\x62\x56\xba" +
-	"\x7b\x64\xe7\xf7\x6e\x00\xae\xe4\xbc\x24\xe7\x09\x80\x5d\x6e\xcc\xcc\x31\xa3\x18\x77\x9c\xb3\x41\x47\x4a\x87\x09" +
-	"\xfe\x7d\xb6\xb1\xe6\x0e\x09\xad\x1b\x9c\xc3\xf0\x3d\x96\x46\x57\x7c\x23\x75\xb9\x95\x95\xf7\xe7\x8e\xcd\x77\x6f" +
-	"\x18\xcf\x76\xe7\xdc\xc1\x59\x08\xd9\x89\x02\xbf\x7a\x24\xcc\xb4\xe1\xac\x28\xf3\x0c\x8d\x9d\x7d\x78\x68\x3a\xf9" +
-	"\x13\xf5\xee\x56\xe5\x0f\x9d\x53\xf1\x3f\xd7\x2e\xc4\x62\x15\x3e\x37\xe3\xc7\x43\xbc\x89\xbf\x01\x00\x00\xff\xff" +
-	"\x68\xb8\x71\x92\x1e\x07\x00\x00")
+	"\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xcc\x5a\x5f\x6f\xdc\x38\x0e\x7f\xf7\xa7\x60\x90\x97\x2c\x30\x0d\xb2\x8b" +
+	"\x5b\xe0\x30\x6f\x69\x9a\xb6\xb9\xdb\xb4\x69\x26\x69\x51\x14\x7d\x50\x6c\xce\x58\x88\x2d\xb9\x92\x3c\xc9\xdc\xa1" +
+	"\xdf\xfd\x20\x52\xb2\xe5\x3f\x93\xa6\x87\x7d\x28\x16\xd8\x8e\x69\x89\xa4\x7e\xa4\xf8\xcf\xb1\x79\x89\xb5\x80\xff" +
+	"\x66\x00\xdf\x5a\x34\xbb\x25\x7c\xf0\xff\x64\x00\x75\xeb\x84\x93\x5a\x2d\xe1\x32\xfc\xca\x00\x6c\x7b\x67\x73\x23" +
+	"\x1b\x7e\xb1\x4a\x9e\xb2\xef\x59\xe6\x76\x0d\xf2\x7e\x62\x68\x1b\xed\x8e\xfc\xff\x2e\x8a\x25\xac\x9c\x91\x6a\x73" +
+	"\xf0\xdb\x12\x56\x8d\x76\x07\x19\xc0\x21\x2d\xb0\x2f\x77\x6f\x50\x97\xc2\x96\x20\x54\x41\x2f\xed\xcb\xdd\x99\x41" +
+	"\xe1\xb4\x81\x46\x6c\x10\x5c\x69\x74\xbb\xf1\xef\x41\xaa\x02\x1f\x41\x2a\xb8\xc7\x1d\x68\x53\xa0\xa1\x5d\xa5\xd8" +
+	"\x22\x28\xcd\x94\x97\xbb\x05\x08\xc8\x5b\x63\xb5\x21\x31\x7a\xed\xb7\xf2\xea\xbb\x1d\x18\xe1\xa4\xda\xc0\x83\x6e" +
+	"\xab\x02\x14\x62\xd1\x33\xb6\xda\x38\x2c\x92\x45\x95\xbc\xf7\xf2\x11\xb4\x42\xeb\x19\xf9\xdf\x06\xb7\x12\x1f\x6c" +
+	"\x06\xa3\x03\x1c\x6d\xf8\xdf\xee\xb0\x0b\x5a\x70\xb3\x6b\xd0\x2e\xe1\xcb\x2a\xfc\x3e\xf8\xba\x00\x51\x55\x37\x62" +
+	"\x43\x54\x5e\xea\x69\x6a\x37\xa1\xd5\x52\xad\xda\xbb\x6b\x52\xc6\x2e\xe1\x32\x7d\xbc\x50\x4d\xeb\x16\xb0\x96\xc6" +
+	"\xba\x25\x5c\x28\xb7\x00\xb1\x76\x68\xa2\xf8\x00\xf5\x99\x56\x0a\x73\x6f\x22\x0f\xfa\x10\xdf\xa3\x9c\xff\xbd\x28" +
+	"\x7e\x65\x9d\x0f\xc1\x89\x8d\x05\x69\x09\x7d\x2b\x6a\x04\x61\xa3\x3a\x0b\x78\x90\xae\xd4\xad\x8b\xc6\x67\xa3\xbc" +
+	"\x43\x61\xc0\xa0\x6b\x8d\xe2\x6d\x79\xa5\x2d\x5a\xc7\x6f\x59\x81\x63\xf8\x24\x5d\xc9\x2e\x12\xf6\xe2\xd6\x3b\xaf" +
+	"\x5f\x03\x52\xb1\xb1\x45\x21\x5b\x12\x6e\x84\xba\xf7\xce\x81\x6b\x6d\xd8\x29\x2a\x59\x4b\xe7\x5f\x89\xa6\xa9\x24" +
+	"\x16\x19\xf4\xc2\x8f\x2a\xe1\xa4\x6b\x0b\x5c\xc2\xeb\x4a\x0b\x77\xb0\x80\x4a\xab\xcd\x88\xc4\xdc\x2f\xd1\xa1\xb1" +
+	"\xc9\x42\xcf\x37\xc0\xb3\xcf\x1a\x6e\x62\x8a\xbf\xcf\x3c\x01\x0d\xb6\xc5\x7b\x7e\xf8\x2d\xc8\xff\xca\x16\x11\x0e" +
+	"\x6a\x6d\x5d\x80\x80\x4e\xbd\x80\x3f\x4e\xfc\xd5\x29\x70\x2d\xda\xca\xd1\xc5\x6c\x1b\x70\x1a\x7e\x3f\x39\x89\xd0" +
+	"\x5c\xa8\x6b\xdc\x48\xad\x8e\xee\x74\xab\x0a\xa9\x36\x2f\xf5\xe3\x12\x5e\xf6\x0f\x41\x85\x46\x57\xbb\x8d\x56\x4b" +
+	"\xb8\xe2\x1f\x81\xfc\xcb\x23\xc3\xb1\xe1\x88\xff\x19\xc6\xbd\x6b\xa2\x85\xc8\x87\x0e\x50\xba\x12\x0d\x70\x80\x04" +
+	"\x6d\xa0\xb5\x68\x2e\x8a\xa9\x43\x27\x31\x87\x62\x19\x6d\x01\x61\x10\x74\x55\x78\x9f\x26\x6f\x26\xbc\xfd\x6b\x12" +
+	"\xe0\x79\x81\xc2\x87\xee\xf5\x31\x54\xc2\xba\xeb\xa0\x17\x39\xad\xa2\xfd\x06\x1e\xc4\x0e\x9c\xa6\x58\x1b\xae\x23" +
+	"\x38\x71\x8f\x16\x1a\x83\x39\x16\xa8\x72\xec\x4e\x66\x47\x01\x7d\x11\xb4\xee\x9f\x53\x31\x3d\x75\xef\x8d\x4f\x30" +
+	"\xe5\x5d\xef\x63\x0c\x5f\xcb\x8a\xd6\x31\xf9\x35\x3d\x91\x19\x3a\x2c\x87\x61\xc2\x2b\x72\x34\xd4\xc6\xc3\x7e\x6b" +
+	"\xd1\x1c\x70\x7a\xb3\x7e\xe9\x51\xfc\x31\x5c\xf6\x21\x50\x0f\xba\x44\x16\xd3\x1e\xe5\xb2\x43\x0e\x22\x21\x2d\x85" +
+	"\x50\x64\x90\x78\x91\xe4\x05\x51\x42\x06\xf0\x0b\x72\x5d\x37\xad\xc3\x02\xd6\x46\xd7\xbc\x5b\x6b\x53\x48\x25\x1c" +
+	"\xda\x0c\x98\x17\x7a\xc7\x39\x92\xfe\x54\x4b\x38\xeb\x28\x74\xcc\x61\xae\xd4\xaa\xda\x0d\x74\x08\xc9\x88\x7c\x41" +
+	"\x1b\x6f\x4d\x51\xd4\x52\x41\x2e\x14\xe4\xa5\x50\x1b\xf4\xe4\x02\x2b\x74\x08\xd2\x79\x80\x9a\x22\x4a\x1c\x65\xe5" +
+	"\x05\x34\xc2\xe5\xe5\x12\x6e\x9b\x62\x9f\x0a\xcc\x69\x35\x9f\xd3\x5f\x6a\x5d\xa1\x88\xd1\xba\x44\x10\xad\x2b\xf7" +
+	"\x01\x25\xe8\x5f\x52\x94\x9d\x2a\xba\xb4\x56\x39\x2e\x62\xea\xf5\xee\x6c\xdb\xbb\x17\xfc\x68\xc9\xdf\x7f\x07\xa7" +
+	"\xe1\xcf\x63\x92\xb2\x95\x56\xba\x57\xc2\x1f\xce\xc2\xe7\xcf\x9f\x3f\xbf\xb8\xbc\x7c\xf1\xea\x15\xed\x53\xda\xb1" +
+	"\x97\x81\xd3\xb5\x36\x46\x3f\xf8\x13\x1a\xb7\x5b\xc9\xff\xd0\x7a\x66\x74\xd2\x99\x81\xfd\x69\x0a\x0b\x0b\x27\xbf" +
+	"\x3d\x58\x40\x8d\xd6\x8a\x0d\xf6\xae\x6b\x93\x70\x31\x89\x15\x9d\x82\xfd\xfa\x2d\x96\x32\xaf\xf0\xac\x12\xd6\x2e" +
+	"\xe1\x63\xf2\xb4\x00\xbd\x45\xa3\xe4\xa6\x74\x1d\x9a\x89\xce\xa4\xc1\x28\x86\x74\x1e\x11\xb0\x4e\x0c\x2f\x02\xb0" +
+	"\x8b\xf4\xfd\xd8\x49\x3a\xd7\x60\x3c\x57\x29\xd6\xaa\x60\xf5\x61\x2d\xb1\x2a\xbc\x11\x05\x47\x1c\x8f\xac\x45\x07" +
+	"\xf7\x88\x8d\x67\x2e\x0d\x6c\x45\xd5\x62\xe7\x5e\xd7\x7b\x02\xe0\x00\xcb\x5f\x0d\x4a\x8f\xd2\x56\x3b\xb4\x0b\x30" +
+	"\xe8\x53\x39\x43\x20\x6b\xb1\x19\x97\x7d\x84\x02\x43\x57\x70\x54\x5b\x80\x5c\x33\x40\x6b\x21\x2b\x9b\xae\x95\x96" +
+	"\xd8\xdf\x63\xc3\xe1\x99\xf6\x79\xe7\x96\x0e\xc4\x46\x48\x05\x6b\xa9\xa4\x2d\x91\x76\xd5\xdd\x2d\xbb\xde\x9f\x45" +
+	"\x06\x37\x4d\x2b\xd6\x1b\x1a\xe4\xf4\x41\x52\xa2\xed\xb7\x9a\x2f\x12\x09\x62\xcf\xb0\xdd\x51\x8f\xe1\x94\xdc\xc2" +
+	"\x7a\x5f\xf0\x56\x25\x3e\x5a\x05\xa3\xf6\x35\xee\x56\xef\xd7\x67\x01\x25\x56\xcd\xba\xad\x7a\xbd\x06\xc8\xa6\xa7" +
+	"\xf9\xa8\x1d\xfe\x30\x2f\x76\xe8\x27\x20\xfb\x74\x98\x80\x4a\xa9\xa8\xa9\x76\x37\x7a\xbf\x56\x23\xef\xea\xa5\x5c" +
+	"\xfb\x9d\xcf\x8b\x52\x31\x4d\xb0\x32\xb1\xde\xf9\xfd\xe4\xe4\x84\x40\x16\xca\x3e\xa0\xb1\xf0\x87\x27\xe4\xa5\x30" +
+	"\x22\x77\x68\x3c\x60\xc2\xde\xc7\x64\x32\x0d\x28\x33\x9a\xf5\x99\x07\x02\xd7\x0f\x4f\xa4\xaa\x59\x16\xa7\xb4\x6d" +
+	"\x3e\x2c\x04\xef\x8d\xac\xe8\xea\x8b\x3c\x67\x97\x0c\x02\x17\x81\xc2\x71\x57\x53\x5d\xa2\x15\x12\xd0\x22\x47\xcb" +
+	"\xf9\x83\xd7\xb0\xac\x79\xd5\x98\xdb\xbe\xc4\xca\x06\xe6\x92\x5c\x40\x63\xd0\xca\x8d\xc2\x02\x5a\x53\x2d\xe0\xea" +
+	"\xf6\x86\xf4\xa4\x3b\x07\x4e\x83\x74\xbd\xe5\xa9\xe6\x3f\xd3\xca\xa1\x72\x2f\x7c\x9d\xe7\xa9\x0a\x72\xad\xd6\xd2" +
+	"\xd4\xac\x5d\xb0\x1e\xe5\x2e\xcf\xe3\xb6\xa9\xb4\x28\xa6\x16\xc8\x99\x8d\xe7\x32\xee\x4a\x93\x7d\x5e\xdb\xc0\xbe" +
+	"\x7b\x33\xe5\x65\xe3\xab\x69\x87\x4b\x64\x3e\x73\x53\x6a\xa7\x43\xe9\x16\x02\xc3\x83\x36\xf7\xdc\x5c\x72\xaf\xe1" +
+	"\x17\xdb\xc5\xc4\x76\x64\xab\xa2\xa0\xe0\xc0\x5e\x17\x19\x94\xbe\x03\x72\xc4\x9e\xfc\xf2\xcf\x63\xb8\x29\x71\x47" +
+	"\xbe\x3a\x0c\x5b\x24\xc0\x69\xcd\xfb\x7f\x70\xaf\x08\xc2\x50\xbd\x25\x20\xce\x5c\xb1\x9f\x86\x31\xe1\x3a\xc7\xef" +
+	"\xc7\x50\x7e\xcf\x32\x5f\x38\x2b\x47\xf1\x9d\x34\x7f\xc0\x3b\xab\xf3\x7b\x74\x80\xaa\x68\xb4\x54\x89\xcf\x6c\x8c" +
+	"\x68\xca\x6f\xd5\x0b\x67\x84\xb2\x8d\x36\xee\xc5\x83\x85\xc6\x68\xa7\x73\x5d\x71\x85\x97\xce\x2f\xa8\xca\x63\xbd" +
+	"\x4e\x8b\x02\x8b\x99\x22\x67\x50\xc0\x53\x1b\xc9\xc5\x03\x15\xef\x1c\x5f\x0b\x90\xca\xca\x02\x03\xae\x1b\xa9\xd5" +
+	"\x82\xbd\x97\x1f\x40\x98\x4d\x5b\xa3\x72\x16\x84\x1d\x76\x44\xa1\x43\xe2\x0a\xac\xf8\xbf\xfa\xa3\xae\x5a\xfb\x9e" +
+	"\x65\xa8\xda\x1a\x62\x5b\x44\x87\xbb\xd6\xa2\x58\xc9\x02\x57\xdd\x34\xe7\x4a\x98\x7b\xa9\x36\x19\xc0\x99\xa8\x9b" +
+	"\x95\x74\xe8\x97\x6d\x3d\x99\xd1\x8e\x3d\x2c\xf9\x4e\xd7\xbe\x92\x17\x7d\x7a\xb3\xfa\xe7\x3f\xa0\xc0\x8d\x41\xef" +
+	"\xb9\xa5\xae\xf1\xca\x9b\xdd\x54\x1c\x30\x4b\xe7\x1a\xd0\x86\xfe\xb5\xfe\x8a\xdb\x8c\xca\xdc\x71\x95\xdb\x4d\x8a" +
+	"\x82\x27\x85\x5f\x1e\xe5\x71\x07\x9d\x41\xaf\x43\x42\x53\xa2\xee\x5d\x90\x92\x4e\x3a\xa2\x22\x72\x06\xfe\x1a\x19" +
+	"\xb4\x36\xa1\xe4\xba\xc0\xe4\xb1\x31\xb8\xc6\xdc\xb5\x26\x25\xe6\xd2\xed\x92\xc7\xf4\x94\x49\xeb\x98\xc1\xb8\xe9" +
+	"\x64\xf8\x9e\x5d\x3c\x31\x32\xa3\xe2\x7b\x0f\x32\x53\x60\x66\x70\x19\xc1\xf2\x0b\xa1\xc2\x47\x1d\x3b\x34\x9d\xb5" +
+	"\x96\xea\xaf\xa9\xc9\x3d\x75\xc6\xea\xb5\x78\x9c\x5b\x2c\x1e\xa7\x8b\xc9\x16\x6f\x50\xff\x6b\xf5\xfe\x5d\xbc\x38" +
+	"\xb0\x41\x5d\xa3\x33\x3b\x7f\x95\xac\xec\x13\xfd\x97\x0e\xca\x45\x87\xf3\xd7\xa0\x75\x7a\xd7\x48\x63\x97\x46\xbf" +
+	"\x0c\xd2\x0e\x6f\x09\x5f\xbe\x7c\x61\x05\xbe\xd2\x7f\x5d\x5f\xe9\x0d\x49\xbb\x57\xc3\x08\x93\x01\xbc\x19\x0d\x10" +
+	"\x29\xd4\xc8\xba\xe1\xb1\x64\x88\x39\x42\xf1\xbc\xd3\x33\x1b\x39\x97\x54\xa9\x9b\xac\x7a\xdf\xe9\xd8\xcd\x60\x36" +
+	"\x87\x2e\x5d\x52\xa9\xd5\x8d\x1c\xde\xad\xc3\xe1\xc8\x61\x34\xa9\xc8\x20\xc4\x48\x7b\xf4\xd3\x4d\xfd\xd3\x43\xc1" +
+	"\xb9\x7e\x9f\x4b\x38\xca\x7e\x06\x73\x54\xae\xda\xf9\xda\x0b\x8b\x58\x87\x70\x60\xe6\xaa\x25\x16\xeb\x19\x74\x05" +
+	"\x89\x3d\x7a\x4a\x62\x5c\x35\x96\xc9\x03\xaf\x30\xe3\xb2\xe0\x34\xfc\x71\xd2\xcd\x30\x06\xf4\xd5\xf9\xd9\xfb\x77" +
+	"\xaf\x56\xc1\x10\xaf\xa4\x75\x42\xe5\x68\x8f\x6a\xf1\xb8\xc2\x5c\xab\x22\x4e\xfc\x16\xde\x6d\x07\x33\xc0\xfd\xc3" +
+	"\xad\xc1\x00\x2a\xf2\xec\xd0\xf5\x97\x1c\xe9\x62\x25\x9d\x4f\x3f\x33\x8b\x93\xaa\xb8\xcf\x5f\x51\x4a\xae\x51\x0a" +
+	"\x3d\x7c\x8d\xf6\x4f\xe7\xc2\x3d\x8d\x27\x2a\x19\xc0\xbb\x61\x80\x79\x35\x1b\x60\x4e\x27\x01\xe6\x6c\x18\x60\xae" +
+	"\xe6\x02\xcc\xd9\x30\xc0\xbc\xdd\x1b\x60\x46\x33\x3c\x52\x83\x2c\xc0\x35\x88\xa9\x12\x2e\x87\xa0\xda\xaa\x82\x87" +
+	"\x52\x56\xd8\x57\x46\xbe\x90\x52\x1a\x0c\xe5\xf1\xbe\xeb\x39\xdd\xa2\x11\x1b\xbc\x0e\x3d\x6b\x0c\xaa\xd1\x0f\x5b" +
+	"\xc5\x88\xf6\x6d\x58\xee\x69\xb0\xd6\x26\xcc\x90\xc3\x14\x83\x86\x3f\x3c\x6d\xf0\xdb\x89\xf8\x56\x5a\xa7\x37\x46" +
+	"\xd4\x4b\xf8\xc2\x14\x62\x78\xf0\xf5\xa0\x17\x9c\x0e\x22\x27\xa4\x10\xd2\x84\x02\xc1\xaf\x40\xda\xf4\x70\xfe\x3c" +
+	"\xa4\xe8\xa0\x06\xe4\x23\x4a\xc7\x51\x68\xc2\x94\x42\xd2\x8d\x96\x15\xba\x33\xef\x38\x95\x54\x64\xb8\x78\xf4\x0f" +
+	"\xad\x44\x37\x24\xbd\xae\xc4\x88\xb2\x12\x6b\x74\xbb\xf8\xcc\x15\x1b\x45\xad\xda\xcf\x97\xb8\x51\x46\x69\xa2\xde" +
+	"\x5d\x6f\x55\xa1\xb0\x8e\x55\x45\xc7\x49\xd1\x2e\xc2\xd6\x18\x64\x92\xe3\x16\x9a\xc2\x1d\x31\x0d\xb1\x79\x3a\xad" +
+	"\xe5\x08\xbd\xff\x40\xdf\xa6\x07\x5a\x4f\x0e\x64\xc7\x07\x22\xf0\x12\xab\x71\x69\x95\x8c\x89\xc8\xc5\x7b\xff\x20" +
+	"\x0c\xae\x4f\x6f\x2e\xde\xbd\x81\xa6\x75\xdc\x69\xde\xa1\x75\xdd\x61\xa2\xa7\xf0\x2c\x61\x78\x66\x13\x6c\xe3\x87" +
+	"\xaa\x7d\x49\x17\xee\x3b\x8b\x26\xd6\x9d\x66\xfe\x9a\x5c\xa8\xb5\xa6\x77\xa5\xb0\xef\xf0\xd1\x5d\x51\xb7\x98\x0c" +
+	"\x0e\x50\x15\x67\xf4\x79\xac\xbb\x1d\x69\x6e\xea\x23\x1e\x31\xc1\xa2\x8f\x0f\xe7\xc5\x06\xd9\x4d\x9b\x20\x67\xd9" +
+	"\x49\x1c\x26\x38\xbf\x92\xb6\xe7\x03\x41\x7e\xab\xe2\x00\x10\x0b\x55\x06\x74\x14\xdf\x07\x92\xf9\xe5\xf3\x64\xf7" +
+	"\x6b\x9f\x94\x1e\x4b\xf9\xe1\x36\x06\x74\xdc\x9b\xcc\x66\xea\x7d\x09\x92\x6b\xb8\x94\xea\x89\x83\xa1\x74\x20\x74" +
+	"\x71\x34\x71\x9e\x0c\xe0\x72\xd8\xdd\x7b\xe1\xb3\x93\x31\x2f\xec\x2d\x0f\x5f\x86\xe1\xe8\x56\x95\x73\xe4\x8f\xe3" +
+	"\x11\x5a\x06\x83\xa9\xd9\x70\x86\x96\x01\xbc\x9f\x0c\xd1\xb8\x63\x48\xa6\x68\x7b\xd3\xc7\xb0\x4c\x20\x50\x69\xae" +
+	"\x73\xf4\xe3\x1c\xef\x57\xee\xd2\xa4\x3b\x34\x11\xbd\x0e\x76\x6a\xaa\xdd\xd0\x28\x73\xa6\x7b\x1a\xfb\x11\xda\x4f" +
+	"\x97\x3e\xd3\x89\x91\x7e\x50\x71\xf2\x9f\xb7\xc6\xa0\x72\x73\xe3\xf9\x63\xb8\xa0\xaf\x84\x54\xfe\x3b\x6d\x92\xde" +
+	"\xbb\x1b\x7a\xed\x16\x60\x35\x48\xd7\xcd\xe9\x1e\x4a\x54\x1d\x87\x8e\x4a\x02\x3d\xec\xf6\xbd\xff\x45\x18\x24\x57" +
+	"\x7b\x06\xab\x27\xef\x14\xad\xf8\x99\x8b\xd5\x6d\x78\xc6\xed\x0a\xb3\xb6\xe4\xef\x02\x6c\xa7\xc6\x87\xe9\xf4\x68" +
+	"\xf6\x9a\xfd\x6d\xd6\x3b\xcd\x9d\xdc\x4a\xb7\x9b\xd0\xa9\x52\x1c\x5e\x95\xd3\x50\x31\x9e\x8e\xa6\x59\x73\x8e\xcd" +
+	"\x6b\x9e\x76\xec\x28\x63\xea\xd3\xd3\x22\x73\x60\xa4\xf8\xfa\x79\x16\x4a\x57\x3f\x69\x9e\xe9\xa7\x2e\xd6\x90\x36" +
+	"\x8d\x0f\x7d\xb0\xd7\x5a\x7f\x9b\x6d\x2e\x6c\x44\x7c\xc6\x95\xc7\xe0\x0d\x00\xe2\x97\xcf\x83\xa7\x5f\xfb\x24\x38" +
+	"\x71\x94\x1a\x47\x28\x69\x54\xa4\x8d\xff\x46\x79\x26\x0c\xff\xf8\x48\x41\x91\x1f\x2f\xa5\x92\x5b\x7a\x8e\xd4\xba" +
+	"\x61\x24\xb4\xd3\x26\xdf\xe5\x15\x86\xb2\x2d\x16\x67\x54\xbe\xa0\x05\xb9\x06\xe9\xba\x27\x2e\x1f\x69\x74\xc0\xcd" +
+	"\x9d\xb4\x60\xd1\x2d\xba\x4f\xc0\xb1\x36\xf0\xc1\x81\x97\xa5\xe5\xd0\x71\x76\xe8\x27\x81\xe0\xd3\x10\x97\x57\xa3" +
+	"\x6f\x63\x52\xe5\x55\x6b\xe5\x36\x4e\x1d\x26\x9d\x18\x9d\x52\xcf\x45\xff\xf4\x9b\x0b\xe1\x9f\x82\x43\xd5\x37\x7d" +
+	"\xa8\xc1\xe2\xb5\xd1\x75\xe2\x18\x81\x7a\xab\x9c\x4c\x0b\xf2\x5a\xaa\x49\x3e\xa9\xc5\xe3\x88\x46\x98\xbd\x3d\xff" +
+	"\xeb\xea\xf5\xed\x5f\xf4\x37\x18\xd6\xff\x91\x81\x3f\x7c\xa5\xbd\xd3\xd2\xa0\x2c\x86\xdb\x4f\xb2\xb2\x5a\x81\xcd" +
+	"\xb5\xc1\x48\x0b\xe9\x30\x7e\xe0\x99\xab\xc5\xb2\xc3\x41\x15\x76\x0c\x37\xf1\x13\x50\xab\x06\x9d\x01\x6c\xf4\xe0" +
+	"\xab\x3a\xfb\xc8\xa0\xc7\x25\xf8\x82\xbe\xbe\x4b\x3a\xff\x74\xbe\xba\x19\x14\x6a\x87\x7d\xe9\x49\xdf\xe1\x1d\x10" +
+	"\xd2\x8b\xae\xfc\x8c\x9a\x37\x61\x3e\x17\x87\x95\x3f\xa8\xd4\x2f\xd4\xa8\x4e\xbf\x50\xc3\x2a\xfd\x42\xa5\x35\x7a" +
+	"\x00\x97\xbd\xe0\x99\xb5\x33\x73\xf8\x36\x16\xb1\x1e\x89\xb0\x43\x11\x5d\x55\x18\x1b\xcf\x3d\xe3\x8f\x57\x3e\xcc" +
+	"\x28\x8a\x11\xcf\xaf\xb8\x22\xcf\x41\x03\x9d\xd0\x87\xbd\xf6\x50\xca\xb4\x85\x4d\xe5\xa7\xb3\x93\xe1\xdb\x99\xee" +
+	"\x32\x79\x3b\xdf\x09\x8f\x98\x73\x05\x3c\x98\xd4\x8e\xda\x79\x06\xa9\x1b\x22\x5c\x9e\xdf\x9c\x5f\xaf\x06\x70\x92" +
+	"\x1a\x3d\x96\xa3\xe9\xf9\x2c\xc4\x63\xcd\xfb\x3f\x47\xd1\xde\x2e\xde\xeb\x5a\x9a\xdc\x53\x63\x7c\x87\xa8\xa0\x31" +
+	"\x3a\x47\x6b\xe9\xcf\x9b\x6e\xca\xb6\xbe\x53\x42\x56\xc3\xc3\x5f\x62\x21\xdb\x7a\x48\x9b\xa4\x87\x7d\x06\x5c\xe9" +
+	"\xd6\xe4\xb8\x64\xd5\xf8\xa1\xd7\xca\x37\xd4\xd3\xef\x27\x33\xb5\x5e\x87\x64\xc2\x86\xa1\xb9\x7a\x4f\xd7\xef\xfc" +
+	"\xe3\xc5\xf9\xa7\x29\x7c\xfc\x9d\xe2\x29\x10\x79\xc5\x08\xb3\xb3\x99\xcf\x1f\x19\xc0\xf9\x63\x23\xcd\xcc\x29\xa3" +
+	"\x58\x0f\x0a\xc9\x1a\xa2\xe3\xb7\xbe\x93\xf9\xfd\x68\x66\xbb\xbf\x14\x1d\xc4\xa0\x7e\xea\xf6\xb3\x23\xb4\x33\xfe" +
+	"\x7e\xe1\x0f\xfe\xf4\xe6\xf1\x1f\xe5\x7d\xcf\xfe\x37\x00\x04\xd3\xa0\x4b\x39\x2a\x00\x00")
 
 func bindataSchemagraphqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -118,10 +207,10 @@ func bindataSchemagraphql() (*asset, error) {
 
 	info := bindataFileInfo{
 		name: "schema.graphql",
-		size: 1822,
+		size: 10809,
 		md5checksum: "",
 		mode: os.FileMode(420),
-		modTime: time.Unix(1617459197, 0),
+		modTime: time.Unix(1792218404, 0),
 	}
 
 	a := &asset{bytes: bytes, info: info}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+func pageSize(first *int32) (int, error) {
+	if first == nil {
+		return common.DefaultPageSize, nil
+	}
+	if *first <= 0 || *first > common.MaxPageSize {
+		return 0, apperror.New(apperror.Validation, ErrorInvalidLimit)
+	}
+	return int(*first), nil
+}
+
+type PageInfoResolver struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+// newPageInfo continues from the last evaluated key if there is one, otherwise from the last edge
+func newPageInfo(page common.Page, lastEdgeCursor *string) (*PageInfoResolver, error) {
+	pageInfo := &PageInfoResolver{hasNextPage: page.HasNextPage(), endCursor: lastEdgeCursor}
+	if page.HasNextPage() {
+		cursor, err := common.EncodeCursor(page.LastEvaluatedKey)
+		if err != nil {
+			return nil, err
+		}
+		pageInfo.endCursor = aws.String(cursor)
+	}
+	return pageInfo, nil
+}
+
+func (z PageInfoResolver) HasNextPage(ctx context.Context) bool {
+	return z.hasNextPage
+}
+
+func (z PageInfoResolver) EndCursor(ctx context.Context) *string {
+	return z.endCursor
+}
+
+type SpotEdgeResolver struct {
+	cursor string
+	node   *SpotResolver
+}
+
+func (z SpotEdgeResolver) Cursor(ctx context.Context) string {
+	return z.cursor
+}
+
+func (z SpotEdgeResolver) Node(ctx context.Context) *SpotResolver {
+	return z.node
+}
+
+type SpotConnectionResolver struct {
+	edges    []*SpotEdgeResolver
+	pageInfo *PageInfoResolver
+}
+
+func newSpotConnection(r *Resolver, page common.Page, indexName *string) (*SpotConnectionResolver, error) {
+
+	edges := make([]*SpotEdgeResolver, len(page.Items))
+	var lastCursor *string
+	for index := range page.Items {
+		item := page.Items[index]
+		var spot common.Spot
+		err := dynamodbattribute.UnmarshalMap(item, &spot)
+		if err != nil {
+			return nil, err
+		}
+		cursor, err := common.EncodeCursor(common.ItemKey(item, indexName))
+		if err != nil {
+			return nil, err
+		}
+		edges[index] = &SpotEdgeResolver{cursor: cursor, node: &SpotResolver{spot: &spot, baseResolver: r}}
+		lastCursor = aws.String(cursor)
+	}
+
+	pageInfo, err := newPageInfo(page, lastCursor)
+	if err != nil {
+		return nil, err
+	}
+	return &SpotConnectionResolver{edges: edges, pageInfo: pageInfo}, nil
+}
+
+func (z SpotConnectionResolver) Edges(ctx context.Context) []*SpotEdgeResolver {
+	return z.edges
+}
+
+func (z SpotConnectionResolver) PageInfo(ctx context.Context) *PageInfoResolver {
+	return z.pageInfo
+}
+
+type ReviewEdgeResolver struct {
+	cursor string
+	node   *ReviewResolver
+}
+
+func (z ReviewEdgeResolver) Cursor(ctx context.Context) string {
+	return z.cursor
+}
+
+func (z ReviewEdgeResolver) Node(ctx context.Context) *ReviewResolver {
+	return z.node
+}
+
+type ReviewConnectionResolver struct {
+	edges    []*ReviewEdgeResolver
+	pageInfo *PageInfoResolver
+}
+
+func newReviewConnection(r *Resolver, page common.Page, indexName *string) (*ReviewConnectionResolver, error) {
+
+	edges := make([]*ReviewEdgeResolver, len(page.Items))
+	var lastCursor *string
+	for index := range page.Items {
+		item := page.Items[index]
+		var review Review
+		err := dynamodbattribute.UnmarshalMap(item, &review)
+		if err != nil {
+			return nil, err
+		}
+		cursor, err := common.EncodeCursor(common.ItemKey(item, indexName))
+		if err != nil {
+			return nil, err
+		}
+		edges[index] = &ReviewEdgeResolver{cursor: cursor, node: &ReviewResolver{review: review, baseResolver: r}}
+		lastCursor = aws.String(cursor)
+	}
+
+	pageInfo, err := newPageInfo(page, lastCursor)
+	if err != nil {
+		return nil, err
+	}
+	return &ReviewConnectionResolver{edges: edges, pageInfo: pageInfo}, nil
+}
+
+func (z ReviewConnectionResolver) Edges(ctx context.Context) []*ReviewEdgeResolver {
+	return z.edges
+}
+
+func (z ReviewConnectionResolver) PageInfo(ctx context.Context) *PageInfoResolver {
+	return z.pageInfo
+}
+
+type ReviewReplyEdgeResolver struct {
+	cursor string
+	node   *ReviewReplyResolver
+}
+
+func (z ReviewReplyEdgeResolver) Cursor(ctx context.Context) string {
+	return z.cursor
+}
+
+func (z ReviewReplyEdgeResolver) Node(ctx context.Context) *ReviewReplyResolver {
+	return z.node
+}
+
+type ReviewReplyConnectionResolver struct {
+	edges    []*ReviewReplyEdgeResolver
+	pageInfo *PageInfoResolver
+}
+
+func newReviewReplyConnection(r *Resolver, page common.Page) (*ReviewReplyConnectionResolver, error) {
+
+	edges := make([]*ReviewReplyEdgeResolver, len(page.Items))
+	var lastCursor *string
+	for index := range page.Items {
+		item := page.Items[index]
+		var reply ReviewReply
+		err := dynamodbattribute.UnmarshalMap(item, &reply)
+		if err != nil {
+			return nil, err
+		}
+		cursor, err := common.EncodeCursor(common.ItemKey(item, nil))
+		if err != nil {
+			return nil, err
+		}
+		edges[index] = &ReviewReplyEdgeResolver{cursor: cursor, node: &ReviewReplyResolver{reply: reply, baseResolver: r}}
+		lastCursor = aws.String(cursor)
+	}
+
+	pageInfo, err := newPageInfo(page, lastCursor)
+	if err != nil {
+		return nil, err
+	}
+	return &ReviewReplyConnectionResolver{edges: edges, pageInfo: pageInfo}, nil
+}
+
+func (z ReviewReplyConnectionResolver) Edges(ctx context.Context) []*ReviewReplyEdgeResolver {
+	return z.edges
+}
+
+func (z ReviewReplyConnectionResolver) PageInfo(ctx context.Context) *PageInfoResolver {
+	return z.pageInfo
+}
+
+type QuestionEdgeResolver struct {
+	cursor string
+	node   *QuestionResolver
+}
+
+func (z QuestionEdgeResolver) Cursor(ctx context.Context) string {
+	return z.cursor
+}
+
+func (z QuestionEdgeResolver) Node(ctx context.Context) *QuestionResolver {
+	return z.node
+}
+
+type QuestionConnectionResolver struct {
+	edges    []*QuestionEdgeResolver
+	pageInfo *PageInfoResolver
+}
+
+func newQuestionConnection(r *Resolver, page common.Page, indexName *string) (*QuestionConnectionResolver, error) {
+
+	edges := make([]*QuestionEdgeResolver, len(page.Items))
+	var lastCursor *string
+	for index := range page.Items {
+		item := page.Items[index]
+		var question Question
+		err := dynamodbattribute.UnmarshalMap(item, &question)
+		if err != nil {
+			return nil, err
+		}
+		cursor, err := common.EncodeCursor(common.ItemKey(item, indexName))
+		if err != nil {
+			return nil, err
+		}
+		edges[index] = &QuestionEdgeResolver{cursor: cursor, node: &QuestionResolver{question: question, baseResolver: r}}
+		lastCursor = aws.String(cursor)
+	}
+
+	pageInfo, err := newPageInfo(page, lastCursor)
+	if err != nil {
+		return nil, err
+	}
+	return &QuestionConnectionResolver{edges: edges, pageInfo: pageInfo}, nil
+}
+
+func (z QuestionConnectionResolver) Edges(ctx context.Context) []*QuestionEdgeResolver {
+	return z.edges
+}
+
+func (z QuestionConnectionResolver) PageInfo(ctx context.Context) *PageInfoResolver {
+	return z.pageInfo
+}
+
+type AnswerEdgeResolver struct {
+	cursor string
+	node   *AnswerResolver
+}
+
+func (z AnswerEdgeResolver) Cursor(ctx context.Context) string {
+	return z.cursor
+}
+
+func (z AnswerEdgeResolver) Node(ctx context.Context) *AnswerResolver {
+	return z.node
+}
+
+type AnswerConnectionResolver struct {
+	edges    []*AnswerEdgeResolver
+	pageInfo *PageInfoResolver
+}
+
+func newAnswerConnection(r *Resolver, page common.Page) (*AnswerConnectionResolver, error) {
+
+	edges := make([]*AnswerEdgeResolver, len(page.Items))
+	var lastCursor *string
+	for index := range page.Items {
+		item := page.Items[index]
+		var answer Answer
+		err := dynamodbattribute.UnmarshalMap(item, &answer)
+		if err != nil {
+			return nil, err
+		}
+		cursor, err := common.EncodeCursor(common.ItemKey(item, nil))
+		if err != nil {
+			return nil, err
+		}
+		edges[index] = &AnswerEdgeResolver{cursor: cursor, node: &AnswerResolver{answer: answer, baseResolver: r}}
+		lastCursor = aws.String(cursor)
+	}
+
+	pageInfo, err := newPageInfo(page, lastCursor)
+	if err != nil {
+		return nil, err
+	}
+	return &AnswerConnectionResolver{edges: edges, pageInfo: pageInfo}, nil
+}
+
+func (z AnswerConnectionResolver) Edges(ctx context.Context) []*AnswerEdgeResolver {
+	return z.edges
+}
+
+func (z AnswerConnectionResolver) PageInfo(ctx context.Context) *PageInfoResolver {
+	return z.pageInfo
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 const (
 	SchemaName = "schema.graphql"
 
@@ -10,19 +12,167 @@ const (
 	TableNameEvn  = "DynamoTableName"
 	BucketNameEnv = "S3BucketName"
 
+	QueryMaxDepthEnv   = "QueryMaxDepth"
+	QueryMaxCostEnv    = "QueryMaxCost"
+	QueryFieldCostsEnv = "QueryFieldCosts"
+
+	HandlerEnv              = "Handler"
+	ConnectionsTableNameEnv = "ConnectionsTableName"
+	WebSocketEndpointEnv    = "WebSocketEndpoint"
+
 	// spot statuses
 	SpotStatusOpen     = "open"
 	SpotStatusReserved = "reserved"
 
+	// loader
+	LoaderMaxBatch  = 100 // BatchGetItem reads at most 100 items
+	LoaderWait      = 2 * time.Millisecond
+	LoaderRetryWait = 50 * time.Millisecond
+
+	// query limits
+	QueryDefaultMaxDepth  = 10
+	QueryDefaultMaxCost   = 1000
+	QueryDefaultFieldCost = 1
+
+	// persisted queries
+	PersistedQueryVersion          = 1
+	PersistedQueryCacheSize        = 1000
+	PersistedQueryNotFoundCode     = "PERSISTED_QUERY_NOT_FOUND"
+	PersistedQueryNotSupportedCode = "PERSISTED_QUERY_NOT_SUPPORTED"
+	PersistedQueryVersionCode      = "PERSISTED_QUERY_VERSION_NOT_SUPPORTED"
+	PersistedQueryHashMismatchCode = "PERSISTED_QUERY_HASH_MISMATCH"
+	GetCacheMaxAgeSeconds          = 60
+
+	// http
+	MediaTypeJSON            = "application/json"
+	MediaTypeGraphQLResponse = "application/graphql-response+json"
+	MaxBatchOperations       = 10
+
+	// reviews, tries of a review write while other reviews change the aggregates of the spot
+	maxReviewWriteAttempts = 3
+
+	// spots, tries of a move while reviews or images change the spot item
+	maxSpotMoveAttempts = 3
+
+	// websocket, message types of the graphql-transport-ws protocol
+	WebSocketHandler            = "websocket"
+	WebSocketProtocol           = "graphql-transport-ws"
+	WebSocketConnectionInit     = "connection_init"
+	WebSocketConnectionAck      = "connection_ack"
+	WebSocketPing               = "ping"
+	WebSocketPong               = "pong"
+	WebSocketSubscribe          = "subscribe"
+	WebSocketNext               = "next"
+	WebSocketError              = "error"
+	WebSocketComplete           = "complete"
+	WebSocketConnectionLifetime = 2 * time.Hour // API Gateway closes connections after two hours
+	HubBufferSize               = 100
+
+	// spot input
+	SpotNameMaxLength        = 100
+	SpotDescriptionMaxLength = 2000
+	SpotAddressMaxLength     = 200
+	SpotCodeMaxLength        = 20
+	SpotPrefectureMaxLength  = 20
+	SpotCityMaxLength        = 100
+	SpotMaxHomePageUrls      = 5
+	SpotUrlMaxLength         = 2048
+	SpotMaxTags              = 20
+	SpotTagMaxLength         = 30
+
+	// review input
+	ReviewMinRating        = 1
+	ReviewMaxRating        = 5
+	ReviewMessageMaxLength = 4000
+	ReviewMaxPartySize     = 50
+	ReviewMaxImages        = 5
+	ReplyMessageMaxLength  = 2000
+	VisitDateLayout        = "2006-01-02"
+
+	// question input
+	QuestionMessageMaxLength = 1000
+	AnswerMessageMaxLength   = 2000
+
+	// review orders
+	ReviewOrderByHelpful = "HELPFUL"
+	ReviewOrderByNewest  = "NEWEST"
+	ReviewOrderByRating  = "RATING"
+
+	// image sources
+	ImageSourceSpot   = "SPOT"
+	ImageSourceReview = "REVIEW"
+
+	// spot orders
+	SpotOrderByRating = "RATING"
+
+	// spot distance orders
+	SpotDistanceOrderBySeconds = "SECONDS"
+	SpotDistanceOrderByMeters  = "METERS"
+
 	// errors
 	ErrorUserIsNotAuthenticated    = "ErrorUserIsNotAuthenticated"
 	ErrorUserDoesNotHaveSellerAuth = "ErrorUserDoesNotHaveSellerAuth"
 	ErrorSpotIsAlreadyReserved     = "ErrorSpotIsAlreadyReserved"
+	ErrorInvalidCoordinates        = "ErrorInvalidCoordinates"
+	ErrorInvalidRadius             = "ErrorInvalidRadius"
+	ErrorInvalidLimit              = "ErrorInvalidLimit"
+	ErrorMissingSpotId             = "ErrorMissingSpotId"
+	ErrorSpotIdAndUserId           = "ErrorSpotIdAndUserId"
+	ErrorUserNotFound              = "ErrorUserNotFound"
+	ErrorInvalidContentType        = "ErrorInvalidContentType"
+	ErrorImageTooLarge             = "ErrorImageTooLarge"
+	ErrorUploadQuotaExceeded       = "ErrorUploadQuotaExceeded"
+	ErrorSpotImageNotUploaded      = "ErrorSpotImageNotUploaded"
+	ErrorSpotImageAlreadyConfirmed = "ErrorSpotImageAlreadyConfirmed"
+	ErrorTooManyReviewImages       = "ErrorTooManyReviewImages"
+	ErrorSpotAlreadyExists         = "ErrorSpotAlreadyExists"
+	ErrorUserIsNotSpotCreator      = "ErrorUserIsNotSpotCreator"
+	ErrorReviewNotFound            = "ErrorReviewNotFound"
+	ErrorReviewAlreadyExists       = "ErrorReviewAlreadyExists"
+	ErrorUserIsNotReviewAuthor     = "ErrorUserIsNotReviewAuthor"
+	ErrorUserIsReviewAuthor        = "ErrorUserIsReviewAuthor"
+	ErrorQuestionNotFound          = "ErrorQuestionNotFound"
+	ErrorAnswerNotFound            = "ErrorAnswerNotFound"
+	ErrorUserIsNotQuestionAuthor   = "ErrorUserIsNotQuestionAuthor"
+	ErrorInvalidRating             = "ErrorInvalidRating"
+	ErrorInvalidDate               = "ErrorInvalidDate"
+	ErrorInvalidPartySize          = "ErrorInvalidPartySize"
+	ErrorEmptyValue                = "ErrorEmptyValue"
+	ErrorValueTooLong              = "ErrorValueTooLong"
+	ErrorTooManyValues             = "ErrorTooManyValues"
+	ErrorInvalidUrl                = "ErrorInvalidUrl"
+	ErrorQueryTooComplex           = "ErrorQueryTooComplex"
+	// clients of the persisted query protocol check for these two messages
+	ErrorPersistedQueryNotFound     = "PersistedQueryNotFound"
+	ErrorPersistedQueryNotSupported = "PersistedQueryNotSupported"
+	ErrorPersistedQueryVersion      = "ErrorPersistedQueryVersion"
+	ErrorPersistedQueryHashMismatch = "ErrorPersistedQueryHashMismatch"
+	ErrorOperationNotAllowed        = "ErrorOperationNotAllowed"
+	ErrorInvalidRequest             = "ErrorInvalidRequest"
+	ErrorInvalidBatch               = "ErrorInvalidBatch"
+	ErrorInvalidIdToken             = "ErrorInvalidIdToken"
+	ErrorUnsupportedMediaType       = "ErrorUnsupportedMediaType"
+	ErrorNotAcceptable              = "ErrorNotAcceptable"
+	ErrorConnectionGone             = "ErrorConnectionGone"
 
 	// prefixes
 	SpotPrefix   = "Spot#"
 	UserPrefix   = "User#"
 	ReviewPrefix = "Review#"
+	// marks that a user reviewed a spot, it must not start with the review prefix
+	ReviewUserPrefix = "ReviewUser#"
+	// votes on a review, Vote#<reviewId>#<userId> in the partition of the spot
+	ReviewVotePrefix = "Vote#"
+	// replies to a review, Reply#<reviewId>#<replyId> in the partition of the spot
+	ReviewReplyPrefix = "Reply#"
+
+	PersistedQueryPrefix = "PersistedQuery#"
+
+	ConnectionPrefix       = "Connection#"
+	SubscriptionPrefix     = "Subscription#"
+	TopicPrefix            = "Topic#"
+	ReviewAddedTopicPrefix = "reviewAdded#"
+	SpotUpdatedTopicPrefix = "spotUpdated#"
 
 	// keys
 	PKKey           = "PK"
@@ -34,6 +184,7 @@ const (
 	LongitudeKey    = "Longitude"
 	CreatorIdKey    = "CreatorId"
 	CreationTimeKey = "CreationTime"
+	UpdateTimeKey   = "UpdateTime"
 	NameKey         = "Name"
 	DescriptionKey  = "Description"
 	AddressKey      = "Address"
@@ -44,4 +195,33 @@ const (
 	TagsKey         = "Tags"
 	MessageKey      = "Message"
 	RatingKey       = "Rating"
+	ReviewCountKey  = "ReviewCount"
+	RatingSumKey    = "RatingSum"
+
+	// review votes
+	HelpfulKey        = "Helpful"
+	HelpfulCountKey   = "HelpfulCount"
+	UnhelpfulCountKey = "UnhelpfulCount"
+
+	// review visits
+	VisitDateKey    = "VisitDate"
+	VehicleClassKey = "VehicleClass"
+	OvernightKey    = "Overnight"
+	PartySizeKey    = "PartySize"
+
+	// review photos
+	ImageCountKey = "ImageCount"
+	ReviewIdKey   = "ReviewId"
+
+	// review deletion
+	DeletingKey = "Deleting"
+
+	// questions
+	ActivityTimeKey     = "ActivityTime"
+	AnswerCountKey      = "AnswerCount"
+	AcceptedAnswerIdKey = "AcceptedAnswerId"
+	AcceptedKey         = "Accepted"
+
+	DefaultImageUrlKey = "DefaultImageUrl"
+	NicknameKey        = "Nickname"
 )
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+// maskErrors gives every error of an executed request a code. Errors without one would leak
+// dynamodb or mapbox details, they are logged and replaced by ErrorInternal.
+func maskErrors(ctx context.Context, resp *graphql.Response) {
+
+	for _, err := range resp.Errors {
+		// documents that do not parse or validate are not executed, a subscription whose
+		// resolver fails has no data either
+		if len(resp.Data) == 0 && err.ResolverError == nil {
+			setErrorCode(err, apperror.Validation)
+			continue
+		}
+
+		// errors that are not returned by a resolver are recovered panics or nil values for non null fields
+		appErr := apperror.From(err.ResolverError)
+		if appErr.Code == apperror.Internal || appErr.Code == apperror.UpstreamUnavailable {
+			logError(ctx, "Failed to resolve field", "maskErrors", appErr.Err, map[string]interface{}{
+				"path":    err.Path,
+				"message": err.Message,
+				"code":    appErr.Code,
+			})
+		}
+		err.Message = appErr.Message
+		setErrorCode(err, appErr.Code)
+	}
+}
+
+func setErrorCode(err *gqlerrors.QueryError, code apperror.Code) {
+	if err.Extensions == nil {
+		err.Extensions = map[string]interface{}{}
+	}
+	if _, ok := err.Extensions["code"]; !ok {
+		err.Extensions["code"] = string(code)
+	}
+}
+
+// rejectedResponse is returned instead of executing a request, rejected requests are
+// VALIDATION errors unless they have a code
+func rejectedResponse(err *gqlerrors.QueryError) *graphql.Response {
+	setErrorCode(err, apperror.Validation)
+	return &graphql.Response{Errors: []*gqlerrors.QueryError{err}}
+}
+
+// rejectedStatus is 400 for requests the client has to change and 500 when the server failed
+func rejectedStatus(err *gqlerrors.QueryError) int {
+	switch err.Extensions["code"] {
+	case string(apperror.Internal), string(apperror.UpstreamUnavailable):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// header returns the value of a request header, API Gateway keeps the case the client sent
+func header(request events.APIGatewayProxyRequest, name string) (string, bool) {
+	for key, value := range request.Headers {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// responseMediaType picks the media type of the response from the Accept header. Without an
+// Accept header the response uses application/graphql-response+json.
+func responseMediaType(request events.APIGatewayProxyRequest) (string, bool) {
+
+	accept, ok := header(request, "Accept")
+	if !ok || strings.TrimSpace(accept) == "" {
+		return MediaTypeGraphQLResponse, true
+	}
+
+	json := false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case MediaTypeGraphQLResponse, "*/*", "application/*":
+			return MediaTypeGraphQLResponse, true
+		case MediaTypeJSON:
+			json = true
+		}
+	}
+	if json {
+		return MediaTypeJSON, true
+	}
+	return "", false
+}
+
+// decodeQueryRequests reads the operations from the body of a POST or the query string of a GET.
+// A POST body can be a single operation or an array of operations.
+func decodeQueryRequests(request events.APIGatewayProxyRequest) ([]QueryRequest, bool, error) {
+
+	if request.HTTPMethod == http.MethodGet {
+		queryRequest, err := decodeQueryString(request.QueryStringParameters)
+		return []QueryRequest{queryRequest}, false, err
+	}
+
+	if contentType, ok := header(request, "Content-Type"); ok {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != MediaTypeJSON {
+			return nil, false, errUnsupportedMediaType
+		}
+	}
+
+	body := bytes.TrimSpace([]byte(request.Body))
+	if len(body) > 0 && body[0] == '[' {
+		var queryRequests []QueryRequest
+		err := json.Unmarshal(body, &queryRequests)
+		if err != nil {
+			return nil, true, err
+		}
+		if len(queryRequests) == 0 || len(queryRequests) > MaxBatchOperations {
+			return nil, true, errors.New(ErrorInvalidBatch)
+		}
+		return queryRequests, true, nil
+	}
+
+	var queryRequest QueryRequest
+	err := json.Unmarshal(body, &queryRequest)
+	return []QueryRequest{queryRequest}, false, err
+}
+
+var errUnsupportedMediaType = errors.New(ErrorUnsupportedMediaType)
+
+func decodeQueryString(params map[string]string) (QueryRequest, error) {
+
+	var queryRequest QueryRequest
+	queryRequest.Query = params["query"]
+	queryRequest.OperationName = params["operationName"]
+	if variables, ok := params["variables"]; ok {
+		if err := json.Unmarshal([]byte(variables), &queryRequest.Variables); err != nil {
+			return queryRequest, err
+		}
+	}
+	if extensions, ok := params["extensions"]; ok {
+		if err := json.Unmarshal([]byte(extensions), &queryRequest.Extensions); err != nil {
+			return queryRequest, err
+		}
+	}
+	return queryRequest, nil
+}
+
+// execute runs a single operation and returns the status code the response calls for.
+// Requests that fail before execution are 400, executed requests have data and are 200.
+func (z *App) execute(ctx context.Context, method string, queryRequest QueryRequest) (*graphql.Response, int) {
+
+	if _, rejected, statusCode := z.prepare(ctx, method, &queryRequest); rejected != nil {
+		return rejected, statusCode
+	}
+	return z.exec(ctx, queryRequest)
+}
+
+// prepare resolves a persisted query and checks the operation before it is executed. It returns
+// the operation, or the response and the status code of a request that is rejected.
+func (z *App) prepare(ctx context.Context, method string, queryRequest *QueryRequest) (*ast.OperationDefinition, *graphql.Response, int) {
+
+	if err := z.persistedQueries.resolve(ctx, queryRequest); err != nil {
+		return nil, rejectedResponse(err), rejectedStatus(err)
+	}
+
+	// the checks before execution share one parse of the document
+	doc, op, err := parseOperation(*queryRequest)
+	if err != nil {
+		return nil, rejectedResponse(err), http.StatusBadRequest
+	}
+	if method == http.MethodGet && op.Operation != ast.Query {
+		// GET requests can be cached so they may not change anything
+		return nil, rejectedResponse(&gqlerrors.QueryError{Message: ErrorOperationNotAllowed}), http.StatusMethodNotAllowed
+	}
+
+	// reject queries that would fan out into too many reads before resolving anything
+	if err := z.limits.check(doc, op, queryRequest.Variables); err != nil {
+		logInfo(ctx, "Rejected query", "prepare", map[string]interface{}{"error": err})
+		return nil, rejectedResponse(err), http.StatusBadRequest
+	}
+	return op, nil, http.StatusOK
+}
+
+func (z *App) exec(ctx context.Context, queryRequest QueryRequest) (*graphql.Response, int) {
+
+	resp := z.schema.Exec(ctx, queryRequest.Query, queryRequest.OperationName, queryRequest.Variables)
+	maskErrors(ctx, resp)
+	// documents that do not parse or validate are not executed and have no data
+	if len(resp.Data) == 0 {
+		return resp, http.StatusBadRequest
+	}
+
+	// only queries that validated against the schema are registered
+	if queryRequest.Extensions != nil && queryRequest.Extensions.PersistedQuery != nil {
+		// a failed registration only means the client sends the query text again
+		_ = z.persistedQueries.register(ctx, queryRequest.Query)
+	}
+	return resp, http.StatusOK
+}
+
+// executeBatch runs the operations in request order, they share the loader of the request.
+// Queries run concurrently with the queries next to them, a mutation waits for the operations
+// before it and the operations after it wait for the mutation.
+func (z *App) executeBatch(ctx context.Context, method string, queryRequests []QueryRequest) []*graphql.Response {
+
+	responses := make([]*graphql.Response, len(queryRequests))
+	var wg sync.WaitGroup
+	for index := range queryRequests {
+		queryRequest := queryRequests[index]
+		op, rejected, _ := z.prepare(ctx, method, &queryRequest)
+		if rejected != nil {
+			responses[index] = rejected
+			continue
+		}
+		if op.Operation == ast.Mutation {
+			wg.Wait()
+			responses[index], _ = z.exec(ctx, queryRequest)
+			continue
+		}
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			responses[index], _ = z.exec(ctx, queryRequest)
+		}(index)
+	}
+	wg.Wait()
+	return responses
+}
+
+// parseOperation returns the document of the request and the operation it executes
+func parseOperation(queryRequest QueryRequest) (*ast.QueryDocument, *ast.OperationDefinition, *gqlerrors.QueryError) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: queryRequest.Query})
+	if err != nil {
+		if gqlErr, ok := err.(*gqlerror.Error); ok {
+			return nil, nil, &gqlerrors.QueryError{Message: gqlErr.Message}
+		}
+		return nil, nil, &gqlerrors.QueryError{Message: err.Error()}
+	}
+	if queryRequest.OperationName == "" {
+		if len(doc.Operations) != 1 {
+			return nil, nil, &gqlerrors.QueryError{Message: "more than one operation in query document and no operation name given"}
+		}
+		return doc, doc.Operations[0], nil
+	}
+	op := doc.Operations.ForName(queryRequest.OperationName)
+	if op == nil {
+		return nil, nil, &gqlerrors.QueryError{Message: fmt.Sprintf("no operation with name %q", queryRequest.OperationName)}
+	}
+	return doc, op, nil
+}
+
+// httpResponse writes the body as json. With application/json every well formed request
+// is answered with 200 as clients of that media type can not tell GraphQL errors apart.
+func httpResponse(statusCode int, mediaType string, body interface{}, headers map[string]string) events.APIGatewayProxyResponse {
+
+	if mediaType == MediaTypeJSON && statusCode == http.StatusBadRequest {
+		statusCode = http.StatusOK
+	}
+	response := events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+			"Content-Type":                fmt.Sprintf("%s; charset=utf-8", mediaType),
+		},
+	}
+	for key, value := range headers {
+		response.Headers[key] = value
+	}
+	if body != nil {
+		rJSON, _ := json.Marshal(body)
+		response.Body = string(rJSON)
+	}
+	return response
+}
+
+// requestError answers requests that are not well formed, they are 400 for every media type
+func requestError(statusCode int, mediaType string, code apperror.Code, message string) events.APIGatewayProxyResponse {
+	err := &gqlerrors.QueryError{Message: message, Extensions: map[string]interface{}{"code": string(code)}}
+	response := httpResponse(statusCode, mediaType, rejectedResponse(err), nil)
+	response.StatusCode = statusCode
+	return response
+}
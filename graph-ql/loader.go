@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+type loaderKey struct{}
+
+// Loader coalesces the user and spot lookups of one request and caches them until the request ends.
+// Resolvers of list items run concurrently, lookups made within LoaderWait are fetched together.
+// Lookups with different projections are loaded separately.
+type Loader struct {
+	resolver *Resolver
+
+	mu      sync.Mutex
+	loaders map[string]*itemLoader
+}
+
+func newLoader(r *Resolver) *Loader {
+	return &Loader{
+		resolver: r,
+		loaders:  map[string]*itemLoader{},
+	}
+}
+
+func withLoader(ctx context.Context, loader *Loader) context.Context {
+	return context.WithValue(ctx, loaderKey{}, loader)
+}
+
+// getLoader returns the loader of the request, resolvers called outside of a request get their own
+func (r *Resolver) getLoader(ctx context.Context) *Loader {
+	if loader, ok := ctx.Value(loaderKey{}).(*Loader); ok {
+		return loader
+	}
+	return newLoader(r)
+}
+
+func (l *Loader) itemLoader(kind string, projection common.Projection, fetch func(ctx context.Context, ids []string, projection common.Projection) (map[string]map[string]*dynamodb.AttributeValue, error)) *itemLoader {
+	key := kind
+	if projection != nil {
+		key = fmt.Sprintf("%s:%s", kind, strings.Join(projection, ","))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	loader, ok := l.loaders[key]
+	if !ok {
+		loader = newItemLoader(LoaderMaxBatch, func(ctx context.Context, ids []string) (map[string]map[string]*dynamodb.AttributeValue, error) {
+			return fetch(ctx, ids, projection)
+		})
+		l.loaders[key] = loader
+	}
+	return loader
+}
+
+// clear drops the item from the loaders of the kind, whatever their projection
+func (l *Loader) clear(kind, id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, loader := range l.loaders {
+		if key == kind || strings.HasPrefix(key, kind+":") {
+			loader.clear(id)
+		}
+	}
+}
+
+// loadUser returns nil if the user does not exist
+func (r *Resolver) loadUser(ctx context.Context, userId string, projection common.Projection) (*User, error) {
+	item, err := r.getLoader(ctx).itemLoader("users", projection, r.batchGetUsers).load(ctx, userId)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	var user User
+	err = dynamodbattribute.UnmarshalMap(item, &user)
+	if err != nil {
+		logError(ctx, "Failed to unmarshal user", "loadUser", err, nil)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// loadSpot returns nil if the spot does not exist
+func (r *Resolver) loadSpot(ctx context.Context, spotId string, projection common.Projection) (*common.Spot, error) {
+	item, err := r.getLoader(ctx).itemLoader("spots", projection, r.batchGetSpots).load(ctx, spotId)
+	if err != nil || item == nil {
+		return nil, err
+	}
+	var spot common.Spot
+	err = dynamodbattribute.UnmarshalMap(item, &spot)
+	if err != nil {
+		logError(ctx, "Failed to unmarshal spot", "loadSpot", err, nil)
+		return nil, err
+	}
+	return &spot, nil
+}
+
+// forgetSpot drops the cached spot after a mutation wrote it or its aggregates,
+// lookups later in the request read it again
+func (r *Resolver) forgetSpot(ctx context.Context, spotId string) {
+	r.getLoader(ctx).clear("spots", spotId)
+}
+
+// batchGetUsers reads the user items with BatchGetItem, retrying unprocessed keys
+func (r *Resolver) batchGetUsers(ctx context.Context, userIds []string, projection common.Projection) (map[string]map[string]*dynamodb.AttributeValue, error) {
+
+	logInfo(ctx, "Invoke", "batchGetUsers", map[string]interface{}{"userIds": userIds})
+	keys := make([]map[string]*dynamodb.AttributeValue, len(userIds))
+	for index, userId := range userIds {
+		key := fmt.Sprintf("%s%s", UserPrefix, userId)
+		keys[index] = map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(key)},
+			SKKey: {S: aws.String(key)},
+		}
+	}
+
+	expressionAttributeNames := map[string]*string{}
+	keysAndAttributes := &dynamodb.KeysAndAttributes{
+		Keys:                 keys,
+		ProjectionExpression: projection.ProjectionExpression(expressionAttributeNames),
+	}
+	if len(expressionAttributeNames) > 0 {
+		keysAndAttributes.ExpressionAttributeNames = expressionAttributeNames
+	}
+
+	items := map[string]map[string]*dynamodb.AttributeValue{}
+	requestItems := map[string]*dynamodb.KeysAndAttributes{
+		r.TableName: keysAndAttributes,
+	}
+	for retry := 0; len(requestItems) > 0; retry++ {
+		if retry > 0 {
+			time.Sleep(time.Duration(retry) * LoaderRetryWait)
+		}
+		output, err := r.Db.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: requestItems})
+		if err != nil {
+			logError(ctx, "Failed to batch get users", "batchGetUsers", err, nil)
+			return nil, err
+		}
+		for _, item := range output.Responses[r.TableName] {
+			var user User
+			err := dynamodbattribute.UnmarshalMap(item, &user)
+			if err != nil {
+				logError(ctx, "Failed to unmarshal user", "batchGetUsers", err, nil)
+				return nil, err
+			}
+			items[user.UserId()] = item
+		}
+		requestItems = output.UnprocessedKeys
+	}
+	return items, nil
+}
+
+// batchGetSpots queries the spots concurrently, BatchGetItem can not be used
+// because the sort key of a spot contains its geohash
+func (r *Resolver) batchGetSpots(ctx context.Context, spotIds []string, projection common.Projection) (map[string]map[string]*dynamodb.AttributeValue, error) {
+
+	logInfo(ctx, "Invoke", "batchGetSpots", map[string]interface{}{"spotIds": spotIds})
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var lastErr error
+	items := map[string]map[string]*dynamodb.AttributeValue{}
+	for _, spotId := range spotIds {
+		wg.Add(1)
+		go func(spotId string) {
+			defer wg.Done()
+			spot, err := common.GetSpot(ctx, spotId, projection, r.Db, r.TableName)
+			var item map[string]*dynamodb.AttributeValue
+			if err == nil {
+				item, err = dynamodbattribute.MarshalMap(spot)
+			} else if apperror.CodeOf(err) == apperror.NotFound {
+				err = nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				lastErr = err
+				return
+			}
+			if item != nil {
+				items[spotId] = item
+			}
+		}(spotId)
+	}
+	wg.Wait()
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return items, nil
+}
+
+type itemResult struct {
+	item map[string]*dynamodb.AttributeValue
+	err  error
+	done chan struct{}
+}
+
+type itemBatch struct {
+	ids     []string
+	results []*itemResult
+}
+
+type itemLoader struct {
+	maxBatch int
+	fetch    func(ctx context.Context, ids []string) (map[string]map[string]*dynamodb.AttributeValue, error)
+
+	mu      sync.Mutex
+	cache   map[string]*itemResult
+	pending *itemBatch
+}
+
+func newItemLoader(maxBatch int, fetch func(ctx context.Context, ids []string) (map[string]map[string]*dynamodb.AttributeValue, error)) *itemLoader {
+	return &itemLoader{
+		maxBatch: maxBatch,
+		fetch:    fetch,
+		cache:    map[string]*itemResult{},
+	}
+}
+
+// load waits for the batch the id was added to, items that do not exist are nil
+func (l *itemLoader) load(ctx context.Context, id string) (map[string]*dynamodb.AttributeValue, error) {
+
+	l.mu.Lock()
+	result, ok := l.cache[id]
+	if !ok {
+		result = &itemResult{done: make(chan struct{})}
+		l.cache[id] = result
+
+		if l.pending == nil {
+			batch := &itemBatch{}
+			l.pending = batch
+			go func() {
+				time.Sleep(LoaderWait)
+				l.mu.Lock()
+				waiting := l.pending == batch
+				if waiting {
+					l.pending = nil
+				}
+				l.mu.Unlock()
+				// a full batch has been dispatched already
+				if waiting {
+					l.dispatch(ctx, batch)
+				}
+			}()
+		}
+		batch := l.pending
+		batch.ids = append(batch.ids, id)
+		batch.results = append(batch.results, result)
+		if len(batch.ids) >= l.maxBatch {
+			l.pending = nil
+			go l.dispatch(ctx, batch)
+		}
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-result.done:
+		return result.item, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// clear makes the next load of the id fetch it again, loads that are waiting keep their result
+func (l *itemLoader) clear(id string) {
+	l.mu.Lock()
+	delete(l.cache, id)
+	l.mu.Unlock()
+}
+
+func (l *itemLoader) dispatch(ctx context.Context, batch *itemBatch) {
+
+	items, err := l.fetch(ctx, batch.ids)
+	for index, id := range batch.ids {
+		result := batch.results[index]
+		result.item, result.err = items[id], err
+		close(result.done)
+	}
+
+	// failed lookups are tried again by the next load
+	if err != nil {
+		l.mu.Lock()
+		for index, id := range batch.ids {
+			if l.cache[id] == batch.results[index] {
+				delete(l.cache, id)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
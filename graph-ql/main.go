@@ -3,18 +3,22 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/graph-gophers/graphql-go"
 	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
 )
 
 var (
@@ -30,7 +34,12 @@ var (
 
 type App struct {
 	schema            *graphql.Schema
+	resolver          *Resolver
 	awsTokenValidator AwsTokenValidator
+	limits            queryLimits
+	persistedQueries  *persistedQueries
+	subscriptions     subscriptionStore
+	sender            connectionSender
 }
 
 func NewApp() *App {
@@ -58,7 +67,21 @@ func NewApp() *App {
 		TableName:    tableName,
 		MapboxClient: mapboxClient,
 	}
-	schema := graphql.MustParseSchema(schemaString, &resolver, graphql.UseStringDescriptions())
+	limits := newQueryLimits()
+	schema := graphql.MustParseSchema(schemaString, &resolver, append(schemaOptions(), graphql.MaxDepth(limits.MaxDepth))...)
+
+	// without a connections table subscriptions only live in this process
+	var subscriptions subscriptionStore
+	var sender connectionSender
+	if connectionsTableName := os.Getenv(ConnectionsTableNameEnv); connectionsTableName != "" {
+		subscriptions = &connectionsTable{db: db, tableName: connectionsTableName}
+		sender = &apiGatewaySender{client: apigatewaymanagementapi.New(mySession, aws.NewConfig().WithEndpoint(os.Getenv(WebSocketEndpointEnv)))}
+	} else {
+		hub := newHub()
+		subscriptions = hub
+		sender = hub
+	}
+	resolver.Publisher = &Publisher{schema: schema, subscriptions: subscriptions, sender: sender}
 
 	publicKeysURL := "https://cognito-idp.ap-northeast-1.amazonaws.com/ap-northeast-1_IkvtTA79k/.well-known/jwks.json"
 	awsTokenValidator, err := NewAwsTokenValidator(publicKeysURL)
@@ -68,14 +91,37 @@ func NewApp() *App {
 
 	return &App{
 		schema:            schema,
+		resolver:          &resolver,
 		awsTokenValidator: awsTokenValidator,
+		limits:            limits,
+		persistedQueries:  newPersistedQueries(db, tableName),
+		subscriptions:     subscriptions,
+		sender:            sender,
+	}
+}
+
+func schemaOptions() []graphql.SchemaOpt {
+	return []graphql.SchemaOpt{
+		graphql.UseStringDescriptions(),
+		graphql.MaxParallelism(LoaderMaxBatch),
 	}
 }
 
 func (z *App) handler(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 
+	mediaType, ok := responseMediaType(request)
+	if !ok {
+		return requestError(http.StatusNotAcceptable, MediaTypeJSON, apperror.Validation, ErrorNotAcceptable), nil
+	}
+	// API Gateway only routes GET and POST, test and direct invocations have no method
+	if method := request.HTTPMethod; method != "" && method != http.MethodGet && method != http.MethodPost {
+		response := requestError(http.StatusMethodNotAllowed, mediaType, apperror.Validation, ErrorOperationNotAllowed)
+		response.Headers["Allow"] = "GET, POST"
+		return response, nil
+	}
+
 	// valid idToken and create user if Authorization header is set
-	if idToken, ok := request.Headers["Authorization"]; ok {
+	if idToken, ok := header(request, "Authorization"); ok {
 		// strip Bearer
 		if strings.Contains(idToken, "Bearer ") {
 			idToken = strings.Replace(idToken, "Bearer ", "", 1)
@@ -84,43 +130,54 @@ func (z *App) handler(ctx context.Context, request events.APIGatewayProxyRequest
 		// validate claims
 		claims, err := z.awsTokenValidator.ValidateIdToken(idToken)
 		if err != nil {
-			return events.APIGatewayProxyResponse{
-				StatusCode: 403,
-				Headers: map[string]string{
-					"Access-Control-Allow-Origin": "*",
-				},
-			}, err
+			logInfo(ctx, "Invalid id token", "handler", map[string]interface{}{"error": err.Error()})
+			return requestError(http.StatusUnauthorized, mediaType, apperror.Unauthenticated, ErrorInvalidIdToken), nil
 		}
 
 		// create user from claims and add to context
-		requestUser := RequestUser{claims.CognitoGroups, claims.SellerId, claims.Username}
-		ctx = context.WithValue(ctx, RequestUserKey, requestUser)
-
-	}
-
-	var queryRequest QueryRequest
-	marshalErr := json.Unmarshal([]byte(request.Body), &queryRequest)
-	if marshalErr != nil {
-		return events.APIGatewayProxyResponse{
-			StatusCode: 403,
-			Headers: map[string]string{
-				"Access-Control-Allow-Origin": "*",
-			},
-		}, marshalErr
-	}
-
-	resp := z.schema.Exec(ctx, queryRequest.Query, queryRequest.OpName, queryRequest.Variables)
-	rJSON, _ := json.Marshal(resp)
-	return events.APIGatewayProxyResponse{
-		Body:       string(rJSON),
-		StatusCode: 200,
-		Headers: map[string]string{
-			"Access-Control-Allow-Origin": "*",
-		},
-	}, nil
+		requestUser := common.NewRequestUser(claims.CognitoGroups, claims.SellerId, claims.Username)
+		ctx = context.WithValue(ctx, common.RequestUserKey, requestUser)
+
+	}
+
+	queryRequests, batch, err := decodeQueryRequests(request)
+	if err == errUnsupportedMediaType {
+		return requestError(http.StatusUnsupportedMediaType, mediaType, apperror.Validation, ErrorUnsupportedMediaType), nil
+	}
+	if err != nil {
+		logInfo(ctx, "Invalid request", "handler", map[string]interface{}{"error": err.Error()})
+		return requestError(http.StatusBadRequest, mediaType, apperror.Validation, ErrorInvalidRequest), nil
+	}
+
+	// lookups are batched and cached for this request only
+	if z.resolver != nil {
+		ctx = withLoader(ctx, newLoader(z.resolver))
+	}
+
+	if batch {
+		responses := z.executeBatch(ctx, request.HTTPMethod, queryRequests)
+		return httpResponse(http.StatusOK, mediaType, responses, nil), nil
+	}
+
+	resp, statusCode := z.execute(ctx, request.HTTPMethod, queryRequests[0])
+	headers := map[string]string{}
+	if statusCode == http.StatusMethodNotAllowed {
+		// mutations are only accepted as POST
+		headers["Allow"] = http.MethodPost
+	}
+	// anonymous reads are the same for everyone and can be cached by API Gateway and CloudFront
+	if request.HTTPMethod == http.MethodGet && statusCode == http.StatusOK && len(resp.Errors) == 0 && getRequestUser(ctx) == nil {
+		headers["Cache-Control"] = fmt.Sprintf("public, max-age=%d", GetCacheMaxAgeSeconds)
+	}
+	return httpResponse(statusCode, mediaType, resp, headers), nil
 }
 
 func main() {
 	app := NewApp()
+	// the websocket api is deployed as its own function from the same code
+	if os.Getenv(HandlerEnv) == WebSocketHandler {
+		lambda.Start(app.websocketHandler)
+		return
+	}
 	lambda.Start(app.handler)
 }
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/mmcloughlin/geohash"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// TestHandler
+	spotQuery = `{
+		"query":"query Spot($spotId: String!){spot(spotId: $spotId){Name\nDescription\nReviews{\nedges{\nnode{\nReviewId\nRating\nMessage\n}}}}}",
+		"variables": {"spotId":"spot1"}
+	}`
+
+	allTalentsQuery = `{
+		"query":"query AllTalents{allTalents(){PK\nSK\nName\nDescription}}"
+	}`
+
+	campanyTalentsQuery = `{
+		"query":"query CompanyTalents($pk: String!){companyTalents(pk: $pk){PK\nSK\nName\nDescription}}",
+		"variables": {"pk":"talent#company_1"}
+	}`
+
+	openTalentSpotsQuery = `{
+		"query":"query OpenTalentSpots($talentId: String!){openTalentSpots(talentId: $talentId){Name}}",
+		"variables": {"talentId":"talent_1"}
+	}`
+
+	companySpotsQuery = `{
+		"query":"query CompanySpots($companyId: String!){companySpots(companyId: $companyId){Name}}",
+		"variables": {"companyId":"company_1"}
+	}`
+
+	userSpotsQuery = `{
+		"query":"query UserSpots($userId: String!){userSpots(userId: $userId){Name}}",
+		"variables": {"userId":"user_1"}
+	}`
+
+	createTalentMutation = `{
+		"query" : "mutation CreateTalent($name: String!, $description: String!){createTalent(name: $name, description: $description){Name\nDescription}}",
+		"variables": {"name": "new talent name","description": "new talent desc"}
+	}`
+
+	updateTalentMutation = `{
+		"query" : "mutation UpdateTalent($pk: String!, $sk: String!, $name: String!, $description: String!){updateTalent(pk: $pk, sk: $sk, name: $name, description: $description){PK\nSK\nName\nDescription}}",
+		"variables": {"pk": "talent#company_1", "sk":"talent_1", "name": "new talent name","description": "new talent desc"}
+	}`
+
+	createSpotMutation = `{
+		"query" : "mutation CreateSpot($talentId: String!, $companyId: String!, $date: String!, $durationSeconds: Int!, $priceYen: Int!, $name: String, $description: String){createSpot(talentId: $talentId, companyId: $companyId, date: $date, durationSeconds: $durationSeconds, priceYen: $priceYen, name: $name, description: $description){PK\nSK\nDate\nDurationSeconds\nName\nDescription}}",
+		"variables": {"talentId":"talent_1", "companyId":"company_1", "date":"2020/09/20T18:30:00", "durationSeconds":360, "priceYen":500, "name":"meet and greet"}
+	}`
+
+	reserveSpotMutation = `{
+		"query" : "mutation ReserveSpot($pk: String!, $sk: String!, $userId: String!, $userName: String!){reserveSpot(pk: $pk, sk: $sk, userId: $userId, userName: $userName){PK\nSK\nDate\nDurationSeconds\nName\nDescription}}",
+		"variables": {"pk":"spot#company_1", "sk":"open", "date":"2020/09/20T18:30:00", "durationSeconds":360, "priceYen":500, "name":"meet and greet"}
+	}`
+
+	spotsNearQuery = `{
+		"query":"query SpotsNear($latitude: Float!, $longitude: Float!, $radiusMeters: Float!, $limit: Int, $spotTypes: [SpotType!]){spotsNear(latitude: $latitude, longitude: $longitude, radiusMeters: $radiusMeters, limit: $limit, spotTypes: $spotTypes){Name}}",
+		"variables": {"latitude":35.0, "longitude":137.0, "radiusMeters":%f, "limit":%d, "spotTypes":["RoadSideStation"]}
+	}`
+
+	spotDistancesQuery = `{
+		"query":"query SpotDistances($maxSeconds: Float, $spotTypes: [SpotType!], $orderBy: SpotDistanceOrderBy, $limit: Int){spot(spotId: \"a\"){SpotDistances(maxSeconds: $maxSeconds, spotTypes: $spotTypes, orderBy: $orderBy, limit: $limit){DestinationName DestinationSpot{Name}}}}",
+		"variables": %s
+	}`
+
+	requestSpotImageUploadMutation = `{
+		"query":"mutation RequestSpotImageUpload($contentType: String!){requestSpotImageUpload(spotId: \"a\", contentType: $contentType){SpotImageId UploadUrl ContentType}}",
+		"variables": {"contentType":"%s"}
+	}`
+
+	confirmSpotImageMutation = `{
+		"query":"mutation ConfirmSpotImage{confirmSpotImage(spotId: \"a\", spotImageId: \"image1\"){SpotImageId SpotId ImageUrl UserId}}"
+	}`
+
+	spotImagesQuery = `{
+		"query":"query SpotImages{spot(spotId: \"a\"){Images{SpotImageId ImageUrl}}}"
+	}`
+
+	spotsInRegionQuery = `{
+		"query":"query SpotsInRegion($boundingBox: BoundingBoxInput, $polygon: PolygonInput, $limit: Int, $spotTypes: [SpotType!]){spotsInRegion(boundingBox: $boundingBox, polygon: $polygon, limit: $limit, spotTypes: $spotTypes){Name}}",
+		"variables": %s
+	}`
+
+	projectionQuery = `{
+		"query":"query Projection{spot(spotId: \"a\"){...spotFields Reviews{edges{node{Rating User{Nickname}}}}} nearby: spotsNear(latitude: 35.0, longitude: 137.0, radiusMeters: 1000){__typename ... on Spot{Name}}} fragment spotFields on Spot{Name Tags}"
+	}`
+
+	nestedReviewsQuery = `{
+		"query":"query Nested($first: Int){spotsByGeohash(geohash: \"xn\", first: $first){edges{node{Name Reviews{edges{node{User{CreatedSpots{edges{node{Reviews{edges{node{Message}}}}}}}}}}}}}}",
+		"variables":{"first":2}
+	}`
+
+	reviewUsersQuery = `{
+		"query":"query ReviewUsers{reviews(spotId: \"spot1\"){edges{node{ReviewId User{UserId Nickname}}}}}"
+	}`
+
+	reviewsPageQuery = `{
+		"query":"query Reviews($spotId: String, $first: Int, $after: String){reviews(spotId: $spotId, first: $first, after: $after){edges{node{ReviewId}}\npageInfo{hasNextPage\nendCursor}}}",
+		"variables": %s
+	}`
+
+	spotsByGeohashQuery = `{
+		"query":"query SpotsByGeohash($geohash: String!, $spotTypes: [SpotType!], $allTags: [String!], $anyTags: [String!], $minSubRatings: MinSubRatingsInput){spotsByGeohash(geohash: $geohash, spotTypes: $spotTypes, allTags: $allTags, anyTags: $anyTags, minSubRatings: $minSubRatings){edges{node{Name}}}}",
+		"variables": %s
+	}`
+
+	userQuery = `{
+		"query":"query User($sk: String!){user(sk: $sk){Nickname}}",
+		"variables": {"sk":"user_e76fff27-ffe8-4317-a62c-5ba167f084da"}
+	}`
+)
+
+var (
+	// user1             = RequestUser{[]string{}, "", "user_1"}
+	// sellerUser1       = RequestUser{[]string{"Seller"}, "company_1", "user_2"}
+	// sellerUser2       = RequestUser{[]string{"Seller"}, "company_2", "user_3"}
+	// adminUser         = RequestUser{[]string{"Admin"}, "", "user_4"}
+	user1Claims = &AWSCognitoClaims{
+		Username: "user_1",
+	}
+	sellerUser1Claims = &AWSCognitoClaims{
+		SellerId:      "company_1",
+		CognitoGroups: []string{"Seller"},
+		Username:      "user_2",
+	}
+	sellerUser2Claims = &AWSCognitoClaims{
+		SellerId:      "company_2",
+		CognitoGroups: []string{"Seller"},
+		Username:      "user_3",
+	}
+	adminUserClaims = &AWSCognitoClaims{
+		CognitoGroups: []string{"Admin"},
+		Username:      "user_4",
+	}
+
+	testSpots = []common.Spot{
+		testSpot("a", "spot a", "RoadSideStation", 35.001, 137.0, "Toilet", "Wifi"),
+		testSpot("b", "spot b", "RoadSideStation", 35.01, 137.0, "Toilet"),
+		testSpot("c", "spot c", "RoadSideStation", 35.2, 137.0),
+		testSpot("d", "spot d", "Parking", 35.0005, 137.0, "Wifi"),
+	}
+)
+
+func createTestRequest(query string, isAuthenticated bool) events.APIGatewayProxyRequest {
+
+	event := events.APIGatewayProxyRequest{
+		Body: query,
+	}
+	if isAuthenticated {
+		event.Headers = map[string]string{
+			"Authorization": "testIdToken",
+		}
+	}
+	return event
+}
+
+func createTestWebSocketRequest(routeKey, connectionId, body string) events.APIGatewayWebsocketProxyRequest {
+	return events.APIGatewayWebsocketProxyRequest{
+		Body: body,
+		RequestContext: events.APIGatewayWebsocketProxyRequestContext{
+			RouteKey:     routeKey,
+			ConnectionID: connectionId,
+		},
+	}
+}
+
+// drainMessages returns the messages a hub connection has received so far
+func drainMessages(h *hub, connectionId string) []string {
+	messages := []string{}
+	for {
+		select {
+		case message, ok := <-h.messages(connectionId):
+			if !ok {
+				return messages
+			}
+			messages = append(messages, string(message))
+		default:
+			return messages
+		}
+	}
+}
+
+type mockClientClient struct {
+	dynamodbiface.DynamoDBAPI
+	QueryFunc              func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+	PutItemFunc            func(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+	UpdateItemFunc         func(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+	GetItemFunc            func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	BatchGetItemFunc       func(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error)
+	DeleteItemFunc         func(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error)
+	TransactWriteItemsFunc func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+func (m *mockClientClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	return m.DeleteItemFunc(input)
+}
+
+func (m *mockClientClient) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	return m.TransactWriteItemsFunc(input)
+}
+
+func (m *mockClientClient) BatchGetItem(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+	return m.BatchGetItemFunc(input)
+}
+
+func (m *mockClientClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	return m.PutItemFunc(input)
+}
+
+func (m *mockClientClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return m.QueryFunc(input)
+}
+
+func (m *mockClientClient) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return m.UpdateItemFunc(input)
+}
+
+func (m *mockClientClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return m.GetItemFunc(input)
+}
+
+type mockS3Client struct {
+	s3iface.S3API
+	PutObjectRequestFunc   func(*s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput)
+	HeadObjectFunc         func(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	DeleteObjectFunc       func(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	ListObjectsV2PagesFunc func(*s3.ListObjectsV2Input, func(*s3.ListObjectsV2Output, bool) bool) error
+}
+
+func (m *mockS3Client) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	return m.ListObjectsV2PagesFunc(in, fn)
+}
+
+func (m *mockS3Client) PutObjectRequest(in *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+	return m.PutObjectRequestFunc(in)
+}
+
+func (m *mockS3Client) HeadObject(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return m.HeadObjectFunc(in)
+}
+
+func (m *mockS3Client) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	return m.DeleteObjectFunc(in)
+}
+
+type mockMapboxClient struct {
+	AddFeatureFunc    func(ctx context.Context, spot common.Spot) error
+	DeleteFeatureFunc func(ctx context.Context, spotId string) error
+	LoadDistancesFunc func(ctx context.Context, origin common.Spot, destinations []common.Spot) (common.LoadDistancesResponse, error)
+}
+
+func (m *mockMapboxClient) AddFeature(ctx context.Context, spot common.Spot) error {
+	return m.AddFeatureFunc(ctx, spot)
+}
+
+func (m *mockMapboxClient) DeleteFeature(ctx context.Context, spotId string) error {
+	return m.DeleteFeatureFunc(ctx, spotId)
+}
+
+func (m *mockMapboxClient) LoadDistances(ctx context.Context, origin common.Spot, destinations []common.Spot) (common.LoadDistancesResponse, error) {
+	return m.LoadDistancesFunc(ctx, origin, destinations)
+}
+
+// presignClient builds requests that can be presigned without network access
+var presignClient = s3.New(session.Must(session.NewSession(&aws.Config{
+	Region:      aws.String("ap-northeast-1"),
+	Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+})))
+
+func createTestApp(queryResponsePath, getItemResponsePath string) *App {
+
+	data, _ := Asset("schema.graphql")
+	schemaString := string(data)
+	db := &mockClientClient{
+		QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			if queryResponsePath != "" {
+				output := dynamodb.QueryOutput{}
+				mockJson, _ := ioutil.ReadFile(queryResponsePath)
+				json.Unmarshal(mockJson, &output)
+				return &output, nil
+			}
+			return nil, nil
+		},
+		GetItemFunc: func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			if getItemResponsePath != "" {
+				output := dynamodb.GetItemOutput{}
+				mockJson, _ := ioutil.ReadFile(getItemResponsePath)
+				json.Unmarshal(mockJson, &output)
+				return &output, nil
+			}
+			return nil, nil
+		},
+	}
+	tableName := "test_table"
+	resolver := Resolver{
+		Db:        db,
+		TableName: tableName,
+	}
+	schema := graphql.MustParseSchema(schemaString, &resolver, graphql.UseStringDescriptions())
+
+	return &App{
+		schema: schema,
+	}
+
+}
+
+func testSpot(spotId, name, spotType string, latitude, longitude float64, tags ...string) common.Spot {
+	spot := common.Spot{
+		PK:           fmt.Sprintf("%s%s", common.SpotPrefix, spotId),
+		SK:           fmt.Sprintf("%s%s", common.SpotPrefix, geohash.Encode(latitude, longitude)),
+		GSI2:         aws.String(common.SpotQueryName),
+		CreationTime: "2020-01-01T00:00:00Z",
+		SpotType:     spotType,
+		Latitude:     latitude,
+		Longitude:    longitude,
+		Name:         aws.String(name),
+	}
+	if len(tags) > 0 {
+		spot.Tags = &tags
+	}
+	return spot
+}
+
+// createSpotsTestApp answers the GSI2 geohash queries from the given spots
+func createSpotsTestApp(t *testing.T, spots []common.Spot) *App {
+
+	items := make([]map[string]*dynamodb.AttributeValue, len(spots))
+	for i := range spots {
+		items[i], _ = dynamodbattribute.MarshalMap(spots[i])
+	}
+
+	data, _ := Asset(SchemaName)
+	schemaString := string(data)
+	db := &mockClientClient{
+		QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			require.Equal(t, aws.String("GSI2"), input.IndexName)
+			sk := *input.ExpressionAttributeValues[":sk"].S
+			filter := mockSpotFilter(input)
+			output := dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{}}
+			for i, item := range items {
+				if strings.HasPrefix(*item["SK"].S, sk) && filter.matches(spots[i]) {
+					output.Items = append(output.Items, item)
+				}
+			}
+			return &output, nil
+		},
+	}
+	resolver := Resolver{
+		Db:        db,
+		TableName: "test_table",
+	}
+	schema := graphql.MustParseSchema(schemaString, &resolver, graphql.UseStringDescriptions())
+
+	return &App{
+		schema: schema,
+	}
+}
+
+type testSpotFilter common.SpotFilter
+
+// mockSpotFilter reads the filter back from the expression attribute values of the query
+func mockSpotFilter(input *dynamodb.QueryInput) testSpotFilter {
+	filter := testSpotFilter{}
+	for name, value := range input.ExpressionAttributeValues {
+		if strings.HasPrefix(name, ":spotType_") {
+			filter.SpotTypes = append(filter.SpotTypes, *value.S)
+		} else if strings.HasPrefix(name, ":allTag_") {
+			filter.AllTags = append(filter.AllTags, *value.S)
+		} else if strings.HasPrefix(name, ":anyTag_") {
+			filter.AnyTags = append(filter.AnyTags, *value.S)
+		} else if strings.HasPrefix(name, ":minSubRating_") {
+			if filter.MinSubRatings == nil {
+				filter.MinSubRatings = map[string]float64{}
+			}
+			minimum, _ := strconv.ParseFloat(*value.N, 64)
+			filter.MinSubRatings[strings.TrimPrefix(name, ":minSubRating_")] = minimum
+		}
+	}
+	return filter
+}
+
+func (f testSpotFilter) matches(spot common.Spot) bool {
+	hasTag := func(tag string) bool {
+		if spot.Tags == nil {
+			return false
+		}
+		for _, t := range *spot.Tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(f.SpotTypes) > 0 {
+		hasType := false
+		for _, st := range f.SpotTypes {
+			hasType = hasType || st == spot.SpotType
+		}
+		if !hasType {
+			return false
+		}
+	}
+	for _, tag := range f.AllTags {
+		if !hasTag(tag) {
+			return false
+		}
+	}
+	if len(f.AnyTags) > 0 {
+		hasAny := false
+		for _, tag := range f.AnyTags {
+			hasAny = hasAny || hasTag(tag)
+		}
+		if !hasAny {
+			return false
+		}
+	}
+	aggregates := spot.SubRatingAggregates()
+	for subRating, minimum := range f.MinSubRatings {
+		average := aggregates[subRating].Average
+		if average == nil || *average < minimum {
+			return false
+		}
+	}
+	return true
+}
@@ -2,12 +2,27 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/graph-gophers/graphql-go"
+	"github.com/mmcloughlin/geohash"
+	"github.com/ninotokuda/carcamp_v2/common"
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,7 +42,7 @@ func TestHandler(t *testing.T) {
 			spotQuery,
 			"test_data/reviews.json",
 			"test_data/spot.json",
-			`{"data":{"spot":{"Name":"test spot 1","Description":"desc","Reviews":[{"ReviewId":"review1","Rating":4,"Message":"very good"},{"ReviewId":"review2","Rating":5,"Message":"very good yay"}]}}}`,
+			`{"data":{"spot":{"Name":"test spot 1","Description":"desc","Reviews":{"edges":[{"node":{"ReviewId":"review1","Rating":4,"Message":"very good"}},{"node":{"ReviewId":"review2","Rating":5,"Message":"very good yay"}}]}}}}`,
 			adminUserClaims,
 			"",
 		},
@@ -286,6 +301,494 @@ func TestCreateSpot(t *testing.T) {
 
 }
 
+func TestSpotsNear(t *testing.T) {
+
+	testCases := []struct {
+		name         string
+		radiusMeters float64
+		limit        int
+		response     string
+	}{
+		{
+			"nearest two",
+			5000,
+			2,
+			`{"data":{"spotsNear":[{"Name":"spot a"},{"Name":"spot b"}]}}`,
+		},
+		{
+			"widen ring",
+			30000,
+			10,
+			`{"data":{"spotsNear":[{"Name":"spot a"},{"Name":"spot b"},{"Name":"spot c"}]}}`,
+		},
+		{
+			"invalid radius",
+			100000,
+			10,
+			fmt.Sprintf(`{"errors":[{"message":"%s","path":["spotsNear"],"extensions":{"code":"VALIDATION"}}],"data":null}`, ErrorInvalidRadius),
+		},
+	}
+
+	for _, tc := range testCases {
+
+		t.Run(tc.name, func(t *testing.T) {
+			app := createSpotsTestApp(t, testSpots)
+			app.awsTokenValidator = &mockAwsTokenValidator{
+				ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+					return user1Claims, nil
+				},
+			}
+
+			request := createTestRequest(fmt.Sprintf(spotsNearQuery, tc.radiusMeters, tc.limit), true)
+			resp, err := app.handler(context.Background(), request)
+			require.Nil(t, err)
+			require.Equal(t, tc.response, resp.Body)
+		})
+	}
+}
+
+func TestSpotDistances(t *testing.T) {
+
+	distances := []common.SpotDistance{}
+	for _, destination := range testSpots[1:] {
+		meters := common.Distance(testSpots[0].Latitude, testSpots[0].Longitude, destination.Latitude, destination.Longitude)
+		seconds := 1000000 / meters // the nearest spot takes the longest to reach
+		distances = append(distances, common.NewSpotDistance(testSpots[0], destination, seconds, meters))
+	}
+
+	testCases := []struct {
+		name      string
+		variables string
+		response  string
+	}{
+		{
+			"by seconds",
+			`{}`,
+			`{"data":{"spot":{"SpotDistances":[{"DestinationName":"spot c","DestinationSpot":{"Name":"spot c"}},{"DestinationName":"spot b","DestinationSpot":{"Name":"spot b"}},{"DestinationName":"spot d","DestinationSpot":{"Name":"spot d"}}]}}}`,
+		},
+		{
+			"by meters with limit",
+			`{"orderBy":"METERS","limit":2}`,
+			`{"data":{"spot":{"SpotDistances":[{"DestinationName":"spot d","DestinationSpot":{"Name":"spot d"}},{"DestinationName":"spot b","DestinationSpot":{"Name":"spot b"}}]}}}`,
+		},
+		{
+			"filtered",
+			`{"maxSeconds":1000,"spotTypes":["Parking"]}`,
+			`{"data":{"spot":{"SpotDistances":[]}}}`,
+		},
+		{
+			"within seconds",
+			`{"maxSeconds":1000}`,
+			`{"data":{"spot":{"SpotDistances":[{"DestinationName":"spot c","DestinationSpot":{"Name":"spot c"}},{"DestinationName":"spot b","DestinationSpot":{"Name":"spot b"}}]}}}`,
+		},
+		{
+			"invalid limit",
+			`{"limit":0}`,
+			fmt.Sprintf(`{"errors":[{"message":"%s","path":["spot","SpotDistances"],"extensions":{"code":"VALIDATION"}}],"data":{"spot":{"SpotDistances":null}}}`, ErrorInvalidLimit),
+		},
+	}
+
+	for _, tc := range testCases {
+
+		t.Run(tc.name, func(t *testing.T) {
+			data, _ := Asset(SchemaName)
+			db := &mockClientClient{
+				QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					pk := *input.ExpressionAttributeValues[":pk"].S
+					sk := *input.ExpressionAttributeValues[":sk"].S
+					output := dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{}}
+					if sk == common.SpotDistancePrefix {
+						filter := mockSpotFilter(input)
+						for _, sd := range distances {
+							if maxSeconds, ok := input.ExpressionAttributeValues[":maxSeconds"]; ok {
+								if max, _ := strconv.ParseFloat(*maxSeconds.N, 64); *sd.DistanceSeconds > max {
+									continue
+								}
+							}
+							if len(filter.SpotTypes) > 0 && filter.SpotTypes[0] != *sd.DestinationSpotType {
+								continue
+							}
+							item, _ := dynamodbattribute.MarshalMap(sd)
+							output.Items = append(output.Items, item)
+						}
+						return &output, nil
+					}
+					for _, spot := range testSpots {
+						if spot.PK == pk {
+							item, _ := dynamodbattribute.MarshalMap(spot)
+							output.Items = append(output.Items, item)
+						}
+					}
+					return &output, nil
+				},
+			}
+			resolver := Resolver{
+				Db:        db,
+				TableName: "test_table",
+			}
+			app := &App{schema: graphql.MustParseSchema(string(data), &resolver, graphql.UseStringDescriptions())}
+			app.awsTokenValidator = &mockAwsTokenValidator{
+				ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+					return user1Claims, nil
+				},
+			}
+
+			request := createTestRequest(fmt.Sprintf(spotDistancesQuery, tc.variables), true)
+			resp, err := app.handler(context.Background(), request)
+			require.Nil(t, err)
+			require.Equal(t, tc.response, resp.Body)
+		})
+	}
+}
+
+func TestSpotImages(t *testing.T) {
+
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	testCases := []struct {
+		name          string
+		query         string
+		imageCount    int64
+		contentLength int64
+		response      string
+		didPut        bool
+		didDelete     bool
+	}{
+		{
+			"request upload",
+			fmt.Sprintf(requestSpotImageUploadMutation, "image/jpeg"),
+			0,
+			0,
+			"",
+			false,
+			false,
+		},
+		{
+			"invalid content type",
+			fmt.Sprintf(requestSpotImageUploadMutation, "image/gif"),
+			0,
+			0,
+			fmt.Sprintf(`{"errors":[{"message":"%s","path":["requestSpotImageUpload"],"extensions":{"code":"VALIDATION"}}],"data":null}`, ErrorInvalidContentType),
+			false,
+			false,
+		},
+		{
+			"quota exceeded",
+			fmt.Sprintf(requestSpotImageUploadMutation, "image/png"),
+			common.SpotImageUploadsPerDay,
+			0,
+			fmt.Sprintf(`{"errors":[{"message":"%s","path":["requestSpotImageUpload"],"extensions":{"code":"RATE_LIMITED"}}],"data":null}`, ErrorUploadQuotaExceeded),
+			false,
+			false,
+		},
+		{
+			"confirm",
+			confirmSpotImageMutation,
+			0,
+			1000,
+			`{"data":{"confirmSpotImage":{"SpotImageId":"image1","SpotId":"a","ImageUrl":"https://test-bucket.s3.amazonaws.com/spots/a/user_1/image1","UserId":"user_1"}}}`,
+			true,
+			false,
+		},
+		{
+			"confirm too large",
+			confirmSpotImageMutation,
+			0,
+			common.SpotImageMaxBytes + 1,
+			fmt.Sprintf(`{"errors":[{"message":"%s","path":["confirmSpotImage"],"extensions":{"code":"VALIDATION"}}],"data":null}`, ErrorImageTooLarge),
+			false,
+			true,
+		},
+		{
+			"images",
+			spotImagesQuery,
+			0,
+			0,
+			`{"data":{"spot":{"Images":[{"SpotImageId":"image2","ImageUrl":"https://test-bucket.s3.amazonaws.com/spots/a/user_2/image2"},{"SpotImageId":"image1","ImageUrl":"https://test-bucket.s3.amazonaws.com/spots/a/user_1/image1"}]}}}`,
+			false,
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+
+		t.Run(tc.name, func(t *testing.T) {
+			didPut := false
+			didDelete := false
+			image1 := common.NewSpotImage("a", "image1", "user_1", "test-bucket", "image/jpeg", 1000)
+			image1.CreationTime = "2020-01-01T00:00:00Z"
+			image2 := common.NewSpotImage("a", "image2", "user_2", "test-bucket", "image/png", 1000)
+			image2.CreationTime = "2020-01-02T00:00:00Z"
+
+			data, _ := Asset(SchemaName)
+			db := &mockClientClient{
+				QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if input.Select != nil {
+						require.Equal(t, aws.String("User#user_1"), input.ExpressionAttributeValues[":gsi2"].S)
+						return &dynamodb.QueryOutput{Count: aws.Int64(tc.imageCount)}, nil
+					}
+					if *input.ExpressionAttributeValues[":sk"].S == common.SpotImagePrefix {
+						item1, _ := dynamodbattribute.MarshalMap(image1)
+						item2, _ := dynamodbattribute.MarshalMap(image2)
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{item1, item2}}, nil
+					}
+					return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+				},
+				PutItemFunc: func(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+					didPut = true
+					require.Equal(t, aws.String("Spot#a"), input.Item["PK"].S)
+					require.Equal(t, aws.String("SpotImage#image1"), input.Item["SK"].S)
+					return &dynamodb.PutItemOutput{}, nil
+				},
+			}
+			s3Client := &mockS3Client{
+				PutObjectRequestFunc: func(input *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+					require.True(t, strings.HasPrefix(*input.Key, "spots/a/user_1/"))
+					return presignClient.PutObjectRequest(input)
+				},
+				HeadObjectFunc: func(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+					require.Equal(t, aws.String("spots/a/user_1/image1"), input.Key)
+					return &s3.HeadObjectOutput{ContentType: aws.String("image/jpeg"), ContentLength: aws.Int64(tc.contentLength)}, nil
+				},
+				DeleteObjectFunc: func(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+					didDelete = true
+					return &s3.DeleteObjectOutput{}, nil
+				},
+			}
+			resolver := Resolver{
+				Db:         db,
+				TableName:  "test_table",
+				S3Client:   s3Client,
+				BucketName: "test-bucket",
+			}
+			app := &App{schema: graphql.MustParseSchema(string(data), &resolver, graphql.UseStringDescriptions())}
+			app.awsTokenValidator = &mockAwsTokenValidator{
+				ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+					return user1Claims, nil
+				},
+			}
+
+			request := createTestRequest(tc.query, true)
+			resp, err := app.handler(context.Background(), request)
+			require.Nil(t, err)
+			if tc.response == "" {
+				var body struct {
+					Data struct {
+						RequestSpotImageUpload SpotImageUpload
+					}
+				}
+				require.Nil(t, json.Unmarshal([]byte(resp.Body), &body))
+				upload := body.Data.RequestSpotImageUpload
+				require.NotEmpty(t, upload.SpotImageId)
+				require.Contains(t, upload.UploadUrl, "spots/a/user_1/"+upload.SpotImageId)
+				require.Contains(t, upload.UploadUrl, "X-Amz-Signature=")
+			} else {
+				require.Equal(t, tc.response, resp.Body)
+			}
+			require.Equal(t, tc.didPut, didPut)
+			require.Equal(t, tc.didDelete, didDelete)
+		})
+	}
+}
+
+func TestSpotsInRegion(t *testing.T) {
+
+	testCases := []struct {
+		name      string
+		variables string
+		response  string
+	}{
+		{
+			"bounding box",
+			`{"boundingBox":{"minLatitude":34.99,"minLongitude":136.99,"maxLatitude":35.05,"maxLongitude":137.01}}`,
+			`{"data":{"spotsInRegion":[{"Name":"spot a"},{"Name":"spot d"},{"Name":"spot b"}]}}`,
+		},
+		{
+			"limit",
+			`{"boundingBox":{"minLatitude":34.99,"minLongitude":136.99,"maxLatitude":35.05,"maxLongitude":137.01},"limit":2}`,
+			`{"data":{"spotsInRegion":[{"Name":"spot a"},{"Name":"spot d"}]}}`,
+		},
+		{
+			"limit too large",
+			`{"boundingBox":{"minLatitude":34.99,"minLongitude":136.99,"maxLatitude":35.05,"maxLongitude":137.01},"limit":101}`,
+			fmt.Sprintf(`{"errors":[{"message":"%s","path":["spotsInRegion"],"extensions":{"code":"VALIDATION"}}],"data":null}`, ErrorInvalidLimit),
+		},
+		{
+			"polygon",
+			`{"polygon":{"type":"Polygon","coordinates":[[[136.99,34.99],[137.01,34.99],[137.0,35.005],[136.99,34.99]]]},"spotTypes":["RoadSideStation"]}`,
+			`{"data":{"spotsInRegion":[{"Name":"spot a"}]}}`,
+		},
+		{
+			"too large",
+			`{"boundingBox":{"minLatitude":30.0,"minLongitude":130.0,"maxLatitude":45.0,"maxLongitude":145.0}}`,
+			fmt.Sprintf(`{"errors":[{"message":"%s","path":["spotsInRegion"],"extensions":{"code":"VALIDATION"}}],"data":null}`, common.ErrorRegionTooLarge),
+		},
+		{
+			"no region",
+			`{}`,
+			fmt.Sprintf(`{"errors":[{"message":"%s","path":["spotsInRegion"],"extensions":{"code":"VALIDATION"}}],"data":null}`, common.ErrorInvalidRegion),
+		},
+	}
+
+	for _, tc := range testCases {
+
+		t.Run(tc.name, func(t *testing.T) {
+			app := createSpotsTestApp(t, testSpots)
+			app.awsTokenValidator = &mockAwsTokenValidator{
+				ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+					return user1Claims, nil
+				},
+			}
+
+			request := createTestRequest(fmt.Sprintf(spotsInRegionQuery, tc.variables), true)
+			resp, err := app.handler(context.Background(), request)
+			require.Nil(t, err)
+			require.Equal(t, tc.response, resp.Body)
+		})
+	}
+}
+
+func TestSpotFilters(t *testing.T) {
+
+	testCases := []struct {
+		name      string
+		variables string
+		response  string
+	}{
+		{
+			"spot types",
+			`{"geohash":"x","spotTypes":["Parking"]}`,
+			`{"data":{"spotsByGeohash":{"edges":[{"node":{"Name":"spot d"}}]}}}`,
+		},
+		{
+			"all tags",
+			`{"geohash":"x","allTags":["Toilet","Wifi"]}`,
+			`{"data":{"spotsByGeohash":{"edges":[{"node":{"Name":"spot a"}}]}}}`,
+		},
+		{
+			"any tags",
+			`{"geohash":"x","spotTypes":["RoadSideStation"],"anyTags":["Toilet","Wifi"]}`,
+			`{"data":{"spotsByGeohash":{"edges":[{"node":{"Name":"spot a"}},{"node":{"Name":"spot b"}}]}}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+
+		t.Run(tc.name, func(t *testing.T) {
+			spots := []common.Spot{}
+			for _, s := range testSpots {
+				s.SK = strings.Replace(s.SK, common.SpotPrefix, common.SpotPrefix+"x", 1)
+				spots = append(spots, s)
+			}
+			app := createSpotsTestApp(t, spots)
+			app.awsTokenValidator = &mockAwsTokenValidator{
+				ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+					return user1Claims, nil
+				},
+			}
+
+			request := createTestRequest(fmt.Sprintf(spotsByGeohashQuery, tc.variables), true)
+			resp, err := app.handler(context.Background(), request)
+			require.Nil(t, err)
+			require.Equal(t, tc.response, resp.Body)
+		})
+	}
+
+	t.Run("imported spot type", func(t *testing.T) {
+		spot := testSpot("e", "spot e", "Onsen", 35.0, 137.0, "Bath")
+		spot.SK = strings.Replace(spot.SK, common.SpotPrefix, common.SpotPrefix+"x", 1)
+		app := createSpotsTestApp(t, []common.Spot{spot})
+		app.awsTokenValidator = &mockAwsTokenValidator{
+			ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+				return user1Claims, nil
+			},
+		}
+
+		request := createTestRequest(`{"query":"{spotsByGeohash(geohash: \"x\"){edges{node{Name SpotType}}}}"}`, true)
+		resp, err := app.handler(context.Background(), request)
+		require.Nil(t, err)
+		require.Equal(t, `{"data":{"spotsByGeohash":{"edges":[{"node":{"Name":"spot e","SpotType":"Onsen"}}]}}}`, resp.Body)
+
+		request = createTestRequest(fmt.Sprintf(spotsByGeohashQuery, `{"geohash":"x","spotTypes":["Onsen"]}`), true)
+		resp, err = app.handler(context.Background(), request)
+		require.Nil(t, err)
+		require.Contains(t, resp.Body, `"errors"`)
+		require.NotContains(t, resp.Body, `"data"`)
+	})
+}
+
+func TestReviewsPagination(t *testing.T) {
+
+	reviews := []map[string]*dynamodb.AttributeValue{}
+	for i := 1; i <= 3; i++ {
+		reviews = append(reviews, map[string]*dynamodb.AttributeValue{
+			"PK":           {S: aws.String("Spot#spot1")},
+			"SK":           {S: aws.String(fmt.Sprintf("Review#2020-01-0%d", i))},
+			"GSI1":         {S: aws.String(fmt.Sprintf("Review#review%d", i))},
+			"CreationTime": {S: aws.String(fmt.Sprintf("2020-01-0%d", i))},
+		})
+	}
+
+	data, _ := Asset(SchemaName)
+	schemaString := string(data)
+	db := &mockClientClient{
+		QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			require.Equal(t, aws.String("Review#"), input.ExpressionAttributeValues[":sk"].S)
+			start := 0
+			if input.ExclusiveStartKey != nil {
+				for i, r := range reviews {
+					if *r["SK"].S == *input.ExclusiveStartKey["SK"].S {
+						start = i + 1
+					}
+				}
+			}
+			end := start + int(*input.Limit)
+			output := dynamodb.QueryOutput{}
+			if end < len(reviews) {
+				output.LastEvaluatedKey = common.ItemKey(reviews[end-1], input.IndexName)
+			} else {
+				end = len(reviews)
+			}
+			output.Items = reviews[start:end]
+			return &output, nil
+		},
+	}
+	resolver := Resolver{
+		Db:        db,
+		TableName: "test_table",
+	}
+	schema := graphql.MustParseSchema(schemaString, &resolver, graphql.UseStringDescriptions())
+	app := &App{schema: schema}
+
+	resp, err := app.handler(context.Background(), createTestRequest(fmt.Sprintf(reviewsPageQuery, `{"spotId":"spot1","first":2}`), false))
+	require.Nil(t, err)
+	var firstPage struct {
+		Data struct {
+			Reviews struct {
+				Edges []struct {
+					Node struct{ ReviewId string }
+				}
+				PageInfo struct {
+					HasNextPage bool
+					EndCursor   string
+				}
+			}
+		}
+	}
+	require.Nil(t, json.Unmarshal([]byte(resp.Body), &firstPage))
+	require.Equal(t, 2, len(firstPage.Data.Reviews.Edges))
+	require.Equal(t, "review2", firstPage.Data.Reviews.Edges[1].Node.ReviewId)
+	require.True(t, firstPage.Data.Reviews.PageInfo.HasNextPage)
+
+	variables := fmt.Sprintf(`{"spotId":"spot1","first":2,"after":"%s"}`, firstPage.Data.Reviews.PageInfo.EndCursor)
+	resp, err = app.handler(context.Background(), createTestRequest(fmt.Sprintf(reviewsPageQuery, variables), false))
+	require.Nil(t, err)
+	require.Contains(t, resp.Body, `"edges":[{"node":{"ReviewId":"review3"}}],"pageInfo":{"hasNextPage":false`)
+
+	resp, err = app.handler(context.Background(), createTestRequest(fmt.Sprintf(reviewsPageQuery, `{"spotId":"spot1","after":"not a cursor"}`), false))
+	require.Nil(t, err)
+	require.Contains(t, resp.Body, common.ErrorInvalidCursor)
+}
+
 func TestReserveSpot(t *testing.T) {
 
 	testCases := []struct {
@@ -305,3 +808,2713 @@ func TestReserveSpot(t *testing.T) {
 	}
 
 }
+
+func TestLoader(t *testing.T) {
+
+	reviews := []map[string]*dynamodb.AttributeValue{}
+	for i, userId := range []string{"user1", "user2", "user1", "deleted"} {
+		reviews = append(reviews, map[string]*dynamodb.AttributeValue{
+			"PK":           {S: aws.String("Spot#spot1")},
+			"SK":           {S: aws.String(fmt.Sprintf("Review#2020-01-0%d", i))},
+			"GSI1":         {S: aws.String(fmt.Sprintf("Review#review%d", i))},
+			"GSI2":         {S: aws.String("User#" + userId)},
+			"CreationTime": {S: aws.String(fmt.Sprintf("2020-01-0%d", i))},
+		})
+	}
+
+	batchGetCount := 0
+	data, _ := Asset(SchemaName)
+	db := &mockClientClient{
+		QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			return &dynamodb.QueryOutput{Items: reviews}, nil
+		},
+		BatchGetItemFunc: func(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+			batchGetCount++
+			keys := input.RequestItems["test_table"].Keys
+			require.Equal(t, 3, len(keys))
+			items := []map[string]*dynamodb.AttributeValue{}
+			for _, key := range keys {
+				require.Equal(t, key["PK"].S, key["SK"].S)
+				if *key["PK"].S != "User#deleted" {
+					items = append(items, map[string]*dynamodb.AttributeValue{
+						"PK":       key["PK"],
+						"SK":       key["SK"],
+						"Nickname": {S: aws.String("nick " + *key["PK"].S)},
+					})
+				}
+			}
+			return &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{"test_table": items}}, nil
+		},
+	}
+	resolver := Resolver{
+		Db:        db,
+		TableName: "test_table",
+	}
+	schema := graphql.MustParseSchema(string(data), &resolver, graphql.UseStringDescriptions())
+	app := &App{schema: schema, resolver: &resolver}
+
+	resp, err := app.handler(context.Background(), createTestRequest(reviewUsersQuery, false))
+	require.Nil(t, err)
+	require.Equal(t, 1, batchGetCount)
+	require.Equal(t, `{"data":{"reviews":{"edges":[`+
+		`{"node":{"ReviewId":"review0","User":{"UserId":"user1","Nickname":"nick User#user1"}}},`+
+		`{"node":{"ReviewId":"review1","User":{"UserId":"user2","Nickname":"nick User#user2"}}},`+
+		`{"node":{"ReviewId":"review2","User":{"UserId":"user1","Nickname":"nick User#user1"}}},`+
+		`{"node":{"ReviewId":"review3","User":null}}]}}}`, resp.Body)
+
+	t.Run("forget spot", func(t *testing.T) {
+		queryCount := 0
+		spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+		resolver := Resolver{
+			Db: &mockClientClient{
+				QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					queryCount++
+					return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+				},
+			},
+			TableName: "test_table",
+		}
+		ctx := withLoader(context.Background(), newLoader(&resolver))
+		load := func(projection common.Projection) {
+			spot, err := resolver.loadSpot(ctx, "a", projection)
+			require.Nil(t, err)
+			require.NotNil(t, spot)
+		}
+		load(nil)
+		load(common.Projection{NameKey})
+		load(nil)
+		require.Equal(t, 2, queryCount)
+		// a mutation wrote the spot, every projection of it is read again
+		resolver.forgetSpot(ctx, "a")
+		load(nil)
+		load(common.Projection{NameKey})
+		require.Equal(t, 4, queryCount)
+	})
+}
+
+func TestQueryLimits(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	db := &mockClientClient{
+		QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			return &dynamodb.QueryOutput{}, nil
+		},
+	}
+	resolver := Resolver{Db: db, TableName: "test_table"}
+
+	tests := []struct {
+		name   string
+		limits queryLimits
+		query  string
+		expect string
+	}{
+		{
+			name:   "within limits",
+			limits: queryLimits{MaxDepth: 14, MaxCost: 34567},
+			query:  nestedReviewsQuery,
+			expect: `{"data":{"spotsByGeohash":{"edges":[]}}}`,
+		},
+		{
+			name:   "too deep",
+			limits: queryLimits{MaxDepth: 13, MaxCost: 34567},
+			query:  nestedReviewsQuery,
+			expect: `{"errors":[{"message":"Field \"Message\" has depth 14 that exceeds max depth 13","locations":[{"line":1,"column":155}],"extensions":{"code":"VALIDATION"}}]}`,
+		},
+		{
+			// 1 + 2 * (edges 0 + node 0 + Reviews 1 + 20 * (User 1 + CreatedSpots 1 + 20 * Reviews 1))
+			name:   "too complex",
+			limits: queryLimits{MaxCost: 882, FieldCosts: defaultFieldCosts},
+			query:  nestedReviewsQuery,
+			expect: `{"errors":[{"message":"ErrorQueryTooComplex","extensions":{"code":"VALIDATION","cost":883,"maxCost":882}}]}`,
+		},
+		{
+			name:   "field costs",
+			limits: queryLimits{MaxCost: 10, FieldCosts: map[string]int{"spotsNear": 11}},
+			query:  `{"query":"{spotsNear(latitude: 35.0, longitude: 137.0, radiusMeters: 1000){Name}}"}`,
+			expect: `{"errors":[{"message":"ErrorQueryTooComplex","extensions":{"code":"VALIDATION","cost":11,"maxCost":10}}]}`,
+		},
+		{
+			// 1 + 2 * (Reviews 1 + 20 * 0), the fragment is spread into the connection
+			name:   "fragments and variables",
+			limits: queryLimits{MaxCost: 2, FieldCosts: defaultFieldCosts},
+			query:  `{"query":"query Q($first: Int){spotsByGeohash(geohash: \"xn\", first: $first){...page}} fragment page on SpotConnection{edges{node{Reviews{edges{node{Message}}}}}}","variables":{"first":2}}`,
+			expect: `{"errors":[{"message":"ErrorQueryTooComplex","extensions":{"code":"VALIDATION","cost":3,"maxCost":2}}]}`,
+		},
+		{
+			name:   "syntax error",
+			limits: queryLimits{MaxCost: 10},
+			query:  `{"query":"{spot(spotId: \"a\"){Name}"}`,
+			expect: `{"errors":[{"message":"Expected Name, found \u003cEOF\u003e","extensions":{"code":"VALIDATION"}}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, append(schemaOptions(), graphql.MaxDepth(test.limits.MaxDepth))...),
+				resolver: &resolver,
+				limits:   test.limits,
+			}
+			app.awsTokenValidator = &mockAwsTokenValidator{
+				ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+					return user1Claims, nil
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, true))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+		})
+	}
+}
+
+func TestPersistedQueries(t *testing.T) {
+
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	query := `{spot(spotId: "a"){Name}}`
+	hash := queryHash(query)
+	extensions := fmt.Sprintf(`{"persistedQuery":{"version":1,"sha256Hash":"%s"}}`, hash)
+
+	stored := map[string]map[string]*dynamodb.AttributeValue{}
+	getItems := 0
+	var getItemErr error
+	db := &mockClientClient{
+		QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+		},
+		GetItemFunc: func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+			if getItemErr != nil {
+				return nil, getItemErr
+			}
+			getItems++
+			return &dynamodb.GetItemOutput{Item: stored[*input.Key["PK"].S]}, nil
+		},
+		PutItemFunc: func(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			require.Equal(t, PersistedQueryPrefix+hash, *input.Item["PK"].S)
+			require.Equal(t, query, *input.Item["Query"].S)
+			stored[*input.Item["PK"].S] = input.Item
+			return &dynamodb.PutItemOutput{}, nil
+		},
+	}
+	resolver := Resolver{Db: db, TableName: "test_table"}
+	data, _ := Asset(SchemaName)
+	newApp := func() *App {
+		return &App{
+			schema:           graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+			resolver:         &resolver,
+			persistedQueries: newPersistedQueries(db, "test_table"),
+		}
+	}
+	getRequest := func(params map[string]string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: "GET", QueryStringParameters: params}
+	}
+	app := newApp()
+
+	// unknown hash
+	resp, err := app.handler(context.Background(), getRequest(map[string]string{"extensions": extensions}))
+	require.Nil(t, err)
+	require.Equal(t, `{"errors":[{"message":"PersistedQueryNotFound","extensions":{"code":"PERSISTED_QUERY_NOT_FOUND"}}]}`, resp.Body)
+	require.Equal(t, "", resp.Headers["Cache-Control"])
+
+	// wrong hash
+	resp, err = app.handler(context.Background(), getRequest(map[string]string{
+		"query":      `{spot(spotId: "b"){Name}}`,
+		"extensions": extensions,
+	}))
+	require.Nil(t, err)
+	require.Equal(t, `{"errors":[{"message":"ErrorPersistedQueryHashMismatch","extensions":{"code":"PERSISTED_QUERY_HASH_MISMATCH"}}]}`, resp.Body)
+	require.Equal(t, 0, len(stored))
+
+	// the lookup fails
+	getItemErr = errors.New("dynamodb is down")
+	resp, err = app.handler(context.Background(), getRequest(map[string]string{"extensions": extensions}))
+	require.Nil(t, err)
+	require.Equal(t, 500, resp.StatusCode)
+	require.Equal(t, `{"errors":[{"message":"ErrorInternal","extensions":{"code":"INTERNAL"}}]}`, resp.Body)
+	getItemErr = nil
+
+	// queries that do not validate are not registered
+	invalidQuery := `{spot(spotId: "a"){Unknown}}`
+	resp, err = app.handler(context.Background(), getRequest(map[string]string{
+		"query":      invalidQuery,
+		"extensions": fmt.Sprintf(`{"persistedQuery":{"version":1,"sha256Hash":"%s"}}`, queryHash(invalidQuery)),
+	}))
+	require.Nil(t, err)
+	require.Contains(t, resp.Body, `"code":"VALIDATION"`)
+	require.Equal(t, 0, len(stored))
+
+	// register
+	resp, err = app.handler(context.Background(), getRequest(map[string]string{"query": query, "extensions": extensions}))
+	require.Nil(t, err)
+	require.Equal(t, `{"data":{"spot":{"Name":"spot a"}}}`, resp.Body)
+	require.Equal(t, 1, len(stored))
+
+	// cached hash
+	resp, err = app.handler(context.Background(), getRequest(map[string]string{"extensions": extensions}))
+	require.Nil(t, err)
+	require.Equal(t, `{"data":{"spot":{"Name":"spot a"}}}`, resp.Body)
+	require.Equal(t, "public, max-age=60", resp.Headers["Cache-Control"])
+	require.Equal(t, 1, getItems)
+
+	// another lambda instance reads the query from dynamodb
+	resp, err = newApp().handler(context.Background(), createTestRequest(fmt.Sprintf(`{"extensions":%s}`, extensions), false))
+	require.Nil(t, err)
+	require.Equal(t, `{"data":{"spot":{"Name":"spot a"}}}`, resp.Body)
+	require.Equal(t, "", resp.Headers["Cache-Control"])
+	require.Equal(t, 2, getItems)
+
+	// mutations need a POST
+	resp, err = app.handler(context.Background(), getRequest(map[string]string{
+		"query": `mutation {createReview(spotId: "a", rating: 5){ReviewId}}`,
+	}))
+	require.Nil(t, err)
+	require.Equal(t, 405, resp.StatusCode)
+	require.Equal(t, `{"errors":[{"message":"ErrorOperationNotAllowed","extensions":{"code":"VALIDATION"}}]}`, resp.Body)
+
+	// not supported without a store
+	resp, err = (&App{schema: app.schema}).handler(context.Background(), getRequest(map[string]string{"extensions": extensions}))
+	require.Nil(t, err)
+	require.Equal(t, `{"errors":[{"message":"PersistedQueryNotSupported","extensions":{"code":"PERSISTED_QUERY_NOT_SUPPORTED"}}]}`, resp.Body)
+}
+
+func TestHTTP(t *testing.T) {
+
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	db := &mockClientClient{
+		QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+		},
+	}
+	resolver := Resolver{Db: db, TableName: "test_table"}
+	data, _ := Asset(SchemaName)
+	app := &App{
+		schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+		resolver: &resolver,
+		awsTokenValidator: &mockAwsTokenValidator{
+			ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+				return nil, errors.New("expired")
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		request     events.APIGatewayProxyRequest
+		statusCode  int
+		contentType string
+		expect      string
+	}{
+		{
+			name:        "operation name",
+			request:     events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: `{"query":"query A{spot(spotId: \"a\"){SpotId}} query B{spot(spotId: \"a\"){Name}}","operationName":"B"}`},
+			statusCode:  200,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			expect:      `{"data":{"spot":{"Name":"spot a"}}}`,
+		},
+		{
+			name:        "invalid json",
+			request:     events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: `{"query":`},
+			statusCode:  400,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			expect:      `{"errors":[{"message":"ErrorInvalidRequest","extensions":{"code":"VALIDATION"}}]}`,
+		},
+		{
+			name:        "validation error",
+			request:     events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: `{"query":"{spot(spotId: \"a\"){Unknown}}"}`},
+			statusCode:  400,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			expect:      `{"errors":[{"message":"Cannot query field \"Unknown\" on type \"Spot\".","locations":[{"line":1,"column":20}],"extensions":{"code":"VALIDATION"}}]}`,
+		},
+		{
+			name: "validation error as application/json",
+			request: events.APIGatewayProxyRequest{
+				HTTPMethod: "POST",
+				Headers:    map[string]string{"accept": "application/json", "content-type": "application/json; charset=utf-8"},
+				Body:       `{"query":"{spot(spotId: \"a\"){Unknown}}"}`,
+			},
+			statusCode:  200,
+			contentType: "application/json; charset=utf-8",
+			expect:      `{"errors":[{"message":"Cannot query field \"Unknown\" on type \"Spot\".","locations":[{"line":1,"column":20}],"extensions":{"code":"VALIDATION"}}]}`,
+		},
+		{
+			name: "batch",
+			request: events.APIGatewayProxyRequest{
+				HTTPMethod: "POST",
+				Body:       `[{"query":"{spot(spotId: \"a\"){Name}}"},{"query":"{spot(spotId: \"a\"){Unknown}}"},{"query":"{spot(spotId: \"a\"){SpotId}}"}]`,
+			},
+			statusCode:  200,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			expect:      `[{"data":{"spot":{"Name":"spot a"}}},{"errors":[{"message":"Cannot query field \"Unknown\" on type \"Spot\".","locations":[{"line":1,"column":20}],"extensions":{"code":"VALIDATION"}}]},{"data":{"spot":{"SpotId":"a"}}}]`,
+		},
+		{
+			name:        "empty batch",
+			request:     events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: `[]`},
+			statusCode:  400,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			expect:      `{"errors":[{"message":"ErrorInvalidRequest","extensions":{"code":"VALIDATION"}}]}`,
+		},
+		{
+			name: "unsupported media type",
+			request: events.APIGatewayProxyRequest{
+				HTTPMethod: "POST",
+				Headers:    map[string]string{"Content-Type": "text/plain"},
+				Body:       `{"query":"{spot(spotId: \"a\"){Name}}"}`,
+			},
+			statusCode:  415,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			expect:      `{"errors":[{"message":"ErrorUnsupportedMediaType","extensions":{"code":"VALIDATION"}}]}`,
+		},
+		{
+			name: "not acceptable",
+			request: events.APIGatewayProxyRequest{
+				HTTPMethod: "POST",
+				Headers:    map[string]string{"Accept": "text/html"},
+				Body:       `{"query":"{spot(spotId: \"a\"){Name}}"}`,
+			},
+			statusCode:  406,
+			contentType: "application/json; charset=utf-8",
+			expect:      `{"errors":[{"message":"ErrorNotAcceptable","extensions":{"code":"VALIDATION"}}]}`,
+		},
+		{
+			name:        "method not allowed",
+			request:     events.APIGatewayProxyRequest{HTTPMethod: "PUT", Body: `{"query":"{spot(spotId: \"a\"){Name}}"}`},
+			statusCode:  405,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			expect:      `{"errors":[{"message":"ErrorOperationNotAllowed","extensions":{"code":"VALIDATION"}}]}`,
+		},
+		{
+			name: "invalid id token",
+			request: events.APIGatewayProxyRequest{
+				HTTPMethod: "POST",
+				Headers:    map[string]string{"Authorization": "Bearer testIdToken"},
+				Body:       `{"query":"{spot(spotId: \"a\"){Name}}"}`,
+			},
+			statusCode:  401,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			expect:      `{"errors":[{"message":"ErrorInvalidIdToken","extensions":{"code":"UNAUTHENTICATED"}}]}`,
+		},
+		{
+			name: "lower case authorization header",
+			request: events.APIGatewayProxyRequest{
+				HTTPMethod:            "GET",
+				Headers:               map[string]string{"authorization": "Bearer testIdToken"},
+				QueryStringParameters: map[string]string{"query": `{spot(spotId: "a"){Name}}`},
+			},
+			statusCode:  401,
+			contentType: "application/graphql-response+json; charset=utf-8",
+			expect:      `{"errors":[{"message":"ErrorInvalidIdToken","extensions":{"code":"UNAUTHENTICATED"}}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := app.handler(context.Background(), test.request)
+			require.Nil(t, err)
+			require.Equal(t, test.statusCode, resp.StatusCode)
+			require.Equal(t, test.contentType, resp.Headers["Content-Type"])
+			require.Equal(t, test.expect, resp.Body)
+		})
+	}
+
+	t.Run("batched mutations", func(t *testing.T) {
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		messages := []string{}
+		resolver := Resolver{
+			Db: &mockClientClient{
+				QueryFunc: db.QueryFunc,
+				TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+					mu.Lock()
+					running++
+					if running > maxRunning {
+						maxRunning = running
+					}
+					messages = append(messages, aws.StringValue(input.TransactItems[1].Put.Item[MessageKey].S))
+					mu.Unlock()
+					time.Sleep(10 * time.Millisecond)
+					mu.Lock()
+					running--
+					mu.Unlock()
+					return &dynamodb.TransactWriteItemsOutput{}, nil
+				},
+			},
+			TableName: "test_table",
+		}
+		app := &App{
+			schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+			resolver: &resolver,
+			awsTokenValidator: &mockAwsTokenValidator{
+				ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+					return user1Claims, nil
+				},
+			},
+		}
+		review := func(message string) string {
+			return fmt.Sprintf(`{"query":"mutation { createReview(spotId: \"a\", rating: 4, message: \"%s\") { Message } }"}`, message)
+		}
+		request := createTestRequest(fmt.Sprintf(`[%s,{"query":"{spot(spotId: \"a\"){Name}}"},%s,%s]`, review("1"), review("2"), review("3")), true)
+		resp, err := app.handler(context.Background(), request)
+		require.Nil(t, err)
+		require.Equal(t, `[{"data":{"createReview":{"Message":"1"}}},{"data":{"spot":{"Name":"spot a"}}},{"data":{"createReview":{"Message":"2"}}},{"data":{"createReview":{"Message":"3"}}}]`, resp.Body)
+		require.Equal(t, []string{"1", "2", "3"}, messages)
+		require.Equal(t, 1, maxRunning)
+	})
+}
+
+func TestErrorCodes(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	tests := []struct {
+		name     string
+		query    string
+		queryErr error
+		auth     bool
+		expect   string
+	}{
+		{
+			name:   "not found",
+			query:  `{"query":"{spot(spotId: \"a\"){Name}}"}`,
+			expect: `{"errors":[{"message":"ErrorSpotNotFound","path":["spot"],"extensions":{"code":"NOT_FOUND"}}],"data":null}`,
+		},
+		{
+			name:   "unauthenticated",
+			query:  `{"query":"{spotsByGeohash(geohash: \"xn\"){pageInfo{hasNextPage}}}"}`,
+			expect: `{"errors":[{"message":"ErrorUserIsNotAuthenticated","path":["spotsByGeohash"],"extensions":{"code":"UNAUTHENTICATED"}}],"data":null}`,
+		},
+		{
+			name:     "upstream unavailable",
+			query:    `{"query":"{spot(spotId: \"a\"){Name}}"}`,
+			queryErr: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "rate of requests exceeds the allowed throughput", nil),
+			expect:   `{"errors":[{"message":"ErrorUpstreamUnavailable","path":["spot"],"extensions":{"code":"UPSTREAM_UNAVAILABLE"}}],"data":null}`,
+		},
+		{
+			name:     "internal",
+			query:    `{"query":"{spot(spotId: \"a\"){Name}}"}`,
+			queryErr: errors.New("dial tcp 10.0.0.1:443: connection refused"),
+			expect:   `{"errors":[{"message":"ErrorInternal","path":["spot"],"extensions":{"code":"INTERNAL"}}],"data":null}`,
+		},
+		{
+			name:   "validation",
+			query:  `{"query":"{spotsNear(latitude: 35.0, longitude: 137.0, radiusMeters: -1){Name}}"}`,
+			auth:   true,
+			expect: `{"errors":[{"message":"ErrorInvalidRadius","path":["spotsNear"],"extensions":{"code":"VALIDATION"}}],"data":null}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						return &dynamodb.QueryOutput{}, test.queryErr
+					},
+				},
+				TableName: "test_table",
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return user1Claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, test.auth))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+		})
+	}
+}
+
+func TestProjection(t *testing.T) {
+
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	projections := map[string]string{}
+	var mu sync.Mutex
+	record := func(name string, expression *string, names map[string]*string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if expression == nil {
+			projections[name] = "*"
+			return
+		}
+		attributes := []string{}
+		for _, placeholder := range strings.Split(*expression, ", ") {
+			attributes = append(attributes, *names[placeholder])
+		}
+		projections[name] = strings.Join(attributes, ",")
+	}
+
+	data, _ := Asset(SchemaName)
+	db := &mockClientClient{
+		QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+			switch {
+			case input.IndexName != nil:
+				record("spotsNear", input.ProjectionExpression, input.ExpressionAttributeNames)
+				return &dynamodb.QueryOutput{}, nil
+			case *input.ExpressionAttributeValues[":sk"].S == ReviewPrefix:
+				record("reviews", input.ProjectionExpression, input.ExpressionAttributeNames)
+				return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{{
+					"PK":   {S: aws.String("Spot#a")},
+					"SK":   {S: aws.String("Review#2020-01-01")},
+					"GSI1": {S: aws.String("Review#review1")},
+					"GSI2": {S: aws.String("User#user1")},
+				}}}, nil
+			}
+			record("spot", input.ProjectionExpression, input.ExpressionAttributeNames)
+			return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+		},
+		BatchGetItemFunc: func(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+			keysAndAttributes := input.RequestItems["test_table"]
+			record("users", keysAndAttributes.ProjectionExpression, keysAndAttributes.ExpressionAttributeNames)
+			return &dynamodb.BatchGetItemOutput{}, nil
+		},
+	}
+	resolver := Resolver{
+		Db:        db,
+		TableName: "test_table",
+	}
+	app := &App{schema: graphql.MustParseSchema(string(data), &resolver, schemaOptions()...), resolver: &resolver}
+	app.awsTokenValidator = &mockAwsTokenValidator{
+		ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+			return user1Claims, nil
+		},
+	}
+
+	resp, err := app.handler(context.Background(), createTestRequest(projectionQuery, true))
+	require.Nil(t, err)
+	require.Equal(t, `{"data":{"spot":{"Name":"spot a","Tags":["Toilet","Wifi"],"Reviews":{"edges":[{"node":{"Rating":null,"User":null}}]}},"nearby":[]}}`, resp.Body)
+	require.Equal(t, map[string]string{
+		"spot":      "PK,SK,GSI1,GSI2,Name,Tags",
+		"reviews":   "*", // the items of connections are read whole
+		"users":     "PK,SK,GSI1,GSI2,Nickname",
+		"spotsNear": "PK,SK,GSI1,GSI2,Name,Latitude,Longitude",
+	}, projections)
+}
+
+func TestSubscriptions(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	review := Review{
+		PK:           "Spot#a",
+		SK:           "Review#2020-12-01T00:00:00Z",
+		GSI1:         aws.String("Review#r1"),
+		CreationTime: "2020-12-01T00:00:00Z",
+		Message:      aws.String("quiet at night"),
+	}
+	otherReview := review
+	otherReview.PK = "Spot#b"
+	farSpot := testSpots[2]
+	reviewAdded := `{"id":"1","type":"subscribe","payload":{"query":"subscription { reviewAdded(spotId: \"a\") { ReviewId Message } }"}}`
+	spotUpdated := `{"id":"2","type":"subscribe","payload":{"query":"subscription($box: BoundingBoxInput) { spotUpdated(boundingBox: $box) { SpotId Name } }","variables":{"box":{"minLatitude":34.99,"minLongitude":136.99,"maxLatitude":35.02,"maxLongitude":137.01}}}}`
+
+	tests := []struct {
+		name      string
+		messages  []string
+		events    []subscriptionEvent
+		expect    []string
+		published []string
+	}{
+		{
+			name:     "connection init",
+			messages: []string{`{"type":"connection_init"}`, `{"type":"ping"}`},
+			expect:   []string{`{"type":"connection_ack"}`, `{"type":"pong"}`},
+		},
+		{
+			name:      "review added",
+			messages:  []string{reviewAdded},
+			events:    []subscriptionEvent{reviewAddedEvent(otherReview), reviewAddedEvent(review)},
+			expect:    []string{},
+			published: []string{`{"id":"1","type":"next","payload":{"data":{"reviewAdded":{"ReviewId":"r1","Message":"quiet at night"}}}}`},
+		},
+		{
+			name:      "spot updated in region",
+			messages:  []string{spotUpdated},
+			events:    []subscriptionEvent{spotUpdatedEvent(farSpot), spotUpdatedEvent(testSpots[0])},
+			expect:    []string{},
+			published: []string{`{"id":"2","type":"next","payload":{"data":{"spotUpdated":{"SpotId":"a","Name":"spot a"}}}}`},
+		},
+		{
+			name:      "completed",
+			messages:  []string{reviewAdded, `{"id":"1","type":"complete"}`},
+			events:    []subscriptionEvent{reviewAddedEvent(review)},
+			expect:    []string{},
+			published: []string{},
+		},
+		{
+			name:     "invalid arguments",
+			messages: []string{`{"id":"3","type":"subscribe","payload":{"query":"subscription { spotUpdated { SpotId } }"}}`},
+			expect:   []string{`{"id":"3","type":"error","payload":[{"message":"ErrorInvalidRegion","extensions":{"code":"VALIDATION"}}]}`},
+		},
+		{
+			name:     "query",
+			messages: []string{`{"id":"4","type":"subscribe","payload":{"query":"{ __typename }"}}`},
+			expect:   []string{`{"id":"4","type":"next","payload":{"data":{"__typename":"Query"}}}`, `{"id":"4","type":"complete"}`},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hub := newHub()
+			resolver := Resolver{TableName: "test_table"}
+			schema := graphql.MustParseSchema(string(data), &resolver, schemaOptions()...)
+			resolver.Publisher = &Publisher{schema: schema, subscriptions: hub, sender: hub}
+			app := &App{
+				schema:        schema,
+				resolver:      &resolver,
+				subscriptions: hub,
+				sender:        hub,
+			}
+
+			resp, err := app.websocketHandler(context.Background(), createTestWebSocketRequest("$connect", "c1", ""))
+			require.Nil(t, err)
+			require.Equal(t, WebSocketProtocol, resp.Headers["Sec-WebSocket-Protocol"])
+			for _, message := range test.messages {
+				resp, err := app.websocketHandler(context.Background(), createTestWebSocketRequest("$default", "c1", message))
+				require.Nil(t, err)
+				require.Equal(t, 200, resp.StatusCode)
+			}
+			require.Equal(t, test.expect, drainMessages(hub, "c1"))
+
+			for _, event := range test.events {
+				resolver.publish(context.Background(), event)
+			}
+			if test.published != nil {
+				require.Equal(t, test.published, drainMessages(hub, "c1"))
+			}
+
+			_, err = app.websocketHandler(context.Background(), createTestWebSocketRequest("$disconnect", "c1", ""))
+			require.Nil(t, err)
+			require.Nil(t, hub.messages("c1"))
+		})
+	}
+}
+
+func TestUpdateSpot(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	spot := testSpots[0]
+	spot.GSI1 = aws.String("User#user_1")
+	spot.ReviewCount = aws.Int64(2)
+	spotItem, _ := dynamodbattribute.MarshalMap(spot)
+	movedSK := fmt.Sprintf("%s%s", common.SpotPrefix, common.SpotGeohash(35.1, 137.0))
+
+	tests := []struct {
+		name      string
+		claims    *AWSCognitoClaims
+		query     string
+		expect    string
+		ops       []string
+		cancelled int // moves cancelled because the spot changed
+	}{
+		{
+			name:   "rename",
+			claims: user1Claims,
+			query:  `{"query":"mutation { updateSpot(spotId: \"a\", patch: {name: \"new name\", tags: [\"Wifi\"]}) { SpotId Name Tags } }"}`,
+			expect: `{"data":{"updateSpot":{"SpotId":"a","Name":"new name","Tags":["Wifi"]}}}`,
+			ops:    []string{"update SET #Name = :Name, #Tags = :Tags", "mapbox add", "query GSI1 Spot#a", "update SET #destinationSpotType = :destinationSpotType, #destinationName = :destinationName REMOVE #destinationDescription"},
+		},
+		{
+			name:   "move",
+			claims: user1Claims,
+			query:  `{"query":"mutation { updateSpot(spotId: \"a\", patch: {latitude: 35.1}) { SpotId Latitude } }"}`,
+			expect: `{"data":{"updateSpot":{"SpotId":"a","Latitude":35.1}}}`,
+			ops:    []string{"transaction delete " + spot.SK + " put " + movedSK, "mapbox add", "query Spot#a", "delete SpotDistance#b", "query GSI1 Spot#a", "delete SpotDistance#a", "delete SpotDistances"},
+		},
+		{
+			name:      "move after a review",
+			claims:    user1Claims,
+			query:     `{"query":"mutation { updateSpot(spotId: \"a\", patch: {latitude: 35.1}) { SpotId Latitude } }"}`,
+			expect:    `{"data":{"updateSpot":{"SpotId":"a","Latitude":35.1}}}`,
+			ops:       []string{"transaction delete " + spot.SK + " put " + movedSK, "transaction delete " + spot.SK + " put " + movedSK, "mapbox add", "query Spot#a", "delete SpotDistance#b", "query GSI1 Spot#a", "delete SpotDistance#a", "delete SpotDistances"},
+			cancelled: 1,
+		},
+		{
+			name:      "move conflict",
+			claims:    user1Claims,
+			query:     `{"query":"mutation { updateSpot(spotId: \"a\", patch: {latitude: 35.1}) { SpotId Latitude } }"}`,
+			expect:    `{"errors":[{"message":"ErrorConflict","path":["updateSpot"],"extensions":{"code":"CONFLICT"}}],"data":null}`,
+			ops:       []string{"transaction delete " + spot.SK + " put " + movedSK, "transaction delete " + spot.SK + " put " + movedSK, "transaction delete " + spot.SK + " put " + movedSK},
+			cancelled: maxSpotMoveAttempts,
+		},
+		{
+			name:   "admin",
+			claims: adminUserClaims,
+			query:  `{"query":"mutation { updateSpot(spotId: \"a\", patch: {address: \"Gifu\"}) { SpotId } }"}`,
+			expect: `{"data":{"updateSpot":{"SpotId":"a"}}}`,
+			ops:    []string{"update SET #Address = :Address"},
+		},
+		{
+			name:   "not creator",
+			claims: sellerUser1Claims,
+			query:  `{"query":"mutation { updateSpot(spotId: \"a\", patch: {name: \"new name\"}) { SpotId } }"}`,
+			expect: `{"errors":[{"message":"ErrorUserIsNotSpotCreator","path":["updateSpot"],"extensions":{"code":"FORBIDDEN"}}],"data":null}`,
+		},
+		{
+			name:   "invalid coordinates",
+			claims: user1Claims,
+			query:  `{"query":"mutation { updateSpot(spotId: \"a\", patch: {latitude: 91}) { SpotId } }"}`,
+			expect: `{"errors":[{"message":"ErrorInvalidCoordinates","path":["updateSpot"],"extensions":{"code":"VALIDATION","field":"latitude"}}],"data":null}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ops := []string{}
+			cancelled := 0
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						sk := aws.StringValue(input.ExpressionAttributeValues[":sk"].S)
+						if input.IndexName == nil && sk == common.SpotPrefix {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+						}
+						if input.IndexName == nil {
+							// distances from the spot
+							ops = append(ops, "query "+aws.StringValue(input.ExpressionAttributeValues[":pk"].S))
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{
+								{PKKey: {S: aws.String("Spot#a")}, SKKey: {S: aws.String("SpotDistance#b")}},
+							}}, nil
+						}
+						if aws.StringValue(input.IndexName) == GSI1Key {
+							// distances that lead to the spot
+							ops = append(ops, "query GSI1 "+aws.StringValue(input.ExpressionAttributeValues[":gsi1"].S))
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{
+								{PKKey: {S: aws.String("Spot#b")}, SKKey: {S: aws.String("SpotDistance#a")}},
+							}}, nil
+						}
+						// nearby spots of the new location
+						return &dynamodb.QueryOutput{}, nil
+					},
+					UpdateItemFunc: func(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+						ops = append(ops, "update "+aws.StringValue(input.UpdateExpression))
+						if aws.StringValue(input.Key[SKKey].S) != spot.SK {
+							return &dynamodb.UpdateItemOutput{}, nil
+						}
+						updated := spot
+						for name, value := range input.ExpressionAttributeValues {
+							switch name {
+							case ":Name":
+								updated.Name = value.S
+							case ":Tags":
+								tags := []string{}
+								_ = dynamodbattribute.Unmarshal(value, &tags)
+								updated.Tags = &tags
+							}
+						}
+						attributes, _ := dynamodbattribute.MarshalMap(updated)
+						return &dynamodb.UpdateItemOutput{Attributes: attributes}, nil
+					},
+					TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+						deleteSK := aws.StringValue(input.TransactItems[0].Delete.Key[SKKey].S)
+						putSK := aws.StringValue(input.TransactItems[1].Put.Item[SKKey].S)
+						ops = append(ops, fmt.Sprintf("transaction delete %s put %s", deleteSK, putSK))
+						// the copy is only written while the aggregates are as they were read
+						condition := aws.StringValue(input.TransactItems[0].Delete.ConditionExpression)
+						require.Contains(t, condition, "#ReviewCount = :ReviewCount")
+						require.Contains(t, condition, "attribute_not_exists(#DefaultImageUrl)")
+						if cancelled < test.cancelled {
+							cancelled++
+							return nil, &dynamodb.TransactionCanceledException{
+								CancellationReasons: []*dynamodb.CancellationReason{{Code: aws.String("ConditionalCheckFailed")}, {Code: aws.String("None")}},
+							}
+						}
+						return &dynamodb.TransactWriteItemsOutput{}, nil
+					},
+					DeleteItemFunc: func(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+						ops = append(ops, "delete "+aws.StringValue(input.Key[SKKey].S))
+						return &dynamodb.DeleteItemOutput{}, nil
+					},
+				},
+				TableName: "test_table",
+				MapboxClient: &mockMapboxClient{
+					AddFeatureFunc: func(ctx context.Context, spot common.Spot) error {
+						ops = append(ops, "mapbox add")
+						return nil
+					},
+				},
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return test.claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, true))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+			if test.ops == nil {
+				test.ops = []string{}
+			}
+			require.Equal(t, test.ops, ops)
+		})
+	}
+}
+
+func TestDeleteSpot(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	spot := testSpots[0]
+	spot.GSI1 = aws.String("User#user_1")
+	spotItem, _ := dynamodbattribute.MarshalMap(spot)
+
+	tests := []struct {
+		name   string
+		claims *AWSCognitoClaims
+		expect string
+		ops    []string
+	}{
+		{
+			name:   "creator",
+			claims: user1Claims,
+			expect: `{"data":{"deleteSpot":true}}`,
+			ops: []string{
+				"mapbox delete a",
+				"s3 delete spots/a/user_1/image1", "s3 delete renditions/a/image1/thumb.jpg",
+				"delete SpotDistance#b", "delete SpotDistance#a", "delete SpotDistances",
+				"delete Review#2020-12-01T00:00:00Z", "delete SpotImage#image1",
+				"delete " + spot.SK,
+			},
+		},
+		{
+			name:   "not creator",
+			claims: sellerUser1Claims,
+			expect: `{"errors":[{"message":"ErrorUserIsNotSpotCreator","path":["deleteSpot"],"extensions":{"code":"FORBIDDEN"}}],"data":null}`,
+			ops:    []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ops := []string{}
+			key := func(pk, sk string) map[string]*dynamodb.AttributeValue {
+				return map[string]*dynamodb.AttributeValue{PKKey: {S: aws.String(pk)}, SKKey: {S: aws.String(sk)}}
+			}
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						var sk string
+						if value, ok := input.ExpressionAttributeValues[":sk"]; ok {
+							sk = aws.StringValue(value.S)
+						}
+						switch {
+						case input.IndexName != nil:
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{key("Spot#b", "SpotDistance#a")}}, nil
+						case sk == common.SpotPrefix:
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+						case sk == common.SpotDistancePrefix:
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{key("Spot#a", "SpotDistance#b")}}, nil
+						}
+						// the whole partition, the distances are already deleted
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{
+							key("Spot#a", "Review#2020-12-01T00:00:00Z"),
+							key("Spot#a", spot.SK),
+							key("Spot#a", "SpotImage#image1"),
+						}}, nil
+					},
+					DeleteItemFunc: func(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+						ops = append(ops, "delete "+aws.StringValue(input.Key[SKKey].S))
+						return &dynamodb.DeleteItemOutput{}, nil
+					},
+				},
+				TableName:  "test_table",
+				BucketName: "test_bucket",
+				S3Client: &mockS3Client{
+					ListObjectsV2PagesFunc: func(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+						objects := map[string]string{"spots/a/": "spots/a/user_1/image1", "renditions/a/": "renditions/a/image1/thumb.jpg"}
+						fn(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(objects[aws.StringValue(input.Prefix)])}}}, true)
+						return nil
+					},
+					DeleteObjectFunc: func(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+						ops = append(ops, "s3 delete "+aws.StringValue(input.Key))
+						return &s3.DeleteObjectOutput{}, nil
+					},
+				},
+				MapboxClient: &mockMapboxClient{
+					DeleteFeatureFunc: func(ctx context.Context, spotId string) error {
+						ops = append(ops, "mapbox delete "+spotId)
+						return nil
+					},
+				},
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return test.claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(`{"query":"mutation { deleteSpot(spotId: \"a\") }"}`, true))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+			require.Equal(t, test.ops, ops)
+		})
+	}
+}
+
+func TestCreateSpotInput(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	mutation := func(input string) string {
+		return fmt.Sprintf(`{"query":"mutation { createSpot(input: {%s}) { SpotType Latitude Name CreatorId } }"}`, strings.ReplaceAll(input, `"`, `\"`))
+	}
+	valid := `spotType: Parking, latitude: 35.0, longitude: 137.0, name: "parking", homePageUrls: ["https://example.com/parking"]`
+
+	tests := []struct {
+		name   string
+		auth   bool
+		input  string
+		expect string
+	}{
+		{
+			name:   "created",
+			auth:   true,
+			input:  valid,
+			expect: `{"data":{"createSpot":{"SpotType":"Parking","Latitude":35,"Name":"parking","CreatorId":"user_1"}}}`,
+		},
+		{
+			name:   "not authenticated",
+			input:  valid,
+			expect: `{"errors":[{"message":"ErrorUserIsNotAuthenticated","path":["createSpot"],"extensions":{"code":"UNAUTHENTICATED"}}],"data":null}`,
+		},
+		{
+			name:   "invalid latitude",
+			auth:   true,
+			input:  `spotType: Parking, latitude: 95.0, longitude: 137.0, name: "parking"`,
+			expect: `{"errors":[{"message":"ErrorInvalidCoordinates","path":["createSpot"],"extensions":{"code":"VALIDATION","field":"latitude"}}],"data":null}`,
+		},
+		{
+			name:   "empty name",
+			auth:   true,
+			input:  `spotType: Parking, latitude: 35.0, longitude: 137.0, name: " "`,
+			expect: `{"errors":[{"message":"ErrorEmptyValue","path":["createSpot"],"extensions":{"code":"VALIDATION","field":"name"}}],"data":null}`,
+		},
+		{
+			name:   "name too long",
+			auth:   true,
+			input:  fmt.Sprintf(`spotType: Parking, latitude: 35.0, longitude: 137.0, name: "%s"`, strings.Repeat("道", SpotNameMaxLength+1)),
+			expect: `{"errors":[{"message":"ErrorValueTooLong","path":["createSpot"],"extensions":{"code":"VALIDATION","field":"name"}}],"data":null}`,
+		},
+		{
+			name:   "invalid url",
+			auth:   true,
+			input:  `spotType: Parking, latitude: 35.0, longitude: 137.0, name: "parking", homePageUrls: ["javascript:alert(1)"]`,
+			expect: `{"errors":[{"message":"ErrorInvalidUrl","path":["createSpot"],"extensions":{"code":"VALIDATION","field":"homePageUrls"}}],"data":null}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var putItem map[string]*dynamodb.AttributeValue
+			resolver := Resolver{
+				Db: &mockClientClient{
+					PutItemFunc: func(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+						putItem = input.Item
+						return &dynamodb.PutItemOutput{}, nil
+					},
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						return &dynamodb.QueryOutput{}, nil
+					},
+				},
+				TableName: "test_table",
+				MapboxClient: &mockMapboxClient{
+					AddFeatureFunc: func(ctx context.Context, spot common.Spot) error {
+						return nil
+					},
+				},
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return user1Claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(mutation(test.input), test.auth))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+			if putItem != nil {
+				require.Equal(t, fmt.Sprintf("%s%s", common.SpotPrefix, geohash.Encode(35.0, 137.0)), aws.StringValue(putItem[SKKey].S))
+				require.Equal(t, "User#user_1", aws.StringValue(putItem[GSI1Key].S))
+			}
+		})
+	}
+}
+
+func TestReviewLifecycle(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	review := Review{
+		PK:           "Spot#a",
+		SK:           "Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K",
+		GSI1:         aws.String("Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K"),
+		GSI2:         aws.String("User#user_1"),
+		CreationTime: "2020-12-01T00:00:00Z",
+		Rating:       aws.Int32(4),
+		Message:      aws.String("quiet at night"),
+	}
+	reviewItem, _ := dynamodbattribute.MarshalMap(review)
+	voteItem, _ := dynamodbattribute.MarshalMap(reviewVote(review, "user_2", aws.Bool(true)))
+	replyItem, _ := dynamodbattribute.MarshalMap(ReviewReply{PK: "Spot#a", SK: "Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#01ENZ3GT7P00000000000000R1"})
+	alreadyReviewed := &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{
+		{Code: aws.String("ConditionalCheckFailed")}, {Code: aws.String("None")},
+	}}
+
+	tests := []struct {
+		name           string
+		claims         *AWSCognitoClaims
+		query          string
+		transactionErr error
+		// error of the item deletes
+		deleteErr error
+		expect    string
+		ops       []string
+	}{
+		{
+			name:   "create",
+			claims: user1Claims,
+			query:  `{"query":"mutation { createReview(spotId: \"a\", rating: 5, message: \"nice\") { SpotId UserId Rating Message } }"}`,
+			expect: `{"data":{"createReview":{"SpotId":"a","UserId":"user_1","Rating":5,"Message":"nice"}}}`,
+			ops:    []string{"transaction put ReviewUser#user_1 put Review# update " + testSpots[0].SK},
+		},
+		{
+			name:           "create twice",
+			claims:         user1Claims,
+			query:          `{"query":"mutation { createReview(spotId: \"a\", rating: 5) { ReviewId } }"}`,
+			transactionErr: alreadyReviewed,
+			expect:         `{"errors":[{"message":"ErrorReviewAlreadyExists","path":["createReview"],"extensions":{"code":"CONFLICT"}}],"data":null}`,
+			ops:            []string{"transaction put ReviewUser#user_1 put Review# update " + testSpots[0].SK},
+		},
+		{
+			name:   "invalid rating",
+			claims: user1Claims,
+			query:  `{"query":"mutation { createReview(spotId: \"a\", rating: 6) { ReviewId } }"}`,
+			expect: `{"errors":[{"message":"ErrorInvalidRating","path":["createReview"],"extensions":{"code":"VALIDATION","field":"rating"}}],"data":null}`,
+			ops:    []string{},
+		},
+		{
+			name:   "read",
+			claims: sellerUser1Claims,
+			query:  `{"query":"{ review(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") { ReviewId SpotId UserId Rating } }"}`,
+			expect: `{"data":{"review":{"ReviewId":"01ENZ3GT7P5V6WTXJRBQ8Q4R7K","SpotId":"a","UserId":"user_1","Rating":4}}}`,
+			ops:    []string{},
+		},
+		{
+			name:   "not found",
+			claims: user1Claims,
+			query:  `{"query":"{ review(reviewId: \"missing\") { ReviewId } }"}`,
+			expect: `{"errors":[{"message":"ErrorReviewNotFound","path":["review"],"extensions":{"code":"NOT_FOUND"}}],"data":null}`,
+			ops:    []string{},
+		},
+		{
+			name:   "update",
+			claims: user1Claims,
+			query:  `{"query":"mutation { updateReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", rating: 2) { Rating Message } }"}`,
+			expect: `{"data":{"updateReview":{"Rating":2,"Message":"quiet at night"}}}`,
+			ops:    []string{"transaction update Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K update " + testSpots[0].SK},
+		},
+		{
+			name:   "update not author",
+			claims: adminUserClaims,
+			query:  `{"query":"mutation { updateReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", rating: 2) { Rating } }"}`,
+			expect: `{"errors":[{"message":"ErrorUserIsNotReviewAuthor","path":["updateReview"],"extensions":{"code":"FORBIDDEN"}}],"data":null}`,
+			ops:    []string{},
+		},
+		{
+			name:   "delete",
+			claims: user1Claims,
+			query:  `{"query":"mutation { deleteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") }"}`,
+			expect: `{"data":{"deleteReview":true}}`,
+			ops:    []string{"mark Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K", "delete Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2", "delete Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#01ENZ3GT7P00000000000000R1", "transaction delete Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K delete ReviewUser#user_1 update " + testSpots[0].SK},
+		},
+		{
+			name:   "delete by admin",
+			claims: adminUserClaims,
+			query:  `{"query":"mutation { deleteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") }"}`,
+			expect: `{"data":{"deleteReview":true}}`,
+			ops:    []string{"mark Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K", "delete Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2", "delete Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#01ENZ3GT7P00000000000000R1", "transaction delete Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K delete ReviewUser#user_1 update " + testSpots[0].SK},
+		},
+		{
+			// the review is kept, deleting it again finishes the cleanup
+			name:      "delete cleanup fails",
+			claims:    user1Claims,
+			query:     `{"query":"mutation { deleteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") }"}`,
+			deleteErr: errors.New("throttled"),
+			expect:    `{"errors":[{"message":"ErrorInternal","path":["deleteReview"],"extensions":{"code":"INTERNAL"}}],"data":null}`,
+			ops:       []string{"mark Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K", "delete Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2"},
+		},
+		{
+			name:   "delete not author",
+			claims: sellerUser1Claims,
+			query:  `{"query":"mutation { deleteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") }"}`,
+			expect: `{"errors":[{"message":"ErrorUserIsNotReviewAuthor","path":["deleteReview"],"extensions":{"code":"FORBIDDEN"}}],"data":null}`,
+			ops:    []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ops := []string{}
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						if input.IndexName == nil && aws.StringValue(input.ExpressionAttributeValues[":sk"].S) == "Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#" {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{voteItem}}, nil
+						}
+						if input.IndexName == nil && aws.StringValue(input.ExpressionAttributeValues[":sk"].S) == "Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#" {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{replyItem}}, nil
+						}
+						if input.IndexName == nil {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+						}
+						if aws.StringValue(input.ExpressionAttributeValues[":gsi1"].S) == aws.StringValue(review.GSI1) {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{reviewItem}}, nil
+						}
+						return &dynamodb.QueryOutput{}, nil
+					},
+					TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+						op := "transaction"
+						for _, item := range input.TransactItems {
+							if item.Put != nil {
+								sk := aws.StringValue(item.Put.Item[SKKey].S)
+								if strings.HasPrefix(sk, ReviewPrefix) {
+									sk = ReviewPrefix // the id is new every time
+								}
+								op += " put " + sk
+							}
+							if item.Delete != nil {
+								op += " delete " + aws.StringValue(item.Delete.Key[SKKey].S)
+							}
+							if item.Update != nil {
+								op += " update " + aws.StringValue(item.Update.Key[SKKey].S)
+							}
+						}
+						ops = append(ops, op)
+						return &dynamodb.TransactWriteItemsOutput{}, test.transactionErr
+					},
+					UpdateItemFunc: func(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+						require.Equal(t, "SET #deleting = :deleting", aws.StringValue(input.UpdateExpression))
+						ops = append(ops, "mark "+aws.StringValue(input.Key[SKKey].S))
+						return &dynamodb.UpdateItemOutput{}, nil
+					},
+					DeleteItemFunc: func(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+						ops = append(ops, "delete "+aws.StringValue(input.Key[SKKey].S))
+						if test.deleteErr != nil {
+							return nil, test.deleteErr
+						}
+						return &dynamodb.DeleteItemOutput{}, nil
+					},
+				},
+				TableName: "test_table",
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return test.claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, true))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+			require.Equal(t, test.ops, ops)
+		})
+	}
+}
+
+func TestRatingAggregates(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	review := Review{
+		PK:           "Spot#a",
+		SK:           "Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K",
+		GSI1:         aws.String("Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K"),
+		GSI2:         aws.String("User#user_1"),
+		CreationTime: "2020-12-01T00:00:00Z",
+		Rating:       aws.Int32(4),
+	}
+	reviewItem, _ := dynamodbattribute.MarshalMap(review)
+
+	tests := []struct {
+		name   string
+		query  string
+		expect map[string]string
+	}{
+		{
+			name:   "create",
+			query:  `{"query":"mutation { createReview(spotId: \"a\", rating: 5) { Rating } }"}`,
+			expect: map[string]string{":reviewCount": "1", ":RatingCount5": "1", ":ratingSum": "5"},
+		},
+		{
+			name:   "change rating",
+			query:  `{"query":"mutation { updateReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", rating: 2) { Rating } }"}`,
+			expect: map[string]string{":reviewCount": "0", ":RatingCount2": "1", ":RatingCount4": "-1", ":ratingSum": "-2"},
+		},
+		{
+			name:  "same rating",
+			query: `{"query":"mutation { updateReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", rating: 4, message: \"still good\") { Rating } }"}`,
+		},
+		{
+			name:   "delete",
+			query:  `{"query":"mutation { deleteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") }"}`,
+			expect: map[string]string{":reviewCount": "-1", ":RatingCount4": "-1", ":ratingSum": "-4"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var aggregates map[string]string
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						sk := aws.StringValue(input.ExpressionAttributeValues[":sk"].S)
+						if strings.HasPrefix(sk, ReviewVotePrefix) || strings.HasPrefix(sk, ReviewReplyPrefix) {
+							return &dynamodb.QueryOutput{}, nil
+						}
+						if input.IndexName == nil {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+						}
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{reviewItem}}, nil
+					},
+					TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+						for _, item := range input.TransactItems {
+							if item.Update == nil || aws.StringValue(item.Update.Key[SKKey].S) != testSpots[0].SK {
+								continue
+							}
+							require.Equal(t, "attribute_exists(#pk)", aws.StringValue(item.Update.ConditionExpression))
+							aggregates = map[string]string{}
+							for name, value := range item.Update.ExpressionAttributeValues {
+								aggregates[name] = aws.StringValue(value.N)
+							}
+						}
+						return &dynamodb.TransactWriteItemsOutput{}, nil
+					},
+					UpdateItemFunc: func(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+						return &dynamodb.UpdateItemOutput{}, nil
+					},
+				},
+				TableName: "test_table",
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return user1Claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, true))
+			require.Nil(t, err)
+			require.NotContains(t, resp.Body, "errors")
+			require.Equal(t, test.expect, aggregates)
+		})
+	}
+}
+
+func TestSpotRatings(t *testing.T) {
+
+	spots := append([]common.Spot{}, testSpots...)
+	spots[1].ReviewCount, spots[1].RatingSum = aws.Int64(2), aws.Int64(9)
+	spots[1].RatingCount4, spots[1].RatingCount5 = aws.Int64(1), aws.Int64(1)
+	spots[3].ReviewCount, spots[3].RatingSum, spots[3].RatingCount5 = aws.Int64(1), aws.Int64(5), aws.Int64(1)
+	fields := `Name AverageRating ReviewCount RatingHistogram{Count}`
+	// the best rated spot is farther away than more than a hundred unrated ones
+	crowded := []common.Spot{}
+	for index := 0; index < common.SpotsNearMaxLimit+10; index++ {
+		crowded = append(crowded, testSpot(fmt.Sprintf("near%d", index), "near", "Parking", 35.0+float64(index)/100000, 137.0))
+	}
+	far := testSpot("far", "far", "Parking", 35.2, 137.0)
+	far.ReviewCount, far.RatingSum, far.RatingCount5 = aws.Int64(1), aws.Int64(5), aws.Int64(1)
+	crowded = append(crowded, far)
+
+	tests := []struct {
+		name   string
+		spots  []common.Spot
+		query  string
+		expect string
+	}{
+		{
+			name:   "closest first",
+			query:  `spotsNear(latitude: 35.0, longitude: 137.0, radiusMeters: 30000, limit: 2){` + fields + `}`,
+			expect: `{"data":{"spotsNear":[{"Name":"spot d","AverageRating":5,"ReviewCount":1,"RatingHistogram":[{"Count":0},{"Count":0},{"Count":0},{"Count":0},{"Count":1}]},{"Name":"spot a","AverageRating":null,"ReviewCount":0,"RatingHistogram":[{"Count":0},{"Count":0},{"Count":0},{"Count":0},{"Count":0}]}]}}`,
+		},
+		{
+			name:   "best rated in radius",
+			query:  `spotsNear(latitude: 35.0, longitude: 137.0, radiusMeters: 30000, limit: 2, orderBy: RATING){Name AverageRating}`,
+			expect: `{"data":{"spotsNear":[{"Name":"spot d","AverageRating":5},{"Name":"spot b","AverageRating":4.5}]}}`,
+		},
+		{
+			name:   "best rated among many",
+			spots:  crowded,
+			query:  `spotsNear(latitude: 35.0, longitude: 137.0, radiusMeters: 30000, limit: 1, orderBy: RATING){Name AverageRating}`,
+			expect: `{"data":{"spotsNear":[{"Name":"far","AverageRating":5}]}}`,
+		},
+		{
+			name:   "region by rating",
+			query:  `spotsInRegion(boundingBox: {minLatitude: 34.9, minLongitude: 136.9, maxLatitude: 35.3, maxLongitude: 137.1}, orderBy: RATING){Name}`,
+			expect: `{"data":{"spotsInRegion":[{"Name":"spot d"},{"Name":"spot b"},{"Name":"spot a"},{"Name":"spot c"}]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			appSpots := spots
+			if test.spots != nil {
+				appSpots = test.spots
+			}
+			app := createSpotsTestApp(t, appSpots)
+			app.awsTokenValidator = &mockAwsTokenValidator{
+				ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+					return user1Claims, nil
+				},
+			}
+			query, _ := json.Marshal(map[string]string{"query": "{" + test.query + "}"})
+			resp, err := app.handler(context.Background(), createTestRequest(string(query), true))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+		})
+	}
+}
+
+func TestReviewsByUser(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	// GSI2 returns the newest review first
+	reviews := []map[string]*dynamodb.AttributeValue{}
+	for _, review := range []struct{ spotId, reviewId string }{{"b", "01ENZ3GT7P00000000000000R3"}, {"a", "01ENZ3GT7P00000000000000R2"}, {"c", "01ENZ3GT7P00000000000000R1"}} {
+		item, _ := dynamodbattribute.MarshalMap(Review{
+			PK:   "Spot#" + review.spotId,
+			SK:   "Review#" + review.reviewId,
+			GSI1: aws.String("Review#" + review.reviewId),
+			GSI2: aws.String("User#user_1"),
+		})
+		reviews = append(reviews, item)
+	}
+	userItem, _ := dynamodbattribute.MarshalMap(User{PK: "User#user_1", SK: "User#user_1", CreationTime: "2020-01-01T00:00:00Z"})
+	query := func(args string) string {
+		return fmt.Sprintf(`{"query":"{ reviews(%s) { edges { node { ReviewId SpotId } } pageInfo { hasNextPage } } }"}`, strings.ReplaceAll(args, `"`, `\"`))
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		expect string
+	}{
+		{
+			name:   "first page",
+			query:  query(`userId: "user_1", first: 2`),
+			expect: `{"data":{"reviews":{"edges":[{"node":{"ReviewId":"01ENZ3GT7P00000000000000R3","SpotId":"b"}},{"node":{"ReviewId":"01ENZ3GT7P00000000000000R2","SpotId":"a"}}],"pageInfo":{"hasNextPage":true}}}}`,
+		},
+		{
+			name:   "after last review",
+			query:  query(`userId: "user_1", lastReviewId: "01ENZ3GT7P00000000000000R2"`),
+			expect: `{"data":{"reviews":{"edges":[{"node":{"ReviewId":"01ENZ3GT7P00000000000000R1","SpotId":"c"}}],"pageInfo":{"hasNextPage":false}}}}`,
+		},
+		{
+			name:   "spot and user",
+			query:  query(`spotId: "a", userId: "user_1"`),
+			expect: `{"errors":[{"message":"ErrorSpotIdAndUserId","path":["reviews"],"extensions":{"code":"VALIDATION"}}],"data":null}`,
+		},
+		{
+			name:   "user profile",
+			query:  `{"query":"{ user(userId: \"user_1\") { Reviews { edges { node { SpotId } } } } }"}`,
+			expect: `{"data":{"user":{"Reviews":{"edges":[{"node":{"SpotId":"b"}},{"node":{"SpotId":"a"}},{"node":{"SpotId":"c"}}]}}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						if aws.StringValue(input.IndexName) == GSI1Key {
+							for _, review := range reviews {
+								if aws.StringValue(review[GSI1Key].S) == aws.StringValue(input.ExpressionAttributeValues[":gsi1"].S) {
+									return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{review}}, nil
+								}
+							}
+							return &dynamodb.QueryOutput{}, nil
+						}
+						require.Equal(t, GSI2Key, aws.StringValue(input.IndexName))
+						require.Equal(t, "User#user_1", aws.StringValue(input.ExpressionAttributeValues[":gsi2"].S))
+						require.False(t, aws.BoolValue(input.ScanIndexForward))
+						start := 0
+						for i, review := range reviews {
+							if input.ExclusiveStartKey != nil && aws.StringValue(review[SKKey].S) == aws.StringValue(input.ExclusiveStartKey[SKKey].S) {
+								require.Equal(t, review[GSI2Key], input.ExclusiveStartKey[GSI2Key])
+								start = i + 1
+							}
+						}
+						output := dynamodb.QueryOutput{Items: reviews[start:]}
+						if end := start + int(aws.Int64Value(input.Limit)); end < len(reviews) {
+							output.Items = reviews[start:end]
+							output.LastEvaluatedKey = common.ItemKey(reviews[end-1], input.IndexName)
+						}
+						return &output, nil
+					},
+					BatchGetItemFunc: func(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+						return &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{"test_table": {userItem}}}, nil
+					},
+				},
+				TableName: "test_table",
+			}
+			app := &App{schema: graphql.MustParseSchema(string(data), &resolver, schemaOptions()...), resolver: &resolver}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, false))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+		})
+	}
+}
+
+func TestSubRatings(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	reviewItem, _ := dynamodbattribute.MarshalMap(Review{
+		PK:           "Spot#a",
+		SK:           "Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K",
+		GSI1:         aws.String("Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K"),
+		GSI2:         aws.String("User#user_1"),
+		CreationTime: "2020-12-01T00:00:00Z",
+		Rating:       aws.Int32(4),
+		Quietness:    aws.Int32(2),
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		response string
+		// values of the aggregate update
+		aggregates map[string]string
+		// transactions that find changed aggregates and the transactions tried
+		conflicts, attempts int
+	}{
+		{
+			name:     "create",
+			query:    `{"query":"mutation { createReview(spotId: \"a\", rating: 5, subRatings: {quietness: 4, safety: 5}) { SubRatings { Quietness Safety Flatness } } }"}`,
+			response: `{"data":{"createReview":{"SubRatings":{"Quietness":4,"Safety":5,"Flatness":null}}}}`,
+			aggregates: map[string]string{":reviewCount": "1", ":RatingCount5": "1", ":ratingSum": "5",
+				":currentQuietnessSum": "12", ":currentQuietnessCount": "4", ":QuietnessSum": "16", ":QuietnessCount": "5", ":QuietnessAverage": "3.2",
+				":SafetySum": "5", ":SafetyCount": "1", ":SafetyAverage": "5"},
+			attempts: 1,
+		},
+		{
+			name:     "change sub-rating",
+			query:    `{"query":"mutation { updateReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", subRatings: {quietness: 5}) { Rating SubRatings { Quietness } } }"}`,
+			response: `{"data":{"updateReview":{"Rating":4,"SubRatings":{"Quietness":5}}}}`,
+			aggregates: map[string]string{":reviewCount": "0", ":ratingSum": "0",
+				":currentQuietnessSum": "12", ":currentQuietnessCount": "4", ":QuietnessSum": "15", ":QuietnessCount": "4", ":QuietnessAverage": "3.75"},
+			attempts: 1,
+		},
+		{
+			name:     "concurrent review",
+			query:    `{"query":"mutation { updateReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", subRatings: {quietness: 5}) { Rating SubRatings { Quietness } } }"}`,
+			response: `{"data":{"updateReview":{"Rating":4,"SubRatings":{"Quietness":5}}}}`,
+			aggregates: map[string]string{":reviewCount": "0", ":ratingSum": "0",
+				":currentQuietnessSum": "12", ":currentQuietnessCount": "4", ":QuietnessSum": "15", ":QuietnessCount": "4", ":QuietnessAverage": "3.75"},
+			conflicts: 1,
+			attempts:  2,
+		},
+		{
+			name:     "aggregates keep changing",
+			query:    `{"query":"mutation { updateReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", subRatings: {quietness: 5}) { Rating } }"}`,
+			response: `{"errors":[{"message":"ErrorConflict","path":["updateReview"],"extensions":{"code":"CONFLICT"}}],"data":null}`,
+			aggregates: map[string]string{":reviewCount": "0", ":ratingSum": "0",
+				":currentQuietnessSum": "12", ":currentQuietnessCount": "4", ":QuietnessSum": "15", ":QuietnessCount": "4", ":QuietnessAverage": "3.75"},
+			conflicts: maxReviewWriteAttempts,
+			attempts:  maxReviewWriteAttempts,
+		},
+		{
+			name:     "invalid sub-rating",
+			query:    `{"query":"mutation { createReview(spotId: \"a\", rating: 5, subRatings: {toiletCleanliness: 6}) { ReviewId } }"}`,
+			response: `{"errors":[{"message":"ErrorInvalidRating","path":["createReview"],"extensions":{"code":"VALIDATION","field":"subRatings.toiletCleanliness"}}],"data":null}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var aggregates map[string]string
+			var reads, attempts int
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						if input.IndexName == nil {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+						}
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{reviewItem}}, nil
+					},
+					TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+						attempts++
+						require.Equal(t, attempts, reads)
+						update := input.TransactItems[len(input.TransactItems)-1].Update
+						require.Equal(t, testSpots[0].SK, aws.StringValue(update.Key[SKKey].S))
+						require.Contains(t, aws.StringValue(update.ConditionExpression), "#QuietnessSum = :currentQuietnessSum")
+						aggregates = map[string]string{}
+						for name, value := range update.ExpressionAttributeValues {
+							aggregates[name] = aws.StringValue(value.N)
+						}
+						if attempts <= test.conflicts {
+							reasons := make([]*dynamodb.CancellationReason, len(input.TransactItems))
+							for index := range reasons {
+								reasons[index] = &dynamodb.CancellationReason{Code: aws.String("None")}
+							}
+							reasons[len(reasons)-1].Code = aws.String("ConditionalCheckFailed")
+							return nil, &dynamodb.TransactionCanceledException{CancellationReasons: reasons}
+						}
+						return &dynamodb.TransactWriteItemsOutput{}, nil
+					},
+					// the spot before the transaction
+					GetItemFunc: func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+						require.True(t, aws.BoolValue(input.ConsistentRead))
+						reads++
+						return &dynamodb.GetItemOutput{Item: map[string]*dynamodb.AttributeValue{
+							PKKey:            {S: aws.String(testSpots[0].PK)},
+							SKKey:            {S: aws.String(testSpots[0].SK)},
+							"QuietnessSum":   {N: aws.String("12")},
+							"QuietnessCount": {N: aws.String("4")},
+						}}, nil
+					},
+				},
+				TableName: "test_table",
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return user1Claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, true))
+			require.Nil(t, err)
+			require.Equal(t, test.response, resp.Body)
+			require.Equal(t, test.aggregates, aggregates)
+			require.Equal(t, test.attempts, attempts)
+		})
+	}
+}
+
+func TestSpotSubRatings(t *testing.T) {
+
+	spots := []common.Spot{}
+	for _, s := range testSpots {
+		s.SK = strings.Replace(s.SK, common.SpotPrefix, common.SpotPrefix+"x", 1)
+		spots = append(spots, s)
+	}
+	spots[0].QuietnessAverage, spots[0].SafetyAverage = aws.Float64(4.5), aws.Float64(3)
+	spots[1].QuietnessAverage = aws.Float64(3.5)
+	spots[3].QuietnessAverage, spots[3].SafetyAverage = aws.Float64(4), aws.Float64(5)
+	app := createSpotsTestApp(t, spots)
+	app.awsTokenValidator = &mockAwsTokenValidator{
+		ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+			return user1Claims, nil
+		},
+	}
+
+	tests := []struct {
+		name      string
+		variables string
+		response  string
+	}{
+		{
+			"quiet",
+			`{"geohash":"x","minSubRatings":{"quietness":4}}`,
+			`{"data":{"spotsByGeohash":{"edges":[{"node":{"Name":"spot a"}},{"node":{"Name":"spot d"}}]}}}`,
+		},
+		{
+			"quiet and safe",
+			`{"geohash":"x","minSubRatings":{"quietness":4,"safety":4}}`,
+			`{"data":{"spotsByGeohash":{"edges":[{"node":{"Name":"spot d"}}]}}}`,
+		},
+		{
+			"without average",
+			`{"geohash":"x","allTags":["Toilet"],"minSubRatings":{"flatness":1}}`,
+			`{"data":{"spotsByGeohash":{"edges":[]}}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			request := createTestRequest(fmt.Sprintf(spotsByGeohashQuery, tc.variables), true)
+			resp, err := app.handler(context.Background(), request)
+			require.Nil(t, err)
+			require.Equal(t, tc.response, resp.Body)
+		})
+	}
+
+	t.Run("averages", func(t *testing.T) {
+		query := `{"query":"{ spotsByGeohash(geohash: \"x\", minSubRatings: {safety: 4}) { edges { node { Name AverageSubRatings { Quietness Safety ToiletCleanliness } } } } }"}`
+		resp, err := app.handler(context.Background(), createTestRequest(query, true))
+		require.Nil(t, err)
+		require.Equal(t, `{"data":{"spotsByGeohash":{"edges":[{"node":{"Name":"spot d","AverageSubRatings":{"Quietness":4,"Safety":5,"ToiletCleanliness":null}}}]}}}`, resp.Body)
+	})
+}
+
+func TestReviewVotes(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	reviewItem, _ := dynamodbattribute.MarshalMap(Review{
+		PK:           "Spot#a",
+		SK:           "Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K",
+		GSI1:         aws.String("Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K"),
+		GSI2:         aws.String("User#user_1"),
+		CreationTime: "2020-12-01T00:00:00Z",
+		HelpfulCount: aws.Int64(3),
+	})
+	voteConflict := &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{
+		{Code: aws.String("ConditionalCheckFailed")}, {Code: aws.String("None")},
+	}}
+	countConflict := &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{
+		{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")},
+	}}
+	fields := `{ HelpfulCount UnhelpfulCount }`
+
+	tests := []struct {
+		name           string
+		claims         *AWSCognitoClaims
+		query          string
+		previous       *bool
+		transactionErr error
+		// other votes change the counts before the first transaction
+		countedBetween bool
+		// the review is being deleted
+		deleting bool
+		expect   string
+		// the vote write, the condition of the vote, the counts and the helpful rank
+		ops []string
+	}{
+		{
+			name:   "helpful",
+			claims: sellerUser1Claims,
+			query:  `voteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", helpful: true)` + fields,
+			expect: `{"data":{"voteReview":{"HelpfulCount":4,"UnhelpfulCount":0}}}`,
+			ops:    []string{"put Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2 attribute_not_exists(#pk) 4 0 0.5100999796#01ENZ3GT7P5V6WTXJRBQ8Q4R7K"},
+		},
+		{
+			name:     "change vote",
+			claims:   sellerUser1Claims,
+			query:    `voteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", helpful: false)` + fields,
+			previous: aws.Bool(true),
+			expect:   `{"data":{"voteReview":{"HelpfulCount":2,"UnhelpfulCount":1}}}`,
+			ops:      []string{"put Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2 #helpful = :previous 2 1 RANK"},
+		},
+		{
+			name:     "same vote",
+			claims:   sellerUser1Claims,
+			query:    `voteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", helpful: true)` + fields,
+			previous: aws.Bool(true),
+			expect:   `{"data":{"voteReview":{"HelpfulCount":3,"UnhelpfulCount":0}}}`,
+			ops:      []string{},
+		},
+		{
+			name:     "delete vote",
+			claims:   sellerUser1Claims,
+			query:    `deleteReviewVote(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\")` + fields,
+			previous: aws.Bool(true),
+			expect:   `{"data":{"deleteReviewVote":{"HelpfulCount":2,"UnhelpfulCount":0}}}`,
+			ops:      []string{"delete Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2 #helpful = :previous 2 0 RANK"},
+		},
+		{
+			name:   "own review",
+			claims: user1Claims,
+			query:  `voteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", helpful: true)` + fields,
+			expect: `{"errors":[{"message":"ErrorUserIsReviewAuthor","path":["voteReview"],"extensions":{"code":"FORBIDDEN"}}],"data":null}`,
+			ops:    []string{},
+		},
+		{
+			name:           "voted in between",
+			claims:         sellerUser1Claims,
+			query:          `voteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", helpful: false)` + fields,
+			transactionErr: voteConflict,
+			expect:         `{"errors":[{"message":"ErrorConflict","path":["voteReview"],"extensions":{"code":"CONFLICT"}}],"data":null}`,
+			ops:            []string{"put Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2 attribute_not_exists(#pk) 3 1 RANK"},
+		},
+		{
+			name:           "counted in between",
+			claims:         sellerUser1Claims,
+			query:          `voteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", helpful: true)` + fields,
+			countedBetween: true,
+			expect:         `{"data":{"voteReview":{"HelpfulCount":5,"UnhelpfulCount":0}}}`,
+			ops: []string{
+				"put Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2 attribute_not_exists(#pk) 4 0 RANK",
+				"put Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2 attribute_not_exists(#pk) 5 0 RANK",
+			},
+		},
+		{
+			name:     "review is being deleted",
+			claims:   sellerUser1Claims,
+			query:    `voteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", helpful: true)` + fields,
+			deleting: true,
+			expect:   `{"errors":[{"message":"ErrorReviewNotFound","path":["voteReview"],"extensions":{"code":"NOT_FOUND"}}],"data":null}`,
+			ops:      []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ops := []string{}
+			helpfulCount := 3
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{reviewItem}}, nil
+					},
+					GetItemFunc: func(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+						if aws.StringValue(input.Key[SKKey].S) == "Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K" {
+							require.True(t, aws.BoolValue(input.ConsistentRead))
+							item := map[string]*dynamodb.AttributeValue{
+								PKKey:           {S: aws.String("Spot#a")},
+								SKKey:           {S: aws.String("Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K")},
+								HelpfulCountKey: {N: aws.String(fmt.Sprintf("%d", helpfulCount))},
+							}
+							if test.deleting {
+								item[DeletingKey] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+							}
+							return &dynamodb.GetItemOutput{Item: item}, nil
+						}
+						require.Equal(t, "Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2", aws.StringValue(input.Key[SKKey].S))
+						if test.previous == nil {
+							return &dynamodb.GetItemOutput{}, nil
+						}
+						item, _ := dynamodbattribute.MarshalMap(ReviewVote{PK: "Spot#a", SK: "Vote#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#user_2", Helpful: test.previous})
+						return &dynamodb.GetItemOutput{Item: item}, nil
+					},
+					TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+						vote, counters := input.TransactItems[0], input.TransactItems[1].Update
+						op := ""
+						if vote.Put != nil {
+							op = fmt.Sprintf("put %s %s", aws.StringValue(vote.Put.Item[SKKey].S), aws.StringValue(vote.Put.ConditionExpression))
+						} else {
+							op = fmt.Sprintf("delete %s %s", aws.StringValue(vote.Delete.Key[SKKey].S), aws.StringValue(vote.Delete.ConditionExpression))
+						}
+						require.Equal(t, "Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K", aws.StringValue(counters.Key[SKKey].S))
+						require.Equal(t, "attribute_exists(#pk) AND attribute_not_exists(#deleting) AND #helpfulCount = :helpfulCount AND attribute_not_exists(#unhelpfulCount)", aws.StringValue(counters.ConditionExpression))
+						require.Equal(t, fmt.Sprintf("%d", helpfulCount), aws.StringValue(counters.ExpressionAttributeValues[":helpfulCount"].N))
+						rank := aws.StringValue(counters.ExpressionAttributeValues[":helpfulRank"].S)
+						if test.name != "helpful" {
+							rank = "RANK"
+						}
+						op += fmt.Sprintf(" %s %s %s", aws.StringValue(counters.ExpressionAttributeValues[":helpful"].N), aws.StringValue(counters.ExpressionAttributeValues[":unhelpful"].N), rank)
+						ops = append(ops, op)
+						if test.countedBetween && len(ops) == 1 {
+							helpfulCount++
+							return nil, countConflict
+						}
+						return &dynamodb.TransactWriteItemsOutput{}, test.transactionErr
+					},
+				},
+				TableName: "test_table",
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return test.claims, nil
+					},
+				},
+			}
+			query := fmt.Sprintf(`{"query":"mutation { %s }"}`, test.query)
+			resp, err := app.handler(context.Background(), createTestRequest(query, true))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+			require.Equal(t, test.ops, ops)
+		})
+	}
+}
+
+func TestReviewOrder(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	// oldest first like the spot partition
+	reviews := []map[string]*dynamodb.AttributeValue{}
+	for _, review := range []struct {
+		reviewId           string
+		rating             int32
+		helpful, unhelpful int64
+	}{
+		{"01ENZ3GT7P00000000000000R1", 3, 8, 2}, // the share is lower but has more votes
+		{"01ENZ3GT7P00000000000000R2", 5, 1, 0},
+		{"01ENZ3GT7P00000000000000R3", 4, 0, 0},
+		{"01ENZ3GT7P00000000000000R4", 5, 0, 3},
+	} {
+		item, _ := dynamodbattribute.MarshalMap(Review{
+			PK:             "Spot#a",
+			SK:             "Review#" + review.reviewId,
+			GSI1:           aws.String("Review#" + review.reviewId),
+			Rating:         aws.Int32(review.rating),
+			HelpfulCount:   aws.Int64(review.helpful),
+			UnhelpfulCount: aws.Int64(review.unhelpful),
+			HelpfulRank:    aws.String(common.HelpfulRank(review.helpful, review.unhelpful, review.reviewId)),
+			RatingRank:     aws.String(common.RatingRank(aws.Int32(review.rating), review.reviewId)),
+		})
+		reviews = append(reviews, item)
+	}
+	// the rank indexes sort by their rank attribute
+	ranks := map[string]string{common.SpotReviewsByHelpfulIndex: common.HelpfulRankKey, common.SpotReviewsByRatingIndex: common.RatingRankKey}
+	resolver := Resolver{
+		Db: &mockClientClient{
+			QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+				require.Equal(t, "Spot#a", aws.StringValue(input.ExpressionAttributeValues[":pk"].S))
+				items := append([]map[string]*dynamodb.AttributeValue{}, reviews...)
+				if input.IndexName != nil {
+					rank := ranks[*input.IndexName]
+					require.NotEmpty(t, rank)
+					sort.SliceStable(items, func(i, j int) bool {
+						return aws.StringValue(items[i][rank].S) < aws.StringValue(items[j][rank].S)
+					})
+				}
+				if input.ScanIndexForward != nil && !*input.ScanIndexForward {
+					for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+						items[i], items[j] = items[j], items[i]
+					}
+				}
+				if input.ExclusiveStartKey != nil {
+					for index, item := range items {
+						if aws.StringValue(item[SKKey].S) == aws.StringValue(input.ExclusiveStartKey[SKKey].S) {
+							items = items[index+1:]
+							break
+						}
+					}
+				}
+				if input.Limit != nil && int(*input.Limit) < len(items) {
+					return &dynamodb.QueryOutput{Items: items[:*input.Limit], LastEvaluatedKey: common.ItemKey(items[*input.Limit-1], input.IndexName)}, nil
+				}
+				return &dynamodb.QueryOutput{Items: items}, nil
+			},
+		},
+		TableName: "test_table",
+	}
+	app := &App{schema: graphql.MustParseSchema(string(data), &resolver, schemaOptions()...), resolver: &resolver}
+	reviewIds := func(t *testing.T, query string) ([]string, string) {
+		resp, err := app.handler(context.Background(), createTestRequest(fmt.Sprintf(`{"query":"{ %s }"}`, query), false))
+		require.Nil(t, err)
+		var body struct {
+			Data struct {
+				Reviews struct {
+					Edges []struct {
+						Node struct{ ReviewId string }
+					}
+					PageInfo struct{ EndCursor string }
+				}
+				Spot struct {
+					Reviews struct {
+						Edges []struct {
+							Node struct{ ReviewId string }
+						}
+						PageInfo struct{ EndCursor string }
+					}
+				}
+			}
+		}
+		require.Nil(t, json.Unmarshal([]byte(resp.Body), &body), resp.Body)
+		ids := []string{}
+		for _, edge := range body.Data.Reviews.Edges {
+			ids = append(ids, strings.TrimPrefix(edge.Node.ReviewId, "01ENZ3GT7P00000000000000"))
+		}
+		for _, edge := range body.Data.Spot.Reviews.Edges {
+			ids = append(ids, strings.TrimPrefix(edge.Node.ReviewId, "01ENZ3GT7P00000000000000"))
+		}
+		return ids, body.Data.Reviews.PageInfo.EndCursor + body.Data.Spot.Reviews.PageInfo.EndCursor
+	}
+	connection := `{ edges { node { ReviewId } } pageInfo { endCursor } }`
+
+	t.Run("helpful", func(t *testing.T) {
+		ids, cursor := reviewIds(t, `reviews(spotId: \"a\", orderBy: HELPFUL, first: 2)`+connection)
+		require.Equal(t, []string{"R1", "R2"}, ids)
+		ids, _ = reviewIds(t, fmt.Sprintf(`reviews(spotId: \"a\", orderBy: HELPFUL, first: 2, after: \"%s\")`, cursor)+connection)
+		// the lower bound of no votes and of only unhelpful votes is 0, the tie goes newest first
+		require.Equal(t, []string{"R4", "R3"}, ids)
+	})
+	t.Run("rating", func(t *testing.T) {
+		ids, _ := reviewIds(t, `reviews(spotId: \"a\", orderBy: RATING)`+connection)
+		require.Equal(t, []string{"R4", "R2", "R3", "R1"}, ids)
+	})
+	t.Run("newest", func(t *testing.T) {
+		ids, cursor := reviewIds(t, `reviews(spotId: \"a\", orderBy: NEWEST, first: 3)`+connection)
+		require.Equal(t, []string{"R4", "R3", "R2"}, ids)
+		require.NotEmpty(t, cursor)
+	})
+	t.Run("spot reviews", func(t *testing.T) {
+		ids, cursor := reviewIds(t, `spot(spotId: \"a\") { Reviews(orderBy: HELPFUL, first: 3)`+connection+` }`)
+		require.Equal(t, []string{"R1", "R2", "R4"}, ids)
+		ids, _ = reviewIds(t, fmt.Sprintf(`spot(spotId: \"a\") { Reviews(orderBy: HELPFUL, first: 3, after: \"%s\")`, cursor)+connection+` }`)
+		require.Equal(t, []string{"R3"}, ids)
+	})
+	t.Run("unknown cursor", func(t *testing.T) {
+		cursor, _ := common.EncodeCursor(map[string]*dynamodb.AttributeValue{PKKey: {S: aws.String("Spot#a")}, SKKey: {S: aws.String("Review#gone")}})
+		resp, err := app.handler(context.Background(), createTestRequest(fmt.Sprintf(`{"query":"{ reviews(spotId: \"a\", orderBy: RATING, after: \"%s\") { edges { cursor } } }"}`, cursor), false))
+		require.Nil(t, err)
+		require.Contains(t, resp.Body, "ErrorInvalidCursor")
+	})
+}
+
+func TestReviewVisits(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	reviewItem, _ := dynamodbattribute.MarshalMap(Review{
+		PK:           "Spot#a",
+		SK:           "Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K",
+		GSI1:         aws.String("Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K"),
+		GSI2:         aws.String("User#user_1"),
+		CreationTime: "2020-12-01T00:00:00Z",
+		Rating:       aws.Int32(4),
+		VisitDate:    aws.String("2020-11-28"),
+		VehicleClass: aws.String("Camper"),
+		Overnight:    aws.Bool(true),
+		PartySize:    aws.Int32(2),
+	})
+
+	tests := []struct {
+		name     string
+		query    string
+		response string
+		// the visit attributes of the written review or the filter of the query
+		review map[string]string
+		filter string
+		values map[string]string
+	}{
+		{
+			name:     "create",
+			query:    `{"query":"mutation { createReview(spotId: \"a\", rating: 5, visitDate: \"2020-11-28\", vehicleClass: KeiVan, overnight: true, partySize: 2) { VisitDate VehicleClass Overnight PartySize } }"}`,
+			response: `{"data":{"createReview":{"VisitDate":"2020-11-28","VehicleClass":"KeiVan","Overnight":true,"PartySize":2}}}`,
+			review:   map[string]string{VisitDateKey: "2020-11-28", VehicleClassKey: "KeiVan", OvernightKey: "true", PartySizeKey: "2"},
+		},
+		{
+			name:     "update",
+			query:    `{"query":"mutation { updateReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", overnight: false) { VisitDate VehicleClass Overnight } }"}`,
+			response: `{"data":{"updateReview":{"VisitDate":"2020-11-28","VehicleClass":"Camper","Overnight":false}}}`,
+			review:   map[string]string{OvernightKey: "false"},
+		},
+		{
+			name:     "invalid visit date",
+			query:    `{"query":"mutation { createReview(spotId: \"a\", rating: 5, visitDate: \"28.11.2020\") { ReviewId } }"}`,
+			response: `{"errors":[{"message":"ErrorInvalidDate","path":["createReview"],"extensions":{"code":"VALIDATION","field":"visitDate"}}],"data":null}`,
+		},
+		{
+			name:     "future visit date",
+			query:    fmt.Sprintf(`{"query":"mutation { createReview(spotId: \"a\", rating: 5, visitDate: \"%s\") { ReviewId } }"}`, time.Now().AddDate(0, 0, 3).Format(VisitDateLayout)),
+			response: `{"errors":[{"message":"ErrorInvalidDate","path":["createReview"],"extensions":{"code":"VALIDATION","field":"visitDate"}}],"data":null}`,
+		},
+		{
+			name:     "invalid party size",
+			query:    `{"query":"mutation { createReview(spotId: \"a\", rating: 5, partySize: 0) { ReviewId } }"}`,
+			response: `{"errors":[{"message":"ErrorInvalidPartySize","path":["createReview"],"extensions":{"code":"VALIDATION","field":"partySize"}}],"data":null}`,
+		},
+		{
+			name:     "overnight in campers",
+			query:    `{"query":"{ reviews(spotId: \"a\", filter: {overnight: true, vehicleClasses: [Camper, KeiVan]}) { edges { node { VehicleClass Overnight } } } }"}`,
+			response: `{"data":{"reviews":{"edges":[{"node":{"VehicleClass":"Camper","Overnight":true}}]}}}`,
+			filter:   "#Overnight = :overnight AND #VehicleClass IN (:vehicleClass_0, :vehicleClass_1)",
+			values:   map[string]string{":overnight": "true", ":vehicleClass_0": "Camper", ":vehicleClass_1": "KeiVan"},
+		},
+		{
+			name:     "spot reviews by visit",
+			query:    `{"query":"{ spot(spotId: \"a\") { Reviews(filter: {visitedFrom: \"2020-11-01\", visitedUntil: \"2020-11-30\", minPartySize: 2}) { edges { node { PartySize } } } } }"}`,
+			response: `{"data":{"spot":{"Reviews":{"edges":[{"node":{"PartySize":2}}]}}}}`,
+			filter:   "#VisitDate >= :visitedFrom AND #VisitDate <= :visitedUntil AND #PartySize >= :minPartySize",
+			values:   map[string]string{":visitedFrom": "2020-11-01", ":visitedUntil": "2020-11-30", ":minPartySize": "2"},
+		},
+		{
+			name:     "invalid filter date",
+			query:    `{"query":"{ reviews(spotId: \"a\", filter: {visitedUntil: \"November\"}) { edges { cursor } } }"}`,
+			response: `{"errors":[{"message":"ErrorInvalidDate","path":["reviews"],"extensions":{"code":"VALIDATION","field":"filter.visitedUntil"}}],"data":null}`,
+		},
+	}
+
+	// visitAttributes reads the visit attributes of a written review item or of update values,
+	// prefix is the prefix of the value names
+	visitAttributes := func(item map[string]*dynamodb.AttributeValue, prefix string) map[string]string {
+		attributes := map[string]string{}
+		for _, key := range []string{VisitDateKey, VehicleClassKey, OvernightKey, PartySizeKey} {
+			value, ok := item[prefix+key]
+			if !ok {
+				continue
+			}
+			switch {
+			case value.S != nil:
+				attributes[key] = *value.S
+			case value.N != nil:
+				attributes[key] = *value.N
+			case value.BOOL != nil:
+				attributes[key] = fmt.Sprintf("%t", *value.BOOL)
+			}
+		}
+		return attributes
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var review map[string]string
+			var filter string
+			var values map[string]string
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						if input.IndexName != nil {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{reviewItem}}, nil
+						}
+						if aws.StringValue(input.ExpressionAttributeValues[":sk"].S) != ReviewPrefix {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+						}
+						filter = aws.StringValue(input.FilterExpression)
+						values = map[string]string{}
+						for name, value := range input.ExpressionAttributeValues {
+							if strings.HasPrefix(name, ":visited") || strings.HasPrefix(name, ":vehicleClass") {
+								values[name] = aws.StringValue(value.S)
+							}
+							if name == ":overnight" {
+								values[name] = fmt.Sprintf("%t", aws.BoolValue(value.BOOL))
+							}
+							if strings.HasSuffix(name, "PartySize") {
+								values[name] = aws.StringValue(value.N)
+							}
+						}
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{reviewItem}}, nil
+					},
+					TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+						for _, item := range input.TransactItems {
+							if item.Put != nil && strings.HasPrefix(aws.StringValue(item.Put.Item[SKKey].S), ReviewPrefix) {
+								review = visitAttributes(item.Put.Item, "")
+							}
+							if item.Update != nil && strings.HasPrefix(aws.StringValue(item.Update.Key[SKKey].S), ReviewPrefix) {
+								review = map[string]string{}
+								for name, value := range visitAttributes(item.Update.ExpressionAttributeValues, ":") {
+									require.Contains(t, aws.StringValue(item.Update.UpdateExpression), fmt.Sprintf("#%s = :%s", name, name))
+									review[name] = value
+								}
+							}
+						}
+						return &dynamodb.TransactWriteItemsOutput{}, nil
+					},
+				},
+				TableName: "test_table",
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return user1Claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, true))
+			require.Nil(t, err)
+			require.Equal(t, test.response, resp.Body)
+			require.Equal(t, test.review, review)
+			require.Equal(t, test.filter, filter)
+			if test.values != nil {
+				require.Equal(t, test.values, values)
+			}
+		})
+	}
+}
+
+func TestReviewImages(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	reviewId := "01ENZ3GT7P5V6WTXJRBQ8Q4R7K"
+	reviewImage := common.NewSpotImage("a", "image1", "user_1", "test-bucket", "image/jpeg", 1000)
+	reviewImage.CreationTime = "2020-12-02T00:00:00Z"
+	reviewImage.ReviewId = aws.String(reviewId)
+	reviewImageItem, _ := dynamodbattribute.MarshalMap(reviewImage)
+	spotImage := common.NewSpotImage("a", "image2", "user_2", "test-bucket", "image/png", 1000)
+	spotImage.CreationTime = "2020-12-01T00:00:00Z"
+	spotImageItem, _ := dynamodbattribute.MarshalMap(spotImage)
+	reviewFull := &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{
+		{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")},
+	}}
+
+	tests := []struct {
+		name           string
+		claims         *AWSCognitoClaims
+		imageCount     int64
+		query          string
+		transactionErr error
+		expect         string
+		ops            []string
+	}{
+		{
+			name:       "request upload",
+			claims:     user1Claims,
+			imageCount: 4,
+			query:      `{"query":"mutation { requestReviewImageUpload(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", contentType: \"image/jpeg\") { ContentType } }"}`,
+			expect:     `{"data":{"requestReviewImageUpload":{"ContentType":"image/jpeg"}}}`,
+			ops:        []string{"presign spots/a/user_1/"},
+		},
+		{
+			name:   "request by other user",
+			claims: sellerUser1Claims,
+			query:  `{"query":"mutation { requestReviewImageUpload(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", contentType: \"image/jpeg\") { ContentType } }"}`,
+			expect: `{"errors":[{"message":"ErrorUserIsNotReviewAuthor","path":["requestReviewImageUpload"],"extensions":{"code":"FORBIDDEN"}}],"data":null}`,
+		},
+		{
+			name:       "review is full",
+			claims:     user1Claims,
+			imageCount: ReviewMaxImages,
+			query:      `{"query":"mutation { requestReviewImageUpload(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", contentType: \"image/jpeg\") { ContentType } }"}`,
+			expect:     `{"errors":[{"message":"ErrorTooManyReviewImages","path":["requestReviewImageUpload"],"extensions":{"code":"VALIDATION"}}],"data":null}`,
+		},
+		{
+			name:       "confirm",
+			claims:     user1Claims,
+			imageCount: 1,
+			query:      `{"query":"mutation { confirmReviewImage(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", spotImageId: \"image3\") { SpotImageId SpotId Source ReviewId } }"}`,
+			expect:     `{"data":{"confirmReviewImage":{"SpotImageId":"image3","SpotId":"a","Source":"REVIEW","ReviewId":"01ENZ3GT7P5V6WTXJRBQ8Q4R7K"}}}`,
+			ops:        []string{"put SpotImage#image3 of 01ENZ3GT7P5V6WTXJRBQ8Q4R7K", "update Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K ADD #imageCount :one"},
+		},
+		{
+			name:           "confirm when full",
+			claims:         user1Claims,
+			imageCount:     4,
+			query:          `{"query":"mutation { confirmReviewImage(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", spotImageId: \"image3\") { SpotImageId } }"}`,
+			transactionErr: reviewFull,
+			expect:         `{"errors":[{"message":"ErrorTooManyReviewImages","path":["confirmReviewImage"],"extensions":{"code":"VALIDATION"}}],"data":null}`,
+			ops:            []string{"put SpotImage#image3 of 01ENZ3GT7P5V6WTXJRBQ8Q4R7K", "update Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K ADD #imageCount :one", "s3 delete spots/a/user_1/image3"},
+		},
+		{
+			name:       "review images",
+			claims:     user1Claims,
+			imageCount: 1,
+			query:      `{"query":"{ review(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") { Images { SpotImageId Source } } }"}`,
+			expect:     `{"data":{"review":{"Images":[{"SpotImageId":"image1","Source":"REVIEW"}]}}}`,
+		},
+		{
+			name:   "review without images",
+			claims: user1Claims,
+			query:  `{"query":"{ review(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") { Images { SpotImageId } } }"}`,
+			expect: `{"data":{"review":{"Images":[]}}}`,
+		},
+		{
+			name:   "spot images",
+			claims: user1Claims,
+			query:  `{"query":"{ spot(spotId: \"a\") { Images { SpotImageId Source ReviewId } } }"}`,
+			expect: `{"data":{"spot":{"Images":[{"SpotImageId":"image1","Source":"REVIEW","ReviewId":"01ENZ3GT7P5V6WTXJRBQ8Q4R7K"},{"SpotImageId":"image2","Source":"SPOT","ReviewId":null}]}}}`,
+		},
+		{
+			name:       "delete review",
+			claims:     user1Claims,
+			imageCount: 1,
+			query:      `{"query":"mutation { deleteReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") }"}`,
+			expect:     `{"data":{"deleteReview":true}}`,
+			ops: []string{
+				"mark Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K",
+				"s3 delete spots/a/user_1/image1", "s3 delete renditions/a/image1/medium.jpg", "delete SpotImage#image1",
+				"delete Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K", "delete ReviewUser#user_1", "update " + testSpots[0].SK + " ADD #reviewCount :reviewCount",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ops := []string{}
+			review := Review{
+				PK:           "Spot#a",
+				SK:           "Review#" + reviewId,
+				GSI1:         aws.String("Review#" + reviewId),
+				GSI2:         aws.String("User#user_1"),
+				CreationTime: "2020-12-01T00:00:00Z",
+				Rating:       aws.Int32(4),
+			}
+			if test.imageCount > 0 {
+				review.ImageCount = aws.Int64(test.imageCount)
+			}
+			reviewItem, _ := dynamodbattribute.MarshalMap(review)
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						if input.Select != nil {
+							return &dynamodb.QueryOutput{Count: aws.Int64(0)}, nil
+						}
+						if input.IndexName != nil {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{reviewItem}}, nil
+						}
+						sk := aws.StringValue(input.ExpressionAttributeValues[":sk"].S)
+						switch {
+						case sk == common.SpotImagePrefix && input.FilterExpression != nil:
+							require.Equal(t, reviewId, aws.StringValue(input.ExpressionAttributeValues[":reviewId"].S))
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{reviewImageItem}}, nil
+						case sk == common.SpotImagePrefix:
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotImageItem, reviewImageItem}}, nil
+						case strings.HasPrefix(sk, ReviewVotePrefix), strings.HasPrefix(sk, ReviewReplyPrefix):
+							return &dynamodb.QueryOutput{}, nil
+						}
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+					},
+					TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+						for _, item := range input.TransactItems {
+							switch {
+							case item.Put != nil:
+								ops = append(ops, fmt.Sprintf("put %s of %s", aws.StringValue(item.Put.Item[SKKey].S), aws.StringValue(item.Put.Item[ReviewIdKey].S)))
+							case item.Update != nil:
+								ops = append(ops, fmt.Sprintf("update %s %s", aws.StringValue(item.Update.Key[SKKey].S), strings.Split(aws.StringValue(item.Update.UpdateExpression), ",")[0]))
+							case item.Delete != nil:
+								ops = append(ops, "delete "+aws.StringValue(item.Delete.Key[SKKey].S))
+							}
+						}
+						if test.transactionErr != nil {
+							return nil, test.transactionErr
+						}
+						return &dynamodb.TransactWriteItemsOutput{}, nil
+					},
+					UpdateItemFunc: func(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+						ops = append(ops, "mark "+aws.StringValue(input.Key[SKKey].S))
+						return &dynamodb.UpdateItemOutput{}, nil
+					},
+					DeleteItemFunc: func(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+						ops = append(ops, "delete "+aws.StringValue(input.Key[SKKey].S))
+						return &dynamodb.DeleteItemOutput{}, nil
+					},
+				},
+				TableName:  "test_table",
+				BucketName: "test-bucket",
+				S3Client: &mockS3Client{
+					PutObjectRequestFunc: func(input *s3.PutObjectInput) (*request.Request, *s3.PutObjectOutput) {
+						ops = append(ops, "presign "+strings.TrimSuffix(aws.StringValue(input.Key), path.Base(aws.StringValue(input.Key))))
+						return presignClient.PutObjectRequest(input)
+					},
+					HeadObjectFunc: func(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+						require.Equal(t, "spots/a/user_1/image3", aws.StringValue(input.Key))
+						return &s3.HeadObjectOutput{ContentType: aws.String("image/jpeg"), ContentLength: aws.Int64(1000)}, nil
+					},
+					ListObjectsV2PagesFunc: func(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+						require.Equal(t, "renditions/a/image1/", aws.StringValue(input.Prefix))
+						fn(&s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String("renditions/a/image1/medium.jpg")}}}, true)
+						return nil
+					},
+					DeleteObjectFunc: func(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+						ops = append(ops, "s3 delete "+aws.StringValue(input.Key))
+						return &s3.DeleteObjectOutput{}, nil
+					},
+				},
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return test.claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, true))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+			if test.ops == nil {
+				test.ops = []string{}
+			}
+			require.Equal(t, test.ops, ops)
+		})
+	}
+}
+
+func TestReviewReplies(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	reviewItem, _ := dynamodbattribute.MarshalMap(Review{
+		PK:           "Spot#a",
+		SK:           "Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K",
+		GSI1:         aws.String("Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K"),
+		GSI2:         aws.String("User#user_1"),
+		CreationTime: "2020-12-01T00:00:00Z",
+		Rating:       aws.Int32(2),
+	})
+	replyItems := []map[string]*dynamodb.AttributeValue{}
+	for _, reply := range []ReviewReply{
+		{PK: "Spot#a", SK: "Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#01ENZ3GT7P00000000000000R1", GSI2: aws.String("User#user_2"), Message: "Thanks, the toilets are fixed now"},
+		{PK: "Spot#a", SK: "Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#01ENZ3GT7P00000000000000R2", GSI2: aws.String("User#user_1"), Message: "Good to know"},
+	} {
+		item, _ := dynamodbattribute.MarshalMap(reply)
+		replyItems = append(replyItems, item)
+	}
+	reviewDeleted := &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{
+		{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")},
+	}}
+
+	tests := []struct {
+		name           string
+		claims         *AWSCognitoClaims
+		query          string
+		transactionErr error
+		// the creator of the spot, user_2 if empty
+		spotCreator string
+		expect      string
+		// the put reply item
+		reply map[string]string
+	}{
+		{
+			name:   "owner reply",
+			claims: sellerUser1Claims,
+			query:  `{"query":"mutation { replyToReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", message: \"Thanks\") { ReviewId UserId Message IsOwnerReply } }"}`,
+			expect: `{"data":{"replyToReview":{"ReviewId":"01ENZ3GT7P5V6WTXJRBQ8Q4R7K","UserId":"user_2","Message":"Thanks","IsOwnerReply":true}}}`,
+			reply:  map[string]string{PKKey: "Spot#a", SKKey: "Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#", GSI2Key: "User#user_2"},
+		},
+		{
+			name:   "reply",
+			claims: user1Claims,
+			query:  `{"query":"mutation { replyToReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", message: \"Thanks\") { IsOwnerReply } }"}`,
+			expect: `{"data":{"replyToReview":{"IsOwnerReply":false}}}`,
+			reply:  map[string]string{PKKey: "Spot#a", SKKey: "Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#", GSI2Key: "User#user_1"},
+		},
+		{
+			name:   "empty message",
+			claims: user1Claims,
+			query:  `{"query":"mutation { replyToReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", message: \" \") { ReplyId } }"}`,
+			expect: `{"errors":[{"message":"ErrorEmptyValue","path":["replyToReview"],"extensions":{"code":"VALIDATION","field":"message"}}],"data":null}`,
+		},
+		{
+			name:           "review deleted",
+			claims:         user1Claims,
+			query:          `{"query":"mutation { replyToReview(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\", message: \"Thanks\") { ReplyId } }"}`,
+			transactionErr: reviewDeleted,
+			expect:         `{"errors":[{"message":"ErrorReviewNotFound","path":["replyToReview"],"extensions":{"code":"NOT_FOUND"}}],"data":null}`,
+			reply:          map[string]string{PKKey: "Spot#a", SKKey: "Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#", GSI2Key: "User#user_1"},
+		},
+		{
+			name:   "replies",
+			claims: user1Claims,
+			query:  `{"query":"{ review(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") { Replies(first: 1) { edges { node { ReplyId ReviewId Message IsOwnerReply } } pageInfo { hasNextPage } } } }"}`,
+			expect: `{"data":{"review":{"Replies":{"edges":[{"node":{"ReplyId":"01ENZ3GT7P00000000000000R1","ReviewId":"01ENZ3GT7P5V6WTXJRBQ8Q4R7K","Message":"Thanks, the toilets are fixed now","IsOwnerReply":true}}],"pageInfo":{"hasNextPage":true}}}}}`,
+		},
+		{
+			name:        "replies after an ownership transfer",
+			claims:      user1Claims,
+			spotCreator: "User#user_1",
+			query:       `{"query":"{ review(reviewId: \"01ENZ3GT7P5V6WTXJRBQ8Q4R7K\") { Replies { edges { node { ReplyId IsOwnerReply } } } } }"}`,
+			expect:      `{"data":{"review":{"Replies":{"edges":[{"node":{"ReplyId":"01ENZ3GT7P00000000000000R1","IsOwnerReply":false}},{"node":{"ReplyId":"01ENZ3GT7P00000000000000R2","IsOwnerReply":true}}]}}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var reply map[string]string
+			spot := testSpots[0]
+			spot.GSI1 = aws.String("User#user_2")
+			if test.spotCreator != "" {
+				spot.GSI1 = aws.String(test.spotCreator)
+			}
+			spotItem, _ := dynamodbattribute.MarshalMap(spot)
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						if input.IndexName != nil {
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{reviewItem}}, nil
+						}
+						if aws.StringValue(input.ExpressionAttributeValues[":sk"].S) == "Reply#01ENZ3GT7P5V6WTXJRBQ8Q4R7K#" {
+							if input.Limit != nil && int(*input.Limit) < len(replyItems) {
+								return &dynamodb.QueryOutput{Items: replyItems[:*input.Limit], LastEvaluatedKey: common.ItemKey(replyItems[*input.Limit-1], nil)}, nil
+							}
+							return &dynamodb.QueryOutput{Items: replyItems}, nil
+						}
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+					},
+					TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+						require.Len(t, input.TransactItems, 2)
+						require.Equal(t, "Review#01ENZ3GT7P5V6WTXJRBQ8Q4R7K", aws.StringValue(input.TransactItems[1].ConditionCheck.Key[SKKey].S))
+						item := input.TransactItems[0].Put.Item
+						reply = map[string]string{
+							PKKey: aws.StringValue(item[PKKey].S),
+							// the reply id is new every time
+							SKKey:   strings.TrimRight(aws.StringValue(item[SKKey].S), "0123456789ABCDEFGHJKMNPQRSTVWXYZ"),
+							GSI2Key: aws.StringValue(item[GSI2Key].S),
+						}
+						if test.transactionErr != nil {
+							return nil, test.transactionErr
+						}
+						return &dynamodb.TransactWriteItemsOutput{}, nil
+					},
+				},
+				TableName: "test_table",
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return test.claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, true))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+			require.Equal(t, test.reply, reply)
+		})
+	}
+}
+
+func TestQuestions(t *testing.T) {
+
+	data, _ := Asset(SchemaName)
+	spotItem, _ := dynamodbattribute.MarshalMap(testSpots[0])
+	question := func(id, activityTime string, acceptedAnswerId *string) Question {
+		return Question{
+			PK:               "Spot#a",
+			SK:               "Question#01ENZ3GT7P00000000000000" + id,
+			GSI1:             aws.String("Question#01ENZ3GT7P00000000000000" + id),
+			GSI2:             aws.String("User#user_1"),
+			CreationTime:     "2020-12-01T00:00:00Z",
+			ActivityTime:     activityTime,
+			ActivityRank:     aws.String(activityRank(activityTime, "01ENZ3GT7P00000000000000"+id)),
+			Message:          "Is the toilet open at night?",
+			AcceptedAnswerId: acceptedAnswerId,
+		}
+	}
+	// oldest first like the spot partition
+	questionItems := []map[string]*dynamodb.AttributeValue{}
+	for _, q := range []Question{
+		question("Q1", "2020-12-05T00:00:00Z", nil), // answered last
+		question("Q2", "2020-12-02T00:00:00Z", nil),
+		question("Q3", "2020-12-03T00:00:00Z", nil),
+	} {
+		item, _ := dynamodbattribute.MarshalMap(q)
+		questionItems = append(questionItems, item)
+	}
+	answerItem, _ := dynamodbattribute.MarshalMap(Answer{
+		PK:       "Spot#a",
+		SK:       "Answer#01ENZ3GT7P00000000000000Q1#01ENZ3GT7P00000000000000A1",
+		GSI2:     aws.String("User#user_2"),
+		Message:  "Yes, all night",
+		Accepted: aws.Bool(true),
+	})
+	answerMissing := &dynamodb.TransactionCanceledException{CancellationReasons: []*dynamodb.CancellationReason{
+		{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")},
+	}}
+
+	tests := []struct {
+		name           string
+		claims         *AWSCognitoClaims
+		query          string
+		question       Question // found through GSI1
+		transactionErr error
+		expect         string
+		ops            []string
+	}{
+		{
+			name:   "ask",
+			claims: user1Claims,
+			query:  `{"query":"mutation { askQuestion(spotId: \"a\", message: \"Is the toilet open at night?\") { SpotId UserId Message AnswerCount AcceptedAnswerId } }"}`,
+			expect: `{"data":{"askQuestion":{"SpotId":"a","UserId":"user_1","Message":"Is the toilet open at night?","AnswerCount":0,"AcceptedAnswerId":null}}}`,
+			ops:    []string{"put Question#", "check " + testSpots[0].SK},
+		},
+		{
+			name:   "ask without message",
+			claims: user1Claims,
+			query:  `{"query":"mutation { askQuestion(spotId: \"a\", message: \"\") { QuestionId } }"}`,
+			expect: `{"errors":[{"message":"ErrorEmptyValue","path":["askQuestion"],"extensions":{"code":"VALIDATION","field":"message"}}],"data":null}`,
+		},
+		{
+			name:     "answer",
+			claims:   sellerUser1Claims,
+			query:    `{"query":"mutation { answerQuestion(questionId: \"01ENZ3GT7P00000000000000Q1\", message: \"Yes\") { QuestionId UserId IsAccepted } }"}`,
+			question: question("Q1", "2020-12-01T00:00:00Z", nil),
+			expect:   `{"data":{"answerQuestion":{"QuestionId":"01ENZ3GT7P00000000000000Q1","UserId":"user_2","IsAccepted":false}}}`,
+			ops:      []string{"put Answer#01ENZ3GT7P00000000000000Q1#", "update Question#01ENZ3GT7P00000000000000Q1 SET #activityTime = :now, #activityRank = :rank ADD #answerCount :one"},
+		},
+		{
+			name:   "answer unknown question",
+			claims: sellerUser1Claims,
+			query:  `{"query":"mutation { answerQuestion(questionId: \"unknown\", message: \"Yes\") { AnswerId } }"}`,
+			expect: `{"errors":[{"message":"ErrorQuestionNotFound","path":["answerQuestion"],"extensions":{"code":"NOT_FOUND"}}],"data":null}`,
+		},
+		{
+			name:     "accept",
+			claims:   user1Claims,
+			query:    `{"query":"mutation { acceptAnswer(questionId: \"01ENZ3GT7P00000000000000Q1\", answerId: \"A1\") { AcceptedAnswerId } }"}`,
+			question: question("Q1", "2020-12-01T00:00:00Z", nil),
+			expect:   `{"data":{"acceptAnswer":{"AcceptedAnswerId":"A1"}}}`,
+			ops: []string{
+				"update Question#01ENZ3GT7P00000000000000Q1 SET #acceptedAnswerId = :answerId, #activityTime = :now, #activityRank = :rank if attribute_exists(#pk) AND attribute_not_exists(#acceptedAnswerId)",
+				"update Answer#01ENZ3GT7P00000000000000Q1#A1 SET #accepted = :accepted if attribute_exists(#pk)",
+			},
+		},
+		{
+			name:     "accept another answer",
+			claims:   user1Claims,
+			query:    `{"query":"mutation { acceptAnswer(questionId: \"01ENZ3GT7P00000000000000Q1\", answerId: \"A2\") { AcceptedAnswerId } }"}`,
+			question: question("Q1", "2020-12-01T00:00:00Z", aws.String("A1")),
+			expect:   `{"data":{"acceptAnswer":{"AcceptedAnswerId":"A2"}}}`,
+			ops: []string{
+				"update Question#01ENZ3GT7P00000000000000Q1 SET #acceptedAnswerId = :answerId, #activityTime = :now, #activityRank = :rank if attribute_exists(#pk) AND #acceptedAnswerId = :previous",
+				"update Answer#01ENZ3GT7P00000000000000Q1#A2 SET #accepted = :accepted if attribute_exists(#pk)",
+				"update Answer#01ENZ3GT7P00000000000000Q1#A1 REMOVE #accepted if attribute_exists(#pk)",
+			},
+		},
+		{
+			name:     "accept by other user",
+			claims:   sellerUser1Claims,
+			query:    `{"query":"mutation { acceptAnswer(questionId: \"01ENZ3GT7P00000000000000Q1\", answerId: \"A1\") { AcceptedAnswerId } }"}`,
+			question: question("Q1", "2020-12-01T00:00:00Z", nil),
+			expect:   `{"errors":[{"message":"ErrorUserIsNotQuestionAuthor","path":["acceptAnswer"],"extensions":{"code":"FORBIDDEN"}}],"data":null}`,
+		},
+		{
+			name:           "accept unknown answer",
+			claims:         user1Claims,
+			query:          `{"query":"mutation { acceptAnswer(questionId: \"01ENZ3GT7P00000000000000Q1\", answerId: \"A9\") { AcceptedAnswerId } }"}`,
+			question:       question("Q1", "2020-12-01T00:00:00Z", nil),
+			transactionErr: answerMissing,
+			expect:         `{"errors":[{"message":"ErrorAnswerNotFound","path":["acceptAnswer"],"extensions":{"code":"NOT_FOUND"}}],"data":null}`,
+			ops: []string{
+				"update Question#01ENZ3GT7P00000000000000Q1 SET #acceptedAnswerId = :answerId, #activityTime = :now, #activityRank = :rank if attribute_exists(#pk) AND attribute_not_exists(#acceptedAnswerId)",
+				"update Answer#01ENZ3GT7P00000000000000Q1#A9 SET #accepted = :accepted if attribute_exists(#pk)",
+			},
+		},
+		{
+			name:     "answers",
+			claims:   user1Claims,
+			query:    `{"query":"{ question(questionId: \"01ENZ3GT7P00000000000000Q1\") { Answers { edges { node { AnswerId QuestionId UserId Message IsAccepted } } } } }"}`,
+			question: question("Q1", "2020-12-01T00:00:00Z", aws.String("01ENZ3GT7P00000000000000A1")),
+			expect:   `{"data":{"question":{"Answers":{"edges":[{"node":{"AnswerId":"01ENZ3GT7P00000000000000A1","QuestionId":"01ENZ3GT7P00000000000000Q1","UserId":"user_2","Message":"Yes, all night","IsAccepted":true}}]}}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ops := []string{}
+			questionItem, _ := dynamodbattribute.MarshalMap(test.question)
+			resolver := Resolver{
+				Db: &mockClientClient{
+					QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						if input.IndexName != nil {
+							if aws.StringValue(input.ExpressionAttributeValues[":gsi1"].S) != aws.StringValue(test.question.GSI1) {
+								return &dynamodb.QueryOutput{}, nil
+							}
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{questionItem}}, nil
+						}
+						if strings.HasPrefix(aws.StringValue(input.ExpressionAttributeValues[":sk"].S), common.AnswerPrefix) {
+							require.Equal(t, "Answer#01ENZ3GT7P00000000000000Q1#", aws.StringValue(input.ExpressionAttributeValues[":sk"].S))
+							return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{answerItem}}, nil
+						}
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+					},
+					TransactWriteItemsFunc: func(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+						for _, item := range input.TransactItems {
+							switch {
+							case item.Put != nil:
+								// the ids are new every time
+								ops = append(ops, "put "+strings.TrimRight(aws.StringValue(item.Put.Item[SKKey].S), "0123456789ABCDEFGHJKMNPQRSTVWXYZ"))
+							case item.ConditionCheck != nil:
+								ops = append(ops, "check "+aws.StringValue(item.ConditionCheck.Key[SKKey].S))
+							case item.Update != nil && strings.HasPrefix(aws.StringValue(item.Update.Key[SKKey].S), common.AnswerPrefix):
+								ops = append(ops, fmt.Sprintf("update %s %s if %s", aws.StringValue(item.Update.Key[SKKey].S), aws.StringValue(item.Update.UpdateExpression), aws.StringValue(item.Update.ConditionExpression)))
+							case item.Update != nil && test.question.AcceptedAnswerId == nil && strings.Contains(aws.StringValue(item.Update.UpdateExpression), "#answerCount"):
+								ops = append(ops, fmt.Sprintf("update %s %s", aws.StringValue(item.Update.Key[SKKey].S), aws.StringValue(item.Update.UpdateExpression)))
+							case item.Update != nil:
+								ops = append(ops, fmt.Sprintf("update %s %s if %s", aws.StringValue(item.Update.Key[SKKey].S), aws.StringValue(item.Update.UpdateExpression), aws.StringValue(item.Update.ConditionExpression)))
+							}
+						}
+						if test.transactionErr != nil {
+							return nil, test.transactionErr
+						}
+						return &dynamodb.TransactWriteItemsOutput{}, nil
+					},
+				},
+				TableName: "test_table",
+			}
+			app := &App{
+				schema:   graphql.MustParseSchema(string(data), &resolver, schemaOptions()...),
+				resolver: &resolver,
+				awsTokenValidator: &mockAwsTokenValidator{
+					ValidateIdTokenFunc: func(idToken string) (*AWSCognitoClaims, error) {
+						return test.claims, nil
+					},
+				},
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(test.query, true))
+			require.Nil(t, err)
+			require.Equal(t, test.expect, resp.Body)
+			if test.ops == nil {
+				test.ops = []string{}
+			}
+			require.Equal(t, test.ops, ops)
+		})
+	}
+
+	t.Run("spot questions", func(t *testing.T) {
+		resolver := Resolver{
+			Db: &mockClientClient{
+				QueryFunc: func(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+					if aws.StringValue(input.IndexName) != common.SpotQuestionsByActivityIndex {
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+					}
+					require.Equal(t, "Spot#a", aws.StringValue(input.ExpressionAttributeValues[":pk"].S))
+					require.False(t, aws.BoolValue(input.ScanIndexForward))
+					// the index sorts by the activity rank, descending here
+					items := append([]map[string]*dynamodb.AttributeValue{}, questionItems...)
+					sort.SliceStable(items, func(i, j int) bool {
+						return aws.StringValue(items[i][common.ActivityRankKey].S) > aws.StringValue(items[j][common.ActivityRankKey].S)
+					})
+					if input.ExclusiveStartKey != nil {
+						for index, item := range items {
+							if aws.StringValue(item[SKKey].S) == aws.StringValue(input.ExclusiveStartKey[SKKey].S) {
+								items = items[index+1:]
+								break
+							}
+						}
+					}
+					if int(*input.Limit) < len(items) {
+						return &dynamodb.QueryOutput{Items: items[:*input.Limit], LastEvaluatedKey: common.ItemKey(items[*input.Limit-1], input.IndexName)}, nil
+					}
+					return &dynamodb.QueryOutput{Items: items}, nil
+				},
+			},
+			TableName: "test_table",
+		}
+		app := &App{schema: graphql.MustParseSchema(string(data), &resolver, schemaOptions()...), resolver: &resolver}
+		questionIds := func(after string) ([]string, string) {
+			query := `spot(spotId: \"a\") { Questions(first: 2) { edges { node { QuestionId } } pageInfo { hasNextPage endCursor } } }`
+			if after != "" {
+				query = fmt.Sprintf(`spot(spotId: \"a\") { Questions(first: 2, after: \"%s\") { edges { node { QuestionId } } pageInfo { hasNextPage endCursor } } }`, after)
+			}
+			resp, err := app.handler(context.Background(), createTestRequest(fmt.Sprintf(`{"query":"{ %s }"}`, query), false))
+			require.Nil(t, err)
+			var body struct {
+				Data struct {
+					Spot struct {
+						Questions struct {
+							Edges []struct {
+								Node struct{ QuestionId string }
+							}
+							PageInfo struct {
+								HasNextPage bool
+								EndCursor   string
+							}
+						}
+					}
+				}
+			}
+			require.Nil(t, json.Unmarshal([]byte(resp.Body), &body), resp.Body)
+			ids := []string{}
+			for _, edge := range body.Data.Spot.Questions.Edges {
+				ids = append(ids, strings.TrimPrefix(edge.Node.QuestionId, "01ENZ3GT7P00000000000000"))
+			}
+			if !body.Data.Spot.Questions.PageInfo.HasNextPage {
+				return ids, ""
+			}
+			return ids, body.Data.Spot.Questions.PageInfo.EndCursor
+		}
+
+		ids, cursor := questionIds("")
+		require.Equal(t, []string{"Q1", "Q3"}, ids)
+		require.NotEmpty(t, cursor)
+		ids, cursor = questionIds(cursor)
+		require.Equal(t, []string{"Q2"}, ids)
+		require.Empty(t, cursor)
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+// QueryExtensions carries the automatic persisted query hash of a request
+type QueryExtensions struct {
+	PersistedQuery *PersistedQueryExtension `json:"persistedQuery"`
+}
+
+type PersistedQueryExtension struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+type PersistedQuery struct {
+	PK           string `dynamodbav:"PK"`
+	SK           string `dynamodbav:"SK"`
+	Query        string `dynamodbav:"Query"`
+	CreationTime string `dynamodbav:"CreationTime"`
+}
+
+func queryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// persistedQueries keeps registered queries in dynamodb so every lambda instance can serve them,
+// recently used queries are cached in memory
+type persistedQueries struct {
+	db        dynamodbiface.DynamoDBAPI
+	tableName string
+	cache     *lruCache
+}
+
+func newPersistedQueries(db dynamodbiface.DynamoDBAPI, tableName string) *persistedQueries {
+	return &persistedQueries{db: db, tableName: tableName, cache: newLRUCache(PersistedQueryCacheSize)}
+}
+
+// resolve fills in the query text of a persisted query request. A request with query text
+// registers it once the hash has been verified, a request with only a hash needs it registered.
+func (z *persistedQueries) resolve(ctx context.Context, queryRequest *QueryRequest) *gqlerrors.QueryError {
+
+	if queryRequest.Extensions == nil || queryRequest.Extensions.PersistedQuery == nil {
+		return nil
+	}
+	persisted := queryRequest.Extensions.PersistedQuery
+	if z == nil {
+		return persistedQueryError(ErrorPersistedQueryNotSupported, PersistedQueryNotSupportedCode)
+	}
+	if persisted.Version != PersistedQueryVersion {
+		return persistedQueryError(ErrorPersistedQueryVersion, PersistedQueryVersionCode)
+	}
+
+	if queryRequest.Query != "" {
+		if queryHash(queryRequest.Query) != persisted.Sha256Hash {
+			return persistedQueryError(ErrorPersistedQueryHashMismatch, PersistedQueryHashMismatchCode)
+		}
+		return nil
+	}
+
+	query, err := z.get(ctx, persisted.Sha256Hash)
+	if err != nil {
+		// get logged the cause, the client only learns that the lookup failed
+		appErr := apperror.From(err)
+		return persistedQueryError(appErr.Message, string(appErr.Code))
+	}
+	if query == "" {
+		return persistedQueryError(ErrorPersistedQueryNotFound, PersistedQueryNotFoundCode)
+	}
+	queryRequest.Query = query
+	return nil
+}
+
+func persistedQueryError(message, code string) *gqlerrors.QueryError {
+	return &gqlerrors.QueryError{Message: message, Extensions: map[string]interface{}{"code": code}}
+}
+
+// get returns an empty query if the hash has not been registered
+func (z *persistedQueries) get(ctx context.Context, hash string) (string, error) {
+
+	if query, ok := z.cache.get(hash); ok {
+		return query, nil
+	}
+
+	key := PersistedQueryPrefix + hash
+	output, err := z.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(z.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(key)},
+			SKKey: {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		logError(ctx, "Failed to get persisted query", "persistedQueries.get", err, map[string]interface{}{"hash": hash})
+		return "", err
+	}
+	if output.Item == nil {
+		return "", nil
+	}
+
+	var persistedQuery PersistedQuery
+	err = dynamodbattribute.UnmarshalMap(output.Item, &persistedQuery)
+	if err != nil {
+		logError(ctx, "Failed to unmarshal persisted query", "persistedQueries.get", err, nil)
+		return "", err
+	}
+	z.cache.add(hash, persistedQuery.Query)
+	return persistedQuery.Query, nil
+}
+
+// register stores a query whose hash has been verified
+func (z *persistedQueries) register(ctx context.Context, query string) error {
+
+	hash := queryHash(query)
+	if _, ok := z.cache.get(hash); ok {
+		return nil
+	}
+
+	key := PersistedQueryPrefix + hash
+	item, err := dynamodbattribute.MarshalMap(PersistedQuery{
+		PK:           key,
+		SK:           key,
+		Query:        query,
+		CreationTime: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = z.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(z.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		logError(ctx, "Failed to register persisted query", "persistedQueries.register", err, map[string]interface{}{"hash": hash})
+		return err
+	}
+	z.cache.add(hash, query)
+	return nil
+}
+
+// lruCache is a fixed size string cache that evicts the least recently used entry
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value string
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *lruCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) add(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lruEntry).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/ninotokuda/carcamp_v2/common"
+)
+
+// projection maps the fields selected on the value of the current field to the attributes
+// they read. graphql-go only knows the fields selected right below the current one, so the
+// items of connections are read whole. It reads whole items if nothing is selected or a
+// field is missing from the attributes.
+func projection(ctx context.Context, attributes map[string][]string) common.Projection {
+	fields := graphql.SelectedFieldNames(ctx)
+	if len(fields) == 0 {
+		return nil
+	}
+	p := common.Projection{}
+	for _, field := range fields {
+		fieldAttributes, ok := attributes[field]
+		if !ok {
+			return nil
+		}
+		p = append(p, fieldAttributes...)
+	}
+	return p
+}
+
+// attributes read by the fields of each type, the key attributes are always read
+var (
+	spotAttributes = map[string][]string{
+		"SpotId":            {},
+		"Geohash":           {},
+		"SpotType":          {SpotTypeKey},
+		"Latitude":          {LatitudeKey},
+		"Longitude":         {LongitudeKey},
+		"CreationTime":      {CreationTimeKey},
+		"Reviews":           {},
+		"Questions":         {},
+		"SpotDistances":     {},
+		"Images":            {},
+		"CreatorId":         {},
+		"Creator":           {},
+		"Name":              {NameKey},
+		"Description":       {DescriptionKey},
+		"Address":           {AddressKey},
+		"Code":              {CodeKey},
+		"Prefecture":        {PrefectureKey},
+		"City":              {CityKey},
+		"HomePageUrls":      {HomePageUrlsKey},
+		"Tags":              {TagsKey},
+		"DefaultImageUrl":   {DefaultImageUrlKey},
+		"AverageRating":     ratingKeys(),
+		"ReviewCount":       {ReviewCountKey},
+		"RatingHistogram":   ratingKeys(),
+		"AverageSubRatings": subRatingAverageKeys(),
+	}
+
+	reviewAttributes = map[string][]string{
+		"ReviewId":       {},
+		"SpotId":         {},
+		"CreationTime":   {CreationTimeKey},
+		"UpdateTime":     {UpdateTimeKey},
+		"UserId":         {},
+		"User":           {},
+		"Rating":         {RatingKey},
+		"Message":        {MessageKey},
+		"SubRatings":     common.SubRatings,
+		"HelpfulCount":   {HelpfulCountKey},
+		"UnhelpfulCount": {UnhelpfulCountKey},
+		"VisitDate":      {VisitDateKey},
+		"VehicleClass":   {VehicleClassKey},
+		"Overnight":      {OvernightKey},
+		"PartySize":      {PartySizeKey},
+		"Images":         {ImageCountKey},
+		"Replies":        {},
+	}
+
+	userAttributes = map[string][]string{
+		"UserId":       {},
+		"Nickname":     {NicknameKey},
+		"CreationTime": {CreationTimeKey},
+		"Reviews":      {},
+		"CreatedSpots": {},
+	}
+)
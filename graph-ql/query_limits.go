@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// listSize is the number of items a list field is expected to return. The size argument
+// (first or limit) overrides the default, values the query does not fix count as max.
+type listSize struct {
+	argument string
+	defaults int
+	max      int
+}
+
+// listFields multiply the cost of their selections by the number of items they return
+var listFields = map[string]listSize{
+	"spotsByGeohash": {argument: "first", defaults: common.DefaultPageSize, max: common.MaxPageSize},
+	"SpotsByCreator": {argument: "first", defaults: common.DefaultPageSize, max: common.MaxPageSize},
+	"reviews":        {argument: "first", defaults: common.DefaultPageSize, max: common.MaxPageSize},
+	"spotsNear":      {argument: "limit", defaults: common.SpotsNearDefaultLimit, max: common.SpotsNearMaxLimit},
+	"spotsInRegion":  {argument: "limit", defaults: common.RegionDefaultLimit, max: common.RegionMaxLimit},
+	"SpotDistances":  {argument: "limit", defaults: common.DefaultPageSize, max: common.MaxPageSize},
+	"Reviews":        {argument: "first", defaults: common.DefaultPageSize, max: common.MaxPageSize},
+	"Images":         {defaults: common.DefaultPageSize, max: common.DefaultPageSize},
+	"CreatedSpots":   {argument: "first", defaults: common.DefaultPageSize, max: common.MaxPageSize},
+	"Replies":        {argument: "first", defaults: common.DefaultPageSize, max: common.MaxPageSize},
+	"Questions":      {argument: "first", defaults: common.DefaultPageSize, max: common.MaxPageSize},
+	"Answers":        {argument: "first", defaults: common.DefaultPageSize, max: common.MaxPageSize},
+}
+
+// defaultFieldCosts are the weights of fields that cost more than a single read.
+// Fields are keyed by name, Query.reviews and Spot.Reviews differ by case.
+var defaultFieldCosts = map[string]int{
+	"edges":                    0, // connections are read by their parent field
+	"node":                     0,
+	"pageInfo":                 0,
+	"spotsNear":                9, // one geohash ring of nine queries
+	"spotsInRegion":            common.RegionMaxCells,
+	"createSpot":               10,
+	"updateSpot":               10,
+	"deleteSpot":               10,
+	"createReview":             5,
+	"updateReview":             5,
+	"deleteReview":             5,
+	"voteReview":               5,
+	"deleteReviewVote":         5,
+	"replyToReview":            5,
+	"askQuestion":              5,
+	"answerQuestion":           5,
+	"acceptAnswer":             5,
+	"requestSpotImageUpload":   5,
+	"confirmSpotImage":         10,
+	"requestReviewImageUpload": 5,
+	"confirmReviewImage":       10,
+}
+
+type queryLimits struct {
+	MaxDepth   int // checked by graphql-go, 0 disables the check
+	MaxCost    int // 0 disables the check
+	FieldCosts map[string]int
+}
+
+// newQueryLimits reads the limits from the environment, QueryFieldCosts is a comma separated
+// list of field=cost pairs that override the default weights
+func newQueryLimits() queryLimits {
+	limits := queryLimits{
+		MaxDepth:   QueryDefaultMaxDepth,
+		MaxCost:    QueryDefaultMaxCost,
+		FieldCosts: map[string]int{},
+	}
+	for field, cost := range defaultFieldCosts {
+		limits.FieldCosts[field] = cost
+	}
+	if maxDepth, err := strconv.Atoi(os.Getenv(QueryMaxDepthEnv)); err == nil {
+		limits.MaxDepth = maxDepth
+	}
+	if maxCost, err := strconv.Atoi(os.Getenv(QueryMaxCostEnv)); err == nil {
+		limits.MaxCost = maxCost
+	}
+	for _, pair := range strings.Split(os.Getenv(QueryFieldCostsEnv), ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if cost, err := strconv.Atoi(parts[1]); err == nil {
+			limits.FieldCosts[parts[0]] = cost
+		}
+	}
+	return limits
+}
+
+// fieldCost is the weight of the field itself. Leaf fields are read with their parent and
+// are free, every other field resolves with at least one read.
+func (l queryLimits) fieldCost(field *ast.Field) int {
+	if cost, ok := l.FieldCosts[field.Name]; ok {
+		return cost
+	}
+	if len(field.SelectionSet) == 0 {
+		return 0
+	}
+	return QueryDefaultFieldCost
+}
+
+// check returns a query error if the operation costs more than MaxCost. The depth is
+// checked by graphql-go when it validates the operation.
+func (l queryLimits) check(doc *ast.QueryDocument, op *ast.OperationDefinition, variables map[string]interface{}) *gqlerrors.QueryError {
+
+	if l.MaxCost <= 0 {
+		return nil
+	}
+	cost := l.selectionCost(doc, op.SelectionSet, variables, map[string]bool{})
+	if cost > l.MaxCost {
+		return &gqlerrors.QueryError{
+			Message: ErrorQueryTooComplex,
+			Extensions: map[string]interface{}{
+				"cost":    cost,
+				"maxCost": l.MaxCost,
+			},
+		}
+	}
+	return nil
+}
+
+// selectionCost returns the cost of the selections. A field costs its own weight plus the
+// cost of its selections times the number of items it returns. Fragments are spread into
+// the selections, cycles are rejected by validation and only skipped here.
+func (l queryLimits) selectionCost(doc *ast.QueryDocument, selections ast.SelectionSet, variables map[string]interface{}, visited map[string]bool) int {
+
+	cost := 0
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			// introspection is answered from the schema
+			if strings.HasPrefix(selection.Name, "__") {
+				continue
+			}
+			childCost := l.selectionCost(doc, selection.SelectionSet, variables, visited)
+			if size, ok := listFields[selection.Name]; ok {
+				childCost = saturatingMultiply(childCost, size.items(selection, variables))
+			}
+			cost = saturatingAdd(cost, saturatingAdd(l.fieldCost(selection), childCost))
+		case *ast.InlineFragment:
+			cost = saturatingAdd(cost, l.selectionCost(doc, selection.SelectionSet, variables, visited))
+		case *ast.FragmentSpread:
+			fragment := doc.Fragments.ForName(selection.Name)
+			if fragment == nil || visited[selection.Name] {
+				continue
+			}
+			visited[selection.Name] = true
+			cost = saturatingAdd(cost, l.selectionCost(doc, fragment.SelectionSet, variables, visited))
+			delete(visited, selection.Name)
+		}
+	}
+	return cost
+}
+
+// costs grow exponentially with depth, they stop at math.MaxInt32 instead of overflowing
+func saturatingMultiply(a, b int) int {
+	if b != 0 && a > math.MaxInt32/b {
+		return math.MaxInt32
+	}
+	return a * b
+}
+
+func saturatingAdd(a, b int) int {
+	if a > math.MaxInt32-b {
+		return math.MaxInt32
+	}
+	return a + b
+}
+
+func (s listSize) items(field *ast.Field, variables map[string]interface{}) int {
+	if s.argument == "" {
+		return s.defaults
+	}
+	argument := field.Arguments.ForName(s.argument)
+	if argument == nil {
+		return s.defaults
+	}
+	if argument.Value.Kind == ast.Variable {
+		if _, ok := variables[argument.Value.Raw]; !ok {
+			// the variable may have a default value we do not know
+			return s.max
+		}
+	}
+	value, err := argument.Value.Value(variables)
+	if err != nil {
+		return s.max
+	}
+
+	items := s.max
+	switch v := value.(type) {
+	case int64:
+		items = int(v)
+	case float64:
+		items = int(v)
+	case nil:
+		items = s.defaults
+	}
+	if items < 0 || items > s.max {
+		return s.max
+	}
+	return items
+}
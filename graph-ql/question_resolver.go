@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+type QuestionIdArgs struct {
+	QuestionId string
+}
+
+// Question finds the question by its id through GSI1, the question partition is the spot's
+func (r *Resolver) Question(ctx context.Context, args QuestionIdArgs) (*QuestionResolver, error) {
+
+	logInfo(ctx, "Invoke", "Question", map[string]interface{}{"args": args})
+	question, err := r.getQuestion(ctx, args.QuestionId)
+	if err != nil {
+		return nil, err
+	}
+	return &QuestionResolver{question: question, baseResolver: r}, nil
+}
+
+func (r *Resolver) getQuestion(ctx context.Context, questionId string) (Question, error) {
+
+	output, err := r.Db.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(r.TableName),
+		IndexName:              aws.String(GSI1Key),
+		KeyConditionExpression: aws.String("#gsi1 = :gsi1 AND begins_with(#sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":gsi1": {S: aws.String(fmt.Sprintf("%s%s", common.QuestionPrefix, questionId))},
+			":sk":   {S: aws.String(common.QuestionPrefix)},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#gsi1": aws.String(GSI1Key),
+			"#sk":   aws.String(SKKey),
+		},
+		Limit: aws.Int64(1),
+	})
+	if err != nil {
+		logError(ctx, "Failed to query question", "getQuestion", err, nil)
+		return Question{}, err
+	}
+	if len(output.Items) == 0 {
+		return Question{}, apperror.New(apperror.NotFound, ErrorQuestionNotFound)
+	}
+
+	var question Question
+	err = dynamodbattribute.UnmarshalMap(output.Items[0], &question)
+	if err != nil {
+		logError(ctx, "Failed to unmarshal question", "getQuestion", err, nil)
+		return Question{}, err
+	}
+	return question, nil
+}
+
+type AskQuestionArgs struct {
+	SpotId  string
+	Message string
+}
+
+// AskQuestion stores a question of the request user about the spot. The question id is a ULID
+// like the review ids.
+func (r *Resolver) AskQuestion(ctx context.Context, args AskQuestionArgs) (*QuestionResolver, error) {
+
+	logInfo(ctx, "Invoke", "AskQuestion", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "AskQuestion", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	if err := validateMessage(args.Message, QuestionMessageMaxLength); err != nil {
+		return nil, err
+	}
+	spot, err := r.loadSpot(ctx, args.SpotId, common.Projection{})
+	if err != nil {
+		return nil, err
+	}
+	if spot == nil {
+		return nil, apperror.New(apperror.NotFound, common.ErrorSpotNotFound)
+	}
+
+	now := time.Now()
+	questionId := common.NewULID(now)
+	question := Question{
+		PK:           spot.PK,
+		SK:           fmt.Sprintf("%s%s", common.QuestionPrefix, questionId),
+		GSI1:         aws.String(fmt.Sprintf("%s%s", common.QuestionPrefix, questionId)),
+		GSI2:         aws.String(fmt.Sprintf("%s%s", UserPrefix, requestUser.UserId())),
+		CreationTime: now.Format(time.RFC3339),
+		ActivityTime: now.Format(time.RFC3339),
+		ActivityRank: aws.String(activityRank(now.Format(time.RFC3339), questionId)),
+		Message:      args.Message,
+	}
+	item, err := dynamodbattribute.MarshalMap(question)
+	if err != nil {
+		logError(ctx, "Failed to marshal question", "AskQuestion", err, nil)
+		return nil, err
+	}
+
+	_, err = r.Db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName:           aws.String(r.TableName),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(PK)"),
+				},
+			},
+			{
+				// a question cannot bring back a deleted spot
+				ConditionCheck: &dynamodb.ConditionCheck{
+					TableName: aws.String(r.TableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						PKKey: {S: aws.String(spot.PK)},
+						SKKey: {S: aws.String(spot.SK)},
+					},
+					ConditionExpression:      aws.String("attribute_exists(#pk)"),
+					ExpressionAttributeNames: map[string]*string{"#pk": aws.String(PKKey)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		logError(ctx, "Failed to put question", "AskQuestion", err, nil)
+		if conditionFailed(err, 1) {
+			return nil, apperror.Wrap(apperror.NotFound, common.ErrorSpotNotFound, err)
+		}
+		return nil, err
+	}
+
+	return &QuestionResolver{question: question, baseResolver: r}, nil
+}
+
+type AnswerQuestionArgs struct {
+	QuestionId string
+	Message    string
+}
+
+// AnswerQuestion stores an answer of the request user. The answer count and the activity time
+// and rank of the question are updated in the same transaction.
+func (r *Resolver) AnswerQuestion(ctx context.Context, args AnswerQuestionArgs) (*AnswerResolver, error) {
+
+	logInfo(ctx, "Invoke", "AnswerQuestion", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "AnswerQuestion", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	if err := validateMessage(args.Message, AnswerMessageMaxLength); err != nil {
+		return nil, err
+	}
+	question, err := r.getQuestion(ctx, args.QuestionId)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	answer := Answer{
+		PK:           question.PK,
+		SK:           fmt.Sprintf("%s%s", questionAnswerPrefix(args.QuestionId), common.NewULID(now)),
+		GSI2:         aws.String(fmt.Sprintf("%s%s", UserPrefix, requestUser.UserId())),
+		CreationTime: now.Format(time.RFC3339),
+		Message:      args.Message,
+	}
+	item, err := dynamodbattribute.MarshalMap(answer)
+	if err != nil {
+		logError(ctx, "Failed to marshal answer", "AnswerQuestion", err, nil)
+		return nil, err
+	}
+
+	_, err = r.Db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName:           aws.String(r.TableName),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(PK)"),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(r.TableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						PKKey: {S: aws.String(question.PK)},
+						SKKey: {S: aws.String(question.SK)},
+					},
+					UpdateExpression:    aws.String("SET #activityTime = :now, #activityRank = :rank ADD #answerCount :one"),
+					ConditionExpression: aws.String("attribute_exists(#pk)"),
+					ExpressionAttributeNames: map[string]*string{
+						"#pk":           aws.String(PKKey),
+						"#activityTime": aws.String(ActivityTimeKey),
+						"#activityRank": aws.String(common.ActivityRankKey),
+						"#answerCount":  aws.String(AnswerCountKey),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":now":  {S: aws.String(answer.CreationTime)},
+						":rank": {S: aws.String(activityRank(answer.CreationTime, args.QuestionId))},
+						":one":  {N: aws.String("1")},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		logError(ctx, "Failed to put answer", "AnswerQuestion", err, nil)
+		if conditionFailed(err, 1) {
+			return nil, apperror.Wrap(apperror.NotFound, ErrorQuestionNotFound, err)
+		}
+		return nil, err
+	}
+
+	return &AnswerResolver{answer: answer, baseResolver: r}, nil
+}
+
+type AcceptAnswerArgs struct {
+	QuestionId string
+	AnswerId   string
+}
+
+// AcceptAnswer marks the answer as the accepted answer of the question, only the author of the
+// question can accept an answer. Accepting another answer takes the flag from the previous one.
+func (r *Resolver) AcceptAnswer(ctx context.Context, args AcceptAnswerArgs) (*QuestionResolver, error) {
+
+	logInfo(ctx, "Invoke", "AcceptAnswer", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "AcceptAnswer", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	question, err := r.getQuestion(ctx, args.QuestionId)
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(question.GSI2) != fmt.Sprintf("%s%s", UserPrefix, requestUser.UserId()) {
+		logInfo(ctx, "User is not the author", "AcceptAnswer", map[string]interface{}{"questionId": args.QuestionId})
+		return nil, apperror.New(apperror.Forbidden, ErrorUserIsNotQuestionAuthor)
+	}
+	if aws.StringValue(question.AcceptedAnswerId) == args.AnswerId {
+		return &QuestionResolver{question: question, baseResolver: r}, nil
+	}
+
+	accepted := question
+	accepted.AcceptedAnswerId = aws.String(args.AnswerId)
+	accepted.ActivityTime = time.Now().Format(time.RFC3339)
+	accepted.ActivityRank = aws.String(activityRank(accepted.ActivityTime, args.QuestionId))
+	// the accepted answer is still the one that was read
+	condition := "attribute_exists(#pk) AND attribute_not_exists(#acceptedAnswerId)"
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":answerId": {S: accepted.AcceptedAnswerId},
+		":now":      {S: aws.String(accepted.ActivityTime)},
+		":rank":     {S: accepted.ActivityRank},
+	}
+	if question.AcceptedAnswerId != nil {
+		condition = "attribute_exists(#pk) AND #acceptedAnswerId = :previous"
+		expressionAttributeValues[":previous"] = &dynamodb.AttributeValue{S: question.AcceptedAnswerId}
+	}
+	transactItems := []*dynamodb.TransactWriteItem{
+		{
+			Update: &dynamodb.Update{
+				TableName: aws.String(r.TableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					PKKey: {S: aws.String(question.PK)},
+					SKKey: {S: aws.String(question.SK)},
+				},
+				UpdateExpression:    aws.String("SET #acceptedAnswerId = :answerId, #activityTime = :now, #activityRank = :rank"),
+				ConditionExpression: aws.String(condition),
+				ExpressionAttributeNames: map[string]*string{
+					"#pk":               aws.String(PKKey),
+					"#acceptedAnswerId": aws.String(AcceptedAnswerIdKey),
+					"#activityTime":     aws.String(ActivityTimeKey),
+					"#activityRank":     aws.String(common.ActivityRankKey),
+				},
+				ExpressionAttributeValues: expressionAttributeValues,
+			},
+		},
+		{
+			Update: answerAcceptedUpdate(question, args.AnswerId, true, r.TableName),
+		},
+	}
+	if question.AcceptedAnswerId != nil {
+		transactItems = append(transactItems, &dynamodb.TransactWriteItem{
+			Update: answerAcceptedUpdate(question, *question.AcceptedAnswerId, false, r.TableName),
+		})
+	}
+
+	_, err = r.Db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+	if err != nil {
+		logError(ctx, "Failed to accept answer", "AcceptAnswer", err, nil)
+		if conditionFailed(err, 0) {
+			// the question was deleted or another answer was accepted since it was read
+			return nil, apperror.Wrap(apperror.Conflict, apperror.ErrorConflict, err)
+		}
+		if conditionFailed(err, 1) {
+			return nil, apperror.Wrap(apperror.NotFound, ErrorAnswerNotFound, err)
+		}
+		return nil, err
+	}
+
+	return &QuestionResolver{question: accepted, baseResolver: r}, nil
+}
+
+// answerAcceptedUpdate sets or removes the accepted flag of an answer of the question, the
+// answer has to exist so the update does not create it
+func answerAcceptedUpdate(question Question, answerId string, accepted bool, tableName string) *dynamodb.Update {
+
+	update := &dynamodb.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(question.PK)},
+			SKKey: {S: aws.String(fmt.Sprintf("%s%s", questionAnswerPrefix(strings.TrimPrefix(question.SK, common.QuestionPrefix)), answerId))},
+		},
+		UpdateExpression:    aws.String("REMOVE #accepted"),
+		ConditionExpression: aws.String("attribute_exists(#pk)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk":       aws.String(PKKey),
+			"#accepted": aws.String(AcceptedKey),
+		},
+	}
+	if accepted {
+		update.UpdateExpression = aws.String("SET #accepted = :accepted")
+		update.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{":accepted": {BOOL: aws.Bool(true)}}
+	}
+	return update
+}
+
+type SpotQuestionsArgs struct {
+	First *int32
+	After *string
+}
+
+// Questions pages through the questions of the spot, the most recently active first. The activity
+// index only holds questions, ties go newest first.
+func (z SpotResolver) Questions(ctx context.Context, args SpotQuestionsArgs) (*QuestionConnectionResolver, error) {
+
+	first, err := pageSize(args.First)
+	if err != nil {
+		return nil, err
+	}
+	queryInput := dynamodb.QueryInput{
+		TableName:              aws.String(z.baseResolver.TableName),
+		IndexName:              aws.String(common.SpotQuestionsByActivityIndex),
+		KeyConditionExpression: aws.String("#pk = :pk"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(fmt.Sprintf("%s%s", SpotPrefix, z.SpotId(ctx)))},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(PKKey),
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	page, err := common.QueryPage(ctx, queryInput, first, aws.StringValue(args.After), z.baseResolver.Db)
+	if err != nil {
+		return nil, err
+	}
+	return newQuestionConnection(z.baseResolver, page, queryInput.IndexName)
+}
+
+type QuestionAnswersArgs struct {
+	First *int32
+	After *string
+}
+
+// Answers pages through the answers to the question, oldest first
+func (u QuestionResolver) Answers(ctx context.Context, args QuestionAnswersArgs) (*AnswerConnectionResolver, error) {
+
+	first, err := pageSize(args.First)
+	if err != nil {
+		return nil, err
+	}
+	queryInput := dynamodb.QueryInput{
+		TableName:              aws.String(u.baseResolver.TableName),
+		KeyConditionExpression: aws.String("#pk = :pk AND begins_with(#sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(u.question.PK)},
+			":sk": {S: aws.String(questionAnswerPrefix(u.QuestionId(ctx)))},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(PKKey),
+			"#sk": aws.String(SKKey),
+		},
+	}
+	page, err := common.QueryPage(ctx, queryInput, first, aws.StringValue(args.After), u.baseResolver.Db)
+	if err != nil {
+		return nil, err
+	}
+	return newAnswerConnection(u.baseResolver, page)
+}
+
+// validateMessage checks that the message of a question or answer is not blank or too long
+func validateMessage(message string, maxLength int) error {
+	if strings.TrimSpace(message) == "" {
+		return apperror.Invalid("message", ErrorEmptyValue)
+	}
+	if utf8.RuneCountInString(message) > maxLength {
+		return apperror.Invalid("message", ErrorValueTooLong)
+	}
+	return nil
+}
+
+// activityRank is the sort key of a question in the activity index, question ids are ULIDs
+func activityRank(activityTime, questionId string) string {
+	return fmt.Sprintf("%s#%s", activityTime, questionId)
+}
+
+// questionAnswerPrefix starts the sort keys of the answers to the question, Answer#<question_id>#
+func questionAnswerPrefix(questionId string) string {
+	return fmt.Sprintf("%s%s#", common.AnswerPrefix, questionId)
+}
+
+// Question is asked about a spot and lives in the partition of the spot
+type Question struct {
+	PK               string  `dynamodbav:"PK"`   // Spot#<spot_id>
+	SK               string  `dynamodbav:"SK"`   // Question#<question_id>
+	GSI1             *string `dynamodbav:"GSI1"` // Question#<question_id>
+	GSI2             *string `dynamodbav:"GSI2"` // User#<user_id>
+	CreationTime     string  `dynamodbav:"CreationTime"`
+	ActivityTime     string  `dynamodbav:"ActivityTime"`           // the last time it was asked, answered or accepted
+	ActivityRank     *string `dynamodbav:"ActivityRank,omitempty"` // <activity_time>#<question_id>
+	Message          string  `dynamodbav:"Message"`
+	AnswerCount      *int64  `dynamodbav:"AnswerCount,omitempty"`
+	AcceptedAnswerId *string `dynamodbav:"AcceptedAnswerId,omitempty"`
+}
+
+// Answer to a question, it lives in the partition of the spot next to the question
+type Answer struct {
+	PK           string  `dynamodbav:"PK"`   // Spot#<spot_id>
+	SK           string  `dynamodbav:"SK"`   // Answer#<question_id>#<answer_id>
+	GSI2         *string `dynamodbav:"GSI2"` // User#<user_id>
+	CreationTime string  `dynamodbav:"CreationTime"`
+	Message      string  `dynamodbav:"Message"`
+	Accepted     *bool   `dynamodbav:"Accepted,omitempty"`
+}
+
+type QuestionResolver struct {
+	question     Question
+	baseResolver *Resolver
+}
+
+func (u QuestionResolver) QuestionId(ctx context.Context) string {
+	return strings.TrimPrefix(u.question.SK, common.QuestionPrefix)
+}
+
+func (u QuestionResolver) SpotId(ctx context.Context) string {
+	return strings.TrimPrefix(u.question.PK, SpotPrefix)
+}
+
+func (u QuestionResolver) UserId(ctx context.Context) *string {
+	if u.question.GSI2 != nil {
+		return aws.String(strings.TrimPrefix(*u.question.GSI2, UserPrefix))
+	}
+	return nil
+}
+
+func (u QuestionResolver) User(ctx context.Context) (*UserResolver, error) {
+	userId := u.UserId(ctx)
+	if userId == nil {
+		return nil, nil
+	}
+	user, err := u.baseResolver.loadUser(ctx, *userId, projection(ctx, userAttributes))
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return &UserResolver{user: *user, baseResolver: u.baseResolver}, nil
+}
+
+func (u QuestionResolver) Message(ctx context.Context) string {
+	return u.question.Message
+}
+
+func (u QuestionResolver) CreationTime(ctx context.Context) string {
+	return u.question.CreationTime
+}
+
+func (u QuestionResolver) ActivityTime(ctx context.Context) string {
+	return u.question.ActivityTime
+}
+
+func (u QuestionResolver) AnswerCount(ctx context.Context) int32 {
+	return int32(aws.Int64Value(u.question.AnswerCount))
+}
+
+func (u QuestionResolver) AcceptedAnswerId(ctx context.Context) *string {
+	return u.question.AcceptedAnswerId
+}
+
+type AnswerResolver struct {
+	answer       Answer
+	baseResolver *Resolver
+}
+
+// AnswerId is a ULID, so answers sort by time
+func (u AnswerResolver) AnswerId(ctx context.Context) string {
+	return u.answer.SK[strings.LastIndex(u.answer.SK, "#")+1:]
+}
+
+func (u AnswerResolver) QuestionId(ctx context.Context) string {
+	return strings.TrimSuffix(strings.TrimPrefix(u.answer.SK, common.AnswerPrefix), "#"+u.AnswerId(ctx))
+}
+
+func (u AnswerResolver) UserId(ctx context.Context) *string {
+	if u.answer.GSI2 != nil {
+		return aws.String(strings.TrimPrefix(*u.answer.GSI2, UserPrefix))
+	}
+	return nil
+}
+
+func (u AnswerResolver) User(ctx context.Context) (*UserResolver, error) {
+	userId := u.UserId(ctx)
+	if userId == nil {
+		return nil, nil
+	}
+	user, err := u.baseResolver.loadUser(ctx, *userId, projection(ctx, userAttributes))
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return &UserResolver{user: *user, baseResolver: u.baseResolver}, nil
+}
+
+func (u AnswerResolver) Message(ctx context.Context) string {
+	return u.answer.Message
+}
+
+func (u AnswerResolver) CreationTime(ctx context.Context) string {
+	return u.answer.CreationTime
+}
+
+func (u AnswerResolver) IsAccepted(ctx context.Context) bool {
+	return aws.BoolValue(u.answer.Accepted)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+// ReviewFilterInput restricts review lists by the visit of the reviewer. A review matches if it
+// matches every field that is set, reviews without the visit field do not match. The visit dates
+// are inclusive.
+type ReviewFilterInput struct {
+	Overnight      *bool
+	VehicleClasses *[]string
+	VisitedFrom    *string
+	VisitedUntil   *string
+	MinPartySize   *int32
+	MaxPartySize   *int32
+}
+
+func (f *ReviewFilterInput) validate() error {
+	if f == nil {
+		return nil
+	}
+	for field, date := range map[string]*string{"visitedFrom": f.VisitedFrom, "visitedUntil": f.VisitedUntil} {
+		if date == nil {
+			continue
+		}
+		if _, err := time.Parse(VisitDateLayout, *date); err != nil {
+			return apperror.Invalid("filter."+field, ErrorInvalidDate)
+		}
+	}
+	return nil
+}
+
+// FilterExpression adds the names and values of the filter to the maps and returns the expression,
+// it returns nil for a filter without fields
+func (f *ReviewFilterInput) FilterExpression(expressionAttributeNames map[string]*string, expressionAttributeValues map[string]*dynamodb.AttributeValue) *string {
+
+	if f == nil {
+		return nil
+	}
+	conditions := []string{}
+	condition := func(key, operator, valueName string, value *dynamodb.AttributeValue) {
+		expressionAttributeNames["#"+key] = aws.String(key)
+		expressionAttributeValues[valueName] = value
+		conditions = append(conditions, fmt.Sprintf("#%s %s %s", key, operator, valueName))
+	}
+
+	if f.Overnight != nil {
+		condition(OvernightKey, "=", ":overnight", &dynamodb.AttributeValue{BOOL: f.Overnight})
+	}
+	if f.VehicleClasses != nil && len(*f.VehicleClasses) > 0 {
+		valueNames := []string{}
+		for index, vehicleClass := range *f.VehicleClasses {
+			valueName := fmt.Sprintf(":vehicleClass_%d", index)
+			expressionAttributeValues[valueName] = &dynamodb.AttributeValue{S: aws.String(vehicleClass)}
+			valueNames = append(valueNames, valueName)
+		}
+		expressionAttributeNames["#"+VehicleClassKey] = aws.String(VehicleClassKey)
+		conditions = append(conditions, fmt.Sprintf("#%s IN (%s)", VehicleClassKey, strings.Join(valueNames, ", ")))
+	}
+	// visit dates are YYYY-MM-DD, they compare as strings
+	if f.VisitedFrom != nil {
+		condition(VisitDateKey, ">=", ":visitedFrom", &dynamodb.AttributeValue{S: f.VisitedFrom})
+	}
+	if f.VisitedUntil != nil {
+		condition(VisitDateKey, "<=", ":visitedUntil", &dynamodb.AttributeValue{S: f.VisitedUntil})
+	}
+	if f.MinPartySize != nil {
+		condition(PartySizeKey, ">=", ":minPartySize", &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", *f.MinPartySize))})
+	}
+	if f.MaxPartySize != nil {
+		condition(PartySizeKey, "<=", ":maxPartySize", &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", *f.MaxPartySize))})
+	}
+
+	if len(conditions) == 0 {
+		return nil
+	}
+	return aws.String(strings.Join(conditions, " AND "))
+}
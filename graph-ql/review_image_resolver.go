@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+type ReviewImageArgs struct {
+	ReviewId    string
+	SpotImageId string
+	ContentType string
+}
+
+// RequestReviewImageUpload returns a presigned url for a photo of a review of the request user.
+// The photo is uploaded like a spot image and attached once it is confirmed with ConfirmReviewImage.
+func (r *Resolver) RequestReviewImageUpload(ctx context.Context, args ReviewImageArgs) (*SpotImageUploadResolver, error) {
+
+	logInfo(ctx, "Invoke", "RequestReviewImageUpload", map[string]interface{}{"args": args})
+	review, err := r.loadOwnReview(ctx, args.ReviewId, false, "RequestReviewImageUpload")
+	if err != nil {
+		return nil, err
+	}
+	if !common.IsImageContentType(args.ContentType) {
+		return nil, apperror.New(apperror.Validation, ErrorInvalidContentType)
+	}
+	if aws.Int64Value(review.ImageCount) >= ReviewMaxImages {
+		return nil, apperror.New(apperror.Validation, ErrorTooManyReviewImages)
+	}
+	reviewer := ReviewResolver{review: review}
+	return r.presignImageUpload(ctx, reviewer.SpotId(ctx), aws.StringValue(reviewer.UserId(ctx)), args.ContentType, "RequestReviewImageUpload")
+}
+
+// ConfirmReviewImage validates the uploaded photo and stores it as an image of the spot that
+// belongs to the review. The image count of the review is raised in the same transaction, so a
+// review never has more than ReviewMaxImages photos.
+func (r *Resolver) ConfirmReviewImage(ctx context.Context, args ReviewImageArgs) (*SpotImageResolver, error) {
+
+	logInfo(ctx, "Invoke", "ConfirmReviewImage", map[string]interface{}{"args": args})
+	review, err := r.loadOwnReview(ctx, args.ReviewId, false, "ConfirmReviewImage")
+	if err != nil {
+		return nil, err
+	}
+	reviewer := ReviewResolver{review: review}
+	spotImage, err := r.uploadedImage(ctx, reviewer.SpotId(ctx), args.SpotImageId, aws.StringValue(reviewer.UserId(ctx)), "ConfirmReviewImage")
+	if err != nil {
+		return nil, err
+	}
+	spotImage.ReviewId = aws.String(args.ReviewId)
+	item, err := dynamodbattribute.MarshalMap(spotImage)
+	if err != nil {
+		logError(ctx, "Failed to marshal spot image", "ConfirmReviewImage", err, nil)
+		return nil, err
+	}
+
+	_, err = r.Db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName:           aws.String(r.TableName),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(PK)"),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName: aws.String(r.TableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						PKKey: {S: aws.String(review.PK)},
+						SKKey: {S: aws.String(review.SK)},
+					},
+					UpdateExpression:    aws.String("ADD #imageCount :one"),
+					ConditionExpression: aws.String("attribute_exists(#pk) AND attribute_not_exists(#deleting) AND (attribute_not_exists(#imageCount) OR #imageCount < :max)"),
+					ExpressionAttributeNames: map[string]*string{
+						"#pk":         aws.String(PKKey),
+						"#deleting":   aws.String(DeletingKey),
+						"#imageCount": aws.String(ImageCountKey),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":one": {N: aws.String("1")},
+						":max": {N: aws.String(fmt.Sprintf("%d", ReviewMaxImages))},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		logError(ctx, "Failed to put review image", "ConfirmReviewImage", err, nil)
+		if conditionFailed(err, 0) {
+			return nil, apperror.Wrap(apperror.Conflict, ErrorSpotImageAlreadyConfirmed, err)
+		}
+		if conditionFailed(err, 1) {
+			// the review is full or was deleted since it was read, or is being deleted, the upload is not used
+			r.deleteObject(ctx, spotImage.ObjectKey, "ConfirmReviewImage")
+			return nil, apperror.Wrap(apperror.Validation, ErrorTooManyReviewImages, err)
+		}
+		return nil, err
+	}
+
+	return &SpotImageResolver{spotImage: spotImage}, nil
+}
+
+// deleteReviewImages deletes the photos of a deleted review with their uploads and renditions
+func (r *Resolver) deleteReviewImages(ctx context.Context, review Review) error {
+
+	if aws.Int64Value(review.ImageCount) == 0 {
+		return nil
+	}
+	reviewer := ReviewResolver{review: review}
+	spotImages, err := r.spotImages(ctx, reviewer.SpotId(ctx), aws.String(reviewer.ReviewId(ctx)))
+	if err != nil {
+		return err
+	}
+	for _, spotImage := range spotImages {
+		// the objects go first, a failed deletion is retried while the item still points at them.
+		// The upload is gone once it has been processed, deleting it again succeeds
+		r.deleteObject(ctx, spotImage.ObjectKey, "deleteReviewImages")
+		prefix := fmt.Sprintf("%s%s/%s/", common.SpotImageRenditionPrefix, spotImage.SpotId(), spotImage.SpotImageId())
+		err = r.deleteObjects(ctx, prefix)
+		if err != nil {
+			logError(ctx, "Failed to delete review image renditions", "deleteReviewImages", err, map[string]interface{}{"prefix": prefix})
+			return err
+		}
+		_, err = r.Db.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(r.TableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				PKKey: {S: aws.String(spotImage.PK)},
+				SKKey: {S: aws.String(spotImage.SK)},
+			},
+		})
+		if err != nil {
+			logError(ctx, "Failed to delete review image", "deleteReviewImages", err, map[string]interface{}{"spotImageId": spotImage.SpotImageId()})
+			return err
+		}
+	}
+	return nil
+}
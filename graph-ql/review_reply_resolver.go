@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+type ReplyToReviewArgs struct {
+	ReviewId string
+	Message  string
+}
+
+// ReplyToReview stores a reply of the request user to a review
+func (r *Resolver) ReplyToReview(ctx context.Context, args ReplyToReviewArgs) (*ReviewReplyResolver, error) {
+
+	logInfo(ctx, "Invoke", "ReplyToReview", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "ReplyToReview", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	if strings.TrimSpace(args.Message) == "" {
+		return nil, apperror.Invalid("message", ErrorEmptyValue)
+	}
+	if utf8.RuneCountInString(args.Message) > ReplyMessageMaxLength {
+		return nil, apperror.Invalid("message", ErrorValueTooLong)
+	}
+	review, err := r.getReview(ctx, args.ReviewId, common.Projection{})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	reply := ReviewReply{
+		PK:           review.PK,
+		SK:           fmt.Sprintf("%s%s", reviewReplyPrefix(args.ReviewId), common.NewULID(now)),
+		GSI2:         aws.String(fmt.Sprintf("%s%s", UserPrefix, requestUser.UserId())),
+		CreationTime: now.Format(time.RFC3339),
+		Message:      args.Message,
+	}
+	item, err := dynamodbattribute.MarshalMap(reply)
+	if err != nil {
+		logError(ctx, "Failed to marshal review reply", "ReplyToReview", err, nil)
+		return nil, err
+	}
+
+	_, err = r.Db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName:           aws.String(r.TableName),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(PK)"),
+				},
+			},
+			{
+				// the review was not deleted since it was read and is not being deleted
+				ConditionCheck: &dynamodb.ConditionCheck{
+					TableName: aws.String(r.TableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						PKKey: {S: aws.String(review.PK)},
+						SKKey: {S: aws.String(review.SK)},
+					},
+					ConditionExpression: aws.String("attribute_exists(#pk) AND attribute_not_exists(#deleting)"),
+					ExpressionAttributeNames: map[string]*string{
+						"#pk":       aws.String(PKKey),
+						"#deleting": aws.String(DeletingKey),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		logError(ctx, "Failed to put review reply", "ReplyToReview", err, nil)
+		if conditionFailed(err, 1) {
+			return nil, apperror.Wrap(apperror.NotFound, ErrorReviewNotFound, err)
+		}
+		return nil, err
+	}
+
+	return &ReviewReplyResolver{reply: reply, baseResolver: r}, nil
+}
+
+type ReviewRepliesArgs struct {
+	First *int32
+	After *string
+}
+
+// Replies pages through the replies to the review, oldest first
+func (u ReviewResolver) Replies(ctx context.Context, args ReviewRepliesArgs) (*ReviewReplyConnectionResolver, error) {
+
+	first, err := pageSize(args.First)
+	if err != nil {
+		return nil, err
+	}
+	queryInput := dynamodb.QueryInput{
+		TableName:              aws.String(u.baseResolver.TableName),
+		KeyConditionExpression: aws.String("#pk = :pk AND begins_with(#sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(u.review.PK)},
+			":sk": {S: aws.String(reviewReplyPrefix(u.ReviewId(ctx)))},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(PKKey),
+			"#sk": aws.String(SKKey),
+		},
+	}
+	page, err := common.QueryPage(ctx, queryInput, first, aws.StringValue(args.After), u.baseResolver.Db)
+	if err != nil {
+		return nil, err
+	}
+	return newReviewReplyConnection(u.baseResolver, page)
+}
+
+// reviewReplyPrefix starts the sort keys of the replies to the review, Reply#<review_id>#
+func reviewReplyPrefix(reviewId string) string {
+	return fmt.Sprintf("%s%s#", ReviewReplyPrefix, reviewId)
+}
+
+// ReviewReply is an answer to a review, it lives in the partition of the spot next to the review
+type ReviewReply struct {
+	PK           string  `dynamodbav:"PK"`   // Spot#<spot_id>
+	SK           string  `dynamodbav:"SK"`   // Reply#<review_id>#<reply_id>
+	GSI2         *string `dynamodbav:"GSI2"` // User#<user_id>
+	CreationTime string  `dynamodbav:"CreationTime"`
+	Message      string  `dynamodbav:"Message"`
+}
+
+type ReviewReplyResolver struct {
+	reply        ReviewReply
+	baseResolver *Resolver
+}
+
+// ReplyId is a ULID, so replies sort by time
+func (u ReviewReplyResolver) ReplyId(ctx context.Context) string {
+	return u.reply.SK[strings.LastIndex(u.reply.SK, "#")+1:]
+}
+
+func (u ReviewReplyResolver) ReviewId(ctx context.Context) string {
+	return strings.TrimSuffix(strings.TrimPrefix(u.reply.SK, ReviewReplyPrefix), "#"+u.ReplyId(ctx))
+}
+
+func (u ReviewReplyResolver) UserId(ctx context.Context) *string {
+	if u.reply.GSI2 != nil {
+		return aws.String(strings.TrimPrefix(*u.reply.GSI2, UserPrefix))
+	}
+	return nil
+}
+
+func (u ReviewReplyResolver) User(ctx context.Context) (*UserResolver, error) {
+	userId := u.UserId(ctx)
+	if userId == nil {
+		return nil, nil
+	}
+	user, err := u.baseResolver.loadUser(ctx, *userId, projection(ctx, userAttributes))
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return &UserResolver{user: *user, baseResolver: u.baseResolver}, nil
+}
+
+func (u ReviewReplyResolver) Message(ctx context.Context) string {
+	return u.reply.Message
+}
+
+func (u ReviewReplyResolver) CreationTime(ctx context.Context) string {
+	return u.reply.CreationTime
+}
+
+// IsOwnerReply compares the author with the current creator of the spot, so it follows
+// ownership transfers
+func (u ReviewReplyResolver) IsOwnerReply(ctx context.Context) (bool, error) {
+	if u.reply.GSI2 == nil {
+		return false, nil
+	}
+	spot, err := u.baseResolver.loadSpot(ctx, strings.TrimPrefix(u.reply.PK, SpotPrefix), common.Projection{GSI1Key})
+	if err != nil || spot == nil {
+		return false, err
+	}
+	return aws.StringValue(spot.GSI1) == *u.reply.GSI2, nil
+}
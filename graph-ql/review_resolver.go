@@ -3,13 +3,16 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
 )
 
 type ReviewArgs struct {
@@ -19,113 +22,695 @@ type ReviewArgs struct {
 	LastReviewId *string
 	Rating       *int32
 	Message      *string
+	First        *int32
+	After        *string
+	OrderBy      *string
+	Filter       *ReviewFilterInput
 }
 
-func (r *Resolver) Review(ctx context.Context, args ReviewArgs) (*ReviewResolver, error) {
+type ReviewIdArgs struct {
+	ReviewId string
+}
 
-	log.Println("Review")
-	pk := fmt.Sprintf("%s%s", ReviewPrefix, *args.ReviewId)
-	output, err := r.Db.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(r.TableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"PK": {
-				S: aws.String(pk),
-			},
-		},
-	})
+// Review finds the review by its id through GSI1, the review partition is the spot's
+func (r *Resolver) Review(ctx context.Context, args ReviewIdArgs) (*ReviewResolver, error) {
 
+	logInfo(ctx, "Invoke", "Review", map[string]interface{}{"args": args})
+	review, err := r.getReview(ctx, args.ReviewId, projection(ctx, reviewAttributes))
 	if err != nil {
 		return nil, err
 	}
+	return &ReviewResolver{review: review, baseResolver: r}, nil
+}
 
-	var review Review
-	err = dynamodbattribute.UnmarshalMap(output.Item, &review)
+func (r *Resolver) getReview(ctx context.Context, reviewId string, projection common.Projection) (Review, error) {
+
+	expressionAttributeNames := map[string]*string{
+		"#gsi1": aws.String(GSI1Key),
+		"#sk":   aws.String(SKKey),
+	}
+	output, err := r.Db.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(r.TableName),
+		IndexName:              aws.String(GSI1Key),
+		KeyConditionExpression: aws.String("#gsi1 = :gsi1 AND begins_with(#sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":gsi1": {S: aws.String(fmt.Sprintf("%s%s", ReviewPrefix, reviewId))},
+			":sk":   {S: aws.String(ReviewPrefix)},
+		},
+		ExpressionAttributeNames: expressionAttributeNames,
+		ProjectionExpression:     projection.ProjectionExpression(expressionAttributeNames),
+		Limit:                    aws.Int64(1),
+	})
 	if err != nil {
-		return nil, err
+		logError(ctx, "Failed to query review", "getReview", err, nil)
+		return Review{}, err
+	}
+	if len(output.Items) == 0 {
+		return Review{}, apperror.New(apperror.NotFound, ErrorReviewNotFound)
 	}
-	reviewResolver := &ReviewResolver{review: review}
-
-	return reviewResolver, nil
 
+	var review Review
+	err = dynamodbattribute.UnmarshalMap(output.Items[0], &review)
+	if err != nil {
+		logError(ctx, "Failed to unmarshal review", "getReview", err, nil)
+		return Review{}, err
+	}
+	return review, nil
 }
 
-func (r *Resolver) Reviews(ctx context.Context, args ReviewArgs) ([]*ReviewResolver, error) {
+// Reviews lists the reviews of a spot, oldest first, or of a user through GSI2, newest first.
+// The order of the sort key can be reversed with NEWEST, HELPFUL and RATING read the rank indexes
+// highest first.
+func (r *Resolver) Reviews(ctx context.Context, args ReviewArgs) (*ReviewConnectionResolver, error) {
+
+	logInfo(ctx, "Invoke", "Reviews", map[string]interface{}{"args": args})
+	if args.SpotId == nil && args.UserId == nil {
+		return nil, apperror.New(apperror.Validation, ErrorMissingSpotId)
+	}
+	if args.SpotId != nil && args.UserId != nil {
+		return nil, apperror.New(apperror.Validation, ErrorSpotIdAndUserId)
+	}
 
-	log.Println("Reviews")
+	if err := args.Filter.validate(); err != nil {
+		return nil, err
+	}
+	first, err := pageSize(args.First)
+	if err != nil {
+		return nil, err
+	}
 
-	pk := fmt.Sprintf("%s%s", SpotPrefix, *args.SpotId)
-	keyConditionExpression := "#pk = :pk AND begins_with(#sk, :sk)"
 	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
-		":pk": {S: aws.String(pk)},
-		":sk": {S: aws.String("review")},
+		":sk": {S: aws.String(ReviewPrefix)},
 	}
 	expressionAttributeNames := map[string]*string{
-		"#pk": aws.String("PK"),
-		"#sk": aws.String("SK"),
+		"#sk": aws.String(SKKey),
 	}
-
-	output, err := r.Db.Query(&dynamodb.QueryInput{
+	queryInput := dynamodb.QueryInput{
 		TableName:                 aws.String(r.TableName),
-		KeyConditionExpression:    aws.String(keyConditionExpression),
 		ExpressionAttributeValues: expressionAttributeValues,
 		ExpressionAttributeNames:  expressionAttributeNames,
-	})
+	}
+	if args.SpotId != nil {
+		queryInput.KeyConditionExpression = aws.String("#pk = :pk AND begins_with(#sk, :sk)")
+		expressionAttributeNames["#pk"] = aws.String(PKKey)
+		expressionAttributeValues[":pk"] = &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf("%s%s", SpotPrefix, *args.SpotId))}
+	} else {
+		// review ids are ULIDs, so the sort key orders the reviews by time
+		queryInput.IndexName = aws.String(GSI2Key)
+		queryInput.KeyConditionExpression = aws.String("#gsi2 = :gsi2 AND begins_with(#sk, :sk)")
+		queryInput.ScanIndexForward = aws.Bool(false)
+		expressionAttributeNames["#gsi2"] = aws.String(GSI2Key)
+		expressionAttributeValues[":gsi2"] = &dynamodb.AttributeValue{S: aws.String(fmt.Sprintf("%s%s", UserPrefix, *args.UserId))}
+	}
+	orderBy := aws.StringValue(args.OrderBy)
+	if orderBy == ReviewOrderByNewest {
+		queryInput.ScanIndexForward = aws.Bool(false)
+	}
+	if orderBy == ReviewOrderByHelpful || orderBy == ReviewOrderByRating {
+		// the rank indexes have the same hash key and only hold reviews, ties go newest first
+		queryInput.IndexName = aws.String(reviewRankIndex(args.SpotId != nil, orderBy))
+		queryInput.ScanIndexForward = aws.Bool(false)
+		if args.SpotId != nil {
+			queryInput.KeyConditionExpression = aws.String("#pk = :pk")
+		} else {
+			queryInput.KeyConditionExpression = aws.String("#gsi2 = :gsi2")
+		}
+		delete(expressionAttributeNames, "#sk")
+		delete(expressionAttributeValues, ":sk")
+	}
+	queryInput.FilterExpression = args.Filter.FilterExpression(expressionAttributeNames, expressionAttributeValues)
 
+	after := aws.StringValue(args.After)
+	if after == "" && args.LastReviewId != nil {
+		after, err = r.reviewCursor(ctx, *args.LastReviewId, queryInput.IndexName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	page, err := common.QueryPage(ctx, queryInput, first, after, r.Db)
 	if err != nil {
 		return nil, err
 	}
-	reviewResolvers := make([]*ReviewResolver, len(output.Items))
-	for index := range output.Items {
-		item := output.Items[index]
-		var review Review
-		err := dynamodbattribute.UnmarshalMap(item, &review)
-		if err != nil {
-			continue
-		}
-		reviewResolver := &ReviewResolver{review: review}
-		reviewResolvers[index] = reviewResolver
+
+	return newReviewConnection(r, page, queryInput.IndexName)
+
+}
+
+// reviewRankIndex is the index of the reviews of a spot or of a user in the order
+func reviewRankIndex(spot bool, orderBy string) string {
+	switch {
+	case spot && orderBy == ReviewOrderByHelpful:
+		return common.SpotReviewsByHelpfulIndex
+	case spot:
+		return common.SpotReviewsByRatingIndex
+	case orderBy == ReviewOrderByHelpful:
+		return common.UserReviewsByHelpfulIndex
+	default:
+		return common.UserReviewsByRatingIndex
+	}
+}
+
+// reviewCursor is the cursor that continues a review list after the review, for clients that
+// still page with lastReviewId
+func (r *Resolver) reviewCursor(ctx context.Context, reviewId string, indexName *string) (string, error) {
+
+	review, err := r.getReview(ctx, reviewId, nil)
+	if err != nil {
+		return "", err
+	}
+	item, err := dynamodbattribute.MarshalMap(review)
+	if err != nil {
+		logError(ctx, "Failed to marshal review", "reviewCursor", err, nil)
+		return "", err
 	}
+	return common.EncodeCursor(common.ItemKey(item, indexName))
+}
 
-	return reviewResolvers, nil
+type CreateReviewArgs struct {
+	SpotId       string
+	Rating       int32
+	Message      *string
+	SubRatings   *SubRatingsInput
+	VisitDate    *string
+	VehicleClass *string
+	Overnight    *bool
+	PartySize    *int32
+}
 
+// SubRatingsInput rates aspects of a spot from 1 to 5, every sub-rating is optional
+type SubRatingsInput struct {
+	ToiletCleanliness *int32
+	Quietness         *int32
+	Flatness          *int32
+	Safety            *int32
 }
 
-func (r *Resolver) CreateReview(ctx context.Context, args ReviewArgs) (*ReviewResolver, error) {
+// values maps the sub-ratings by name, it is empty for a nil input
+func (input *SubRatingsInput) values() map[string]*int32 {
+	if input == nil {
+		return map[string]*int32{}
+	}
+	return map[string]*int32{
+		common.SubRatingToiletCleanliness: input.ToiletCleanliness,
+		common.SubRatingQuietness:         input.Quietness,
+		common.SubRatingFlatness:          input.Flatness,
+		common.SubRatingSafety:            input.Safety,
+	}
+}
 
-	log.Println("Reviews")
+// CreateReview stores the review of the request user. The review id is a ULID so reviews sort
+// by time, a marker item keyed by the user makes sure a user reviews a spot only once. The
+// rating aggregates of the spot are updated in the same transaction.
+func (r *Resolver) CreateReview(ctx context.Context, args CreateReviewArgs) (*ReviewResolver, error) {
 
-	creationTime := time.Now().Format(time.RFC3339)
-	pk := fmt.Sprintf("%s%s", SpotPrefix, *args.SpotId)
-	sk := fmt.Sprintf("%s%s", ReviewPrefix, creationTime)
-	gsi1 := fmt.Sprintf("%s%s", ReviewPrefix, *args.ReviewId)
+	logInfo(ctx, "Invoke", "CreateReview", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "CreateReview", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	if err := validateReview(&args.Rating, args.Message, args.SubRatings); err != nil {
+		return nil, err
+	}
+	if err := validateVisit(args.VisitDate, args.PartySize); err != nil {
+		return nil, err
+	}
+	spot, err := r.loadSpot(ctx, args.SpotId, common.Projection{})
+	if err != nil {
+		return nil, err
+	}
+	if spot == nil {
+		return nil, apperror.New(apperror.NotFound, common.ErrorSpotNotFound)
+	}
 
+	now := time.Now()
+	reviewId := common.NewULID(now)
+	pk := fmt.Sprintf("%s%s", SpotPrefix, args.SpotId)
 	review := Review{
 		PK:           pk,
-		SK:           sk,
-		CreationTime: creationTime,
-		GSI1:         aws.String(gsi1),
-		Rating:       args.Rating,
+		SK:           fmt.Sprintf("%s%s", ReviewPrefix, reviewId),
+		GSI1:         aws.String(fmt.Sprintf("%s%s", ReviewPrefix, reviewId)),
+		GSI2:         aws.String(fmt.Sprintf("%s%s", UserPrefix, requestUser.UserId())),
+		CreationTime: now.Format(time.RFC3339),
+		Rating:       aws.Int32(args.Rating),
 		Message:      args.Message,
+		VisitDate:    args.VisitDate,
+		VehicleClass: args.VehicleClass,
+		Overnight:    args.Overnight,
+		PartySize:    args.PartySize,
 	}
-	if args.UserId != nil {
-		gsi2 := fmt.Sprintf("%s%s", UserPrefix, *args.UserId)
-		review.GSI2 = aws.String(gsi2)
+	for subRating, value := range args.SubRatings.values() {
+		review.setSubRating(subRating, value)
+	}
+	review.HelpfulRank = aws.String(common.HelpfulRank(0, 0, reviewId))
+	review.RatingRank = aws.String(common.RatingRank(review.Rating, reviewId))
+	change := ratingChange(review, 1)
+	reviewItem, err := dynamodbattribute.MarshalMap(review)
+	if err != nil {
+		logError(ctx, "Failed to marshal review", "CreateReview", err, nil)
+		return nil, err
+	}
+	markerItem, err := dynamodbattribute.MarshalMap(reviewMarker(args.SpotId, requestUser.UserId(), reviewId))
+	if err != nil {
+		logError(ctx, "Failed to marshal review marker", "CreateReview", err, nil)
+		return nil, err
 	}
 
-	item, err := dynamodbattribute.MarshalMap(review)
+	err = r.writeReview(ctx, *spot, change, []*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(r.TableName),
+				Item:                markerItem,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			},
+		},
+		{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(r.TableName),
+				Item:                reviewItem,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			},
+		},
+	}, "CreateReview")
+	if err != nil {
+		logError(ctx, "Failed to put review", "CreateReview", err, nil)
+		if conditionFailed(err, 0) {
+			return nil, apperror.Wrap(apperror.Conflict, ErrorReviewAlreadyExists, err)
+		}
+		return nil, err
+	}
+	r.forgetSpot(ctx, spot.SpotId())
+
+	r.publish(ctx, reviewAddedEvent(review))
+	return &ReviewResolver{review: review, baseResolver: r}, nil
+}
+
+type UpdateReviewArgs struct {
+	ReviewId     string
+	Rating       *int32
+	Message      *string
+	SubRatings   *SubRatingsInput
+	VisitDate    *string
+	VehicleClass *string
+	Overnight    *bool
+	PartySize    *int32
+}
+
+// UpdateReview changes the ratings or message of a review of the request user, changed ratings
+// move the review between the rating aggregates of the spot. Sub-ratings that are not set keep
+// their value.
+func (r *Resolver) UpdateReview(ctx context.Context, args UpdateReviewArgs) (*ReviewResolver, error) {
+
+	logInfo(ctx, "Invoke", "UpdateReview", map[string]interface{}{"args": args})
+	review, err := r.loadOwnReview(ctx, args.ReviewId, false, "UpdateReview")
+	if err != nil {
+		return nil, err
+	}
+	if err := validateReview(args.Rating, args.Message, args.SubRatings); err != nil {
+		return nil, err
+	}
+	if err := validateVisit(args.VisitDate, args.PartySize); err != nil {
+		return nil, err
+	}
+
+	updated := review
+	updated.UpdateTime = aws.String(time.Now().Format(time.RFC3339))
+	set := []string{"#updateTime = :updateTime"}
+	expressionAttributeNames := map[string]*string{
+		"#pk":         aws.String(PKKey),
+		"#gsi2":       aws.String(GSI2Key),
+		"#updateTime": aws.String(UpdateTimeKey),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":updateTime": {S: updated.UpdateTime},
+		":gsi2":       {S: review.GSI2},
+	}
+	if args.Rating != nil {
+		updated.Rating = args.Rating
+	}
+	for subRating, value := range args.SubRatings.values() {
+		if value != nil {
+			updated.setSubRating(subRating, value)
+		}
+	}
+	if args.Message != nil {
+		updated.Message = args.Message
+		set = append(set, "#message = :message")
+		expressionAttributeNames["#message"] = aws.String(MessageKey)
+		expressionAttributeValues[":message"] = &dynamodb.AttributeValue{S: args.Message}
+	}
+	visit := map[string]*dynamodb.AttributeValue{}
+	if args.VisitDate != nil {
+		updated.VisitDate = args.VisitDate
+		visit[VisitDateKey] = &dynamodb.AttributeValue{S: args.VisitDate}
+	}
+	if args.VehicleClass != nil {
+		updated.VehicleClass = args.VehicleClass
+		visit[VehicleClassKey] = &dynamodb.AttributeValue{S: args.VehicleClass}
+	}
+	if args.Overnight != nil {
+		updated.Overnight = args.Overnight
+		visit[OvernightKey] = &dynamodb.AttributeValue{BOOL: args.Overnight}
+	}
+	if args.PartySize != nil {
+		updated.PartySize = args.PartySize
+		visit[PartySizeKey] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", *args.PartySize))}
+	}
+	for key, value := range visit {
+		set = append(set, fmt.Sprintf("#%s = :%s", key, key))
+		expressionAttributeNames["#"+key] = aws.String(key)
+		expressionAttributeValues[":"+key] = value
+	}
+	condition := "attribute_exists(#pk) AND #gsi2 = :gsi2"
+	change := ratingChange(updated, 1).Plus(ratingChange(review, -1))
+	if !change.IsZero() {
+		updated.RatingRank = aws.String(common.RatingRank(updated.Rating, strings.TrimPrefix(review.SK, ReviewPrefix)))
+		set = append(set, ratingAssignments(updated, expressionAttributeNames, expressionAttributeValues)...)
+		set = append(set, "#ratingRank = :ratingRank")
+		expressionAttributeNames["#ratingRank"] = aws.String(common.RatingRankKey)
+		expressionAttributeValues[":ratingRank"] = &dynamodb.AttributeValue{S: updated.RatingRank}
+		condition = fmt.Sprintf("%s AND %s", condition, unchangedRatings(review, expressionAttributeNames, expressionAttributeValues))
+	}
+
+	transactItems := []*dynamodb.TransactWriteItem{
+		{
+			Update: &dynamodb.Update{
+				TableName: aws.String(r.TableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					PKKey: {S: aws.String(review.PK)},
+					SKKey: {S: aws.String(review.SK)},
+				},
+				UpdateExpression:          aws.String(fmt.Sprintf("SET %s", strings.Join(set, ", "))),
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  expressionAttributeNames,
+				ExpressionAttributeValues: expressionAttributeValues,
+			},
+		},
+	}
+	var spot common.Spot
+	if change.IsZero() {
+		_, err = r.Db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: transactItems})
+	} else {
+		spot, err = r.loadReviewSpot(ctx, review)
+		if err != nil {
+			return nil, err
+		}
+		err = r.writeReview(ctx, spot, change, transactItems, "UpdateReview")
+	}
+	if err != nil {
+		logError(ctx, "Failed to update review", "UpdateReview", err, nil)
+		if conditionFailed(err, 0) {
+			// the review was deleted or its ratings changed since it was read
+			return nil, apperror.Wrap(apperror.Conflict, apperror.ErrorConflict, err)
+		}
+		return nil, err
+	}
+	r.forgetSpot(ctx, spot.SpotId())
+	return &ReviewResolver{review: updated, baseResolver: r}, nil
+}
+
+type DeleteReviewArgs struct {
+	ReviewId string
+}
+
+// DeleteReview deletes a review of the request user, admins can delete any review. The review
+// is removed from the rating aggregates of the spot in the same transaction.
+func (r *Resolver) DeleteReview(ctx context.Context, args DeleteReviewArgs) (bool, error) {
+
+	logInfo(ctx, "Invoke", "DeleteReview", map[string]interface{}{"args": args})
+	review, err := r.loadOwnReview(ctx, args.ReviewId, true, "DeleteReview")
+	if err != nil {
+		return false, err
+	}
+	spot, err := r.loadReviewSpot(ctx, review)
+	if err != nil {
+		return false, err
+	}
 
-	output, err := r.Db.PutItem(&dynamodb.PutItemInput{
+	// the votes, replies and images go first. If one of them fails the review stays marked and
+	// deleting it again finishes the cleanup
+	err = r.markReviewDeleting(ctx, review)
+	if err != nil {
+		return false, err
+	}
+	reviewer := ReviewResolver{review: review}
+	err = common.DeleteSpotItems(ctx, spot.SpotId(), fmt.Sprintf("%s%s#", ReviewVotePrefix, reviewer.ReviewId(ctx)), r.Db, r.TableName)
+	if err != nil {
+		logError(ctx, "Failed to delete review votes", "DeleteReview", err, nil)
+		return false, err
+	}
+	err = common.DeleteSpotItems(ctx, spot.SpotId(), reviewReplyPrefix(reviewer.ReviewId(ctx)), r.Db, r.TableName)
+	if err != nil {
+		logError(ctx, "Failed to delete review replies", "DeleteReview", err, nil)
+		return false, err
+	}
+	err = r.deleteReviewImages(ctx, review)
+	if err != nil {
+		logError(ctx, "Failed to delete review images", "DeleteReview", err, nil)
+		return false, err
+	}
+
+	expressionAttributeNames := map[string]*string{"#pk": aws.String(PKKey)}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{}
+	condition := fmt.Sprintf("attribute_exists(#pk) AND %s", unchangedRatings(review, expressionAttributeNames, expressionAttributeValues))
+	change := ratingChange(review, -1)
+	marker := reviewMarker(reviewer.SpotId(ctx), aws.StringValue(reviewer.UserId(ctx)), reviewer.ReviewId(ctx))
+	err = r.writeReview(ctx, spot, change, []*dynamodb.TransactWriteItem{
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(r.TableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					PKKey: {S: aws.String(review.PK)},
+					SKKey: {S: aws.String(review.SK)},
+				},
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  expressionAttributeNames,
+				ExpressionAttributeValues: nilIfEmpty(expressionAttributeValues),
+			},
+		},
+		{
+			Delete: &dynamodb.Delete{
+				TableName: aws.String(r.TableName),
+				Key: map[string]*dynamodb.AttributeValue{
+					PKKey: {S: aws.String(marker.PK)},
+					SKKey: {S: aws.String(marker.SK)},
+				},
+			},
+		},
+	}, "DeleteReview")
+	if err != nil {
+		logError(ctx, "Failed to delete review", "DeleteReview", err, nil)
+		if conditionFailed(err, 0) {
+			// the review was deleted or its ratings changed since it was read
+			return false, apperror.Wrap(apperror.Conflict, apperror.ErrorConflict, err)
+		}
+		return false, err
+	}
+	r.forgetSpot(ctx, spot.SpotId())
+	return true, nil
+}
+
+// markReviewDeleting sets the deleting flag of the review, votes, replies and images check it
+// so none are added while the items of the review are deleted
+func (r *Resolver) markReviewDeleting(ctx context.Context, review Review) error {
+
+	_, err := r.Db.UpdateItem(&dynamodb.UpdateItemInput{
 		TableName: aws.String(r.TableName),
-		Item:      item,
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(review.PK)},
+			SKKey: {S: aws.String(review.SK)},
+		},
+		UpdateExpression:    aws.String("SET #deleting = :deleting"),
+		ConditionExpression: aws.String("attribute_exists(#pk)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk":       aws.String(PKKey),
+			"#deleting": aws.String(DeletingKey),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":deleting": {BOOL: aws.Bool(true)},
+		},
 	})
 	if err != nil {
-		log.Println("Error putting item", err)
-		return nil, err
+		logError(ctx, "Failed to mark review as deleting", "markReviewDeleting", err, nil)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return apperror.Wrap(apperror.NotFound, ErrorReviewNotFound, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// loadOwnReview returns the review if the request user wrote it, or is an admin and admins are allowed
+func (r *Resolver) loadOwnReview(ctx context.Context, reviewId string, allowAdmin bool, function string) (Review, error) {
+
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", function, nil, nil)
+		return Review{}, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	review, err := r.getReview(ctx, reviewId, nil)
+	if err != nil {
+		return Review{}, err
+	}
+	author := fmt.Sprintf("%s%s", UserPrefix, requestUser.UserId())
+	if aws.StringValue(review.GSI2) != author && !(allowAdmin && requestUser.IsAdminUser()) {
+		logInfo(ctx, "User is not the author", function, map[string]interface{}{"reviewId": reviewId})
+		return Review{}, apperror.New(apperror.Forbidden, ErrorUserIsNotReviewAuthor)
+	}
+	return review, nil
+}
+
+// loadReviewSpot reads the keys of the spot of the review
+func (r *Resolver) loadReviewSpot(ctx context.Context, review Review) (common.Spot, error) {
+	spot, err := r.loadSpot(ctx, strings.TrimPrefix(review.PK, SpotPrefix), common.Projection{})
+	if err != nil {
+		return common.Spot{}, err
+	}
+	if spot == nil {
+		return common.Spot{}, apperror.New(apperror.NotFound, common.ErrorSpotNotFound)
+	}
+	return *spot, nil
+}
+
+// ratingChange is the change of the rating aggregates when count reviews like the review are added
+func ratingChange(review Review, count int64) common.RatingChange {
+	change := common.RatingChange{
+		ReviewCount: count,
+		Ratings:     map[int32]int64{},
+		SubRatings:  map[string]common.SubRatingChange{},
+	}
+	if review.Rating != nil {
+		change.Ratings[*review.Rating] += count
+	}
+	for subRating, value := range review.subRatings() {
+		if value != nil {
+			change.SubRatings[subRating] = common.SubRatingChange{Sum: int64(*value) * count, Count: count}
+		}
+	}
+	return change
+}
+
+// writeReview writes the items of a review write in one transaction with the update of the
+// rating aggregates of the spot. Sub-ratings are set from the sums and counts read just before,
+// when another review of the spot changed them in between the transaction is tried again.
+func (r *Resolver) writeReview(ctx context.Context, spot common.Spot, change common.RatingChange, transactItems []*dynamodb.TransactWriteItem, function string) error {
+
+	for attempt := 1; ; attempt++ {
+		if change.HasSubRatings() {
+			current, err := common.GetSubRatingAggregates(ctx, spot, r.Db, r.TableName)
+			if err != nil {
+				return err
+			}
+			if current == nil {
+				return apperror.New(apperror.NotFound, common.ErrorSpotNotFound)
+			}
+			spot = *current
+		}
+		items := append(append([]*dynamodb.TransactWriteItem{}, transactItems...), &dynamodb.TransactWriteItem{
+			Update: common.RatingAggregateUpdate(spot, change, r.TableName),
+		})
+		_, err := r.Db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{TransactItems: items})
+		if !conditionFailed(err, len(transactItems)) {
+			return err
+		}
+		for index := range transactItems {
+			if conditionFailed(err, index) {
+				return err
+			}
+		}
+		if !change.HasSubRatings() {
+			return apperror.Wrap(apperror.NotFound, common.ErrorSpotNotFound, err)
+		}
+		if attempt == maxReviewWriteAttempts {
+			return apperror.Wrap(apperror.Conflict, apperror.ErrorConflict, err)
+		}
+		logInfo(ctx, "Sub-rating aggregates changed", function, map[string]interface{}{"spotId": spot.SpotId(), "attempt": attempt})
+	}
+}
+
+// ratingAssignments sets the rating and sub-ratings of the review
+func ratingAssignments(review Review, expressionAttributeNames map[string]*string, expressionAttributeValues map[string]*dynamodb.AttributeValue) []string {
+	assignments := []string{}
+	for _, key := range append([]string{RatingKey}, common.SubRatings...) {
+		value := review.ratings()[key]
+		if value == nil {
+			continue
+		}
+		expressionAttributeNames["#"+key] = aws.String(key)
+		expressionAttributeValues[":"+key] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", *value))}
+		assignments = append(assignments, fmt.Sprintf("#%s = :%s", key, key))
+	}
+	return assignments
+}
+
+// unchangedRatings is the condition that the review still has the ratings the aggregates counted
+func unchangedRatings(review Review, expressionAttributeNames map[string]*string, expressionAttributeValues map[string]*dynamodb.AttributeValue) string {
+	conditions := []string{}
+	for _, key := range append([]string{RatingKey}, common.SubRatings...) {
+		value := review.ratings()[key]
+		expressionAttributeNames["#"+key] = aws.String(key)
+		if value == nil {
+			conditions = append(conditions, fmt.Sprintf("attribute_not_exists(#%s)", key))
+			continue
+		}
+		expressionAttributeValues[":previous"+key] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", *value))}
+		conditions = append(conditions, fmt.Sprintf("#%s = :previous%s", key, key))
 	}
-	log.Println("Output", output)
-	reviewResolver := ReviewResolver{review: review}
-	return &reviewResolver, nil
+	return strings.Join(conditions, " AND ")
+}
 
+// nilIfEmpty drops empty value maps, dynamodb rejects them
+func nilIfEmpty(values map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+func validateReview(rating *int32, message *string, subRatings *SubRatingsInput) error {
+	if rating != nil && (*rating < ReviewMinRating || *rating > ReviewMaxRating) {
+		return apperror.Invalid("rating", ErrorInvalidRating)
+	}
+	for _, subRating := range common.SubRatings {
+		value := subRatings.values()[subRating]
+		if value != nil && (*value < ReviewMinRating || *value > ReviewMaxRating) {
+			return apperror.Invalid("subRatings."+strings.ToLower(subRating[:1])+subRating[1:], ErrorInvalidRating)
+		}
+	}
+	if message != nil && utf8.RuneCountInString(*message) > ReviewMessageMaxLength {
+		return apperror.Invalid("message", ErrorValueTooLong)
+	}
+	return nil
+}
+
+// validateVisit checks the visit date, which cannot be after tomorrow so every time zone can
+// review the day it is in, and the party size
+func validateVisit(visitDate *string, partySize *int32) error {
+	if visitDate != nil {
+		date, err := time.Parse(VisitDateLayout, *visitDate)
+		if err != nil || date.After(time.Now().AddDate(0, 0, 1)) {
+			return apperror.Invalid("visitDate", ErrorInvalidDate)
+		}
+	}
+	if partySize != nil && (*partySize < 1 || *partySize > ReviewMaxPartySize) {
+		return apperror.Invalid("partySize", ErrorInvalidPartySize)
+	}
+	return nil
+}
+
+// ReviewMarker is stored next to the reviews of a spot, one for every user that reviewed it
+type ReviewMarker struct {
+	PK       string `dynamodbav:"PK"` // Spot#<spot_id>
+	SK       string `dynamodbav:"SK"` // ReviewUser#<user_id>
+	ReviewId string `dynamodbav:"ReviewId"`
+}
+
+func reviewMarker(spotId, userId, reviewId string) ReviewMarker {
+	return ReviewMarker{
+		PK:       fmt.Sprintf("%s%s", SpotPrefix, spotId),
+		SK:       fmt.Sprintf("%s%s", ReviewUserPrefix, userId),
+		ReviewId: reviewId,
+	}
 }
 
 type Review struct {
@@ -134,8 +719,58 @@ type Review struct {
 	GSI1         *string `dynamodbav:"GSI1"`
 	GSI2         *string `dynamodbav:"GSI2"`
 	CreationTime string  `dynamodbav:"CreationTime"`
+	UpdateTime   *string `dynamodbav:"UpdateTime,omitempty"`
 	Rating       *int32  `dynamodbav:"Rating"`
 	Message      *string `dynamodbav:"Message"`
+	// sub-ratings, the attribute names are the common.SubRatings
+	ToiletCleanliness *int32 `dynamodbav:"ToiletCleanliness,omitempty"`
+	Quietness         *int32 `dynamodbav:"Quietness,omitempty"`
+	Flatness          *int32 `dynamodbav:"Flatness,omitempty"`
+	Safety            *int32 `dynamodbav:"Safety,omitempty"`
+	// votes, counted in the same transaction as the vote items
+	HelpfulCount   *int64 `dynamodbav:"HelpfulCount,omitempty"`
+	UnhelpfulCount *int64 `dynamodbav:"UnhelpfulCount,omitempty"`
+	// sort keys of the rank indexes, written with the rating and the votes
+	HelpfulRank *string `dynamodbav:"HelpfulRank,omitempty"`
+	RatingRank  *string `dynamodbav:"RatingRank,omitempty"`
+	// visit of the reviewer, the visit date is YYYY-MM-DD
+	VisitDate    *string `dynamodbav:"VisitDate,omitempty"`
+	VehicleClass *string `dynamodbav:"VehicleClass,omitempty"`
+	Overnight    *bool   `dynamodbav:"Overnight,omitempty"`
+	PartySize    *int32  `dynamodbav:"PartySize,omitempty"`
+	// photos, the images are stored with the images of the spot
+	ImageCount *int64 `dynamodbav:"ImageCount,omitempty"`
+	// set while the votes, replies and images of the review are deleted, it takes no new ones
+	Deleting *bool `dynamodbav:"Deleting,omitempty"`
+}
+
+func (review Review) subRatings() map[string]*int32 {
+	return map[string]*int32{
+		common.SubRatingToiletCleanliness: review.ToiletCleanliness,
+		common.SubRatingQuietness:         review.Quietness,
+		common.SubRatingFlatness:          review.Flatness,
+		common.SubRatingSafety:            review.Safety,
+	}
+}
+
+// ratings maps the rating and the sub-ratings by attribute name
+func (review Review) ratings() map[string]*int32 {
+	ratings := review.subRatings()
+	ratings[RatingKey] = review.Rating
+	return ratings
+}
+
+func (review *Review) setSubRating(subRating string, value *int32) {
+	switch subRating {
+	case common.SubRatingToiletCleanliness:
+		review.ToiletCleanliness = value
+	case common.SubRatingQuietness:
+		review.Quietness = value
+	case common.SubRatingFlatness:
+		review.Flatness = value
+	case common.SubRatingSafety:
+		review.Safety = value
+	}
 }
 
 type ReviewResolver struct {
@@ -155,6 +790,10 @@ func (u ReviewResolver) CreationTime(ctx context.Context) string {
 	return u.review.CreationTime
 }
 
+func (u ReviewResolver) UpdateTime(ctx context.Context) *string {
+	return u.review.UpdateTime
+}
+
 func (u ReviewResolver) UserId(ctx context.Context) *string {
 	if u.review.GSI2 != nil {
 		return aws.String(strings.TrimPrefix(*u.review.GSI2, UserPrefix))
@@ -163,11 +802,15 @@ func (u ReviewResolver) UserId(ctx context.Context) *string {
 }
 
 func (u ReviewResolver) User(ctx context.Context) (*UserResolver, error) {
-	if userId := u.UserId(ctx); userId != nil {
-		userArgs := UserArgs{UserId: *userId}
-		return u.baseResolver.User(ctx, userArgs)
+	userId := u.UserId(ctx)
+	if userId == nil {
+		return nil, nil
+	}
+	user, err := u.baseResolver.loadUser(ctx, *userId, projection(ctx, userAttributes))
+	if err != nil || user == nil {
+		return nil, err
 	}
-	return nil, nil
+	return &UserResolver{user: *user, baseResolver: u.baseResolver}, nil
 }
 
 func (u ReviewResolver) Rating(ctx context.Context) *int32 {
@@ -177,3 +820,68 @@ func (u ReviewResolver) Rating(ctx context.Context) *int32 {
 func (u ReviewResolver) Message(ctx context.Context) *string {
 	return u.review.Message
 }
+
+func (u ReviewResolver) VisitDate(ctx context.Context) *string {
+	return u.review.VisitDate
+}
+
+func (u ReviewResolver) VehicleClass(ctx context.Context) *string {
+	return u.review.VehicleClass
+}
+
+func (u ReviewResolver) Overnight(ctx context.Context) *bool {
+	return u.review.Overnight
+}
+
+func (u ReviewResolver) PartySize(ctx context.Context) *int32 {
+	return u.review.PartySize
+}
+
+// Images are the photos of the review, newest first like the images of the spot
+func (u ReviewResolver) Images(ctx context.Context) (*[]*SpotImageResolver, error) {
+
+	resolvers := []*SpotImageResolver{}
+	if aws.Int64Value(u.review.ImageCount) == 0 {
+		return &resolvers, nil
+	}
+	spotImages, err := u.baseResolver.spotImages(ctx, u.SpotId(ctx), aws.String(u.ReviewId(ctx)))
+	if err != nil {
+		return nil, err
+	}
+	for index := range spotImages {
+		resolvers = append(resolvers, &SpotImageResolver{spotImage: spotImages[index]})
+	}
+	return &resolvers, nil
+}
+
+func (u ReviewResolver) HelpfulCount(ctx context.Context) int32 {
+	return int32(aws.Int64Value(u.review.HelpfulCount))
+}
+
+func (u ReviewResolver) UnhelpfulCount(ctx context.Context) int32 {
+	return int32(aws.Int64Value(u.review.UnhelpfulCount))
+}
+
+func (u ReviewResolver) SubRatings(ctx context.Context) *SubRatingsResolver {
+	return &SubRatingsResolver{review: u.review}
+}
+
+type SubRatingsResolver struct {
+	review Review
+}
+
+func (z SubRatingsResolver) ToiletCleanliness(ctx context.Context) *int32 {
+	return z.review.ToiletCleanliness
+}
+
+func (z SubRatingsResolver) Quietness(ctx context.Context) *int32 {
+	return z.review.Quietness
+}
+
+func (z SubRatingsResolver) Flatness(ctx context.Context) *int32 {
+	return z.review.Flatness
+}
+
+func (z SubRatingsResolver) Safety(ctx context.Context) *int32 {
+	return z.review.Safety
+}
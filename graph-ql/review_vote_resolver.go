@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+type VoteReviewArgs struct {
+	ReviewId string
+	Helpful  bool
+}
+
+// VoteReview marks a review as helpful or unhelpful for the request user, voting again changes
+// the vote. Authors cannot vote on their own reviews.
+func (r *Resolver) VoteReview(ctx context.Context, args VoteReviewArgs) (*ReviewResolver, error) {
+
+	logInfo(ctx, "Invoke", "VoteReview", map[string]interface{}{"args": args})
+	return r.changeReviewVote(ctx, args.ReviewId, aws.Bool(args.Helpful), "VoteReview")
+}
+
+type DeleteReviewVoteArgs struct {
+	ReviewId string
+}
+
+// DeleteReviewVote takes back the vote of the request user, it is a no-op without a vote
+func (r *Resolver) DeleteReviewVote(ctx context.Context, args DeleteReviewVoteArgs) (*ReviewResolver, error) {
+
+	logInfo(ctx, "Invoke", "DeleteReviewVote", map[string]interface{}{"args": args})
+	return r.changeReviewVote(ctx, args.ReviewId, nil, "DeleteReviewVote")
+}
+
+// changeReviewVote replaces the vote of the request user with helpful, nil removes it. The vote
+// item, the counters of the review and its helpful rank are written in one transaction, which is
+// tried again when other votes changed the counters in between.
+func (r *Resolver) changeReviewVote(ctx context.Context, reviewId string, helpful *bool, function string) (*ReviewResolver, error) {
+
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", function, nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	review, err := r.getReview(ctx, reviewId, projection(ctx, reviewAttributes).With(HelpfulCountKey, UnhelpfulCountKey))
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(review.GSI2) == fmt.Sprintf("%s%s", UserPrefix, requestUser.UserId()) {
+		logInfo(ctx, "User is the author", function, map[string]interface{}{"reviewId": reviewId})
+		return nil, apperror.New(apperror.Forbidden, ErrorUserIsReviewAuthor)
+	}
+	previous, err := r.getReviewVote(ctx, review, requestUser.UserId())
+	if err != nil {
+		return nil, err
+	}
+
+	helpfulChange, unhelpfulChange := voteCounts(helpful)
+	previousHelpful, previousUnhelpful := voteCounts(previous)
+	helpfulChange, unhelpfulChange = helpfulChange-previousHelpful, unhelpfulChange-previousUnhelpful
+	if helpfulChange == 0 && unhelpfulChange == 0 {
+		return &ReviewResolver{review: review, baseResolver: r}, nil
+	}
+
+	vote := reviewVote(review, requestUser.UserId(), helpful)
+	voteKey := map[string]*dynamodb.AttributeValue{
+		PKKey: {S: aws.String(vote.PK)},
+		SKKey: {S: aws.String(vote.SK)},
+	}
+	// the vote is still the one that was read
+	voteCondition := aws.String("attribute_not_exists(#pk)")
+	voteNames := map[string]*string{"#pk": aws.String(PKKey)}
+	var voteValues map[string]*dynamodb.AttributeValue
+	if previous != nil {
+		voteCondition = aws.String("#helpful = :previous")
+		voteNames = map[string]*string{"#helpful": aws.String(HelpfulKey)}
+		voteValues = map[string]*dynamodb.AttributeValue{":previous": {BOOL: previous}}
+	}
+	voteItem := &dynamodb.TransactWriteItem{
+		Delete: &dynamodb.Delete{
+			TableName:                 aws.String(r.TableName),
+			Key:                       voteKey,
+			ConditionExpression:       voteCondition,
+			ExpressionAttributeNames:  voteNames,
+			ExpressionAttributeValues: voteValues,
+		},
+	}
+	if helpful != nil {
+		item, err := dynamodbattribute.MarshalMap(vote)
+		if err != nil {
+			logError(ctx, "Failed to marshal review vote", function, err, nil)
+			return nil, err
+		}
+		voteItem = &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:                 aws.String(r.TableName),
+				Item:                      item,
+				ConditionExpression:       voteCondition,
+				ExpressionAttributeNames:  voteNames,
+				ExpressionAttributeValues: voteValues,
+			},
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		current, err := r.getReviewVoteCounts(ctx, review)
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.Db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				voteItem,
+				{Update: r.reviewVoteUpdate(current, helpfulChange, unhelpfulChange)},
+			},
+		})
+		if err == nil {
+			review.HelpfulCount = aws.Int64(aws.Int64Value(current.HelpfulCount) + helpfulChange)
+			review.UnhelpfulCount = aws.Int64(aws.Int64Value(current.UnhelpfulCount) + unhelpfulChange)
+			return &ReviewResolver{review: review, baseResolver: r}, nil
+		}
+		logError(ctx, "Failed to write review vote", function, err, nil)
+		if conditionFailed(err, 0) {
+			// the user voted again since the vote was read
+			return nil, apperror.Wrap(apperror.Conflict, apperror.ErrorConflict, err)
+		}
+		if !conditionFailed(err, 1) {
+			return nil, err
+		}
+		// other votes changed the counts since they were read, or the review is gone
+		if attempt == maxReviewWriteAttempts {
+			return nil, apperror.Wrap(apperror.Conflict, apperror.ErrorConflict, err)
+		}
+	}
+}
+
+// getReviewVoteCounts reads the keys and the vote counts of the review with a consistent read, the
+// review lookup goes through GSI1 which can lag behind
+func (r *Resolver) getReviewVoteCounts(ctx context.Context, review Review) (Review, error) {
+
+	expressionAttributeNames := map[string]*string{}
+	output, err := r.Db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(r.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(review.PK)},
+			SKKey: {S: aws.String(review.SK)},
+		},
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     common.Projection{HelpfulCountKey, UnhelpfulCountKey, DeletingKey}.ProjectionExpression(expressionAttributeNames),
+		ExpressionAttributeNames: expressionAttributeNames,
+	})
+	if err != nil {
+		logError(ctx, "Failed to get review vote counts", "getReviewVoteCounts", err, nil)
+		return Review{}, err
+	}
+	if len(output.Item) == 0 {
+		return Review{}, apperror.New(apperror.NotFound, ErrorReviewNotFound)
+	}
+	var current Review
+	err = dynamodbattribute.UnmarshalMap(output.Item, &current)
+	if err != nil {
+		logError(ctx, "Failed to unmarshal review", "getReviewVoteCounts", err, nil)
+		return Review{}, err
+	}
+	if current.Deleting != nil {
+		return Review{}, apperror.New(apperror.NotFound, ErrorReviewNotFound)
+	}
+	return current, nil
+}
+
+// reviewVoteUpdate sets the vote counts of the review and its helpful rank from the counts that
+// were read, it fails if they changed in between
+func (r *Resolver) reviewVoteUpdate(current Review, helpfulChange, unhelpfulChange int64) *dynamodb.Update {
+
+	helpful := aws.Int64Value(current.HelpfulCount) + helpfulChange
+	unhelpful := aws.Int64Value(current.UnhelpfulCount) + unhelpfulChange
+	conditions := []string{"attribute_exists(#pk) AND attribute_not_exists(#deleting)"}
+	expressionAttributeNames := map[string]*string{
+		"#pk":             aws.String(PKKey),
+		"#deleting":       aws.String(DeletingKey),
+		"#helpfulCount":   aws.String(HelpfulCountKey),
+		"#unhelpfulCount": aws.String(UnhelpfulCountKey),
+		"#helpfulRank":    aws.String(common.HelpfulRankKey),
+	}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":helpful":     {N: aws.String(fmt.Sprintf("%d", helpful))},
+		":unhelpful":   {N: aws.String(fmt.Sprintf("%d", unhelpful))},
+		":helpfulRank": {S: aws.String(common.HelpfulRank(helpful, unhelpful, strings.TrimPrefix(current.SK, ReviewPrefix)))},
+	}
+	for name, count := range map[string]*int64{"helpfulCount": current.HelpfulCount, "unhelpfulCount": current.UnhelpfulCount} {
+		if count == nil {
+			conditions = append(conditions, fmt.Sprintf("attribute_not_exists(#%s)", name))
+			continue
+		}
+		conditions = append(conditions, fmt.Sprintf("#%s = :%s", name, name))
+		expressionAttributeValues[":"+name] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", *count))}
+	}
+	sort.Strings(conditions[1:])
+
+	return &dynamodb.Update{
+		TableName: aws.String(r.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(current.PK)},
+			SKKey: {S: aws.String(current.SK)},
+		},
+		UpdateExpression:          aws.String("SET #helpfulCount = :helpful, #unhelpfulCount = :unhelpful, #helpfulRank = :helpfulRank"),
+		ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+	}
+}
+
+// getReviewVote returns the vote of the user on the review, nil if the user did not vote
+func (r *Resolver) getReviewVote(ctx context.Context, review Review, userId string) (*bool, error) {
+
+	vote := reviewVote(review, userId, nil)
+	output, err := r.Db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(r.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(vote.PK)},
+			SKKey: {S: aws.String(vote.SK)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		logError(ctx, "Failed to get review vote", "getReviewVote", err, nil)
+		return nil, err
+	}
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &vote)
+	if err != nil {
+		logError(ctx, "Failed to unmarshal review vote", "getReviewVote", err, nil)
+		return nil, err
+	}
+	return vote.Helpful, nil
+}
+
+// voteCounts is what the vote adds to the helpful and unhelpful counts of the review
+func voteCounts(helpful *bool) (int64, int64) {
+	if helpful == nil {
+		return 0, 0
+	}
+	if *helpful {
+		return 1, 0
+	}
+	return 0, 1
+}
+
+// ReviewVote is the vote of a user on a review, it lives in the partition of the spot so deleting
+// the spot deletes it
+type ReviewVote struct {
+	PK           string `dynamodbav:"PK"`
+	SK           string `dynamodbav:"SK"`
+	CreationTime string `dynamodbav:"CreationTime"`
+	Helpful      *bool  `dynamodbav:"Helpful"`
+}
+
+func reviewVote(review Review, userId string, helpful *bool) ReviewVote {
+	return ReviewVote{
+		PK:           review.PK,
+		SK:           fmt.Sprintf("%s%s#%s", ReviewVotePrefix, strings.TrimPrefix(review.SK, ReviewPrefix), userId),
+		CreationTime: time.Now().Format(time.RFC3339),
+		Helpful:      helpful,
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 type SpotDistanceResolver struct {
 	spotDistance common.SpotDistance
+	baseResolver *Resolver
 }
 
 func (u SpotDistanceResolver) SpotId(ctx context.Context) string {
@@ -45,3 +46,7 @@ func (u SpotDistanceResolver) DestinationImageUrl(ctx context.Context) *string {
 func (u SpotDistanceResolver) DestinationDescription(ctx context.Context) *string {
 	return u.spotDistance.DestinationDescription
 }
+
+func (u SpotDistanceResolver) DestinationSpot(ctx context.Context) (*SpotResolver, error) {
+	return u.baseResolver.Spot(ctx, SpotArgs{SpotId: u.spotDistance.DestinationSpotId()})
+}
@@ -1,39 +1,357 @@
 package main
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+	uuid "github.com/satori/go.uuid"
+)
 
 type SpotImageArgs struct {
-	SpotId string
+	SpotId      string
+	SpotImageId string
+	ContentType string
+}
+
+type SpotImageUpload struct {
+	SpotImageId    string
+	UploadUrl      string
+	ContentType    string
+	ExpirationTime string
+}
+
+// RequestSpotImageUpload returns a presigned url the client can PUT the image to.
+// The image is stored once the upload is confirmed with ConfirmSpotImage.
+func (r *Resolver) RequestSpotImageUpload(ctx context.Context, args SpotImageArgs) (*SpotImageUploadResolver, error) {
+
+	logInfo(ctx, "Invoke", "RequestSpotImageUpload", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "RequestSpotImageUpload", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	if !common.IsImageContentType(args.ContentType) {
+		return nil, apperror.New(apperror.Validation, ErrorInvalidContentType)
+	}
+
+	spot, err := r.loadSpot(ctx, args.SpotId, common.Projection{})
+	if err != nil {
+		return nil, err
+	}
+	if spot == nil {
+		return nil, apperror.New(apperror.NotFound, common.ErrorSpotNotFound)
+	}
+	return r.presignImageUpload(ctx, args.SpotId, requestUser.UserId(), args.ContentType, "RequestSpotImageUpload")
+}
+
+// presignImageUpload checks the upload quota of the user and presigns the upload of a new image of the spot.
+// A presigned put cannot limit the size of the upload, so ConfirmSpotImage checks it before the image is stored
+// and processed, and the bucket expires uploads that are never confirmed.
+func (r *Resolver) presignImageUpload(ctx context.Context, spotId, userId, contentType, function string) (*SpotImageUploadResolver, error) {
+
+	err := r.checkSpotImageQuota(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	spotImageId := uuid.NewV4().String()
+	req, _ := r.S3Client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(r.BucketName),
+		Key:         aws.String(common.SpotImageObjectKey(spotId, userId, spotImageId)),
+		ContentType: aws.String(contentType),
+	})
+	expiry := common.SpotImageUploadExpiryMinutes * time.Minute
+	uploadUrl, err := req.Presign(expiry)
+	if err != nil {
+		logError(ctx, "Failed to presign upload", function, err, nil)
+		return nil, err
+	}
+
+	upload := SpotImageUpload{
+		SpotImageId:    spotImageId,
+		UploadUrl:      uploadUrl,
+		ContentType:    contentType,
+		ExpirationTime: time.Now().Add(expiry).Format(time.RFC3339),
+	}
+	return &SpotImageUploadResolver{upload: upload}, nil
+}
+
+// ConfirmSpotImage validates the uploaded object and stores the spot image.
+// Objects with an invalid content type or size are deleted again.
+func (r *Resolver) ConfirmSpotImage(ctx context.Context, args SpotImageArgs) (*SpotImageResolver, error) {
+
+	logInfo(ctx, "Invoke", "ConfirmSpotImage", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "ConfirmSpotImage", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+
+	spotImage, err := r.uploadedImage(ctx, args.SpotId, args.SpotImageId, requestUser.UserId(), "ConfirmSpotImage")
+	if err != nil {
+		return nil, err
+	}
+	item, err := dynamodbattribute.MarshalMap(spotImage)
+	if err != nil {
+		logError(ctx, "Failed to marshal spot image", "ConfirmSpotImage", err, nil)
+		return nil, err
+	}
+	_, err = r.Db.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(r.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, apperror.New(apperror.Conflict, ErrorSpotImageAlreadyConfirmed)
+		}
+		logError(ctx, "Failed to put spot image", "ConfirmSpotImage", err, nil)
+		return nil, err
+	}
+
+	return &SpotImageResolver{spotImage: spotImage}, nil
+}
+
+// uploadedImage validates the uploaded object and returns the spot image for it.
+// Objects with an invalid content type or size are deleted again.
+func (r *Resolver) uploadedImage(ctx context.Context, spotId, spotImageId, userId, function string) (common.SpotImage, error) {
+
+	objectKey := common.SpotImageObjectKey(spotId, userId, spotImageId)
+	head, err := r.S3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(r.BucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		logError(ctx, "Failed to find uploaded image", function, err, map[string]interface{}{"objectKey": objectKey})
+		return common.SpotImage{}, apperror.New(apperror.Validation, ErrorSpotImageNotUploaded)
+	}
+
+	contentType := aws.StringValue(head.ContentType)
+	contentLength := aws.Int64Value(head.ContentLength)
+	var validationErr error
+	if !common.IsImageContentType(contentType) {
+		validationErr = apperror.New(apperror.Validation, ErrorInvalidContentType)
+	} else if contentLength > common.SpotImageMaxBytes {
+		validationErr = apperror.New(apperror.Validation, ErrorImageTooLarge)
+	} else {
+		validationErr = r.checkSpotImageQuota(ctx, userId)
+	}
+	if validationErr != nil {
+		r.deleteObject(ctx, objectKey, function)
+		return common.SpotImage{}, validationErr
+	}
+	return common.NewSpotImage(spotId, spotImageId, userId, r.BucketName, contentType, contentLength), nil
+}
+
+// deleteObject deletes an upload that is not used, failures are only logged
+func (r *Resolver) deleteObject(ctx context.Context, objectKey, function string) {
+
+	_, err := r.S3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(r.BucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		logError(ctx, "Failed to delete upload", function, err, map[string]interface{}{"objectKey": objectKey})
+	}
+}
+
+// deleteSpotObjects deletes the uploads and renditions of every image of the spot
+func (r *Resolver) deleteSpotObjects(ctx context.Context, spotId string) error {
+
+	for _, prefix := range []string{common.SpotImageObjectPrefix, common.SpotImageRenditionPrefix} {
+		err := r.deleteObjects(ctx, fmt.Sprintf("%s%s/", prefix, spotId))
+		if err != nil {
+			logError(ctx, "Failed to delete spot objects", "deleteSpotObjects", err, map[string]interface{}{"prefix": prefix})
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteObjects deletes every object whose key starts with prefix
+func (r *Resolver) deleteObjects(ctx context.Context, prefix string) error {
+
+	var deleteErr error
+	err := r.S3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(r.BucketName),
+		Prefix: aws.String(prefix),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range output.Contents {
+			_, deleteErr = r.S3Client.DeleteObject(&s3.DeleteObjectInput{
+				Bucket: aws.String(r.BucketName),
+				Key:    object.Key,
+			})
+			if deleteErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = deleteErr
+	}
+	return err
+}
+
+// checkSpotImageQuota counts the images the user confirmed during the last day
+func (r *Resolver) checkSpotImageQuota(ctx context.Context, userId string) error {
+
+	since := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
+	queryInput := dynamodb.QueryInput{
+		TableName:              aws.String(r.TableName),
+		IndexName:              aws.String(GSI2Key),
+		KeyConditionExpression: aws.String("#gsi2 = :gsi2 AND begins_with(#sk, :sk)"),
+		FilterExpression:       aws.String("#creationTime >= :since"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":gsi2":  {S: aws.String(fmt.Sprintf("%s%s", UserPrefix, userId))},
+			":sk":    {S: aws.String(common.SpotImagePrefix)},
+			":since": {S: aws.String(since)},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#gsi2":         aws.String(GSI2Key),
+			"#sk":           aws.String(SKKey),
+			"#creationTime": aws.String(CreationTimeKey),
+		},
+		Select: aws.String(dynamodb.SelectCount),
+	}
+
+	count := int64(0)
+	for {
+		output, err := r.Db.Query(&queryInput)
+		if err != nil {
+			logError(ctx, "Failed to count spot images", "checkSpotImageQuota", err, nil)
+			return err
+		}
+		count += aws.Int64Value(output.Count)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		queryInput.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	if count >= common.SpotImageUploadsPerDay {
+		return apperror.New(apperror.RateLimited, ErrorUploadQuotaExceeded)
+	}
+	return nil
+}
+
+// spotImages returns the images of the spot, newest first. With a review id only the photos
+// of the review are returned.
+func (r *Resolver) spotImages(ctx context.Context, spotId string, reviewId *string) ([]common.SpotImage, error) {
+
+	queryInput := dynamodb.QueryInput{
+		TableName:              aws.String(r.TableName),
+		KeyConditionExpression: aws.String("#pk = :pk AND begins_with(#sk, :sk)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(fmt.Sprintf("%s%s", SpotPrefix, spotId))},
+			":sk": {S: aws.String(common.SpotImagePrefix)},
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(PKKey),
+			"#sk": aws.String(SKKey),
+		},
+	}
+	if reviewId != nil {
+		queryInput.FilterExpression = aws.String("#reviewId = :reviewId")
+		queryInput.ExpressionAttributeNames["#reviewId"] = aws.String(ReviewIdKey)
+		queryInput.ExpressionAttributeValues[":reviewId"] = &dynamodb.AttributeValue{S: reviewId}
+	}
+
+	spotImages := []common.SpotImage{}
+	for {
+		output, err := r.Db.Query(&queryInput)
+		if err != nil {
+			logError(ctx, "Failed to query spot images", "spotImages", err, nil)
+			return nil, err
+		}
+		var page []common.SpotImage
+		err = dynamodbattribute.UnmarshalListOfMaps(output.Items, &page)
+		if err != nil {
+			logError(ctx, "Failed to unmarshal spot images", "spotImages", err, nil)
+			return nil, err
+		}
+		spotImages = append(spotImages, page...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		queryInput.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+
+	sort.SliceStable(spotImages, func(i, j int) bool {
+		return spotImages[i].CreationTime > spotImages[j].CreationTime
+	})
+	return spotImages, nil
+}
+
+type SpotImageUploadResolver struct {
+	upload SpotImageUpload
 }
 
-type SpotImage struct {
-	SpotImageId  string
-	SpotId       string
-	ImageUrl     string
-	UserId       *string
-	CreationTime string
+func (u SpotImageUploadResolver) SpotImageId(ctx context.Context) string {
+	return u.upload.SpotImageId
+}
+
+func (u SpotImageUploadResolver) UploadUrl(ctx context.Context) string {
+	return u.upload.UploadUrl
+}
+
+func (u SpotImageUploadResolver) ContentType(ctx context.Context) string {
+	return u.upload.ContentType
+}
+
+func (u SpotImageUploadResolver) ExpirationTime(ctx context.Context) string {
+	return u.upload.ExpirationTime
 }
 
 type SpotImageResolver struct {
-	spotImage SpotImage
+	spotImage common.SpotImage
 }
 
 func (u SpotImageResolver) SpotImageId(ctx context.Context) string {
-	return u.spotImage.SpotImageId
+	return u.spotImage.SpotImageId()
 }
 
 func (u SpotImageResolver) SpotId(ctx context.Context) string {
-	return u.spotImage.SpotId
+	return u.spotImage.SpotId()
 }
 
 func (u SpotImageResolver) ImageUrl(ctx context.Context) string {
 	return u.spotImage.ImageUrl
 }
 
+func (u SpotImageResolver) ThumbnailUrl(ctx context.Context) *string {
+	return u.spotImage.ThumbnailUrl
+}
+
+func (u SpotImageResolver) MediumUrl(ctx context.Context) *string {
+	return u.spotImage.MediumUrl
+}
+
 func (u SpotImageResolver) UserId(ctx context.Context) *string {
-	return u.spotImage.UserId
+	return u.spotImage.UserId()
 }
 
 func (u SpotImageResolver) CreationTime(ctx context.Context) string {
 	return u.spotImage.CreationTime
 }
+
+func (u SpotImageResolver) ReviewId(ctx context.Context) *string {
+	return u.spotImage.ReviewId
+}
+
+// Source tells photos of the spot from photos attached to a review
+func (u SpotImageResolver) Source(ctx context.Context) string {
+	if u.spotImage.ReviewId != nil {
+		return ImageSourceReview
+	}
+	return ImageSourceSpot
+}
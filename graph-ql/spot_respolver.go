@@ -2,86 +2,111 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
 	uuid "github.com/satori/go.uuid"
 )
 
 type SpotArgs struct {
-	SpotId       string
-	CreatorId    string
-	Geohash      string
-	SpotTypes    *[]*string
-	SpotType     string
-	Latitude     float64
-	Longitude    float64
-	Name         string
-	Address      string
-	Code         string
-	Prefecture   string
-	City         string
-	HomePageUrls []string
-	Tags         []string
+	SpotId        string
+	CreatorId     string
+	Geohash       string
+	SpotTypes     *[]string
+	SpotType      string
+	Latitude      float64
+	Longitude     float64
+	Name          string
+	Address       string
+	Code          string
+	Prefecture    string
+	City          string
+	HomePageUrls  []string
+	Tags          []string
+	AllTags       *[]string
+	AnyTags       *[]string
+	MinSubRatings *MinSubRatingsInput
+	First         *int32
+	After         *string
 }
 
-func (r *Resolver) Spot(ctx context.Context, args SpotArgs) (*SpotResolver, error) {
-
-	common.LogInfo(ctx, "Invoke", "Spot", map[string]interface{}{"args": args})
-	pk := fmt.Sprintf("%s%s", common.SpotPrefix, args.SpotId)
-	keyConditionExpression := "#pk = :pk AND begins_with(#sk, :sk)"
-	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
-		":pk": {S: aws.String(pk)},
-		":sk": {S: aws.String(SpotPrefix)},
+func newSpotFilter(spotTypes []string, allTags, anyTags *[]string, minSubRatings *MinSubRatingsInput) common.SpotFilter {
+	filter := common.SpotFilter{SpotTypes: spotTypes, MinSubRatings: minSubRatings.values()}
+	if allTags != nil {
+		filter.AllTags = *allTags
 	}
-	expressionAttributeNames := map[string]*string{
-		"#pk": aws.String("PK"),
-		"#sk": aws.String("SK"),
+	if anyTags != nil {
+		filter.AnyTags = *anyTags
 	}
+	return filter
+}
 
-	output, err := r.Db.Query(&dynamodb.QueryInput{
-		TableName:                 aws.String(r.TableName),
-		KeyConditionExpression:    aws.String(keyConditionExpression),
-		ExpressionAttributeValues: expressionAttributeValues,
-		ExpressionAttributeNames:  expressionAttributeNames,
-		Limit:                     aws.Int64(1),
-	})
-	if err != nil {
-		common.LogError(ctx, "Failed to query spot", "Spot", err, nil)
-		return nil, err
-	}
+// MinSubRatingsInput restricts spot queries to spots with sub-rating averages of at least the values
+type MinSubRatingsInput struct {
+	ToiletCleanliness *float64
+	Quietness         *float64
+	Flatness          *float64
+	Safety            *float64
+}
 
-	if len(output.Items) != 1 {
-		common.LogError(ctx, "Did not find spot", "Spot", nil, nil)
-		return nil, errors.New("Did not find spot")
+// values maps the set minimums by sub-rating
+func (input *MinSubRatingsInput) values() map[string]float64 {
+	values := map[string]float64{}
+	if input == nil {
+		return values
+	}
+	minimums := map[string]*float64{
+		common.SubRatingToiletCleanliness: input.ToiletCleanliness,
+		common.SubRatingQuietness:         input.Quietness,
+		common.SubRatingFlatness:          input.Flatness,
+		common.SubRatingSafety:            input.Safety,
+	}
+	for subRating, minimum := range minimums {
+		if minimum != nil {
+			values[subRating] = *minimum
+		}
 	}
+	return values
+}
 
-	var spot common.Spot
-	err = dynamodbattribute.UnmarshalMap(output.Items[0], &spot)
+func (r *Resolver) Spot(ctx context.Context, args SpotArgs) (*SpotResolver, error) {
+
+	common.LogInfo(ctx, "Invoke", "Spot", map[string]interface{}{"args": args})
+	spot, err := r.loadSpot(ctx, args.SpotId, projection(ctx, spotAttributes))
 	if err != nil {
-		common.LogError(ctx, "Failed to unmarshal spot", "Spot", err, nil)
+		common.LogError(ctx, "Failed to load spot", "Spot", err, nil)
 		return nil, err
 	}
-	spotResolver := &SpotResolver{spot: &spot, baseResolver: r}
+	if spot == nil {
+		common.LogError(ctx, "Did not find spot", "Spot", nil, nil)
+		return nil, apperror.New(apperror.NotFound, common.ErrorSpotNotFound)
+	}
+	spotResolver := &SpotResolver{spot: spot, baseResolver: r}
 	return spotResolver, nil
 
 }
 
-// change to use sk instead
-func (r *Resolver) SpotsByGeohash(ctx context.Context, args SpotArgs) ([]*SpotResolver, error) {
+func (r *Resolver) SpotsByGeohash(ctx context.Context, args SpotArgs) (*SpotConnectionResolver, error) {
 
 	logInfo(ctx, "Invoke", "SpotsByGeohash", map[string]interface{}{"args": args})
 	requestUser := getRequestUser(ctx)
 	if requestUser == nil {
 		log.Print("Error: requestUser is nil")
-		return nil, errors.New(ErrorUserIsNotAuthenticated)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+
+	first, err := pageSize(args.First)
+	if err != nil {
+		return nil, err
 	}
 
 	keyConditionExpression := "#gsi2 = :gsi2"
@@ -98,40 +123,41 @@ func (r *Resolver) SpotsByGeohash(ctx context.Context, args SpotArgs) ([]*SpotRe
 
 	keyConditionExpression = keyConditionExpression + " AND begins_with(#sk, :sk)"
 
-	output, err := r.Db.Query(&dynamodb.QueryInput{
+	var spotTypes []string
+	if args.SpotTypes != nil {
+		spotTypes = *args.SpotTypes
+	}
+	filter := newSpotFilter(spotTypes, args.AllTags, args.AnyTags, args.MinSubRatings)
+
+	queryInput := dynamodb.QueryInput{
 		TableName:                 aws.String(r.TableName),
 		IndexName:                 aws.String("GSI2"),
 		KeyConditionExpression:    aws.String(keyConditionExpression),
 		ExpressionAttributeValues: expressionAttributeValues,
 		ExpressionAttributeNames:  expressionAttributeNames,
-	})
+		FilterExpression:          filter.FilterExpression(expressionAttributeNames, expressionAttributeValues),
+	}
+	page, err := common.QueryPage(ctx, queryInput, first, aws.StringValue(args.After), r.Db)
 	if err != nil {
 		return nil, err
 	}
 
-	spotResolvers := make([]*SpotResolver, len(output.Items))
-	for index := range output.Items {
-		item := output.Items[index]
-		var spot common.Spot
-		err := dynamodbattribute.UnmarshalMap(item, &spot)
-		if err != nil {
-			return nil, err
-		}
-		spotResolver := &SpotResolver{spot: &spot}
-		spotResolvers[index] = spotResolver
-	}
-
-	return spotResolvers, nil
+	return newSpotConnection(r, page, queryInput.IndexName)
 
 }
 
-func (r *Resolver) SpotsByCreator(ctx context.Context, args SpotArgs) ([]*SpotResolver, error) {
+func (r *Resolver) SpotsByCreator(ctx context.Context, args SpotArgs) (*SpotConnectionResolver, error) {
 
 	logInfo(ctx, "Invoke", "SpotsByCreator", map[string]interface{}{"args": args})
 	requestUser := getRequestUser(ctx)
 	if requestUser == nil {
 		log.Print("Error: requestUser is nil")
-		return nil, errors.New(ErrorUserIsNotAuthenticated)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+
+	first, err := pageSize(args.First)
+	if err != nil {
+		return nil, err
 	}
 
 	gsi1 := fmt.Sprintf("%s%s", UserPrefix, args.CreatorId)
@@ -143,75 +169,295 @@ func (r *Resolver) SpotsByCreator(ctx context.Context, args SpotArgs) ([]*SpotRe
 		"#gsi1": aws.String("GSI1"),
 	}
 
-	if args.SpotTypes != nil && len(*args.SpotTypes) > 0 {
-		//TODO
+	var spotTypes []string
+	if args.SpotTypes != nil {
+		spotTypes = *args.SpotTypes
 	}
+	filter := newSpotFilter(spotTypes, args.AllTags, args.AnyTags, args.MinSubRatings)
 
-	output, err := r.Db.Query(&dynamodb.QueryInput{
+	queryInput := dynamodb.QueryInput{
 		TableName:                 aws.String(r.TableName),
 		IndexName:                 aws.String("GSI1"),
 		KeyConditionExpression:    aws.String(keyConditionExpression),
 		ExpressionAttributeValues: expressionAttributeValues,
 		ExpressionAttributeNames:  expressionAttributeNames,
-	})
+		FilterExpression:          filter.FilterExpression(expressionAttributeNames, expressionAttributeValues),
+	}
+	page, err := common.QueryPage(ctx, queryInput, first, aws.StringValue(args.After), r.Db)
 	if err != nil {
 		return nil, err
 	}
-	spotResolvers := make([]*SpotResolver, len(output.Items))
-	for index := range output.Items {
-		item := output.Items[index]
-		var spot common.Spot
-		err := dynamodbattribute.UnmarshalMap(item, &spot)
-		if err != nil {
-			return nil, err
-		}
-		spotResolver := &SpotResolver{spot: &spot}
-		spotResolvers[index] = spotResolver
-	}
 
-	return spotResolvers, nil
+	return newSpotConnection(r, page, queryInput.IndexName)
 
 }
 
-type CreateSpotArgs struct {
-	CreatorUserId string
-	Goehash       string
-	SpotType      string
+type SpotsNearArgs struct {
 	Latitude      float64
 	Longitude     float64
-	Name          *string
-	Address       *string
-	Code          *string
-	Prefecture    *string
-	City          *string
-	HomePageUrls  *[]string
+	RadiusMeters  float64
+	Limit         *int32
+	SpotTypes     *[]string
+	Tags          *[]string
+	AllTags       *[]string
+	AnyTags       *[]string
+	MinSubRatings *MinSubRatingsInput
+	OrderBy       *string
+}
+
+func (r *Resolver) SpotsNear(ctx context.Context, args SpotsNearArgs) ([]*SpotResolver, error) {
+
+	logInfo(ctx, "Invoke", "SpotsNear", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "SpotsNear", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+
+	if args.Latitude < -90 || args.Latitude > 90 || args.Longitude < -180 || args.Longitude > 180 {
+		return nil, apperror.New(apperror.Validation, ErrorInvalidCoordinates)
+	}
+	if args.RadiusMeters <= 0 || args.RadiusMeters > common.SpotsNearMaxRadiusMeters {
+		return nil, apperror.New(apperror.Validation, ErrorInvalidRadius)
+	}
+	limit := common.SpotsNearDefaultLimit
+	if args.Limit != nil {
+		limit = int(*args.Limit)
+	}
+	if limit <= 0 || limit > common.SpotsNearMaxLimit {
+		return nil, apperror.New(apperror.Validation, ErrorInvalidLimit)
+	}
+
+	var spotTypes []string
+	if args.SpotTypes != nil {
+		spotTypes = *args.SpotTypes
+	}
+	filter := newSpotFilter(spotTypes, args.AllTags, args.AnyTags, args.MinSubRatings)
+	filter.Projection = projection(ctx, spotAttributes)
+	// tags is kept for older clients and means the same as allTags
+	if args.Tags != nil {
+		filter.AllTags = append(filter.AllTags, *args.Tags...)
+	}
+
+	// the best rated spots can be anywhere in the radius, not only among the closest
+	nearLimit := limit
+	if args.OrderBy != nil {
+		nearLimit = 0
+		filter.Projection = filter.Projection.With(ratingKeys()...)
+	}
+	spots, err := common.GetSpotsNear(ctx, args.Latitude, args.Longitude, args.RadiusMeters, nearLimit, filter, r.Db, r.TableName)
+	if err != nil {
+		logError(ctx, "Failed to get spots near", "SpotsNear", err, nil)
+		return nil, err
+	}
+	if args.OrderBy != nil {
+		sortSpots(spots, *args.OrderBy)
+	}
+	if len(spots) > limit {
+		spots = spots[:limit]
+	}
+
+	spotResolvers := make([]*SpotResolver, len(spots))
+	for index := range spots {
+		spot := spots[index]
+		spotResolvers[index] = &SpotResolver{spot: &spot, baseResolver: r}
+	}
+
+	return spotResolvers, nil
+
+}
+
+type BoundingBoxInput struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+type PolygonInput struct {
+	Type        string
+	Coordinates [][][]float64
+}
+
+// newRegion takes either a bounding box or a GeoJSON polygon
+func newRegion(boundingBox *BoundingBoxInput, polygon *PolygonInput) (common.Region, error) {
+	if boundingBox != nil && polygon == nil {
+		return common.NewBoundingBoxRegion(common.BoundingBox{
+			MinLatitude:  boundingBox.MinLatitude,
+			MinLongitude: boundingBox.MinLongitude,
+			MaxLatitude:  boundingBox.MaxLatitude,
+			MaxLongitude: boundingBox.MaxLongitude,
+		})
+	}
+	if polygon != nil && boundingBox == nil && polygon.Type == "Polygon" {
+		return common.NewPolygonRegion(polygon.Coordinates)
+	}
+	return common.Region{}, apperror.New(apperror.Validation, common.ErrorInvalidRegion)
+}
+
+type SpotsInRegionArgs struct {
+	BoundingBox   *BoundingBoxInput
+	Polygon       *PolygonInput
+	Limit         *int32
+	SpotTypes     *[]string
 	Tags          *[]string
+	AllTags       *[]string
+	AnyTags       *[]string
+	MinSubRatings *MinSubRatingsInput
+	OrderBy       *string
+}
+
+func (r *Resolver) SpotsInRegion(ctx context.Context, args SpotsInRegionArgs) ([]*SpotResolver, error) {
+
+	logInfo(ctx, "Invoke", "SpotsInRegion", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "SpotsInRegion", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+
+	region, err := newRegion(args.BoundingBox, args.Polygon)
+	if err != nil {
+		logError(ctx, "Invalid region", "SpotsInRegion", err, nil)
+		return nil, err
+	}
+	limit := common.RegionDefaultLimit
+	if args.Limit != nil {
+		limit = int(*args.Limit)
+	}
+	if limit <= 0 || limit > common.RegionMaxLimit {
+		return nil, apperror.New(apperror.Validation, ErrorInvalidLimit)
+	}
+
+	var spotTypes []string
+	if args.SpotTypes != nil {
+		spotTypes = *args.SpotTypes
+	}
+	filter := newSpotFilter(spotTypes, args.AllTags, args.AnyTags, args.MinSubRatings)
+	filter.Projection = projection(ctx, spotAttributes)
+	// tags is kept for older clients and means the same as allTags
+	if args.Tags != nil {
+		filter.AllTags = append(filter.AllTags, *args.Tags...)
+	}
+
+	// the best rated spots can be in any cell, ordered lists read the whole region
+	regionLimit := limit
+	if args.OrderBy != nil {
+		regionLimit = 0
+		filter.Projection = filter.Projection.With(ratingKeys()...)
+	}
+	spots, err := common.GetSpotsInRegion(ctx, region, regionLimit, filter, r.Db, r.TableName)
+	if err != nil {
+		logError(ctx, "Failed to get spots in region", "SpotsInRegion", err, nil)
+		return nil, err
+	}
+	if args.OrderBy != nil {
+		sortSpots(spots, *args.OrderBy)
+	}
+	if len(spots) > limit {
+		spots = spots[:limit]
+	}
+
+	spotResolvers := make([]*SpotResolver, len(spots))
+	for index := range spots {
+		spot := spots[index]
+		spotResolvers[index] = &SpotResolver{spot: &spot, baseResolver: r}
+	}
+
+	return spotResolvers, nil
+
+}
+
+// sortSpots orders the spots by their average rating, best first. Ties go to the spot with more
+// reviews, spots without ratings come last.
+func sortSpots(spots []common.Spot, orderBy string) {
+	if orderBy != SpotOrderByRating {
+		return
+	}
+	sort.SliceStable(spots, func(i, j int) bool {
+		a, b := spots[i].AverageRating(), spots[j].AverageRating()
+		if a == nil || b == nil {
+			return a != nil
+		}
+		if *a != *b {
+			return *a > *b
+		}
+		return aws.Int64Value(spots[i].ReviewCount) > aws.Int64Value(spots[j].ReviewCount)
+	})
+}
+
+// subRatingAverageKeys are the attributes read for the sub-rating averages
+func subRatingAverageKeys() []string {
+	keys := []string{}
+	for _, subRating := range common.SubRatings {
+		keys = append(keys, common.SubRatingAverageKey(subRating))
+	}
+	return keys
+}
+
+// ratingKeys are the attributes of the rating aggregates
+func ratingKeys() []string {
+	keys := []string{ReviewCountKey, RatingSumKey}
+	for rating := int32(common.MinRating); rating <= common.MaxRating; rating++ {
+		keys = append(keys, common.RatingCountKey(rating))
+	}
+	return keys
+}
+
+type CreateSpotInput struct {
+	SpotType     string
+	Latitude     float64
+	Longitude    float64
+	Name         string
+	Description  *string
+	Address      *string
+	Code         *string
+	Prefecture   *string
+	City         *string
+	HomePageUrls *[]string
+	Tags         *[]string
 }
 
+type CreateSpotArgs struct {
+	Input CreateSpotInput
+}
+
+// CreateSpot stores a spot created by the request user, the geohash is computed from the coordinates
 func (r *Resolver) CreateSpot(ctx context.Context, args CreateSpotArgs) (*SpotResolver, error) {
 
 	logInfo(ctx, "Invoke", "CreateSpot", map[string]interface{}{"args": args})
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", "CreateSpot", nil, nil)
+		return nil, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	input := args.Input
+	if err := input.fields().validate(); err != nil {
+		return nil, err
+	}
+
 	creationTime := time.Now().Format(time.RFC3339)
 	spotId := uuid.NewV4().String()
 	pk := fmt.Sprintf("%s%s", common.SpotPrefix, spotId)
-	sk := fmt.Sprintf("%s%s", common.GeohashPrefix, args.Goehash)
-	gsi1 := fmt.Sprintf("%s%s", common.UserPrefix, args.CreatorUserId)
+	sk := fmt.Sprintf("%s%s", common.SpotPrefix, common.SpotGeohash(input.Latitude, input.Longitude))
+	gsi1 := fmt.Sprintf("%s%s", common.UserPrefix, requestUser.UserId())
 	spot := common.Spot{
 		PK:           pk,
 		SK:           sk,
 		GSI1:         aws.String(gsi1),
 		GSI2:         aws.String(common.SpotQueryName),
 		CreationTime: creationTime,
-		SpotType:     args.SpotType,
-		Latitude:     args.Latitude,
-		Longitude:    args.Longitude,
-		Name:         args.Name,
-		Address:      args.Address,
-		Code:         args.Code,
-		Prefecture:   args.Prefecture,
-		City:         args.City,
-		HomePageUrls: args.HomePageUrls,
-		Tags:         args.Tags,
+		SpotType:     input.SpotType,
+		Latitude:     input.Latitude,
+		Longitude:    input.Longitude,
+		Name:         aws.String(input.Name),
+		Description:  input.Description,
+		Address:      input.Address,
+		Code:         input.Code,
+		Prefecture:   input.Prefecture,
+		City:         input.City,
+		HomePageUrls: input.HomePageUrls,
+		Tags:         input.Tags,
 	}
 
 	item, err := dynamodbattribute.MarshalMap(spot)
@@ -226,6 +472,9 @@ func (r *Resolver) CreateSpot(ctx context.Context, args CreateSpotArgs) (*SpotRe
 	})
 	if err != nil {
 		logError(ctx, "failed to put item", "CreateSpot", err, nil)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, apperror.Wrap(apperror.Conflict, ErrorSpotAlreadyExists, err)
+		}
 		return nil, err
 	}
 
@@ -243,10 +492,350 @@ func (r *Resolver) CreateSpot(ctx context.Context, args CreateSpotArgs) (*SpotRe
 		return nil, err
 	}
 
+	r.publish(ctx, spotUpdatedEvent(spot))
+
 	spotResolver := SpotResolver{spot: &spot, baseResolver: r}
 	return &spotResolver, nil
 }
 
+// UpdateSpotInput holds the fields to change, fields that are not set keep their value
+type UpdateSpotInput struct {
+	SpotType     *string
+	Latitude     *float64
+	Longitude    *float64
+	Name         *string
+	Description  *string
+	Address      *string
+	Code         *string
+	Prefecture   *string
+	City         *string
+	HomePageUrls *[]string
+	Tags         *[]string
+}
+
+type UpdateSpotArgs struct {
+	SpotId string
+	Patch  UpdateSpotInput
+}
+
+// UpdateSpot changes the spot and keeps mapbox and the spot distances in sync with it.
+// A spot that moves gets a new geohash sort key and its distances are loaded again.
+func (r *Resolver) UpdateSpot(ctx context.Context, args UpdateSpotArgs) (*SpotResolver, error) {
+
+	logInfo(ctx, "Invoke", "UpdateSpot", map[string]interface{}{"args": args})
+	spot, err := r.loadOwnSpot(ctx, args.SpotId, "UpdateSpot")
+	if err != nil {
+		return nil, err
+	}
+
+	patch := args.Patch
+	if err := patch.fields().validate(); err != nil {
+		return nil, err
+	}
+	updated := spot
+	if patch.Latitude != nil {
+		updated.Latitude = *patch.Latitude
+	}
+	if patch.Longitude != nil {
+		updated.Longitude = *patch.Longitude
+	}
+	moved := updated.Latitude != spot.Latitude || updated.Longitude != spot.Longitude
+	if moved {
+		updated.SK = fmt.Sprintf("%s%s", common.SpotPrefix, common.SpotGeohash(updated.Latitude, updated.Longitude))
+	}
+
+	if updated.SK != spot.SK {
+		updated, err = r.moveSpot(ctx, spot, updated, patch)
+	} else {
+		updated, err = r.updateSpotAttributes(ctx, spot, patch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.forgetSpot(ctx, updated.SpotId())
+
+	// the mapbox feature has the location, name and spot type
+	renamed := aws.StringValue(updated.Name) != aws.StringValue(spot.Name) || updated.SpotType != spot.SpotType
+	if moved || renamed {
+		err = r.MapboxClient.AddFeature(ctx, updated)
+		if err != nil {
+			logError(ctx, "Failed to update feature in mapbox", "UpdateSpot", err, nil)
+			return nil, err
+		}
+	}
+
+	if moved {
+		// distances of the old location are wrong in both directions
+		err = common.DeleteSpotDistances(ctx, updated.SpotId(), r.Db, r.TableName)
+		if err == nil {
+			err = common.CreateSpotDistances(ctx, updated, r.Db, r.TableName, r.MapboxClient)
+		}
+	} else if renamed || aws.StringValue(updated.Description) != aws.StringValue(spot.Description) {
+		err = common.UpdateDestinationDetails(ctx, updated, r.Db, r.TableName)
+	}
+	if err != nil {
+		logError(ctx, "Failed to update spot distances", "UpdateSpot", err, nil)
+		return nil, err
+	}
+
+	r.publish(ctx, spotUpdatedEvent(updated))
+
+	return &SpotResolver{spot: &updated, baseResolver: r}, nil
+}
+
+// updateSpotAttributes sets the attributes of the patch, attributes that are not in the patch
+// may have been changed meanwhile and are read back from the updated item
+func (r *Resolver) updateSpotAttributes(ctx context.Context, spot common.Spot, patch UpdateSpotInput) (common.Spot, error) {
+
+	values := map[string]interface{}{}
+	addValue := func(key string, isSet bool, value interface{}) {
+		if isSet {
+			values[key] = value
+		}
+	}
+	addValue(SpotTypeKey, patch.SpotType != nil, patch.SpotType)
+	addValue(LatitudeKey, patch.Latitude != nil, patch.Latitude)
+	addValue(LongitudeKey, patch.Longitude != nil, patch.Longitude)
+	addValue(NameKey, patch.Name != nil, patch.Name)
+	addValue(DescriptionKey, patch.Description != nil, patch.Description)
+	addValue(AddressKey, patch.Address != nil, patch.Address)
+	addValue(CodeKey, patch.Code != nil, patch.Code)
+	addValue(PrefectureKey, patch.Prefecture != nil, patch.Prefecture)
+	addValue(CityKey, patch.City != nil, patch.City)
+	addValue(HomePageUrlsKey, patch.HomePageUrls != nil, patch.HomePageUrls)
+	addValue(TagsKey, patch.Tags != nil, patch.Tags)
+	if len(values) == 0 {
+		return spot, nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	set := make([]string, len(keys))
+	expressionAttributeNames := map[string]*string{"#pk": aws.String(PKKey)}
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{}
+	for index, key := range keys {
+		value, err := dynamodbattribute.Marshal(values[key])
+		if err != nil {
+			logError(ctx, "Failed to marshal value", "updateSpotAttributes", err, map[string]interface{}{"key": key})
+			return common.Spot{}, err
+		}
+		set[index] = fmt.Sprintf("#%s = :%s", key, key)
+		expressionAttributeNames["#"+key] = aws.String(key)
+		expressionAttributeValues[":"+key] = value
+	}
+
+	output, err := r.Db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(r.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			PKKey: {S: aws.String(spot.PK)},
+			SKKey: {S: aws.String(spot.SK)},
+		},
+		UpdateExpression:          aws.String(fmt.Sprintf("SET %s", strings.Join(set, ", "))),
+		ConditionExpression:       aws.String("attribute_exists(#pk)"),
+		ExpressionAttributeNames:  expressionAttributeNames,
+		ExpressionAttributeValues: expressionAttributeValues,
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if err != nil {
+		logError(ctx, "Failed to update spot", "updateSpotAttributes", err, nil)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return common.Spot{}, apperror.Wrap(apperror.NotFound, common.ErrorSpotNotFound, err)
+		}
+		return common.Spot{}, err
+	}
+
+	var updated common.Spot
+	err = dynamodbattribute.UnmarshalMap(output.Attributes, &updated)
+	if err != nil {
+		logError(ctx, "Failed to unmarshal spot", "updateSpotAttributes", err, nil)
+		return common.Spot{}, err
+	}
+	return updated, nil
+}
+
+// moveSpot replaces the spot item with one under the new geohash sort key. The new item is a
+// copy of the old one, it may only be written while the attributes other requests change are as
+// they were read. Otherwise the spot is read again and the move tried again.
+func (r *Resolver) moveSpot(ctx context.Context, spot, updated common.Spot, patch UpdateSpotInput) (common.Spot, error) {
+
+	for attempt := 1; ; attempt++ {
+		moved := spot
+		moved.SK, moved.Latitude, moved.Longitude = updated.SK, updated.Latitude, updated.Longitude
+		applySpotPatch(&moved, patch)
+		item, err := dynamodbattribute.MarshalMap(moved)
+		if err != nil {
+			logError(ctx, "Failed to marshal spot", "moveSpot", err, nil)
+			return common.Spot{}, err
+		}
+		read, err := dynamodbattribute.MarshalMap(spot)
+		if err != nil {
+			logError(ctx, "Failed to marshal spot", "moveSpot", err, nil)
+			return common.Spot{}, err
+		}
+		expressionAttributeNames := map[string]*string{"#sk": aws.String(SKKey)}
+		expressionAttributeValues := map[string]*dynamodb.AttributeValue{}
+		conditions := []string{"attribute_exists(#sk)"}
+		for _, key := range spotAggregateKeys() {
+			expressionAttributeNames["#"+key] = aws.String(key)
+			if read[key] == nil {
+				conditions = append(conditions, fmt.Sprintf("attribute_not_exists(#%s)", key))
+				continue
+			}
+			conditions = append(conditions, fmt.Sprintf("#%s = :%s", key, key))
+			expressionAttributeValues[":"+key] = read[key]
+		}
+
+		_, err = r.Db.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				{
+					Delete: &dynamodb.Delete{
+						TableName: aws.String(r.TableName),
+						Key: map[string]*dynamodb.AttributeValue{
+							PKKey: {S: aws.String(spot.PK)},
+							SKKey: {S: aws.String(spot.SK)},
+						},
+						ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+						ExpressionAttributeNames:  expressionAttributeNames,
+						ExpressionAttributeValues: expressionAttributeValues,
+					},
+				},
+				{
+					Put: &dynamodb.Put{
+						TableName:           aws.String(r.TableName),
+						Item:                item,
+						ConditionExpression: aws.String("attribute_not_exists(SK)"),
+					},
+				},
+			},
+		})
+		if err == nil {
+			return moved, nil
+		}
+		logError(ctx, "Failed to move spot", "moveSpot", err, map[string]interface{}{"sk": updated.SK, "attempt": attempt})
+		if !conditionFailed(err, 0) {
+			if _, ok := err.(*dynamodb.TransactionCanceledException); ok {
+				return common.Spot{}, apperror.Wrap(apperror.Conflict, apperror.ErrorConflict, err)
+			}
+			return common.Spot{}, err
+		}
+		if attempt == maxSpotMoveAttempts {
+			return common.Spot{}, apperror.Wrap(apperror.Conflict, apperror.ErrorConflict, err)
+		}
+
+		// the spot changed or was moved or deleted in between
+		current, err := common.GetSpot(ctx, spot.SpotId(), nil, r.Db, r.TableName)
+		if err != nil {
+			return common.Spot{}, err
+		}
+		if current.SK != spot.SK {
+			return common.Spot{}, apperror.New(apperror.Conflict, apperror.ErrorConflict)
+		}
+		spot = current
+	}
+}
+
+// spotAggregateKeys are the attributes of the spot item that reviews and images change, the
+// sub-rating averages are set together with their sums and counts
+func spotAggregateKeys() []string {
+	keys := append(ratingKeys(), DefaultImageUrlKey)
+	for _, subRating := range common.SubRatings {
+		keys = append(keys, common.SubRatingSumKey(subRating), common.SubRatingCountKey(subRating))
+	}
+	return keys
+}
+
+// conditionFailed reports whether the condition of the transaction item at index failed
+func conditionFailed(err error, index int) bool {
+	if aerr, ok := err.(*dynamodb.TransactionCanceledException); ok {
+		return len(aerr.CancellationReasons) > index && aws.StringValue(aerr.CancellationReasons[index].Code) == "ConditionalCheckFailed"
+	}
+	return false
+}
+
+func applySpotPatch(spot *common.Spot, patch UpdateSpotInput) {
+	if patch.SpotType != nil {
+		spot.SpotType = *patch.SpotType
+	}
+	if patch.Name != nil {
+		spot.Name = patch.Name
+	}
+	if patch.Description != nil {
+		spot.Description = patch.Description
+	}
+	if patch.Address != nil {
+		spot.Address = patch.Address
+	}
+	if patch.Code != nil {
+		spot.Code = patch.Code
+	}
+	if patch.Prefecture != nil {
+		spot.Prefecture = patch.Prefecture
+	}
+	if patch.City != nil {
+		spot.City = patch.City
+	}
+	if patch.HomePageUrls != nil {
+		spot.HomePageUrls = patch.HomePageUrls
+	}
+	if patch.Tags != nil {
+		spot.Tags = patch.Tags
+	}
+}
+
+type DeleteSpotArgs struct {
+	SpotId string
+}
+
+// DeleteSpot deletes the spot with its reviews, images and distances and removes it from mapbox
+func (r *Resolver) DeleteSpot(ctx context.Context, args DeleteSpotArgs) (bool, error) {
+
+	logInfo(ctx, "Invoke", "DeleteSpot", map[string]interface{}{"args": args})
+	spot, err := r.loadOwnSpot(ctx, args.SpotId, "DeleteSpot")
+	if err != nil {
+		return false, err
+	}
+
+	// the spot item goes last, until then a failed delete can be retried
+	err = r.MapboxClient.DeleteFeature(ctx, spot.SpotId())
+	if err != nil {
+		logError(ctx, "Failed to delete feature from mapbox", "DeleteSpot", err, nil)
+		return false, err
+	}
+	err = r.deleteSpotObjects(ctx, spot.SpotId())
+	if err != nil {
+		return false, err
+	}
+	err = common.DeleteSpot(ctx, spot, r.Db, r.TableName)
+	if err != nil {
+		return false, err
+	}
+	r.forgetSpot(ctx, spot.SpotId())
+	return true, nil
+}
+
+// loadOwnSpot returns the spot if the request user created it or is an admin
+func (r *Resolver) loadOwnSpot(ctx context.Context, spotId, function string) (common.Spot, error) {
+
+	requestUser := getRequestUser(ctx)
+	if requestUser == nil {
+		logError(ctx, "RequestUser is nil", function, nil, nil)
+		return common.Spot{}, apperror.New(apperror.Unauthenticated, ErrorUserIsNotAuthenticated)
+	}
+	spot, err := common.GetSpot(ctx, spotId, nil, r.Db, r.TableName)
+	if err != nil {
+		return common.Spot{}, err
+	}
+	creator := fmt.Sprintf("%s%s", UserPrefix, requestUser.UserId())
+	if aws.StringValue(spot.GSI1) != creator && !requestUser.IsAdminUser() {
+		logInfo(ctx, "User is not the creator", function, map[string]interface{}{"spotId": spotId})
+		return common.Spot{}, apperror.New(apperror.Forbidden, ErrorUserIsNotSpotCreator)
+	}
+	return spot, nil
+}
+
 type SpotResolver struct {
 	spot         *common.Spot
 	baseResolver *Resolver // consider using interface instead
@@ -276,21 +865,95 @@ func (z SpotResolver) CreationTime(ctx context.Context) string {
 	return z.spot.CreationTime
 }
 
-func (z SpotResolver) Reviews(ctx context.Context) (*[]*ReviewResolver, error) {
+type SpotReviewsArgs struct {
+	OrderBy *string
+	Filter  *ReviewFilterInput
+	First   *int32
+	After   *string
+}
+
+func (z SpotResolver) Reviews(ctx context.Context, args SpotReviewsArgs) (*ReviewConnectionResolver, error) {
 	spotId := z.SpotId(ctx)
-	reviewArgs := ReviewArgs{SpotId: aws.String(spotId)}
-	reslovers, err := z.baseResolver.Reviews(ctx, reviewArgs)
-	return &reslovers, err
+	reviewArgs := ReviewArgs{SpotId: aws.String(spotId), OrderBy: args.OrderBy, Filter: args.Filter, First: args.First, After: args.After}
+	return z.baseResolver.Reviews(ctx, reviewArgs)
+}
+
+type SpotDistancesArgs struct {
+	MaxSeconds *float64
+	MaxMeters  *float64
+	SpotTypes  *[]string
+	OrderBy    *string
+	Descending *bool
+	Limit      *int32
 }
 
-func (z SpotResolver) SpotDistances(ctx context.Context) (*[]*SpotDistanceResolver, error) {
+func (z SpotResolver) SpotDistances(ctx context.Context, args SpotDistancesArgs) (*[]*SpotDistanceResolver, error) {
+
+	logInfo(ctx, "Invoke", "SpotDistances", map[string]interface{}{"args": args})
+	limit, err := pageSize(args.Limit)
+	if err != nil {
+		logError(ctx, "Invalid limit", "SpotDistances", err, nil)
+		return nil, err
+	}
+	spotTypes := []string{}
+	if args.SpotTypes != nil {
+		spotTypes = *args.SpotTypes
+	}
+
+	spotDistances, err := common.QuerySpotDistances(ctx, z.SpotId(ctx), args.MaxSeconds, args.MaxMeters, spotTypes, z.baseResolver.Db, z.baseResolver.TableName)
+	if err != nil {
+		logError(ctx, "Failed to query spot distances", "SpotDistances", err, nil)
+		return nil, err
+	}
+
+	orderBy := SpotDistanceOrderBySeconds
+	if args.OrderBy != nil {
+		orderBy = *args.OrderBy
+	}
+	descending := args.Descending != nil && *args.Descending
+	sortSpotDistances(spotDistances, orderBy, descending)
+	if len(spotDistances) > limit {
+		spotDistances = spotDistances[:limit]
+	}
 
-	return nil, nil
+	resolvers := make([]*SpotDistanceResolver, len(spotDistances))
+	for index := range spotDistances {
+		resolvers[index] = &SpotDistanceResolver{spotDistance: spotDistances[index], baseResolver: z.baseResolver}
+	}
+	return &resolvers, nil
+}
+
+// sortSpotDistances sorts by the selected distance, distances that have not been calculated come last
+func sortSpotDistances(spotDistances []common.SpotDistance, orderBy string, descending bool) {
+	value := func(sd common.SpotDistance) *float64 {
+		if orderBy == SpotDistanceOrderByMeters {
+			return sd.DistanceMeters
+		}
+		return sd.DistanceSeconds
+	}
+	sort.SliceStable(spotDistances, func(i, j int) bool {
+		a, b := value(spotDistances[i]), value(spotDistances[j])
+		if a == nil || b == nil {
+			return a != nil
+		}
+		if descending {
+			return *a > *b
+		}
+		return *a < *b
+	})
 }
 
 func (z SpotResolver) Images(ctx context.Context) (*[]*SpotImageResolver, error) {
 
-	return nil, nil
+	spotImages, err := z.baseResolver.spotImages(ctx, z.SpotId(ctx), nil)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*SpotImageResolver, len(spotImages))
+	for index := range spotImages {
+		resolvers[index] = &SpotImageResolver{spotImage: spotImages[index]}
+	}
+	return &resolvers, nil
 }
 
 func (z SpotResolver) CreatorId(ctx context.Context) *string {
@@ -301,11 +964,15 @@ func (z SpotResolver) CreatorId(ctx context.Context) *string {
 }
 
 func (z SpotResolver) Creator(ctx context.Context) (*UserResolver, error) {
-	if userId := z.CreatorId(ctx); userId != nil {
-		userArgs := UserArgs{UserId: *userId}
-		return z.baseResolver.User(ctx, userArgs)
+	userId := z.CreatorId(ctx)
+	if userId == nil {
+		return nil, nil
 	}
-	return nil, nil
+	user, err := z.baseResolver.loadUser(ctx, *userId, projection(ctx, userAttributes))
+	if err != nil || user == nil {
+		return nil, err
+	}
+	return &UserResolver{user: *user, baseResolver: z.baseResolver}, nil
 }
 
 func (z SpotResolver) Name(ctx context.Context) *string {
@@ -343,3 +1010,59 @@ func (z SpotResolver) Tags(ctx context.Context) *[]string {
 func (z SpotResolver) DefaultImageUrl(ctx context.Context) *string {
 	return z.spot.DefaultImageUrl
 }
+
+func (z SpotResolver) AverageRating(ctx context.Context) *float64 {
+	return z.spot.AverageRating()
+}
+
+func (z SpotResolver) ReviewCount(ctx context.Context) int32 {
+	return int32(aws.Int64Value(z.spot.ReviewCount))
+}
+
+func (z SpotResolver) RatingHistogram(ctx context.Context) []*RatingCountResolver {
+	histogram := z.spot.RatingHistogram()
+	resolvers := make([]*RatingCountResolver, len(histogram))
+	for index, count := range histogram {
+		resolvers[index] = &RatingCountResolver{rating: int32(common.MinRating + index), count: int32(count)}
+	}
+	return resolvers
+}
+
+func (z SpotResolver) AverageSubRatings(ctx context.Context) *AverageSubRatingsResolver {
+	return &AverageSubRatingsResolver{spot: z.spot}
+}
+
+// AverageSubRatingsResolver resolves the sub-rating averages, an average is nil while no review
+// rated it
+type AverageSubRatingsResolver struct {
+	spot *common.Spot
+}
+
+func (z AverageSubRatingsResolver) ToiletCleanliness(ctx context.Context) *float64 {
+	return z.spot.ToiletCleanlinessAverage
+}
+
+func (z AverageSubRatingsResolver) Quietness(ctx context.Context) *float64 {
+	return z.spot.QuietnessAverage
+}
+
+func (z AverageSubRatingsResolver) Flatness(ctx context.Context) *float64 {
+	return z.spot.FlatnessAverage
+}
+
+func (z AverageSubRatingsResolver) Safety(ctx context.Context) *float64 {
+	return z.spot.SafetyAverage
+}
+
+type RatingCountResolver struct {
+	rating int32
+	count  int32
+}
+
+func (z RatingCountResolver) Rating(ctx context.Context) int32 {
+	return z.rating
+}
+
+func (z RatingCountResolver) Count(ctx context.Context) int32 {
+	return z.count
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+// spotFields are the client provided fields of a spot, fields that are nil are not validated
+type spotFields struct {
+	Latitude     *float64
+	Longitude    *float64
+	Name         *string
+	Description  *string
+	Address      *string
+	Code         *string
+	Prefecture   *string
+	City         *string
+	HomePageUrls *[]string
+	Tags         *[]string
+}
+
+// validate checks the ranges, lengths and urls of the fields. The spot type is an enum and
+// already checked by the schema.
+func (f spotFields) validate() error {
+
+	if f.Latitude != nil && (*f.Latitude < -90 || *f.Latitude > 90) {
+		return apperror.Invalid("latitude", ErrorInvalidCoordinates)
+	}
+	if f.Longitude != nil && (*f.Longitude < -180 || *f.Longitude > 180) {
+		return apperror.Invalid("longitude", ErrorInvalidCoordinates)
+	}
+	if f.Name != nil && strings.TrimSpace(*f.Name) == "" {
+		return apperror.Invalid("name", ErrorEmptyValue)
+	}
+
+	lengths := []struct {
+		field     string
+		value     *string
+		maxLength int
+	}{
+		{"name", f.Name, SpotNameMaxLength},
+		{"description", f.Description, SpotDescriptionMaxLength},
+		{"address", f.Address, SpotAddressMaxLength},
+		{"code", f.Code, SpotCodeMaxLength},
+		{"prefecture", f.Prefecture, SpotPrefectureMaxLength},
+		{"city", f.City, SpotCityMaxLength},
+	}
+	for _, l := range lengths {
+		if l.value != nil && utf8.RuneCountInString(*l.value) > l.maxLength {
+			return apperror.Invalid(l.field, ErrorValueTooLong)
+		}
+	}
+
+	if f.HomePageUrls != nil {
+		if len(*f.HomePageUrls) > SpotMaxHomePageUrls {
+			return apperror.Invalid("homePageUrls", ErrorTooManyValues)
+		}
+		for _, homePageUrl := range *f.HomePageUrls {
+			if len(homePageUrl) > SpotUrlMaxLength || !isWebUrl(homePageUrl) {
+				return apperror.Invalid("homePageUrls", ErrorInvalidUrl)
+			}
+		}
+	}
+	if f.Tags != nil {
+		if len(*f.Tags) > SpotMaxTags {
+			return apperror.Invalid("tags", ErrorTooManyValues)
+		}
+		for _, tag := range *f.Tags {
+			if strings.TrimSpace(tag) == "" {
+				return apperror.Invalid("tags", ErrorEmptyValue)
+			}
+			if utf8.RuneCountInString(tag) > SpotTagMaxLength {
+				return apperror.Invalid("tags", ErrorValueTooLong)
+			}
+		}
+	}
+	return nil
+}
+
+// isWebUrl accepts absolute http and https urls
+func isWebUrl(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (input CreateSpotInput) fields() spotFields {
+	return spotFields{
+		Latitude:     &input.Latitude,
+		Longitude:    &input.Longitude,
+		Name:         &input.Name,
+		Description:  input.Description,
+		Address:      input.Address,
+		Code:         input.Code,
+		Prefecture:   input.Prefecture,
+		City:         input.City,
+		HomePageUrls: input.HomePageUrls,
+		Tags:         input.Tags,
+	}
+}
+
+func (patch UpdateSpotInput) fields() spotFields {
+	return spotFields{
+		Latitude:     patch.Latitude,
+		Longitude:    patch.Longitude,
+		Name:         patch.Name,
+		Description:  patch.Description,
+		Address:      patch.Address,
+		Code:         patch.Code,
+		Prefecture:   patch.Prefecture,
+		City:         patch.City,
+		HomePageUrls: patch.HomePageUrls,
+		Tags:         patch.Tags,
+	}
+}
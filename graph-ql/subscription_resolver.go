@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
+)
+
+// Subscriptions are stateless. Subscribing runs the resolver without an event to validate the
+// arguments and collect the topics the subscription listens to. Publishing runs the stored
+// subscription again with the event in the context, the resolver sends it if it matches.
+
+type publishedEventKey struct{}
+type subscriptionTopicsKey struct{}
+
+type subscriptionEvent struct {
+	topics []string
+	review *Review
+	spot   *common.Spot
+}
+
+func withPublishedEvent(ctx context.Context, event subscriptionEvent) context.Context {
+	return context.WithValue(ctx, publishedEventKey{}, event)
+}
+
+func publishedEvent(ctx context.Context) (subscriptionEvent, bool) {
+	event, ok := ctx.Value(publishedEventKey{}).(subscriptionEvent)
+	return event, ok
+}
+
+type subscriptionTopics struct {
+	mu     sync.Mutex
+	topics []string
+}
+
+func withSubscriptionTopics(ctx context.Context, topics *subscriptionTopics) context.Context {
+	return context.WithValue(ctx, subscriptionTopicsKey{}, topics)
+}
+
+func addSubscriptionTopics(ctx context.Context, topics ...string) {
+	if collector, ok := ctx.Value(subscriptionTopicsKey{}).(*subscriptionTopics); ok {
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		collector.topics = append(collector.topics, topics...)
+	}
+}
+
+func reviewAddedTopic(spotId string) string {
+	return fmt.Sprintf("%s%s", ReviewAddedTopicPrefix, spotId)
+}
+
+func spotUpdatedTopic(geohash string) string {
+	return fmt.Sprintf("%s%s", SpotUpdatedTopicPrefix, geohash)
+}
+
+// reviewAddedEvent is published for a new review of a spot
+func reviewAddedEvent(review Review) subscriptionEvent {
+	spotId := ReviewResolver{review: review}.SpotId(context.Background())
+	return subscriptionEvent{topics: []string{reviewAddedTopic(spotId)}, review: &review}
+}
+
+// spotUpdatedEvent is published to every geohash cell a region subscription can listen to
+func spotUpdatedEvent(spot common.Spot) subscriptionEvent {
+	cells := common.ContainingGeohashes(spot.Latitude, spot.Longitude, common.RegionMinPrecision, common.RegionMaxPrecision)
+	topics := make([]string, len(cells))
+	for index, cell := range cells {
+		topics[index] = spotUpdatedTopic(cell)
+	}
+	return subscriptionEvent{topics: topics, spot: &spot}
+}
+
+type ReviewAddedArgs struct {
+	SpotId string
+}
+
+func (r *Resolver) ReviewAdded(ctx context.Context, args ReviewAddedArgs) (<-chan *ReviewResolver, error) {
+
+	if args.SpotId == "" {
+		return nil, apperror.New(apperror.Validation, ErrorMissingSpotId)
+	}
+	topic := reviewAddedTopic(args.SpotId)
+	addSubscriptionTopics(ctx, topic)
+
+	c := make(chan *ReviewResolver, 1)
+	if event, ok := publishedEvent(ctx); ok && event.review != nil && reviewAddedTopic(ReviewResolver{review: *event.review}.SpotId(ctx)) == topic {
+		c <- &ReviewResolver{review: *event.review, baseResolver: r}
+	}
+	close(c)
+	return c, nil
+}
+
+type SpotUpdatedArgs struct {
+	BoundingBox *BoundingBoxInput
+	Polygon     *PolygonInput
+}
+
+func (r *Resolver) SpotUpdated(ctx context.Context, args SpotUpdatedArgs) (<-chan *SpotResolver, error) {
+
+	region, err := newRegion(args.BoundingBox, args.Polygon)
+	if err != nil {
+		logError(ctx, "Invalid region", "SpotUpdated", err, nil)
+		return nil, err
+	}
+	cells, err := common.CoveringGeohashes(region)
+	if err != nil {
+		logError(ctx, "Failed to cover region", "SpotUpdated", err, nil)
+		return nil, err
+	}
+	for _, cell := range cells {
+		addSubscriptionTopics(ctx, spotUpdatedTopic(cell))
+	}
+
+	c := make(chan *SpotResolver, 1)
+	if event, ok := publishedEvent(ctx); ok && event.spot != nil && region.Contains(event.spot.Latitude, event.spot.Longitude) {
+		c <- &SpotResolver{spot: event.spot, baseResolver: r}
+	}
+	close(c)
+	return c, nil
+}
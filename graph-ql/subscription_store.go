@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi/apigatewaymanagementapiiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var errConnectionGone = errors.New(ErrorConnectionGone)
+
+// Subscription is stored once for every topic it listens to
+type Subscription struct {
+	PK             string  `dynamodbav:"PK"`
+	SK             string  `dynamodbav:"SK"`
+	GSI1           *string `dynamodbav:"GSI1"`
+	ConnectionId   string  `dynamodbav:"ConnectionId"`
+	SubscriptionId string  `dynamodbav:"SubscriptionId"`
+	Query          string  `dynamodbav:"Query"`
+	OperationName  string  `dynamodbav:"OperationName"`
+	Variables      string  `dynamodbav:"Variables"` // json
+	ExpirationTime int64   `dynamodbav:"ExpirationTime"`
+}
+
+type Connection struct {
+	PK             string `dynamodbav:"PK"`
+	SK             string `dynamodbav:"SK"`
+	ConnectionId   string `dynamodbav:"ConnectionId"`
+	CreationTime   string `dynamodbav:"CreationTime"`
+	ExpirationTime int64  `dynamodbav:"ExpirationTime"`
+}
+
+// subscriptionStore keeps the open connections and what they subscribed to
+type subscriptionStore interface {
+	addConnection(ctx context.Context, connectionId string) error
+	removeConnection(ctx context.Context, connectionId string) error
+	addSubscription(ctx context.Context, subscription Subscription, topics []string) error
+	removeSubscription(ctx context.Context, connectionId, subscriptionId string) error
+	subscriptions(ctx context.Context, topic string) ([]Subscription, error)
+}
+
+// connectionSender sends a message to a websocket connection, it returns errConnectionGone
+// once the client has disconnected
+type connectionSender interface {
+	send(ctx context.Context, connectionId string, message []byte) error
+}
+
+// connectionsTable stores the connections of the API Gateway WebSocket API. Items expire after
+// the two hours API Gateway keeps a connection open.
+type connectionsTable struct {
+	db        dynamodbiface.DynamoDBAPI
+	tableName string
+}
+
+func connectionKey(connectionId string) string {
+	return fmt.Sprintf("%s%s", ConnectionPrefix, connectionId)
+}
+
+func connectionExpirationTime() int64 {
+	return time.Now().Add(WebSocketConnectionLifetime).Unix()
+}
+
+func (z *connectionsTable) addConnection(ctx context.Context, connectionId string) error {
+
+	item, err := dynamodbattribute.MarshalMap(Connection{
+		PK:             connectionKey(connectionId),
+		SK:             connectionKey(connectionId),
+		ConnectionId:   connectionId,
+		CreationTime:   time.Now().Format(time.RFC3339),
+		ExpirationTime: connectionExpirationTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = z.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(z.tableName),
+		Item:      item,
+	})
+	if err != nil {
+		logError(ctx, "Failed to put connection", "addConnection", err, map[string]interface{}{"connectionId": connectionId})
+	}
+	return err
+}
+
+func (z *connectionsTable) removeConnection(ctx context.Context, connectionId string) error {
+	return z.deleteItems(ctx, connectionId, "")
+}
+
+func (z *connectionsTable) addSubscription(ctx context.Context, subscription Subscription, topics []string) error {
+
+	subscription.PK = connectionKey(subscription.ConnectionId)
+	subscription.ExpirationTime = connectionExpirationTime()
+	for _, topic := range topics {
+		subscription.SK = fmt.Sprintf("%s%s#%s", SubscriptionPrefix, subscription.SubscriptionId, topic)
+		subscription.GSI1 = aws.String(fmt.Sprintf("%s%s", TopicPrefix, topic))
+		item, err := dynamodbattribute.MarshalMap(subscription)
+		if err != nil {
+			return err
+		}
+		_, err = z.db.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(z.tableName),
+			Item:      item,
+		})
+		if err != nil {
+			logError(ctx, "Failed to put subscription", "addSubscription", err, map[string]interface{}{"topic": topic})
+			return err
+		}
+	}
+	return nil
+}
+
+func (z *connectionsTable) removeSubscription(ctx context.Context, connectionId, subscriptionId string) error {
+	return z.deleteItems(ctx, connectionId, fmt.Sprintf("%s%s#", SubscriptionPrefix, subscriptionId))
+}
+
+// deleteItems deletes the items of the connection whose sort key starts with prefix
+func (z *connectionsTable) deleteItems(ctx context.Context, connectionId, prefix string) error {
+
+	keyConditionExpression := "#pk = :pk"
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":pk": {S: aws.String(connectionKey(connectionId))},
+	}
+	if prefix != "" {
+		keyConditionExpression = keyConditionExpression + " AND begins_with(#sk, :sk)"
+		expressionAttributeValues[":sk"] = &dynamodb.AttributeValue{S: aws.String(prefix)}
+	}
+	queryInput := &dynamodb.QueryInput{
+		TableName:              aws.String(z.tableName),
+		KeyConditionExpression: aws.String(keyConditionExpression),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk": aws.String(PKKey),
+			"#sk": aws.String(SKKey),
+		},
+		ExpressionAttributeValues: expressionAttributeValues,
+		ProjectionExpression:      aws.String("#pk, #sk"),
+	}
+
+	var deleteErr error
+	err := z.db.QueryPages(queryInput, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			_, deleteErr = z.db.DeleteItem(&dynamodb.DeleteItemInput{
+				TableName: aws.String(z.tableName),
+				Key:       map[string]*dynamodb.AttributeValue{PKKey: item[PKKey], SKKey: item[SKKey]},
+			})
+			if deleteErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = deleteErr
+	}
+	if err != nil {
+		logError(ctx, "Failed to delete connection items", "deleteItems", err, map[string]interface{}{"connectionId": connectionId})
+	}
+	return err
+}
+
+func (z *connectionsTable) subscriptions(ctx context.Context, topic string) ([]Subscription, error) {
+
+	subscriptions := []Subscription{}
+	err := z.db.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(z.tableName),
+		IndexName:              aws.String(GSI1Key),
+		KeyConditionExpression: aws.String("#gsi1 = :gsi1"),
+		ExpressionAttributeNames: map[string]*string{
+			"#gsi1": aws.String(GSI1Key),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":gsi1": {S: aws.String(fmt.Sprintf("%s%s", TopicPrefix, topic))},
+		},
+	}, func(output *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range output.Items {
+			var subscription Subscription
+			if err := dynamodbattribute.UnmarshalMap(item, &subscription); err != nil {
+				logError(ctx, "Failed to unmarshal subscription", "subscriptions", err, nil)
+				continue
+			}
+			subscriptions = append(subscriptions, subscription)
+		}
+		return true
+	})
+	if err != nil {
+		logError(ctx, "Failed to query subscriptions", "subscriptions", err, map[string]interface{}{"topic": topic})
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// apiGatewaySender posts to connections through the API Gateway management API
+type apiGatewaySender struct {
+	client apigatewaymanagementapiiface.ApiGatewayManagementApiAPI
+}
+
+func (z *apiGatewaySender) send(ctx context.Context, connectionId string, message []byte) error {
+	_, err := z.client.PostToConnectionWithContext(ctx, &apigatewaymanagementapi.PostToConnectionInput{
+		ConnectionId: aws.String(connectionId),
+		Data:         message,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == apigatewaymanagementapi.ErrCodeGoneException {
+		return errConnectionGone
+	}
+	return err
+}
+
+// hub keeps connections and subscriptions in memory. It is used for local development where
+// there is no API Gateway, messages for a connection are read from its channel.
+type hub struct {
+	mu          sync.Mutex
+	connections map[string]chan []byte
+	topics      map[string]map[string]Subscription // topic to subscriptions by connection and id
+}
+
+func newHub() *hub {
+	return &hub{
+		connections: map[string]chan []byte{},
+		topics:      map[string]map[string]Subscription{},
+	}
+}
+
+func hubKey(connectionId, subscriptionId string) string {
+	return fmt.Sprintf("%s#%s", connectionId, subscriptionId)
+}
+
+// messages returns the channel of the connection, it is closed when the connection is removed
+func (h *hub) messages(connectionId string) <-chan []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.connections[connectionId]
+}
+
+func (h *hub) addConnection(ctx context.Context, connectionId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.connections[connectionId]; !ok {
+		h.connections[connectionId] = make(chan []byte, HubBufferSize)
+	}
+	return nil
+}
+
+func (h *hub) removeConnection(ctx context.Context, connectionId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subscriptions := range h.topics {
+		for key := range subscriptions {
+			if strings.HasPrefix(key, connectionId+"#") {
+				delete(subscriptions, key)
+			}
+		}
+	}
+	if messages, ok := h.connections[connectionId]; ok {
+		close(messages)
+		delete(h.connections, connectionId)
+	}
+	return nil
+}
+
+func (h *hub) addSubscription(ctx context.Context, subscription Subscription, topics []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, topic := range topics {
+		if h.topics[topic] == nil {
+			h.topics[topic] = map[string]Subscription{}
+		}
+		h.topics[topic][hubKey(subscription.ConnectionId, subscription.SubscriptionId)] = subscription
+	}
+	return nil
+}
+
+func (h *hub) removeSubscription(ctx context.Context, connectionId, subscriptionId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subscriptions := range h.topics {
+		delete(subscriptions, hubKey(connectionId, subscriptionId))
+	}
+	return nil
+}
+
+func (h *hub) subscriptions(ctx context.Context, topic string) ([]Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subscriptions := []Subscription{}
+	for _, subscription := range h.topics[topic] {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+// send drops the message if the reader of the connection does not keep up
+func (h *hub) send(ctx context.Context, connectionId string, message []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	messages, ok := h.connections[connectionId]
+	if !ok {
+		return errConnectionGone
+	}
+	select {
+	case messages <- message:
+	default:
+		logInfo(ctx, "Dropped message for slow connection", "hub.send", map[string]interface{}{"connectionId": connectionId})
+	}
+	return nil
+}
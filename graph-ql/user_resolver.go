@@ -2,13 +2,10 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"log"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/ninotokuda/carcamp_v2/common/apperror"
 )
 
 type UserArgs struct {
@@ -17,38 +14,28 @@ type UserArgs struct {
 
 func (r *Resolver) User(ctx context.Context, args UserArgs) (*UserResolver, error) {
 
-	log.Println("User")
-	pk := fmt.Sprintf("%s%s", UserPrefix, args.UserId)
-	output, err := r.Db.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(r.TableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"PK": {
-				S: aws.String(pk),
-			},
-		},
-	})
-
+	logInfo(ctx, "Invoke", "User", map[string]interface{}{"args": args})
+	user, err := r.loadUser(ctx, args.UserId, projection(ctx, userAttributes))
 	if err != nil {
 		return nil, err
 	}
-
-	var user User
-	err = dynamodbattribute.UnmarshalMap(output.Item, &user)
-	if err != nil {
-		return nil, err
+	if user == nil {
+		return nil, apperror.New(apperror.NotFound, ErrorUserNotFound)
 	}
-	userResolver := &UserResolver{user: user}
-	return userResolver, nil
-
+	return &UserResolver{user: *user, baseResolver: r}, nil
 }
 
 type User struct {
-	PK           string  `dynamodbav:"PK"`
-	SK           string  `dynamodbav:"SK"`
+	PK           string  `dynamodbav:"PK"` // User#<user_id>
+	SK           string  `dynamodbav:"SK"` // User#<user_id>
 	GSI1         *string `dynamodbav:"GSI1"`
 	GSI2         *string `dynamodbav:"GSI2"`
 	CreationTime string  `dynamodbav:"CreationTime"`
-	Nickname     *string `dynamodbav:"CreationTime"`
+	Nickname     *string `dynamodbav:"Nickname"`
+}
+
+func (u User) UserId() string {
+	return strings.TrimPrefix(u.PK, UserPrefix)
 }
 
 type UserResolver struct {
@@ -57,7 +44,7 @@ type UserResolver struct {
 }
 
 func (u UserResolver) UserId(ctx context.Context) string {
-	return strings.TrimPrefix(u.user.PK, UserPrefix)
+	return u.user.UserId()
 }
 
 func (u UserResolver) Nickname(ctx context.Context) *string {
@@ -68,16 +55,19 @@ func (u UserResolver) CreationTime(ctx context.Context) string {
 	return u.user.CreationTime
 }
 
-func (u UserResolver) Reviews(ctx context.Context) (*[]*ReviewResolver, error) {
+type UserConnectionArgs struct {
+	First *int32
+	After *string
+}
+
+func (u UserResolver) Reviews(ctx context.Context, args UserConnectionArgs) (*ReviewConnectionResolver, error) {
 	userId := u.UserId(ctx)
-	reviewArgs := ReviewArgs{UserId: aws.String(userId)}
-	resolvers, err := u.baseResolver.Reviews(ctx, reviewArgs)
-	return &resolvers, err
+	reviewArgs := ReviewArgs{UserId: aws.String(userId), First: args.First, After: args.After}
+	return u.baseResolver.Reviews(ctx, reviewArgs)
 }
 
-func (u UserResolver) CreatedSpots(ctx context.Context) (*[]*SpotResolver, error) {
+func (u UserResolver) CreatedSpots(ctx context.Context, args UserConnectionArgs) (*SpotConnectionResolver, error) {
 	userId := u.UserId(ctx)
-	spotArgs := SpotArgs{CreatorId: userId}
-	resolvers, err := u.baseResolver.SpotsByCreator(ctx, spotArgs)
-	return &resolvers, err
+	spotArgs := SpotArgs{CreatorId: userId, First: args.First, After: args.After}
+	return u.baseResolver.SpotsByCreator(ctx, spotArgs)
 }
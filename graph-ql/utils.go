@@ -5,37 +5,15 @@ import (
 	"encoding/json"
 	"log"
 	"time"
-)
-
-func getRequestUser(ctx context.Context) *RequestUser {
-	if u := ctx.Value(RequestUserKey); u != nil {
-		ru := u.(RequestUser)
-		return &ru
-	}
-	return nil
-}
-
-type RequestUser struct {
-	userGroups []string
-	companyId  string
-	userId     string
-}
 
-func (u *RequestUser) IsAdminUser() bool {
-	for _, ug := range u.userGroups {
-		if ug == "Admin" {
-			return true
-		}
-	}
-	return false
-}
+	"github.com/ninotokuda/carcamp_v2/common"
+)
 
-func (u *RequestUser) CompanyId() string {
-	return u.companyId
-}
+// RequestUser is shared with common so that its loggers can read the user from the context
+type RequestUser = common.RequestUser
 
-func (u *RequestUser) UserId() string {
-	return u.userId
+func getRequestUser(ctx context.Context) *RequestUser {
+	return common.GetRequestUser(ctx)
 }
 
 type LogImpl struct {
@@ -60,7 +38,7 @@ func logInfo(ctx context.Context, msg, function string, props map[string]interfa
 	}
 	requestUser := getRequestUser(ctx)
 	if requestUser != nil {
-		logObj.UserId = requestUser.userId
+		logObj.UserId = requestUser.UserId()
 		logObj.IsAdmin = requestUser.IsAdminUser()
 	}
 
@@ -90,7 +68,7 @@ func logError(ctx context.Context, msg, function string, err error, props map[st
 	}
 	requestUser := getRequestUser(ctx)
 	if requestUser != nil {
-		logObj.UserId = requestUser.userId
+		logObj.UserId = requestUser.UserId()
 		logObj.IsAdmin = requestUser.IsAdminUser()
 	}
 
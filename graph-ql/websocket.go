@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"github.com/ninotokuda/carcamp_v2/common"
+)
+
+// The websocket transport speaks the graphql-transport-ws protocol over an API Gateway
+// WebSocket API. Every message is a separate invocation, subscriptions are kept in the
+// subscription store and delivered by the publisher of the mutation that caused the event.
+
+type webSocketMessage struct {
+	Id      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type webSocketResponse struct {
+	Id      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+func (z *App) websocketHandler(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	connectionId := request.RequestContext.ConnectionID
+	logInfo(ctx, "Invoke", "websocketHandler", map[string]interface{}{"route": request.RequestContext.RouteKey, "connectionId": connectionId})
+
+	switch request.RequestContext.RouteKey {
+	case "$connect":
+		if err := z.subscriptions.addConnection(ctx, connectionId); err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: 500}, nil
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: 200,
+			Headers:    map[string]string{"Sec-WebSocket-Protocol": WebSocketProtocol},
+		}, nil
+	case "$disconnect":
+		_ = z.subscriptions.removeConnection(ctx, connectionId)
+		return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+	}
+
+	var message webSocketMessage
+	if err := json.Unmarshal([]byte(request.Body), &message); err != nil {
+		logInfo(ctx, "Invalid message", "websocketHandler", map[string]interface{}{"error": err.Error()})
+		return events.APIGatewayProxyResponse{StatusCode: 400}, nil
+	}
+
+	var err error
+	switch message.Type {
+	case WebSocketConnectionInit:
+		err = z.sendMessage(ctx, connectionId, webSocketResponse{Type: WebSocketConnectionAck})
+	case WebSocketPing:
+		err = z.sendMessage(ctx, connectionId, webSocketResponse{Type: WebSocketPong})
+	case WebSocketSubscribe:
+		err = z.subscribe(ctx, connectionId, message)
+	case WebSocketComplete:
+		err = z.subscriptions.removeSubscription(ctx, connectionId, message.Id)
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: 500}, nil
+	}
+	return events.APIGatewayProxyResponse{StatusCode: 200}, nil
+}
+
+// subscribe validates the operation and stores it for the topics its resolver listens to.
+// Queries and mutations sent over the socket are executed right away.
+func (z *App) subscribe(ctx context.Context, connectionId string, message webSocketMessage) error {
+
+	var queryRequest QueryRequest
+	if err := json.Unmarshal(message.Payload, &queryRequest); err != nil {
+		return z.sendErrors(ctx, connectionId, message.Id, rejectedResponse(&gqlerrors.QueryError{Message: ErrorInvalidRequest}))
+	}
+	doc, op, queryErr := parseOperation(queryRequest)
+	if queryErr != nil {
+		return z.sendErrors(ctx, connectionId, message.Id, rejectedResponse(queryErr))
+	}
+	if queryErr := z.limits.check(doc, op, queryRequest.Variables); queryErr != nil {
+		return z.sendErrors(ctx, connectionId, message.Id, rejectedResponse(queryErr))
+	}
+
+	topics := &subscriptionTopics{}
+	responses, err := z.schema.Subscribe(withSubscriptionTopics(ctx, topics), queryRequest.Query, queryRequest.OperationName, queryRequest.Variables)
+	if err != nil {
+		logError(ctx, "Failed to subscribe", "subscribe", err, nil)
+		return err
+	}
+
+	executed := false
+	for response := range responses {
+		executed = true
+		resp := response.(*graphql.Response)
+		maskErrors(ctx, resp)
+		if len(resp.Data) == 0 {
+			return z.sendErrors(ctx, connectionId, message.Id, resp)
+		}
+		if err := z.sendMessage(ctx, connectionId, webSocketResponse{Id: message.Id, Type: WebSocketNext, Payload: resp}); err != nil {
+			return err
+		}
+	}
+	if executed || len(topics.topics) == 0 {
+		return z.sendMessage(ctx, connectionId, webSocketResponse{Id: message.Id, Type: WebSocketComplete})
+	}
+
+	variables, err := json.Marshal(queryRequest.Variables)
+	if err != nil {
+		return err
+	}
+	return z.subscriptions.addSubscription(ctx, Subscription{
+		ConnectionId:   connectionId,
+		SubscriptionId: message.Id,
+		Query:          queryRequest.Query,
+		OperationName:  queryRequest.OperationName,
+		Variables:      string(variables),
+	}, topics.topics)
+}
+
+func (z *App) sendErrors(ctx context.Context, connectionId, id string, resp *graphql.Response) error {
+	return z.sendMessage(ctx, connectionId, webSocketResponse{Id: id, Type: WebSocketError, Payload: resp.Errors})
+}
+
+func (z *App) sendMessage(ctx context.Context, connectionId string, message webSocketResponse) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	err = z.sender.send(ctx, connectionId, data)
+	if err == errConnectionGone {
+		return z.subscriptions.removeConnection(ctx, connectionId)
+	}
+	if err != nil {
+		logError(ctx, "Failed to send message", "sendMessage", err, map[string]interface{}{"connectionId": connectionId})
+	}
+	return err
+}
+
+// Publisher delivers events to the subscriptions listening to their topics
+type Publisher struct {
+	schema        *graphql.Schema
+	subscriptions subscriptionStore
+	sender        connectionSender
+}
+
+// publish runs every subscription of the event's topics with the event, subscriptions whose
+// arguments do not match it send nothing. Failures are logged, they do not fail the mutation.
+func (p *Publisher) publish(ctx context.Context, event subscriptionEvent) {
+
+	// the user of the mutation does not apply to the subscriptions
+	ctx = context.WithValue(ctx, common.RequestUserKey, nil)
+	ctx = withPublishedEvent(ctx, event)
+
+	delivered := map[string]bool{}
+	var wg sync.WaitGroup
+	for _, topic := range event.topics {
+		subscriptions, err := p.subscriptions.subscriptions(ctx, topic)
+		if err != nil {
+			continue
+		}
+		for index := range subscriptions {
+			subscription := subscriptions[index]
+			// a region subscription listens to several cells that can contain the same spot
+			key := hubKey(subscription.ConnectionId, subscription.SubscriptionId)
+			if delivered[key] {
+				continue
+			}
+			delivered[key] = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.deliver(ctx, subscription)
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func (p *Publisher) deliver(ctx context.Context, subscription Subscription) {
+
+	var variables map[string]interface{}
+	if err := json.Unmarshal([]byte(subscription.Variables), &variables); err != nil {
+		logError(ctx, "Failed to unmarshal variables", "deliver", err, nil)
+		return
+	}
+	responses, err := p.schema.Subscribe(ctx, subscription.Query, subscription.OperationName, variables)
+	if err != nil {
+		logError(ctx, "Failed to run subscription", "deliver", err, nil)
+		return
+	}
+	for response := range responses {
+		resp := response.(*graphql.Response)
+		maskErrors(ctx, resp)
+		data, err := json.Marshal(webSocketResponse{Id: subscription.SubscriptionId, Type: WebSocketNext, Payload: resp})
+		if err != nil {
+			continue
+		}
+		err = p.sender.send(ctx, subscription.ConnectionId, data)
+		if err == errConnectionGone {
+			_ = p.subscriptions.removeConnection(ctx, subscription.ConnectionId)
+			return
+		}
+		if err != nil {
+			logError(ctx, "Failed to send subscription event", "deliver", err, map[string]interface{}{"connectionId": subscription.ConnectionId})
+		}
+	}
+}
+
+// publish is a no-op until the app sets up a publisher
+func (r *Resolver) publish(ctx context.Context, event subscriptionEvent) {
+	if r.Publisher != nil {
+		r.Publisher.publish(ctx, event)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+const (
+	// env vars
+	TableNameEvn  = "DynamoTableName"
+	BucketNameEnv = "S3BucketName"
+
+	// renditions, sizes are the longest edge in pixels
+	ThumbnailName     = "thumb"
+	ThumbnailMaxPixel = 200
+	MediumName        = "medium"
+	MediumMaxPixel    = 800
+	FullName          = "full"
+	JPEGQuality       = 85
+
+	// limits, decoding needs about four bytes per pixel
+	MaxImagePixels = 40000000
+
+	// errors
+	ErrorUnsupportedImage = "ErrorUnsupportedImage"
+	ErrorImageTooLarge    = "ErrorImageTooLarge"
+
+	// keys
+	PKKey            = "PK"
+	SKKey            = "SK"
+	ImageUrlKey      = "ImageUrl"
+	ThumbnailUrlKey  = "ThumbnailUrl"
+	MediumUrlKey     = "MediumUrl"
+	ProcessedTimeKey = "ProcessedTime"
+)
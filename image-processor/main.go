@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/ninotokuda/carcamp_v2/common"
+)
+
+type App struct {
+	s3Client   s3iface.S3API
+	db         dynamodbiface.DynamoDBAPI
+	tableName  string
+	bucketName string
+}
+
+func NewApp() *App {
+
+	sess := session.Must(session.NewSession())
+	return &App{
+		s3Client:   s3.New(sess),
+		db:         dynamodb.New(sess),
+		tableName:  os.Getenv(TableNameEvn),
+		bucketName: os.Getenv(BucketNameEnv),
+	}
+}
+
+type rendition struct {
+	name     string
+	maxPixel int // 0 keeps the original size
+}
+
+var renditions = []rendition{
+	{name: FullName},
+	{name: MediumName, maxPixel: MediumMaxPixel},
+	{name: ThumbnailName, maxPixel: ThumbnailMaxPixel},
+}
+
+// processImage writes the renditions of a confirmed spot image, points the spot image at them
+// and removes the upload, which still contains its exif data.
+func (z *App) processImage(ctx context.Context, spotId, spotImageId string) error {
+
+	common.LogInfo(ctx, "Invoke", "processImage", map[string]interface{}{"spotId": spotId, "spotImageId": spotImageId})
+	spotImage, err := z.getSpotImage(ctx, spotId, spotImageId)
+	if err != nil {
+		return err
+	}
+	if spotImage == nil || spotImage.ProcessedTime != nil {
+		common.LogInfo(ctx, "Skip spot image that was deleted or already processed", "processImage", nil)
+		return nil
+	}
+
+	object, err := z.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(z.bucketName),
+		Key:    aws.String(spotImage.ObjectKey),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			common.LogInfo(ctx, "Skip spot image without upload", "processImage", nil)
+			return nil
+		}
+		common.LogError(ctx, "Failed to get object", "processImage", err, nil)
+		return err
+	}
+	defer object.Body.Close()
+	if aws.Int64Value(object.ContentLength) > common.SpotImageMaxBytes {
+		// the upload was replaced after it was confirmed
+		common.LogError(ctx, "Upload is too large", "processImage", errors.New(ErrorImageTooLarge), map[string]interface{}{"contentLength": aws.Int64Value(object.ContentLength)})
+		return z.deleteObject(ctx, spotImage.ObjectKey)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(object.Body, common.SpotImageMaxBytes))
+	if err != nil {
+		common.LogError(ctx, "Failed to read object", "processImage", err, nil)
+		return err
+	}
+
+	img, format, err := decodeImage(data)
+	if err != nil {
+		// retrying will not help, the upload stays unprocessed
+		common.LogError(ctx, "Failed to decode image", "processImage", err, nil)
+		return nil
+	}
+	extension, contentType := "jpg", common.ContentTypeJPEG
+	if format == "png" {
+		extension, contentType = "png", common.ContentTypePNG
+	}
+
+	urls := map[string]string{}
+	renditionKeys := []string{}
+	for _, r := range renditions {
+		resized := img
+		if r.maxPixel > 0 {
+			resized = resize(img, r.maxPixel)
+		}
+		encoded, err := encodeImage(resized, format)
+		if err != nil {
+			common.LogError(ctx, "Failed to encode rendition", "processImage", err, map[string]interface{}{"rendition": r.name})
+			return err
+		}
+		renditionKey := common.SpotImageRenditionKey(spotId, spotImageId, r.name, extension)
+		_, err = z.s3Client.PutObject(&s3.PutObjectInput{
+			Bucket:      aws.String(z.bucketName),
+			Key:         aws.String(renditionKey),
+			Body:        bytes.NewReader(encoded),
+			ContentType: aws.String(contentType),
+		})
+		if err != nil {
+			common.LogError(ctx, "Failed to put rendition", "processImage", err, map[string]interface{}{"rendition": r.name})
+			return err
+		}
+		urls[r.name] = common.ObjectUrl(z.bucketName, renditionKey)
+		renditionKeys = append(renditionKeys, renditionKey)
+	}
+
+	updated, err := z.updateSpotImage(ctx, spotId, spotImageId, urls)
+	if err != nil {
+		return err
+	}
+	if updated == nil {
+		// the spot image was deleted while it was processed, its deletion may have missed the renditions
+		for _, renditionKey := range renditionKeys {
+			err = z.deleteObject(ctx, renditionKey)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = z.deleteObject(ctx, spotImage.ObjectKey)
+	if err != nil {
+		return err
+	}
+
+	// review photos go away with the review, they do not become the default image
+	if updated.ReviewId != nil {
+		return nil
+	}
+	return z.updateDefaultImage(ctx, spotId, urls[MediumName])
+}
+
+func (z *App) getSpotImage(ctx context.Context, spotId, spotImageId string) (*common.SpotImage, error) {
+
+	output, err := z.db.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(z.tableName),
+		Key:            spotImageKey(spotId, spotImageId),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		common.LogError(ctx, "Failed to get spot image", "getSpotImage", err, nil)
+		return nil, err
+	}
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+
+	var spotImage common.SpotImage
+	err = dynamodbattribute.UnmarshalMap(output.Item, &spotImage)
+	if err != nil {
+		common.LogError(ctx, "Failed to unmarshal spot image", "getSpotImage", err, nil)
+		return nil, err
+	}
+	return &spotImage, nil
+}
+
+// updateSpotImage returns nil when the spot image was deleted in the meantime
+func (z *App) updateSpotImage(ctx context.Context, spotId, spotImageId string, urls map[string]string) (*common.SpotImage, error) {
+
+	output, err := z.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(z.tableName),
+		Key:                 spotImageKey(spotId, spotImageId),
+		UpdateExpression:    aws.String("SET #imageUrl = :imageUrl, #thumbnailUrl = :thumbnailUrl, #mediumUrl = :mediumUrl, #processedTime = :processedTime"),
+		ConditionExpression: aws.String("attribute_exists(#pk)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk":            aws.String(PKKey),
+			"#imageUrl":      aws.String(ImageUrlKey),
+			"#thumbnailUrl":  aws.String(ThumbnailUrlKey),
+			"#mediumUrl":     aws.String(MediumUrlKey),
+			"#processedTime": aws.String(ProcessedTimeKey),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":imageUrl":      {S: aws.String(urls[FullName])},
+			":thumbnailUrl":  {S: aws.String(urls[ThumbnailName])},
+			":mediumUrl":     {S: aws.String(urls[MediumName])},
+			":processedTime": {S: aws.String(time.Now().Format(time.RFC3339))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			common.LogInfo(ctx, "Spot image was deleted", "updateSpotImage", nil)
+			return nil, nil
+		}
+		common.LogError(ctx, "Failed to update spot image", "updateSpotImage", err, nil)
+		return nil, err
+	}
+
+	var spotImage common.SpotImage
+	err = dynamodbattribute.UnmarshalMap(output.Attributes, &spotImage)
+	if err != nil {
+		common.LogError(ctx, "Failed to unmarshal spot image", "updateSpotImage", err, nil)
+		return nil, err
+	}
+	return &spotImage, nil
+}
+
+func (z *App) deleteObject(ctx context.Context, objectKey string) error {
+
+	_, err := z.s3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(z.bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		common.LogError(ctx, "Failed to delete object", "deleteObject", err, map[string]interface{}{"objectKey": objectKey})
+	}
+	return err
+}
+
+func spotImageKey(spotId, spotImageId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		PKKey: {S: aws.String(fmt.Sprintf("%s%s", common.SpotPrefix, spotId))},
+		SKKey: {S: aws.String(fmt.Sprintf("%s%s", common.SpotImagePrefix, spotImageId))},
+	}
+}
+
+// updateDefaultImage gives spots without an image this one and shows it on the distances leading to the spot
+func (z *App) updateDefaultImage(ctx context.Context, spotId, imageUrl string) error {
+
+	spot, err := common.GetSpot(ctx, spotId, common.Projection{common.DefaultImageUrlKey}, z.db, z.tableName)
+	if err != nil {
+		return err
+	}
+	if spot.DefaultImageUrl != nil {
+		return nil
+	}
+	didSet, err := common.SetDefaultImageUrl(ctx, spot, imageUrl, z.db, z.tableName)
+	if err != nil || !didSet {
+		return err
+	}
+	return common.UpdateDestinationImageUrls(ctx, spotId, imageUrl, z.db, z.tableName)
+}
+
+// handler processes spot images when they are inserted into the table, which happens when the
+// upload is confirmed, so unconfirmed uploads are never processed
+func (z *App) handler(ctx context.Context, event events.DynamoDBEvent) error {
+
+	var lastErr error
+	for _, record := range event.Records {
+		if record.EventName != string(events.DynamoDBOperationTypeInsert) {
+			continue
+		}
+		pk, sk := record.Change.Keys[PKKey].String(), record.Change.Keys[SKKey].String()
+		if !strings.HasPrefix(pk, common.SpotPrefix) || !strings.HasPrefix(sk, common.SpotImagePrefix) {
+			continue
+		}
+		err := z.processImage(ctx, strings.TrimPrefix(pk, common.SpotPrefix), strings.TrimPrefix(sk, common.SpotImagePrefix))
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func main() {
+	app := NewApp()
+	lambda.Start(app.handler)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/ninotokuda/carcamp_v2/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessImage(t *testing.T) {
+
+	upload := "spots/spot1/user1/image1"
+	renditionKeys := []string{
+		"renditions/spot1/image1/full.jpg",
+		"renditions/spot1/image1/medium.jpg",
+		"renditions/spot1/image1/thumb.jpg",
+	}
+
+	testCases := []struct {
+		name                     string
+		eventName                string
+		sk                       string
+		defaultImageUrl          *string
+		stored                   bool
+		processed                bool
+		deletedMeanwhile         bool
+		contentLength            int64
+		renditionCount           int
+		deletedKeys              []string
+		spotImageUpdated         bool
+		defaultImageUpdated      bool
+		destinationImagesUpdated int
+		reviewId                 *string
+	}{
+		{"process", "INSERT", "SpotImage#image1", nil, true, false, false, 1000, 3, []string{upload}, true, true, 2, nil},
+		{"spot has image", "INSERT", "SpotImage#image1", aws.String("https://existing"), true, false, false, 1000, 3, []string{upload}, true, false, 0, nil},
+		{"review photo", "INSERT", "SpotImage#image1", nil, true, false, false, 1000, 3, []string{upload}, true, false, 0, aws.String("review1")},
+		{"deleted", "INSERT", "SpotImage#image1", nil, false, false, false, 1000, 0, nil, false, false, 0, nil},
+		{"already processed", "INSERT", "SpotImage#image1", nil, true, true, false, 1000, 0, nil, false, false, 0, nil},
+		{"deleted while processing", "INSERT", "SpotImage#image1", nil, true, false, true, 1000, 3, renditionKeys, false, false, 0, nil},
+		{"too large", "INSERT", "SpotImage#image1", nil, true, false, false, common.SpotImageMaxBytes + 1, 0, []string{upload}, false, false, 0, nil},
+		{"not a spot image", "INSERT", "Review#review1", nil, true, false, false, 1000, 0, nil, false, false, 0, nil},
+		{"modify", "MODIFY", "SpotImage#image1", nil, true, false, false, 1000, 0, nil, false, false, 0, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+
+			renditions := map[string][]byte{}
+			var deletedKeys []string
+			spotImageUpdated := false
+			defaultImageUpdated := false
+			destinationImagesUpdated := 0
+
+			spot := common.Spot{
+				PK:              "Spot#spot1",
+				SK:              "Spot#xn76urx",
+				DefaultImageUrl: tc.defaultImageUrl,
+			}
+			spotItem, _ := dynamodbattribute.MarshalMap(spot)
+			distanceItems := []map[string]*dynamodb.AttributeValue{}
+			for _, origin := range []string{"spot2", "spot3"} {
+				item, _ := dynamodbattribute.MarshalMap(common.NewSpotDistance(common.Spot{PK: "Spot#" + origin}, spot, 100, 1000))
+				distanceItems = append(distanceItems, item)
+			}
+			spotImage := common.NewSpotImage("spot1", "image1", "user1", "images", "image/jpeg", 1000)
+			spotImage.ReviewId = tc.reviewId
+			if tc.processed {
+				spotImage.ProcessedTime = aws.String("2020-01-01T00:00:00Z")
+			}
+			spotImageItem, _ := dynamodbattribute.MarshalMap(spotImage)
+
+			app := &App{
+				tableName:  "test_table",
+				bucketName: "images",
+				s3Client: &mockS3Client{
+					GetObjectFunc: func(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+						require.Equal(t, upload, *in.Key)
+						return &s3.GetObjectOutput{
+							Body:          ioutil.NopCloser(bytes.NewReader(testJPEG(t))),
+							ContentLength: aws.Int64(tc.contentLength),
+						}, nil
+					},
+					PutObjectFunc: func(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+						require.Equal(t, "image/jpeg", *in.ContentType)
+						data, _ := ioutil.ReadAll(in.Body)
+						renditions[*in.Key] = data
+						return &s3.PutObjectOutput{}, nil
+					},
+					DeleteObjectFunc: func(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+						deletedKeys = append(deletedKeys, *in.Key)
+						return &s3.DeleteObjectOutput{}, nil
+					},
+				},
+				db: &mockDbClient{
+					GetItemFunc: func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+						require.Equal(t, "SpotImage#image1", *in.Key["SK"].S)
+						require.True(t, *in.ConsistentRead)
+						if !tc.stored {
+							return &dynamodb.GetItemOutput{}, nil
+						}
+						return &dynamodb.GetItemOutput{Item: spotImageItem}, nil
+					},
+					QueryFunc: func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+						if in.IndexName != nil {
+							require.Equal(t, "Spot#spot1", *in.ExpressionAttributeValues[":gsi1"].S)
+							return &dynamodb.QueryOutput{Items: distanceItems}, nil
+						}
+						return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{spotItem}}, nil
+					},
+					UpdateItemFunc: func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+						switch {
+						case *in.Key["SK"].S == "SpotImage#image1":
+							if tc.deletedMeanwhile {
+								return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)
+							}
+							spotImageUpdated = true
+							require.Equal(t, "https://images.s3.amazonaws.com/renditions/spot1/image1/thumb.jpg", *in.ExpressionAttributeValues[":thumbnailUrl"].S)
+							return &dynamodb.UpdateItemOutput{Attributes: spotImageItem}, nil
+						case *in.Key["SK"].S == spot.SK:
+							defaultImageUpdated = true
+							require.Equal(t, "https://images.s3.amazonaws.com/renditions/spot1/image1/medium.jpg", *in.ExpressionAttributeValues[":defaultImageUrl"].S)
+						default:
+							destinationImagesUpdated++
+							require.Equal(t, "SpotDistance#spot1", *in.Key["SK"].S)
+							require.Equal(t, "https://images.s3.amazonaws.com/renditions/spot1/image1/medium.jpg", *in.ExpressionAttributeValues[":destinationImageUrl"].S)
+						}
+						return &dynamodb.UpdateItemOutput{}, nil
+					},
+				},
+			}
+
+			event := events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{{
+				EventName: tc.eventName,
+				Change: events.DynamoDBStreamRecord{
+					Keys: map[string]events.DynamoDBAttributeValue{
+						"PK": events.NewStringAttribute("Spot#spot1"),
+						"SK": events.NewStringAttribute(tc.sk),
+					},
+				},
+			}}}
+			err := app.handler(context.Background(), event)
+			require.Nil(t, err)
+
+			require.Equal(t, tc.renditionCount, len(renditions))
+			require.Equal(t, tc.deletedKeys, deletedKeys)
+			require.Equal(t, tc.spotImageUpdated, spotImageUpdated)
+			require.Equal(t, tc.defaultImageUpdated, defaultImageUpdated)
+			require.Equal(t, tc.destinationImagesUpdated, destinationImagesUpdated)
+
+			if tc.renditionCount > 0 {
+				// the exif orientation rotates the landscape upload into portrait
+				sizes := map[string]image.Point{
+					"renditions/spot1/image1/full.jpg":   {600, 800},
+					"renditions/spot1/image1/medium.jpg": {600, 800},
+					"renditions/spot1/image1/thumb.jpg":  {150, 200},
+				}
+				for key, size := range sizes {
+					data, ok := renditions[key]
+					require.True(t, ok, key)
+					require.False(t, bytes.Contains(data, []byte("Exif")), key)
+					require.False(t, bytes.Contains(data, []byte("GPS")), key)
+					config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+					require.Nil(t, err)
+					require.Equal(t, size, image.Point{config.Width, config.Height}, key)
+				}
+			}
+		})
+	}
+}
+
+// testJPEG is an 800x600 jpeg with an exif segment rotating it by 90 degrees and a gps marker
+func testJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 800, 600)), nil)
+	require.Nil(t, err)
+	data := buf.Bytes()
+
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // header, first ifd at 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x00, // orientation 6
+		0x00, 0x00, 0x00, 0x00, // no next ifd
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	payload = append(payload, []byte("GPS")...)
+	length := len(payload) + 2
+	segment := append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+
+	return append(append([]byte{0xFF, 0xD8}, segment...), data[2:]...)
+}
+
+func TestResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	require.Equal(t, image.Rect(0, 0, 40, 20), resize(img, 40).Bounds())
+	require.Equal(t, img.Bounds(), resize(img, 200).Bounds())
+}
+
+func TestDecodeImageTooLarge(t *testing.T) {
+	// DecodeConfig only reads the frame header, the pixels are never decoded
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)), nil)
+	require.Nil(t, err)
+	data := buf.Bytes()
+	sof := bytes.Index(data, []byte{0xFF, 0xC0})
+	require.True(t, sof > 0)
+	binary.BigEndian.PutUint16(data[sof+5:], 10000)
+	binary.BigEndian.PutUint16(data[sof+7:], 10000)
+
+	_, _, err = decodeImage(data)
+	require.EqualError(t, err, ErrorImageTooLarge)
+}
+
+func TestExifOrientation(t *testing.T) {
+	require.Equal(t, 6, exifOrientation(testJPEG(t)))
+	require.Equal(t, 1, exifOrientation([]byte(strings.Repeat("x", 10))))
+}
+
+type mockS3Client struct {
+	s3iface.S3API
+	GetObjectFunc    func(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	PutObjectFunc    func(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	DeleteObjectFunc func(*s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+}
+
+func (m *mockS3Client) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return m.GetObjectFunc(in)
+}
+
+func (m *mockS3Client) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return m.PutObjectFunc(in)
+}
+
+func (m *mockS3Client) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	return m.DeleteObjectFunc(in)
+}
+
+type mockDbClient struct {
+	dynamodbiface.DynamoDBAPI
+	GetItemFunc    func(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error)
+	QueryFunc      func(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error)
+	UpdateItemFunc func(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error)
+}
+
+func (m *mockDbClient) GetItem(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return m.GetItemFunc(in)
+}
+
+func (m *mockDbClient) Query(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	return m.QueryFunc(in)
+}
+
+func (m *mockDbClient) UpdateItem(in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	return m.UpdateItemFunc(in)
+}